@@ -0,0 +1,305 @@
+// Package apierr defines the errors returned by the APIs of all services. Every Error carries a
+// stable Code clients can rely on, which maps to an HTTP status and a gRPC code. Errors are sent
+// as RFC 7807 problem details and decoded back into an Error by clients.
+package apierr
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Code identifies the kind of an Error. Codes are part of the API and never change.
+type Code string
+
+// Codes of all errors returned by the services.
+const (
+	// InvalidPayload means the request body isn't valid JSON or doesn't match the expected type.
+	InvalidPayload Code = "invalid_payload"
+
+	// InvalidArgument means a path or query parameter is malformed.
+	InvalidArgument Code = "invalid_argument"
+
+	// ValidationFailed means the request payload is well-formed but has invalid fields.
+	ValidationFailed Code = "validation_failed"
+
+	// Unauthenticated means the request lacks valid credentials.
+	Unauthenticated Code = "unauthenticated"
+
+	// PermissionDenied means the authenticated caller isn't allowed to make the request.
+	PermissionDenied Code = "permission_denied"
+
+	// NotFound means the requested resource doesn't exist.
+	NotFound Code = "not_found"
+
+	// AlreadyExists means the resource to be created exists already.
+	AlreadyExists Code = "already_exists"
+
+	// FailedPrecondition means the resource isn't in the state the request requires, e.g. an item
+	// doesn't have enough units left.
+	FailedPrecondition Code = "failed_precondition"
+
+	// Conflict means the resource was modified concurrently and the request should be retried.
+	Conflict Code = "conflict"
+
+	// Canceled means the caller canceled the request before it completed.
+	Canceled Code = "canceled"
+
+	// ResourceExhausted means the caller exceeded its rate limit and should retry later.
+	ResourceExhausted Code = "resource_exhausted"
+
+	// Unavailable means a dependency, e.g. another service, can't be reached.
+	Unavailable Code = "unavailable"
+
+	// Internal means the request failed for reasons the client can't do anything about.
+	Internal Code = "internal"
+)
+
+// StatusClientClosedRequest is the non-standard HTTP status of requests canceled by the client.
+const StatusClientClosedRequest = 499
+
+// Status returns the HTTP status code of a Code. Unknown codes are internal errors.
+func (c Code) Status() int {
+	switch c {
+	case InvalidPayload, InvalidArgument:
+		return http.StatusBadRequest
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case PermissionDenied:
+		return http.StatusForbidden
+	case NotFound:
+		return http.StatusNotFound
+	case ValidationFailed, AlreadyExists, FailedPrecondition:
+		return http.StatusUnprocessableEntity
+	case Conflict:
+		return http.StatusConflict
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case Canceled:
+		return StatusClientClosedRequest
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GRPCCode returns the gRPC status code of a Code. Unknown codes are internal errors.
+func (c Code) GRPCCode() codes.Code {
+	switch c {
+	case InvalidPayload, InvalidArgument, ValidationFailed:
+		return codes.InvalidArgument
+	case Unauthenticated:
+		return codes.Unauthenticated
+	case PermissionDenied:
+		return codes.PermissionDenied
+	case NotFound:
+		return codes.NotFound
+	case AlreadyExists:
+		return codes.AlreadyExists
+	case FailedPrecondition:
+		return codes.FailedPrecondition
+	case Conflict:
+		return codes.Aborted
+	case ResourceExhausted:
+		return codes.ResourceExhausted
+	case Canceled:
+		return codes.Canceled
+	case Unavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// CodeFromStatus returns the Code of an HTTP status code, for responses not carrying one.
+func CodeFromStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return InvalidPayload
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusUnprocessableEntity:
+		return ValidationFailed
+	case http.StatusTooManyRequests:
+		return ResourceExhausted
+	case StatusClientClosedRequest:
+		return Canceled
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return Unavailable
+	default:
+		return Internal
+	}
+}
+
+// CodeFromGRPC returns the Code of a gRPC status code.
+func CodeFromGRPC(code codes.Code) Code {
+	switch code {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return InvalidArgument
+	case codes.Unauthenticated:
+		return Unauthenticated
+	case codes.PermissionDenied:
+		return PermissionDenied
+	case codes.NotFound:
+		return NotFound
+	case codes.AlreadyExists:
+		return AlreadyExists
+	case codes.FailedPrecondition:
+		return FailedPrecondition
+	case codes.Aborted:
+		return Conflict
+	case codes.ResourceExhausted:
+		return ResourceExhausted
+	case codes.Canceled:
+		return Canceled
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return Unavailable
+	default:
+		return Internal
+	}
+}
+
+// FieldError describes why a single field of a request payload was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors collects all FieldErrors found while validating a request payload.
+type FieldErrors []FieldError
+
+// Add appends a FieldError for field with a formatted message.
+func (e *FieldErrors) Add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fmt.Sprintf("%s: %s", fe.Field, fe.Message)
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// CheckRequired records a FieldError if s is empty.
+func (e *FieldErrors) CheckRequired(field, s string) {
+	if s == "" {
+		e.Add(field, "is required")
+	}
+}
+
+// CheckLength records a FieldError if s is longer than max characters.
+func (e *FieldErrors) CheckLength(field, s string, max int) {
+	if n := utf8.RuneCountInString(s); n > max {
+		e.Add(field, "must be at most %d characters long, got %d", max, n)
+	}
+}
+
+// CheckRange records a FieldError if n is outside of [min, max].
+func (e *FieldErrors) CheckRange(field string, n, min, max int) {
+	if n < min || n > max {
+		e.Add(field, "must be between %d and %d, got %d", min, max, n)
+	}
+}
+
+// Error is an error returned by an API. Detail and Fields are sent to the client, the wrapped Err
+// is only logged.
+type Error struct {
+	Code   Code
+	Detail string
+	Fields FieldErrors
+	Err    error
+}
+
+// New returns an Error with a formatted detail message.
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:   code,
+		Detail: fmt.Sprintf(format, args...),
+	}
+}
+
+// Wrap returns an Error with a formatted detail message, caused by err.
+func Wrap(err error, code Code, format string, args ...interface{}) *Error {
+	e := New(code, format, args...)
+	e.Err = err
+	return e
+}
+
+// WithFields returns a copy of e rejecting the passed fields.
+func (e *Error) WithFields(fields FieldErrors) *Error {
+	c := *e
+	c.Fields = fields
+	return &c
+}
+
+// Status returns the HTTP status code of e.
+func (e *Error) Status() int {
+	return e.Code.Status()
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Code, e.Detail)
+	if len(e.Fields) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Fields)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+// Unwrap returns the error causing e.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// From returns the first Error in the chain of err, following both Unwrap and the Cause of
+// github.com/pkg/errors. Any other error is wrapped as Internal error. From returns nil for nil.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	for next := err; next != nil; {
+		if e, ok := next.(*Error); ok {
+			return e
+		}
+		switch u := next.(type) {
+		case interface{ Unwrap() error }:
+			next = u.Unwrap()
+		case interface{ Cause() error }:
+			next = u.Cause()
+		default:
+			next = nil
+		}
+	}
+	return Wrap(err, Internal, "internal server error")
+}
+
+// CodeOf returns the Code of err, which is Internal for errors not wrapping an Error and empty
+// for nil.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	return From(err).Code
+}
+
+// Is returns true if err is an Error with the passed Code.
+func Is(err error, code Code) bool {
+	return err != nil && CodeOf(err) == code
+}
@@ -0,0 +1,192 @@
+package apierr
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCode(t *testing.T) {
+	var tests = []struct {
+		code   Code
+		status int
+		grpc   codes.Code
+	}{
+		{InvalidPayload, http.StatusBadRequest, codes.InvalidArgument},
+		{InvalidArgument, http.StatusBadRequest, codes.InvalidArgument},
+		{ValidationFailed, http.StatusUnprocessableEntity, codes.InvalidArgument},
+		{Unauthenticated, http.StatusUnauthorized, codes.Unauthenticated},
+		{PermissionDenied, http.StatusForbidden, codes.PermissionDenied},
+		{NotFound, http.StatusNotFound, codes.NotFound},
+		{AlreadyExists, http.StatusUnprocessableEntity, codes.AlreadyExists},
+		{FailedPrecondition, http.StatusUnprocessableEntity, codes.FailedPrecondition},
+		{Conflict, http.StatusConflict, codes.Aborted},
+		{ResourceExhausted, http.StatusTooManyRequests, codes.ResourceExhausted},
+		{Canceled, StatusClientClosedRequest, codes.Canceled},
+		{Unavailable, http.StatusServiceUnavailable, codes.Unavailable},
+		{Internal, http.StatusInternalServerError, codes.Internal},
+		{Code("unknown"), http.StatusInternalServerError, codes.Internal},
+	}
+
+	for _, tt := range tests {
+		if got := tt.code.Status(); got != tt.status {
+			t.Errorf("%s.Status() = %d, want %d", tt.code, got, tt.status)
+		}
+		if got := tt.code.GRPCCode(); got != tt.grpc {
+			t.Errorf("%s.GRPCCode() = %s, want %s", tt.code, got, tt.grpc)
+		}
+
+		// Both transports report the same status for the same error
+		err := GRPCStatus(New(tt.code, "failed"))
+		if got := StatusFromGRPC(err); got != tt.status {
+			t.Errorf("StatusFromGRPC() of %s = %d, want %d", tt.code, got, tt.status)
+		}
+		if got := FromGRPC(err).Code; got != tt.code {
+			t.Errorf("FromGRPC() of %s has code %s", tt.code, got)
+		}
+	}
+	if got := StatusFromGRPC(nil); got != http.StatusOK {
+		t.Errorf("StatusFromGRPC(nil) = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	t.Run("Typed errors", func(t *testing.T) {
+		cause := errors.New("connection refused")
+		e := Wrap(cause, NotFound, "item %s doesn't exist", "abc")
+
+		for _, err := range []error{e, errors.Wrap(e, "unable to get item")} {
+			if got := From(err); got != e {
+				t.Errorf("From(%s) = %#v, want %#v", err, got, e)
+			}
+			if !Is(err, NotFound) {
+				t.Errorf("Is(%s, %s) = false", err, NotFound)
+			}
+		}
+		if got := CodeOf(Wrap(e, Internal, "outer")); got != Internal {
+			t.Errorf("code of outer error is %s, want %s", got, Internal)
+		}
+		if e.Unwrap() != cause {
+			t.Errorf("Unwrap() = %v, want %v", e.Unwrap(), cause)
+		}
+	})
+
+	t.Run("Untyped errors", func(t *testing.T) {
+		cause := errors.New("boom")
+		e := From(cause)
+		if e.Code != Internal || e.Err != cause {
+			t.Errorf("From(%s) = %#v, want internal error wrapping it", cause, e)
+		}
+		if strings.Contains(e.Detail, "boom") {
+			t.Errorf("detail %#v of internal error exposes its cause", e.Detail)
+		}
+		if From(nil) != nil || CodeOf(nil) != "" || Is(nil, Internal) {
+			t.Errorf("nil error isn't an Error")
+		}
+	})
+
+	t.Run("Fields", func(t *testing.T) {
+		var fields FieldErrors
+		fields.CheckRequired("name", "")
+		fields.CheckRange("qty", -1, 0, 10)
+		fields.CheckLength("desc", "abc", 2)
+
+		e := New(ValidationFailed, "invalid items")
+		withFields := e.WithFields(fields)
+		if len(e.Fields) != 0 {
+			t.Errorf("WithFields modified the original error")
+		}
+		if !reflect.DeepEqual(withFields.Fields, fields) {
+			t.Errorf("fields are %v, want %v", withFields.Fields, fields)
+		}
+		if !strings.Contains(withFields.Error(), "qty: must be between 0 and 10, got -1") {
+			t.Errorf("error message %#v doesn't contain field errors", withFields.Error())
+		}
+	})
+}
+
+func TestDecode(t *testing.T) {
+	var tests = []struct {
+		name   string
+		status int
+		body   string
+		want   *Error
+	}{
+		{
+			"Problem",
+			http.StatusUnprocessableEntity,
+			`{"type": "about:blank", "title": "Unprocessable Entity", "status": 422, "detail": "unable to create items", "code": "already_exists", "errors": [{"field": "[0].name", "message": "item abc already exists"}]}`,
+			&Error{Code: AlreadyExists, Detail: "unable to create items", Fields: FieldErrors{{"[0].name", "item abc already exists"}}},
+		},
+		{
+			"Problem without code",
+			http.StatusNotFound,
+			`{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "resource not found"}`,
+			&Error{Code: NotFound, Detail: "resource not found"},
+		},
+		{
+			"Plain text",
+			http.StatusServiceUnavailable,
+			"upstream connect error\n",
+			&Error{Code: Unavailable, Detail: "upstream connect error"},
+		},
+		{
+			"Empty body",
+			http.StatusInternalServerError,
+			"",
+			&Error{Code: Internal, Detail: "Internal Server Error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Decode(tt.status, []byte(tt.body)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+
+			resp := &http.Response{StatusCode: tt.status, Body: ioutil.NopCloser(strings.NewReader(tt.body))}
+			if err := FromResponse(resp); !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("FromResponse() = %#v, want %#v", err, tt.want)
+			}
+		})
+	}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{}"))}
+	if err := FromResponse(resp); err != nil {
+		t.Errorf("FromResponse() of successful response returned %s", err)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	err := GRPCStatus(Wrap(errors.New("secret cause"), NotFound, "item abc doesn't exist"))
+	s, _ := status.FromError(err)
+	if s.Code() != codes.NotFound || s.Message() != "item abc doesn't exist" {
+		t.Errorf("GRPCStatus() = %s, want NotFound without cause", s)
+	}
+
+	if e := FromGRPC(err); e.Code != NotFound || e.Detail != "item abc doesn't exist" {
+		t.Errorf("FromGRPC() = %#v, want the original error", e)
+	}
+	if e := FromGRPC(status.Error(codes.Unavailable, "connection refused")); e.Code != Unavailable {
+		t.Errorf("FromGRPC(Unavailable) has code %s, want %s", e.Code, Unavailable)
+	}
+	if e := FromGRPC(errors.New("no status")); e.Code != Unavailable {
+		t.Errorf("FromGRPC() of error without status has code %s, want %s", e.Code, Unavailable)
+	}
+	fields := FieldErrors{{"[0].name", "can't be empty"}}
+	if e := FromGRPC(GRPCStatus(New(ValidationFailed, "invalid items").WithFields(fields))); e.Code != ValidationFailed || !reflect.DeepEqual(e.Fields, fields) {
+		t.Errorf("FromGRPC() = %#v, want %s with fields %v", e, ValidationFailed, fields)
+	}
+	if e := FromGRPC(status.Error(codes.FailedPrecondition, "not enough units")); e.Code != FailedPrecondition {
+		t.Errorf("FromGRPC(FailedPrecondition) has code %s, want %s", e.Code, FailedPrecondition)
+	}
+	if GRPCStatus(nil) != nil || FromGRPC(nil) != nil {
+		t.Errorf("nil error isn't converted to nil")
+	}
+}
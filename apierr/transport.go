@@ -0,0 +1,131 @@
+package apierr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// problem holds the members of an RFC 7807 problem details response needed to restore an Error.
+type problem struct {
+	Status int         `json:"status"`
+	Detail string      `json:"detail"`
+	Code   Code        `json:"code"`
+	Errors FieldErrors `json:"errors"`
+}
+
+// Decode restores the Error sent as problem details with an HTTP status code. If body isn't a
+// problem, the Code is derived from the status and body becomes the detail.
+func Decode(status int, body []byte) *Error {
+	var p problem
+	if err := json.Unmarshal(body, &p); err != nil || p.Status == 0 {
+		detail := strings.TrimSpace(string(body))
+		if detail == "" {
+			detail = http.StatusText(status)
+		}
+		return New(CodeFromStatus(status), "%s", detail)
+	}
+
+	code := p.Code
+	if code == "" {
+		code = CodeFromStatus(status)
+	}
+	return &Error{
+		Code:   code,
+		Detail: p.Detail,
+		Fields: p.Errors,
+	}
+}
+
+// FromResponse returns the Error of a response with a status code of 400 or higher and nil
+// otherwise. The body of failed responses is consumed.
+func FromResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Wrap(errors.Wrap(err, "unable to read response"), CodeFromStatus(resp.StatusCode), "%s", http.StatusText(resp.StatusCode))
+	}
+	return Decode(resp.StatusCode, b)
+}
+
+// errorDomain is the domain of the ErrorInfo details carrying the Code of an Error.
+const errorDomain = "micro-obs"
+
+// GRPCStatus returns the gRPC status error of err, to be returned by gRPC handlers. Only the
+// detail of an Error is sent, causes are left out. The Code is sent as ErrorInfo details, so it
+// survives codes shared by several Codes, and field errors as BadRequest details.
+func GRPCStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	e := From(err)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: string(e.Code), Domain: errorDomain}}
+	if len(e.Fields) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, fe := range e.Fields {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Field,
+				Description: fe.Message,
+			})
+		}
+		details = append(details, br)
+	}
+
+	s := status.New(e.Code.GRPCCode(), e.Detail)
+	if withDetails, err := s.WithDetails(details...); err == nil {
+		s = withDetails
+	}
+	return s.Err()
+}
+
+// FromGRPC restores the Error of a gRPC status error, including the field errors of its BadRequest
+// details. Errors not carrying a status, e.g. of a
+// failed connection, are Unavailable errors.
+func FromGRPC(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	s, ok := status.FromError(err)
+	if !ok {
+		return Wrap(err, Unavailable, "unable to reach service")
+	}
+	if s.Code() == codes.Unavailable {
+		return Wrap(err, Unavailable, "%s", s.Message())
+	}
+
+	e := New(CodeFromGRPC(s.Code()), "%s", s.Message())
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.GetDomain() == errorDomain {
+				e.Code = Code(d.GetReason())
+			}
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				e.Fields.Add(v.GetField(), "%s", v.GetDescription())
+			}
+		}
+	}
+	return e
+}
+
+// StatusFromGRPC returns the HTTP status of a gRPC call failing with err, which is the status of
+// the Code restored by FromGRPC, so metrics and spans of calls are labeled like those of HTTP
+// requests. It's 200 for nil.
+func StatusFromGRPC(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return FromGRPC(err).Status()
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/obitech/micro-obs/apierr"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -111,6 +112,9 @@ func workerData(id int, jobs <-chan dataRequest, jobsDone chan<- bool) {
 				errExit(err)
 			}
 			req.Header.Add("Content-Type", "application/JSON; charset=UTF-8")
+			if token != "" {
+				req.Header.Add("Authorization", "Bearer "+token)
+			}
 
 			vl(fmt.Sprintf("Worker %d -> %s %s\n%s\n", id, dr.method, dr.url, dr.data))
 
@@ -127,8 +131,10 @@ func workerData(id int, jobs <-chan dataRequest, jobsDone chan<- bool) {
 				fallthrough
 			case http.StatusCreated:
 				vl(fmt.Sprintf("%s", string(b)))
+			case http.StatusMultiStatus:
+				fmt.Printf("Request to %s partially failed: %s\n", dr.url, string(b))
 			default:
-				fmt.Printf("Unexpected status code %d: %s", res.StatusCode, b)
+				fmt.Printf("Request to %s failed with status %d: %s\n", dr.url, res.StatusCode, apierr.Decode(res.StatusCode, b))
 				os.Exit(1)
 			}
 		}
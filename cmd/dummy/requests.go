@@ -127,7 +127,12 @@ func workerRequest(id int, jobs <-chan string, jobsDone chan<- bool) {
 		start := time.Now()
 		vl(fmt.Sprintf("Worker %d -> %s\n", id, url))
 
-		_, err := http.Get(url)
+		req, err := http.NewRequest("GET", url, nil)
+		errExit(err)
+		if token != "" {
+			req.Header.Add("Authorization", "Bearer "+token)
+		}
+		_, err = http.DefaultClient.Do(req)
 		errExit(err)
 		time.Sleep(time.Duration(wait) * time.Millisecond)
 
@@ -16,6 +16,7 @@ var (
 	allowedTargets = map[string]bool{"item": true, "order": true, "all": true}
 	itemAddr       = "http://localhost:8080"
 	orderAddr      = "http://localhost:8090"
+	token          = ""
 	verbose        bool
 )
 
@@ -25,6 +26,7 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVarP(&itemAddr, "item-addr", "i", itemAddr, "address of the item service")
 	rootCmd.PersistentFlags().StringVarP(&orderAddr, "order-addr", "o", orderAddr, "address of the order service")
+	rootCmd.PersistentFlags().StringVarP(&token, "token", "t", token, "API key or JWT to authenticate with")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 }
 
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/obitech/micro-obs/gateway"
+	"github.com/obitech/micro-obs/util"
+	"github.com/spf13/cobra"
+)
+
+func runServer(cmd *cobra.Command, args []string) {
+	s, err := gateway.NewServer(
+		util.SetServerAddress(address),
+		util.SetServerEndpoint(endpoint),
+		util.SetLogConfig(logConfig),
+		util.SetLogLevel(logLevel),
+		util.SetLogLevelConfig(logLevelConfig),
+		util.SetRedactionConfig(redactionConfig),
+		util.SetAPIKeysFile(apiKeys),
+		util.SetJWKSFile(jwks, jwtIssuer, jwtAudience),
+		util.SetInsecureNoAuth(insecureNoAuth),
+		util.SetTracing(tracing),
+		util.SetPropagation(propagation),
+		util.SetSampler(samplerType, samplerParam, samplerURL),
+		util.SetSamplingOverrides(samplingRoutes),
+		util.SetSampleErrors(sampleErrors),
+		util.SetDrainPeriod(drainPeriod),
+		util.SetShutdownTimeout(shutdownTimeout),
+		util.SetRateLimit(rateLimit, rateBurst),
+		util.SetClientRateLimit(clientRateLimit, clientRateBurst),
+		util.SetMaxInFlight(maxInFlight),
+		util.SetRateLimitRedis(rateLimitRedis),
+		gateway.SetOrderServiceAddress(order),
+		gateway.SetItemServiceGRPCAddress(itemGRPC),
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	s.InitPromReg()
+
+	if err := s.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(3)
+	}
+}
@@ -4,16 +4,41 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/obitech/micro-obs/util"
 	"github.com/spf13/cobra"
 )
 
 // Default values to be used to initialize the item server
 var (
-	address  = ":8080"
-	endpoint = "127.0.0.1:8081"
-	logLevel = "info"
-	redis    = "redis://127.0.0.1:6379/0"
-	rootCmd  = &cobra.Command{
+	address         = ":8080"
+	grpcAddress     = ":9080"
+	endpoint        = "127.0.0.1:8081"
+	logLevel        = "info"
+	logLevelConfig  = ""
+	logConfig       = util.DefaultLogConfig()
+	redactionConfig = ""
+	apiKeys         = ""
+	jwks            = ""
+	jwtIssuer       = ""
+	jwtAudience     = ""
+	insecureNoAuth  = false
+	tracing         = "jaeger"
+	propagation     = "jaeger,w3c"
+	samplerType     = ""
+	samplerParam    = 1.0
+	samplerURL      = ""
+	samplingRoutes  = "/healthz=never,/livez=never,/readyz=never"
+	sampleErrors    = true
+	drainPeriod     = util.DefaultDrainPeriod
+	shutdownTimeout = util.DefaultShutdownTimeout
+	rateLimit       = 0.0
+	rateBurst       = 0
+	clientRateLimit = 0.0
+	clientRateBurst = 0
+	maxInFlight     = 0
+	rateLimitRedis  = ""
+	redis           = "redis://127.0.0.1:6379/0"
+	rootCmd         = &cobra.Command{
 		Use:   "item",
 		Short: "Simple HTTP item serivce",
 		Run:   runServer,
@@ -31,7 +56,40 @@ func Execute() {
 func init() {
 	f := rootCmd.Flags()
 	f.StringVarP(&address, "address", "a", address, "listening address")
+	f.StringVarP(&grpcAddress, "grpc-address", "g", grpcAddress, "gRPC listening address")
 	f.StringVarP(&endpoint, "endpoint", "e", endpoint, "endpoint for other services to reach item service")
 	f.StringVarP(&logLevel, "log-level", "l", logLevel, "log level (debug, info, warn, error), empty or invalid values will fallback to default")
+	f.StringVar(&logLevelConfig, "log-level-config", logLevelConfig, "JSON file with the log level and per-route overrides, reloaded on SIGHUP")
+	f.StringVar(&logConfig.Encoding, "log-format", logConfig.Encoding, "log encoding (json, console), console is meant for local development")
+	f.StringSliceVar(&logConfig.Outputs, "log-output", logConfig.Outputs, "comma-separated log outputs (stdout, stderr or file paths), files are rotated")
+	f.StringSliceVar(&logConfig.ErrorOutputs, "log-error-output", logConfig.ErrorOutputs, "comma-separated outputs for errors of the logger itself (stdout, stderr or file paths)")
+	f.IntVar(&logConfig.Rotation.MaxSize, "log-max-size", logConfig.Rotation.MaxSize, "size in megabytes after which log files are rotated")
+	f.DurationVar(&logConfig.Rotation.Interval, "log-rotate-interval", logConfig.Rotation.Interval, "rotate log files periodically, e.g. 24h, 0 rotates by size only")
+	f.DurationVar(&logConfig.Rotation.MaxAge, "log-max-age", logConfig.Rotation.MaxAge, "remove rotated log files older than this, rounded up to days, 0 keeps them")
+	f.IntVar(&logConfig.Rotation.MaxBackups, "log-max-backups", logConfig.Rotation.MaxBackups, "number of rotated log files to keep, 0 keeps all")
+	f.BoolVar(&logConfig.Rotation.Compress, "log-compress", logConfig.Rotation.Compress, "gzip rotated log files")
+	f.IntVar(&logConfig.Sampling.Initial, "log-sampling-initial", logConfig.Sampling.Initial, "log the first n entries with the same level and message per second, 0 disables sampling")
+	f.IntVar(&logConfig.Sampling.Thereafter, "log-sampling-thereafter", logConfig.Sampling.Thereafter, "log every n-th entry with the same level and message per second after the initial ones")
+	f.StringVar(&redactionConfig, "redaction-config", redactionConfig, "JSON file with the policy for redacting headers and fields from traces and logs")
+	f.StringVar(&apiKeys, "api-keys", apiKeys, "JSON file with the API keys accepted by private routes, see util.APIKey")
+	f.StringVar(&jwks, "jwks", jwks, "JSON Web Key Set file with the keys JWTs accepted by private routes are verified with")
+	f.StringVar(&jwtIssuer, "jwt-issuer", jwtIssuer, "required iss claim of JWTs, empty accepts any issuer")
+	f.StringVar(&jwtAudience, "jwt-audience", jwtAudience, "required aud claim of JWTs, empty accepts any audience")
+	f.BoolVar(&insecureNoAuth, "insecure-no-auth", insecureNoAuth, "serve private routes without credentials, only meant for local development and demos")
+	f.StringVar(&tracing, "tracing", tracing, "tracing backend (jaeger, otlp, none), otlp exports metrics as well, OTLP exporters are configured via the OTEL_EXPORTER_OTLP_* environment variables")
+	f.StringVar(&propagation, "propagation", propagation, "comma-separated trace context formats (w3c, b3, b3multi, jaeger), extracted in order of precedence and injected in all formats")
+	f.StringVar(&samplerType, "sampler-type", samplerType, "trace sampler (const, probabilistic, ratelimiting, remote), empty will fallback to the JAEGER_SAMPLER_* environment variables or sample all traces")
+	f.Float64Var(&samplerParam, "sampler-param", samplerParam, "sampler parameter: 0 or 1 for const, a probability for probabilistic, traces per second for ratelimiting")
+	f.StringVar(&samplerURL, "sampler-url", samplerURL, "sampling strategy endpoint queried by the remote sampler, e.g. http://127.0.0.1:5778/sampling")
+	f.StringVar(&samplingRoutes, "sampling-overrides", samplingRoutes, "comma-separated route=always|never pairs overriding the sampler per route pattern or name")
+	f.BoolVar(&sampleErrors, "sample-errors", sampleErrors, "sample requests failing with a server error even if their trace wasn't sampled")
+	f.DurationVar(&drainPeriod, "drain-period", drainPeriod, "time between failing readiness and shutting down, so load balancers stop sending requests")
+	f.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "time in-flight requests have to finish during a shutdown")
+	f.Float64Var(&rateLimit, "rate-limit", rateLimit, "requests per second each client may send to a route, 0 disables rate limiting")
+	f.IntVar(&rateBurst, "rate-burst", rateBurst, "requests a client may send to a route in a burst, 0 allows a second worth of requests")
+	f.Float64Var(&clientRateLimit, "client-rate-limit", clientRateLimit, "requests per second each IP address may send across all routes, checked before authentication, 0 disables it")
+	f.IntVar(&clientRateBurst, "client-rate-burst", clientRateBurst, "requests an IP address may send across all routes in a burst, 0 allows a second worth of requests")
+	f.IntVar(&maxInFlight, "max-in-flight", maxInFlight, "requests served concurrently before further ones are shed with a 503, 0 disables load shedding")
+	f.StringVar(&rateLimitRedis, "rate-limit-redis", rateLimitRedis, "redis address to share rate limits across replicas, e.g. redis://127.0.0.1:6379/1, empty keeps them in memory")
 	f.StringVarP(&redis, "redis-address", "r", redis, "redis address to connect to")
 }
@@ -5,15 +5,34 @@ import (
 	"os"
 
 	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/util"
 	"github.com/spf13/cobra"
 )
 
 func runServer(cmd *cobra.Command, args []string) {
 	s, err := item.NewServer(
-		item.SetServerAddress(address),
-		item.SetServerEndpoint(endpoint),
-		item.SetLogLevel(logLevel),
-		item.SetRedisAddress(redis),
+		util.SetServerAddress(address),
+		util.SetGRPCAddress(grpcAddress),
+		util.SetServerEndpoint(endpoint),
+		util.SetLogConfig(logConfig),
+		util.SetLogLevel(logLevel),
+		util.SetLogLevelConfig(logLevelConfig),
+		util.SetRedactionConfig(redactionConfig),
+		util.SetAPIKeysFile(apiKeys),
+		util.SetJWKSFile(jwks, jwtIssuer, jwtAudience),
+		util.SetInsecureNoAuth(insecureNoAuth),
+		util.SetTracing(tracing),
+		util.SetPropagation(propagation),
+		util.SetSampler(samplerType, samplerParam, samplerURL),
+		util.SetSamplingOverrides(samplingRoutes),
+		util.SetSampleErrors(sampleErrors),
+		util.SetDrainPeriod(drainPeriod),
+		util.SetShutdownTimeout(shutdownTimeout),
+		util.SetRateLimit(rateLimit, rateBurst),
+		util.SetClientRateLimit(clientRateLimit, clientRateBurst),
+		util.SetMaxInFlight(maxInFlight),
+		util.SetRateLimitRedis(rateLimitRedis),
+		util.SetRedisAddress(redis),
 	)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
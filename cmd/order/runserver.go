@@ -5,16 +5,37 @@ import (
 	"os"
 
 	"github.com/obitech/micro-obs/order"
+	"github.com/obitech/micro-obs/util"
 	"github.com/spf13/cobra"
 )
 
 func runServer(cmd *cobra.Command, args []string) {
 	s, err := order.NewServer(
-		order.SetServerAddress(address),
-		order.SetServerEndpoint(endpoint),
-		order.SetLogLevel(logLevel),
-		order.SetRedisAddress(redis),
+		util.SetServerAddress(address),
+		util.SetServerEndpoint(endpoint),
+		util.SetLogConfig(logConfig),
+		util.SetLogLevel(logLevel),
+		util.SetLogLevelConfig(logLevelConfig),
+		util.SetRedactionConfig(redactionConfig),
+		util.SetAPIKeysFile(apiKeys),
+		util.SetJWKSFile(jwks, jwtIssuer, jwtAudience),
+		util.SetInsecureNoAuth(insecureNoAuth),
+		util.SetTracing(tracing),
+		util.SetPropagation(propagation),
+		util.SetSampler(samplerType, samplerParam, samplerURL),
+		util.SetSamplingOverrides(samplingRoutes),
+		util.SetSampleErrors(sampleErrors),
+		util.SetDrainPeriod(drainPeriod),
+		util.SetShutdownTimeout(shutdownTimeout),
+		util.SetRateLimit(rateLimit, rateBurst),
+		util.SetClientRateLimit(clientRateLimit, clientRateBurst),
+		util.SetMaxInFlight(maxInFlight),
+		util.SetRateLimitRedis(rateLimitRedis),
+		util.SetRedisAddress(redis),
 		order.SetItemServiceAddress(item),
+		order.SetItemServiceGRPCAddress(itemGRPC),
+		order.SetItemTransport(itemTransport),
+		order.SetItemServiceToken(itemToken),
 	)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
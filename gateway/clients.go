@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/obitech/micro-obs/item/itempb"
+	"github.com/obitech/micro-obs/order"
+	"github.com/obitech/micro-obs/util"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/pkg/errors"
+)
+
+// queryOrders sends a GET request to the order service and returns the orders of the response.
+// A 404 is treated as an empty result.
+func (s *Server) queryOrders(ctx context.Context, path string) ([]*order.Order, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "queryOrders", ext.SpanKindRPCClient)
+	defer span.Finish()
+
+	url := fmt.Sprintf("%s%s", s.orderService, path)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create request to order service")
+	}
+
+	// Inject requestID
+	req.Header.Add("X-Request-ID", util.RequestIDFromContext(ctx))
+
+	// Forward credentials
+	util.SetAuthorization(ctx, req.Header, "")
+
+	// Inject tracer
+	ext.HTTPMethod.Set(span, "GET")
+	ext.HTTPUrl.Set(span, url)
+	span.Tracer().Inject(
+		span.Context(),
+		ot.HTTPHeaders,
+		ot.HTTPHeadersCarrier(req.Header),
+	)
+
+	resp, err := s.orders.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, apierr.Wrap(err, apierr.Unavailable, "unable to connect to order service")
+	}
+	defer resp.Body.Close()
+
+	if err := apierr.FromResponse(resp); err != nil {
+		if apierr.Is(err, apierr.NotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read response from order service")
+	}
+
+	var r order.Response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse response from order service")
+	}
+
+	return r.Data, nil
+}
+
+// getOrder retrieves a single order by ID. Returns nil if the order doesn't exist.
+func (s *Server) getOrder(ctx context.Context, id string) (*order.Order, error) {
+	orders, err := s.queryOrders(ctx, fmt.Sprintf("/orders/%s", id))
+	if err != nil || len(orders) == 0 {
+		return nil, err
+	}
+	return orders[0], nil
+}
+
+// getAllOrders retrieves all orders.
+func (s *Server) getAllOrders(ctx context.Context) ([]*order.Order, error) {
+	return s.queryOrders(ctx, "/orders")
+}
+
+// batchGetItems retrieves multiple items with a single call to the item service.
+func (s *Server) batchGetItems(ctx context.Context, ids []string) (map[string]*itempb.Item, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "batchGetItems")
+	defer span.Finish()
+	span.SetTag("count", len(ids))
+
+	res, err := s.items.BatchGet(ctx, &itempb.BatchGetRequest{Ids: ids})
+	if err != nil {
+		return nil, apierr.FromGRPC(err)
+	}
+
+	items := make(map[string]*itempb.Item, len(res.GetItems()))
+	for _, i := range res.GetItems() {
+		items[i.GetId()] = i
+	}
+	return items, nil
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/obitech/micro-obs/util"
+	ot "github.com/opentracing/opentracing-go"
+)
+
+// pong sends a simple JSON response.
+func (s *Server) pong() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := ot.StartSpanFromContext(r.Context(), "pong")
+		defer span.Finish()
+		s.Respond(ctx, http.StatusOK, "pong", w)
+	}
+}
+
+// graphqlRequest is the JSON body of a GraphQL query.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphql executes a GraphQL query sent as JSON body.
+func (s *Server) graphql() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := ot.StartSpanFromContext(r.Context(), "graphql")
+		defer span.Finish()
+		log := util.RequestIDLogger(s.Logger, r)
+
+		var params graphqlRequest
+
+		// Parse payload
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1048576)).Decode(&params); err != nil {
+			log.Errorw("unable to parse payload",
+				"error", err,
+			)
+			s.Respond(ctx, http.StatusBadRequest, "unable to parse payload", w)
+			return
+		}
+		defer r.Body.Close()
+
+		// Every request gets its own loader so items are only cached for a single query
+		ctx = withItemLoader(ctx, newItemLoader(s.batchGetItems))
+		res := s.schema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+		for _, err := range res.Errors {
+			log.Warnw("GraphQL error",
+				"error", err,
+			)
+		}
+
+		w.Header().Set("Content-Type", "application/JSON; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Errorw("unable to send response",
+				"error", err,
+			)
+		}
+	}
+}
+
+// Respond sends a JSON-encoded response.
+func (s *Server) Respond(ctx context.Context, status int, m string, w http.ResponseWriter) {
+	span, ctx := ot.StartSpanFromContext(ctx, "Respond")
+	defer span.Finish()
+	span.SetTag("status", status)
+	log := util.RequestIDLoggerFromContext(ctx, s.Logger)
+
+	res := Response{
+		Status:  status,
+		Message: m,
+	}
+	if err := res.SendJSON(w); err != nil {
+		log.Errorw("sending JSON response failed",
+			"error", err,
+			"response", res,
+		)
+	}
+	span.LogKV(
+		"message", m,
+	)
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+
+	"github.com/obitech/micro-obs/item/itempb"
+)
+
+type key int
+
+const itemLoaderKey key = iota
+
+// batchFunc retrieves multiple items at once. Missing items are not part of the returned map.
+type batchFunc func(ctx context.Context, ids []string) (map[string]*itempb.Item, error)
+
+// itemLoader collects item IDs and retrieves them with a single batched call to the item service.
+// IDs are registered ahead of time via prime, so resolving the items of many orders doesn't result
+// in one call per item.
+type itemLoader struct {
+	mu      sync.Mutex
+	fetch   batchFunc
+	pending map[string]bool
+	cache   map[string]*itempb.Item
+}
+
+func newItemLoader(fetch batchFunc) *itemLoader {
+	return &itemLoader{
+		fetch:   fetch,
+		pending: make(map[string]bool),
+		cache:   make(map[string]*itempb.Item),
+	}
+}
+
+// withItemLoader adds an itemLoader to a context.
+func withItemLoader(ctx context.Context, l *itemLoader) context.Context {
+	return context.WithValue(ctx, itemLoaderKey, l)
+}
+
+// itemLoaderFromContext extracts an itemLoader from a context.
+func itemLoaderFromContext(ctx context.Context) (*itemLoader, bool) {
+	l, ok := ctx.Value(itemLoaderKey).(*itemLoader)
+	return l, ok
+}
+
+// prime registers IDs to be retrieved with the next batch.
+func (l *itemLoader) prime(ids ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := l.cache[id]; !ok {
+			l.pending[id] = true
+		}
+	}
+}
+
+// load returns a single item, retrieving all pending items if it hasn't been retrieved yet.
+// A nil item is returned if the item doesn't exist.
+func (l *itemLoader) load(ctx context.Context, id string) (*itempb.Item, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if i, ok := l.cache[id]; ok {
+		return i, nil
+	}
+
+	l.pending[id] = true
+	ids := make([]string, 0, len(l.pending))
+	for k := range l.pending {
+		ids = append(ids, k)
+	}
+
+	items, err := l.fetch(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Missing items are cached as well to not query them again
+	for _, k := range ids {
+		l.cache[k] = items[k]
+		delete(l.pending, k)
+	}
+
+	return l.cache[id], nil
+}
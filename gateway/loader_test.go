@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/obitech/micro-obs/item/itempb"
+)
+
+func TestItemLoader(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls [][]string
+	)
+
+	fetch := func(ctx context.Context, ids []string) (map[string]*itempb.Item, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sort.Strings(ids)
+		calls = append(calls, ids)
+
+		items := make(map[string]*itempb.Item)
+		for _, id := range ids {
+			if id != "missing" {
+				items[id] = &itempb.Item{Id: id}
+			}
+		}
+		return items, nil
+	}
+
+	l := newItemLoader(fetch)
+	l.prime("a", "b", "c", "missing")
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"a", "b", "c", "a", "b", "c"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			i, err := l.load(context.Background(), id)
+			if err != nil {
+				t.Errorf("unable to load %s: %s", id, err)
+				return
+			}
+			if i.GetId() != id {
+				t.Errorf("load(%s) returned %v", id, i)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if i, err := l.load(context.Background(), "missing"); i != nil || err != nil {
+		t.Errorf("load(missing) = %v, %v, want nil, nil", i, err)
+	}
+
+	if len(calls) != 1 {
+		t.Fatalf("expected a single batch, got %d: %v", len(calls), calls)
+	}
+	if len(calls[0]) != 4 {
+		t.Errorf("expected batch of 4 IDs, got %v", calls[0])
+	}
+
+	// Unknown IDs trigger a new batch
+	if _, err := l.load(context.Background(), "d"); err != nil {
+		t.Errorf("unable to load d: %s", err)
+	}
+	if len(calls) != 2 {
+		t.Errorf("expected a second batch, got %d: %v", len(calls), calls)
+	}
+}
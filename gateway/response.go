@@ -0,0 +1,20 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Response defines an API response for non-GraphQL endpoints.
+type Response struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// SendJSON encodes a Response as JSON and sends it on a passed http.ResponseWriter.
+func (r Response) SendJSON(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/JSON; charset=UTF-8")
+	w.WriteHeader(r.Status)
+	err := json.NewEncoder(w).Encode(r)
+	return err
+}
@@ -0,0 +1,34 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/obitech/micro-obs/util"
+)
+
+// routes defines all HTTP routes of the service, hanging off the main Server struct.
+// Like that, all routes have access to the Server's dependencies.
+func (s *Server) routes() util.Routes {
+	res := Response{}
+	return util.Routes{
+		util.Route{
+			Name:        "pong",
+			Method:      "GET",
+			Pattern:     "/",
+			HandlerFunc: s.pong(),
+			Summary:     "Ping the service",
+			Responses:   map[int]interface{}{http.StatusOK: res},
+			Access:      util.Access{Public: true},
+		},
+		util.Route{
+			Name:        "graphql",
+			Method:      "POST",
+			Pattern:     "/graphql",
+			HandlerFunc: s.graphql(),
+			Summary:     "Execute a GraphQL query",
+			Request:     graphqlRequest{},
+			Responses:   map[int]interface{}{http.StatusOK: map[string]interface{}{}, http.StatusBadRequest: res},
+			Access:      util.Access{Roles: []string{util.RoleReader}},
+		},
+	}
+}
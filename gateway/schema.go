@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"context"
+	"strconv"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	gqltrace "github.com/graph-gophers/graphql-go/trace/opentracing"
+	"github.com/obitech/micro-obs/item/itempb"
+	"github.com/obitech/micro-obs/order"
+	"github.com/pkg/errors"
+)
+
+const schema = `
+	schema {
+		query: Query
+	}
+
+	type Query {
+		# Returns a single order by ID.
+		order(id: ID!): Order
+		# Returns all orders.
+		orders: [Order!]!
+		# Returns a single item by ID.
+		item(id: ID!): Item
+	}
+
+	type Order {
+		id: ID!
+		items: [OrderItem!]!
+	}
+
+	# An item of an order with the ordered quantity.
+	type OrderItem {
+		id: ID!
+		qty: Int!
+		# Full details of the ordered item, null if the item doesn't exist anymore.
+		item: Item
+	}
+
+	type Item {
+		id: ID!
+		name: String!
+		desc: String!
+		# Current stock of the item.
+		qty: Int!
+	}
+`
+
+// newSchema parses the GraphQL schema with the resolvers attached to the passed Server.
+func newSchema(s *Server) (*graphql.Schema, error) {
+	return graphql.ParseSchema(schema, &rootResolver{s: s}, graphql.Tracer(gqltrace.Tracer{}))
+}
+
+// rootResolver resolves the Query type.
+type rootResolver struct {
+	s *Server
+}
+
+// Order resolves a single order. All items of the order are fetched in a single batch.
+func (r *rootResolver) Order(ctx context.Context, args struct{ ID graphql.ID }) (*orderResolver, error) {
+	o, err := r.s.getOrder(ctx, string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, nil
+	}
+
+	res := newOrderResolvers(ctx, o)
+	return res[0], nil
+}
+
+// Orders resolves all orders. The items of all orders are fetched in a single batch.
+func (r *rootResolver) Orders(ctx context.Context) ([]*orderResolver, error) {
+	orders, err := r.s.getAllOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newOrderResolvers(ctx, orders...), nil
+}
+
+// Item resolves a single item.
+func (r *rootResolver) Item(ctx context.Context, args struct{ ID graphql.ID }) (*itemResolver, error) {
+	l, ok := itemLoaderFromContext(ctx)
+	if !ok {
+		return nil, errors.New("no item loader present")
+	}
+
+	i, err := l.load(ctx, string(args.ID))
+	if err != nil || i == nil {
+		return nil, err
+	}
+	return &itemResolver{i}, nil
+}
+
+// newOrderResolvers creates resolvers for orders and registers all of their items with the item loader.
+func newOrderResolvers(ctx context.Context, orders ...*order.Order) []*orderResolver {
+	l, _ := itemLoaderFromContext(ctx)
+
+	res := make([]*orderResolver, len(orders))
+	for i, o := range orders {
+		if l != nil {
+			for _, oi := range o.Items {
+				l.prime(oi.ID)
+			}
+		}
+		res[i] = &orderResolver{o}
+	}
+	return res
+}
+
+// orderResolver resolves the Order type.
+type orderResolver struct {
+	o *order.Order
+}
+
+func (r *orderResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(r.o.ID, 10))
+}
+
+func (r *orderResolver) Items() []*orderItemResolver {
+	res := make([]*orderItemResolver, len(r.o.Items))
+	for i, oi := range r.o.Items {
+		res[i] = &orderItemResolver{oi}
+	}
+	return res
+}
+
+// orderItemResolver resolves the OrderItem type.
+type orderItemResolver struct {
+	i *order.Item
+}
+
+func (r *orderItemResolver) ID() graphql.ID {
+	return graphql.ID(r.i.ID)
+}
+
+func (r *orderItemResolver) Qty() int32 {
+	return int32(r.i.Qty)
+}
+
+func (r *orderItemResolver) Item(ctx context.Context) (*itemResolver, error) {
+	l, ok := itemLoaderFromContext(ctx)
+	if !ok {
+		return nil, errors.New("no item loader present")
+	}
+
+	i, err := l.load(ctx, r.i.ID)
+	if err != nil || i == nil {
+		return nil, err
+	}
+	return &itemResolver{i}, nil
+}
+
+// itemResolver resolves the Item type.
+type itemResolver struct {
+	i *itempb.Item
+}
+
+func (r *itemResolver) ID() graphql.ID {
+	return graphql.ID(r.i.GetId())
+}
+
+func (r *itemResolver) Name() string {
+	return r.i.GetName()
+}
+
+func (r *itemResolver) Desc() string {
+	return r.i.GetDesc()
+}
+
+func (r *itemResolver) Qty() int32 {
+	return int32(r.i.GetQty())
+}
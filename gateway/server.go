@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/obitech/micro-obs/item/itempb"
+	"github.com/obitech/micro-obs/util"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+const (
+	serviceName = "gateway"
+	apiVersion  = "1.0.0"
+)
+
+// Server is the gateway, serving a GraphQL API backed by the order and item services.
+type Server struct {
+	*util.BaseServer
+	orderService    string
+	itemServiceGRPC string
+	orders          *http.Client
+	items           itempb.ItemServiceClient
+	itemConn        *grpc.ClientConn
+	schema          *graphql.Schema
+}
+
+// NewServer creates a new Server according to options.
+func NewServer(options ...util.ServerOption) (*Server, error) {
+	base, err := util.NewBaseServer(serviceName, apiVersion, ":8070", "http://127.0.0.1:8070")
+	if err != nil {
+		return nil, err
+	}
+
+	// Sane defaults
+	s := &Server{
+		BaseServer:      base,
+		orderService:    "http://127.0.0.1:8090",
+		itemServiceGRPC: "127.0.0.1:9080",
+		orders:          &http.Client{},
+	}
+
+	// Applying custom settings
+	if err := util.Configure(s, options...); err != nil {
+		return nil, err
+	}
+
+	// Connecting to the item service, forwarding the credentials of requests
+	s.itemConn, err = util.DialGRPC(s.itemServiceGRPC, "")
+	if err != nil {
+		return nil, err
+	}
+	s.items = itempb.NewItemServiceClient(s.itemConn)
+	s.AddCloser("items", s.itemConn)
+
+	// Parsing schema
+	s.schema, err = newSchema(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse GraphQL schema")
+	}
+
+	// Setting routes
+	s.HandleRoutes(s.routes(), nil)
+
+	return s, nil
+}
+
+// SetOrderServiceAddress sets the address to reach the Order service.
+func SetOrderServiceAddress(address string) util.ServerOption {
+	return util.ServiceOption(func(s *Server) error {
+		if _, err := url.Parse(address); err != nil {
+			return err
+		}
+		s.orderService = address
+		return nil
+	})
+}
+
+// SetItemServiceGRPCAddress sets the address to reach the Item service's gRPC API.
+func SetItemServiceGRPCAddress(address string) util.ServerOption {
+	return util.ServiceOption(func(s *Server) error {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return err
+		}
+		s.itemServiceGRPC = address
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/order"
+	"github.com/obitech/micro-obs/util"
+)
+
+var (
+	basicEndpoints = []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"GET", "/", http.StatusOK},
+		{"GET", "/healthz", http.StatusOK},
+		{"GET", "/livez", http.StatusOK},
+		{"GET", "/readyz", http.StatusOK},
+		{"GET", "/openapi.json", http.StatusOK},
+		{"GET", "/asdasd", http.StatusNotFound},
+		{"GET", "/metrics", http.StatusOK},
+		{"GET", "/graphql", http.StatusMethodNotAllowed},
+		{"POST", "/graphql", http.StatusBadRequest},
+	}
+)
+
+// helperPrepareServices starts an item and an order service backed by miniredis and returns a gateway
+// connected to both.
+func helperPrepareServices(t *testing.T) (*Server, func()) {
+	imr, _ := miniredis.Run()
+	omr, _ := miniredis.Run()
+
+	is, err := item.NewServer(
+		util.SetInsecureNoAuth(true),
+		util.SetRedisAddress(strings.Join([]string{"redis://", imr.Addr()}, "")),
+	)
+	if err != nil {
+		t.Fatalf("unable to create item server: %s", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		desc string
+		qty  int
+	}{
+		{"banana", "a yellow fruit", 5},
+		{"water", "bottles of water", 10},
+	} {
+		i, _ := item.NewItem(tt.name, tt.desc, tt.qty)
+		if err := is.RedisSetItem(context.Background(), i); err != nil {
+			t.Fatalf("unable to store item: %s", err)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create listener: %s", err)
+	}
+	go is.ServeGRPC(l)
+
+	os, err := order.NewServer(
+		util.SetInsecureNoAuth(true),
+		util.SetRedisAddress(strings.Join([]string{"redis://", omr.Addr()}, "")),
+	)
+	if err != nil {
+		t.Fatalf("unable to create order server: %s", err)
+	}
+	banana, _ := item.NewItem("banana", "", 0)
+	water, _ := item.NewItem("water", "", 0)
+	o, _ := order.NewOrder(1, &order.Item{ID: banana.ID, Qty: 2}, &order.Item{ID: water.ID, Qty: 3}, &order.Item{ID: "gone", Qty: 1})
+	if err := os.RedisSetOrder(context.Background(), o); err != nil {
+		t.Fatalf("unable to store order: %s", err)
+	}
+	hs := httptest.NewServer(os)
+
+	s, err := NewServer(
+		util.SetInsecureNoAuth(true),
+		SetOrderServiceAddress(hs.URL),
+		SetItemServiceGRPCAddress(l.Addr().String()),
+	)
+	if err != nil {
+		t.Fatalf("unable to create gateway: %s", err)
+	}
+
+	return s, func() {
+		s.itemConn.Close()
+		hs.Close()
+		l.Close()
+		imr.Close()
+		omr.Close()
+	}
+}
+
+func helperQuery(s *Server, query string, t *testing.T) map[string]interface{} {
+	js, _ := json.Marshal(map[string]string{"query": query})
+	req, err := http.NewRequest("POST", "/graphql", bytes.NewBuffer(js))
+	if err != nil {
+		t.Fatalf("unable to create request: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	b, _ := ioutil.ReadAll(w.Result().Body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("wrong status code on query %s. Got: %d, want: %d, received: %s", query, w.Code, http.StatusOK, b)
+	}
+
+	var res struct {
+		Data   map[string]interface{}   `json:"data"`
+		Errors []map[string]interface{} `json:"errors"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatalf("unable to parse response %s: %s", b, err)
+	}
+	if len(res.Errors) > 0 {
+		t.Errorf("query %s returned errors: %v", query, res.Errors)
+	}
+
+	return res.Data
+}
+
+func TestEndpoints(t *testing.T) {
+	s, done := helperPrepareServices(t)
+	defer done()
+
+	for _, tt := range basicEndpoints {
+		req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBuffer([]byte{}))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("wrong status code on request %#v %#v. Got: %d, want: %d", tt.method, tt.path, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestQueries(t *testing.T) {
+	s, done := helperPrepareServices(t)
+	defer done()
+
+	t.Run("Order with item details", func(t *testing.T) {
+		data := helperQuery(s, `{ order(id: 1) { id items { qty item { name desc qty } } } }`, t)
+
+		js, _ := json.Marshal(data)
+		want := `{"order":{"id":"1","items":[{"item":{"desc":"a yellow fruit","name":"banana","qty":5},"qty":2},{"item":{"desc":"bottles of water","name":"water","qty":10},"qty":3},{"item":null,"qty":1}]}}`
+		if string(js) != want {
+			t.Errorf("got %s, want %s", js, want)
+		}
+	})
+
+	t.Run("Missing order", func(t *testing.T) {
+		data := helperQuery(s, `{ order(id: 42) { id } }`, t)
+		if data["order"] != nil {
+			t.Errorf("expected null order, got %v", data["order"])
+		}
+	})
+
+	t.Run("All orders", func(t *testing.T) {
+		data := helperQuery(s, `{ orders { id items { item { name } } } }`, t)
+		orders, ok := data["orders"].([]interface{})
+		if !ok || len(orders) != 1 {
+			t.Errorf("expected a single order, got %v", data["orders"])
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package item
+
+import (
+	"context"
+
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/obitech/micro-obs/item/itempb"
+	"github.com/obitech/micro-obs/util"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+)
+
+// grpcAccess declares who may call the methods of the ItemService, like the HTTP routes.
+var grpcAccess = map[string]util.Access{
+	itempb.ItemService_Get_FullMethodName:      {Roles: []string{util.RoleReader}, Scopes: []string{ScopeRead}},
+	itempb.ItemService_BatchGet_FullMethodName: {Roles: []string{util.RoleReader}, Scopes: []string{ScopeRead}},
+	itempb.ItemService_List_FullMethodName:     {Roles: []string{util.RoleReader}, Scopes: []string{ScopeRead}},
+	itempb.ItemService_Upsert_FullMethodName:   {Roles: []string{util.RoleOperator}, Scopes: []string{ScopeWrite}},
+	itempb.ItemService_Reserve_FullMethodName:  {Roles: []string{util.RoleOperator}, Scopes: []string{ScopeWrite}},
+	itempb.ItemService_Delete_FullMethodName:   {Roles: []string{util.RoleAdmin}, Scopes: []string{ScopeWrite}},
+}
+
+// grpcServer implements itempb.ItemServiceServer on top of the Server's storage layer. Like the HTTP
+// handlers, methods return apierr errors, which util.GRPCErrorInterceptor sends as status.
+type grpcServer struct {
+	itempb.UnimplementedItemServiceServer
+	s *Server
+}
+
+// ItemToProto converts an Item into its protobuf representation.
+func ItemToProto(i *Item) *itempb.Item {
+	return &itempb.Item{
+		Id:   i.ID,
+		Name: i.Name,
+		Desc: i.Desc,
+		Qty:  int64(i.Qty),
+	}
+}
+
+// ItemFromProto converts a protobuf Item into an Item.
+func ItemFromProto(i *itempb.Item) *Item {
+	return &Item{
+		ID:   i.GetId(),
+		Name: i.GetName(),
+		Desc: i.GetDesc(),
+		Qty:  int(i.GetQty()),
+	}
+}
+
+// Get retrieves a single Item by ID.
+func (g *grpcServer) Get(ctx context.Context, req *itempb.GetRequest) (*itempb.Item, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "getItem")
+	defer span.Finish()
+
+	item, err := g.s.RedisGetItem(ctx, req.GetId())
+	if err != nil {
+		return nil, apierr.Wrap(errors.Wrapf(err, "unable to get key %s from redis", req.GetId()), apierr.Internal, "unable to retrieve item")
+	}
+	if item == nil {
+		return nil, apierr.New(apierr.NotFound, "item with ID %s doesn't exist", req.GetId())
+	}
+
+	return ItemToProto(item), nil
+}
+
+// BatchGet retrieves multiple Items by ID.
+func (g *grpcServer) BatchGet(ctx context.Context, req *itempb.BatchGetRequest) (*itempb.BatchGetResponse, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "batchGetItems")
+	defer span.Finish()
+
+	res := &itempb.BatchGetResponse{}
+	for _, id := range req.GetIds() {
+		item, err := g.s.RedisGetItem(ctx, id)
+		if err != nil {
+			return nil, apierr.Wrap(errors.Wrapf(err, "unable to get key %s from redis", id), apierr.Internal, "unable to retrieve items")
+		}
+		if item == nil {
+			res.Missing = append(res.Missing, id)
+			continue
+		}
+		res.Items = append(res.Items, ItemToProto(item))
+	}
+
+	return res, nil
+}
+
+// List streams all Items.
+func (g *grpcServer) List(req *itempb.ListRequest, stream itempb.ItemService_ListServer) error {
+	span, ctx := ot.StartSpanFromContext(stream.Context(), "getAllItems")
+	defer span.Finish()
+
+	const defaultErrMsg = "unable to retrieve items"
+	keys, err := g.s.RedisScanKeys(ctx)
+	if err != nil {
+		return apierr.Wrap(errors.Wrap(err, "unable to SCAN redis for keys"), apierr.Internal, defaultErrMsg)
+	}
+
+	for _, k := range keys {
+		item, err := g.s.RedisGetItem(ctx, k)
+		if err != nil {
+			return apierr.Wrap(errors.Wrapf(err, "unable to retrieve item %s", k), apierr.Internal, defaultErrMsg)
+		}
+		if item == nil {
+			continue
+		}
+
+		if err := stream.Send(ItemToProto(item)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Upsert creates or updates Items. The IDs are derived from the names, like with PUT /items.
+func (g *grpcServer) Upsert(ctx context.Context, req *itempb.UpsertRequest) (*itempb.UpsertResponse, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "setItem")
+	defer span.Finish()
+
+	const defaultErrMsg = "unable to create items"
+	if len(req.GetItems()) == 0 {
+		return nil, apierr.New(apierr.ValidationFailed, "items can't be empty")
+	}
+
+	items := make([]*Item, len(req.GetItems()))
+	for i, v := range req.GetItems() {
+		items[i] = ItemFromProto(v)
+	}
+
+	if errs := ValidateItems(items); len(errs) > 0 {
+		return nil, apierr.New(apierr.ValidationFailed, "invalid items").WithFields(errs)
+	}
+
+	for _, item := range items {
+		if err := item.SetID(ctx); err != nil {
+			return nil, apierr.Wrap(errors.Wrap(err, "unable to set item ID"), apierr.Internal, defaultErrMsg)
+		}
+	}
+
+	res := &itempb.UpsertResponse{}
+	for _, item := range items {
+		if err := g.s.RedisSetItem(ctx, item); err != nil {
+			return nil, apierr.Wrap(errors.Wrapf(err, "unable to create item %s in redis", item.ID), apierr.Internal, "unable to create item %s", item.ID)
+		}
+		res.Items = append(res.Items, ItemToProto(item))
+	}
+
+	return res, nil
+}
+
+// Delete removes a single Item by ID.
+func (g *grpcServer) Delete(ctx context.Context, req *itempb.DeleteRequest) (*itempb.DeleteResponse, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "delItem")
+	defer span.Finish()
+
+	if err := g.s.RedisDelItem(ctx, req.GetId()); err != nil {
+		return nil, apierr.Wrap(errors.Wrapf(err, "unable to delete key %s from redis", req.GetId()), apierr.Internal, "an error occured while trying to delete item")
+	}
+
+	return &itempb.DeleteResponse{}, nil
+}
+
+// Reserve decreases the stock of an Item.
+func (g *grpcServer) Reserve(ctx context.Context, req *itempb.ReserveRequest) (*itempb.Item, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "reserveItem")
+	defer span.Finish()
+
+	if req.GetQty() <= 0 {
+		return nil, apierr.New(apierr.InvalidArgument, "qty needs to be positive")
+	}
+
+	item, err := g.s.RedisReserveItem(ctx, req.GetId(), int(req.GetQty()))
+	switch {
+	case err == errInsufficientQty:
+		return nil, apierr.New(apierr.FailedPrecondition, "not enough units of %s available", req.GetId())
+	case err == errReserveConflict:
+		return nil, apierr.New(apierr.Conflict, "item %s is being reserved concurrently, retry later", req.GetId())
+	case err != nil:
+		return nil, apierr.Wrap(errors.Wrapf(err, "unable to reserve item %s", req.GetId()), apierr.Internal, "unable to reserve item")
+	case item == nil:
+		return nil, apierr.New(apierr.NotFound, "item with ID %s doesn't exist", req.GetId())
+	}
+
+	return ItemToProto(item), nil
+}
@@ -0,0 +1,228 @@
+package item
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/obitech/micro-obs/item/itempb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func helperPrepareGRPC(s *Server, t *testing.T) (itempb.ItemServiceClient, func()) {
+	conn, done := helperDialGRPC(s, t)
+	return itempb.NewItemServiceClient(conn), done
+}
+
+// helperDialGRPC serves s via an in-memory listener and returns a connection to it.
+func helperDialGRPC(s *Server, t *testing.T) (*grpc.ClientConn, func()) {
+	l := bufconn.Listen(1 << 20)
+	go s.ServeGRPC(l)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("unable to dial gRPC server: %s", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		s.GRPCServer().Stop()
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	mr, s := helperPrepareRedis(t)
+	defer mr.Close()
+
+	c, done := helperPrepareGRPC(s, t)
+	defer done()
+	ctx := context.Background()
+
+	var ids []string
+	t.Run("Upsert items", func(t *testing.T) {
+		req := &itempb.UpsertRequest{}
+		for _, tt := range sampleItems {
+			req.Items = append(req.Items, &itempb.Item{Name: tt.name, Desc: tt.desc, Qty: int64(tt.qty)})
+		}
+
+		res, err := c.Upsert(ctx, req)
+		if err != nil {
+			t.Fatalf("unable to upsert items: %s", err)
+		}
+
+		for i, item := range res.GetItems() {
+			want, _ := NewItem(sampleItems[i].name, sampleItems[i].desc, sampleItems[i].qty)
+			if item.GetId() != want.ID {
+				t.Errorf("Upsert returned ID %s, want %s", item.GetId(), want.ID)
+			}
+			ids = append(ids, item.GetId())
+		}
+	})
+
+	t.Run("Upsert invalid items", func(t *testing.T) {
+		for _, req := range []*itempb.UpsertRequest{{}, {Items: []*itempb.Item{{Desc: "no name"}}}} {
+			_, err := c.Upsert(ctx, req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Upsert(%v) returned %v, want %v", req, status.Code(err), codes.InvalidArgument)
+			}
+			if e := apierr.FromGRPC(err); e.Code != apierr.ValidationFailed {
+				t.Errorf("Upsert(%v) returned code %s, want %s", req, e.Code, apierr.ValidationFailed)
+			}
+		}
+
+		_, err := c.Upsert(ctx, &itempb.UpsertRequest{Items: []*itempb.Item{{Desc: "no name"}}})
+		if e := apierr.FromGRPC(err); len(e.Fields) == 0 || e.Fields[0].Field != "[0].name" {
+			t.Errorf("Upsert returned fields %v, want [0].name", e.Fields)
+		}
+	})
+
+	t.Run("Get items", func(t *testing.T) {
+		for i, id := range ids {
+			item, err := c.Get(ctx, &itempb.GetRequest{Id: id})
+			if err != nil {
+				t.Errorf("unable to get item %s: %s", id, err)
+				continue
+			}
+			if item.GetName() != sampleItems[i].name {
+				t.Errorf("Get(%s) returned name %#v, want %#v", id, item.GetName(), sampleItems[i].name)
+			}
+		}
+
+		if _, err := c.Get(ctx, &itempb.GetRequest{Id: "unknown"}); status.Code(err) != codes.NotFound {
+			t.Errorf("Get(unknown) returned %v, want %v", status.Code(err), codes.NotFound)
+		}
+	})
+
+	t.Run("BatchGet items", func(t *testing.T) {
+		res, err := c.BatchGet(ctx, &itempb.BatchGetRequest{Ids: append(ids, "unknown")})
+		if err != nil {
+			t.Fatalf("unable to batch get items: %s", err)
+		}
+		if len(res.GetItems()) != len(ids) {
+			t.Errorf("BatchGet returned %d items, want %d", len(res.GetItems()), len(ids))
+		}
+		if len(res.GetMissing()) != 1 || res.GetMissing()[0] != "unknown" {
+			t.Errorf("BatchGet returned missing %v, want %v", res.GetMissing(), []string{"unknown"})
+		}
+	})
+
+	t.Run("List items", func(t *testing.T) {
+		stream, err := c.List(ctx, &itempb.ListRequest{})
+		if err != nil {
+			t.Fatalf("unable to list items: %s", err)
+		}
+
+		var n int
+		for {
+			_, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unable to receive item: %s", err)
+			}
+			n++
+		}
+
+		if n != len(ids) {
+			t.Errorf("List streamed %d items, want %d", n, len(ids))
+		}
+	})
+
+	t.Run("Reserve items", func(t *testing.T) {
+		// sampleItems[1] has 100 units
+		id := ids[1]
+		item, err := c.Reserve(ctx, &itempb.ReserveRequest{Id: id, Qty: 40})
+		if err != nil {
+			t.Fatalf("unable to reserve item: %s", err)
+		}
+		if item.GetQty() != 60 {
+			t.Errorf("Reserve returned qty %d, want %d", item.GetQty(), 60)
+		}
+
+		var tests = []struct {
+			req  *itempb.ReserveRequest
+			want codes.Code
+		}{
+			{&itempb.ReserveRequest{Id: id, Qty: 61}, codes.FailedPrecondition},
+			{&itempb.ReserveRequest{Id: id, Qty: 0}, codes.InvalidArgument},
+			{&itempb.ReserveRequest{Id: id, Qty: -1}, codes.InvalidArgument},
+			{&itempb.ReserveRequest{Id: "unknown", Qty: 1}, codes.NotFound},
+		}
+		for _, tt := range tests {
+			if _, err := c.Reserve(ctx, tt.req); status.Code(err) != tt.want {
+				t.Errorf("Reserve(%v) returned %v, want %v", tt.req, status.Code(err), tt.want)
+			}
+		}
+	})
+
+	t.Run("Request ID", func(t *testing.T) {
+		reqID := "e4b0b9d6-9d6b-4a43-9d0b-9b4a7f0b3b1e"
+		var header metadata.MD
+
+		octx := metadata.AppendToOutgoingContext(ctx, "x-request-id", reqID)
+		if _, err := c.Get(octx, &itempb.GetRequest{Id: ids[0]}, grpc.Header(&header)); err != nil {
+			t.Fatalf("unable to get item: %s", err)
+		}
+		if v := header.Get("x-request-id"); len(v) != 1 || v[0] != reqID {
+			t.Errorf("x-request-id header is %v, want %v", v, []string{reqID})
+		}
+
+		if _, err := c.Get(ctx, &itempb.GetRequest{Id: ids[0]}, grpc.Header(&header)); err != nil {
+			t.Fatalf("unable to get item: %s", err)
+		}
+		if v := header.Get("x-request-id"); len(v) != 1 || v[0] == "" {
+			t.Errorf("x-request-id header should be assigned, got %v", v)
+		}
+	})
+
+	t.Run("Delete items", func(t *testing.T) {
+		for _, id := range ids {
+			if _, err := c.Delete(ctx, &itempb.DeleteRequest{Id: id}); err != nil {
+				t.Errorf("unable to delete item %s: %s", id, err)
+			}
+			if _, err := c.Get(ctx, &itempb.GetRequest{Id: id}); status.Code(err) != codes.NotFound {
+				t.Errorf("Get(%s) after Delete returned %v, want %v", id, status.Code(err), codes.NotFound)
+			}
+		}
+	})
+}
+
+func TestGRPCHealth(t *testing.T) {
+	mr, s := helperPrepareRedis(t)
+	defer mr.Close()
+
+	conn, done := helperDialGRPC(s, t)
+	defer done()
+	c := healthpb.NewHealthClient(conn)
+
+	res, err := c.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("unable to check health: %s", err)
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health status is %s, want %s", res.GetStatus(), healthpb.HealthCheckResponse_SERVING)
+	}
+
+	s.Health.Shutdown()
+	res, err = c.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("unable to check health: %s", err)
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("health status after shutdown is %s, want %s", res.GetStatus(), healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
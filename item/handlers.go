@@ -1,29 +1,20 @@
 package item
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/obitech/micro-obs/apierr"
 	"github.com/obitech/micro-obs/util"
 	ot "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 )
 
-// NotFound is a 404 Message according to the Response type
-func (s *Server) notFound() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		span, ctx := ot.StartSpanFromContext(r.Context(), "notFound")
-		defer span.Finish()
-		s.Respond(ctx, http.StatusNotFound, "resource not found", 0, nil, w)
-	}
-}
-
 // pong sends a simple JSON response.
 func (s *Server) pong() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -34,97 +25,81 @@ func (s *Server) pong() http.HandlerFunc {
 }
 
 // getAllItems retrieves all items from Redis.
-func (s *Server) getAllItems() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getAllItems() util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "getAllItems")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
 
-		defaultErrMsg := "unable to retrieve items"
+		const defaultErrMsg = "unable to retrieve items"
 		keys, err := s.RedisScanKeys(ctx)
 		if err != nil {
-			log.Errorw("unable to SCAN redis for keys",
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-			return
+			return apierr.Wrap(errors.Wrap(err, "unable to SCAN redis for keys"), apierr.Internal, defaultErrMsg)
 		}
 
 		var items = []*Item{}
 		for _, k := range keys {
 			i, err := s.RedisGetItem(ctx, k)
 			if err != nil {
-				log.Errorw("unable to retrieve item",
-					"key", k,
-					"error", err,
-				)
-				s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-				return
+				return apierr.Wrap(errors.Wrapf(err, "unable to retrieve item %s", k), apierr.Internal, defaultErrMsg)
 			}
 			items = append(items, i)
 		}
 
 		l := len(items)
 		if l == 0 {
-			s.Respond(ctx, http.StatusNotFound, "no items present", 0, nil, w)
-			return
+			return apierr.New(apierr.NotFound, "no items present")
 		}
 
 		s.Respond(ctx, http.StatusOK, "items retrieved", l, items, w)
+		return nil
 	}
 }
 
-// setItem sets an Item as a hash in Redis with URL parameters.
-func (s *Server) setItem(update bool) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// setItem sets Items as hashes in Redis and reports the outcome per Item. With ?atomic=true, all
+// Items are written in a single transaction or none at all.
+func (s *Server) setItem(update bool) util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "setItem")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
+		log := util.RequestIDLogger(s.Logger, r)
 
-		var (
-			defaultErrMsg = "unable to create items"
-			items         []*Item
-		)
+		const defaultErrMsg = "unable to create items"
+		var items []*Item
+
+		atomic := false
+		if v := r.URL.Query().Get("atomic"); v != "" {
+			var err error
+			if atomic, err = strconv.ParseBool(v); err != nil {
+				return apierr.New(apierr.InvalidArgument, "atomic must be a boolean, got %#v", v)
+			}
+		}
+		span.SetTag("atomic", atomic)
 
 		// Accept payload
 		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+		r.Body.Close()
 		if err != nil {
-			log.Errorw("unable to read request body",
-				"error", err,
-			)
-			r.Body.Close()
-			s.Respond(ctx, http.StatusInternalServerError, "unable to read payload", 0, nil, w)
-			return
+			return apierr.Wrap(err, apierr.Internal, "unable to read payload")
 		}
-		defer r.Body.Close()
 
 		// Parse payload
-		if err := json.Unmarshal(body, &items); err != nil {
-			log.Errorw("unable to parse payload",
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusBadRequest, "unable to parse payload", 0, nil, w)
-			return
+		errs, err := util.DecodeJSON(body, &items)
+		if err != nil {
+			return apierr.Wrap(err, apierr.InvalidPayload, "unable to parse payload")
 		}
 
-		if items == nil || len(items) == 0 {
-			s.Respond(ctx, http.StatusUnprocessableEntity, "items can't be empty", 0, nil, w)
+		if len(items) == 0 {
+			return apierr.New(apierr.ValidationFailed, "items can't be empty")
 		}
 
 		// Verify sent items
-		for _, item := range items {
-			// Catch empty response
-			if item.Name == "" {
-				s.Respond(ctx, http.StatusUnprocessableEntity, "item needs name", 0, nil, w)
-				return
-			}
+		if errs = append(errs, ValidateItems(items)...); len(errs) > 0 {
+			return apierr.New(apierr.ValidationFailed, "invalid items").WithFields(errs)
+		}
 
+		for _, item := range items {
 			if err := item.SetID(ctx); err != nil {
-				log.Errorw("unable to set item ID",
-					"error", err,
-				)
-				s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-				return
+				return apierr.Wrap(errors.Wrap(err, "unable to set item ID"), apierr.Internal, defaultErrMsg)
 			}
 
 			log.Debugw("item struct created",
@@ -133,159 +108,174 @@ func (s *Server) setItem(update bool) http.HandlerFunc {
 				"desc", item.Desc,
 				"qty", item.Qty,
 			)
+		}
 
-			// Check for existence
-			_, err := s.RedisGetItem(ctx, item.ID)
+		if atomic {
+			existed, err := s.RedisSetItemsAtomic(ctx, items, update)
+			if err == errItemsExist {
+				var fields apierr.FieldErrors
+				for n, item := range items {
+					if existed[n] {
+						fields.Add(fmt.Sprintf("[%d].name", n), "item %s already exists", item.ID)
+					}
+				}
+				return apierr.New(apierr.AlreadyExists, "%s, no item was written", defaultErrMsg).WithFields(fields)
+			}
+			if err == errItemsConflict {
+				return apierr.New(apierr.Conflict, "%s, items are being modified concurrently, retry later", defaultErrMsg)
+			}
 			if err != nil {
-				log.Errorw("unable to retrieve Item from Redis",
-					"key", item.ID,
-					"error", err,
-				)
-				s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-				return
+				return apierr.Wrap(errors.Wrap(err, "unable to set items in transaction"), apierr.Internal, defaultErrMsg)
 			}
-		}
 
-		var itemsCreatedMsg string
-		var itemsCreatedData []*Item
-		var itemsFailedMsg string
+			var results = make([]*Result, len(items))
+			for n, item := range items {
+				results[n] = newResult(n, item, existed[n], nil)
+			}
+			return s.respondResults(ctx, log, results, defaultErrMsg, w)
+		}
 
-		for _, item := range items {
+		var results = make([]*Result, len(items))
+		for n, item := range items {
 			// Check for existence
 			i, err := s.RedisGetItem(ctx, item.ID)
 			if err != nil {
-				log.Errorw("unable to retrieve Item from Redis",
+				log.Errorw("unable to retrieve item from redis",
 					"key", item.ID,
 					"error", err,
 				)
-				s.Respond(ctx, http.StatusInternalServerError, "unable to create items", 0, nil, w)
-				return
+				results[n] = newResult(n, item, false, apierr.Wrap(err, apierr.Internal, "unable to retrieve item %s", item.ID))
+				continue
 			}
-			if i != nil {
-				if !update {
-					log.Debugw("item already exists",
-						"key", item.ID,
-					)
-					itemsFailedMsg += fmt.Sprintf("%s already exists, ", item.ID)
-					continue
-				}
+			if i != nil && !update {
+				log.Debugw("item already exists",
+					"key", item.ID,
+				)
+				results[n] = newResult(n, item, true, apierr.New(apierr.AlreadyExists, "item %s already exists", item.ID))
+				continue
 			}
 
 			// Create Item in Redis
-			err = s.RedisSetItem(ctx, item)
-			if err != nil {
+			if err := s.RedisSetItem(ctx, item); err != nil {
 				log.Errorw("unable to create item in redis",
 					"key", item.ID,
 					"error", err,
 				)
-				itemsFailedMsg += fmt.Sprintf("%s, ", item.ID)
+				results[n] = newResult(n, item, i != nil, apierr.Wrap(err, apierr.Internal, "unable to store item %s", item.ID))
 				continue
 			}
-			itemsCreatedMsg += fmt.Sprintf("%s, ", item.ID)
-			itemsCreatedData = append(itemsCreatedData, item)
+			results[n] = newResult(n, item, i != nil, nil)
+		}
+
+		return s.respondResults(ctx, log, results, defaultErrMsg, w)
+	}
+}
+
+// newResult returns the Result of writing the Item at index n. Created Items have status 201,
+// updated ones 200 and failed ones the status of err.
+func newResult(n int, item *Item, existed bool, err *apierr.Error) *Result {
+	if err != nil {
+		return &Result{
+			Index:  n,
+			ID:     item.ID,
+			Status: err.Status(),
+			Code:   err.Code,
+			Detail: err.Detail,
 		}
+	}
 
-		switch {
-		// All failed
-		case len(itemsFailedMsg) != 0 && len(itemsCreatedData) == 0:
-			msg := fmt.Sprintf("unable to create items: %s", itemsFailedMsg)
-			s.Respond(ctx, http.StatusUnprocessableEntity, msg, 0, nil, w)
-			return
-
-		// Some created, some failed
-		case len(itemsFailedMsg) != 0 && len(itemsCreatedData) > 0:
-			msg := fmt.Sprintf("items %s created but some failed: %s", itemsCreatedMsg, itemsFailedMsg)
-			s.Respond(ctx, http.StatusOK, msg, len(itemsCreatedData), itemsCreatedData, w)
-			return
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+	return &Result{
+		Index:  n,
+		ID:     item.ID,
+		Status: status,
+		Item:   item,
+	}
+}
+
+// respondResults sends the Results of a bulk write. If no Item failed, 201 is sent if at least one
+// was created and 200 if all were updated. If all Items failed with the same Code, an error with
+// that Code is returned. Any other outcome is sent as 207.
+func (s *Server) respondResults(ctx context.Context, log *util.Logger, results []*Result, errMsg string, w http.ResponseWriter) error {
+	var (
+		failed  apierr.FieldErrors
+		code    apierr.Code
+		mixed   bool
+		created bool
+	)
+	for _, res := range results {
+		if res.Code == "" {
+			created = created || res.Status == http.StatusCreated
+			continue
 		}
+		if code != "" && res.Code != code {
+			mixed = true
+		}
+		code = res.Code
 
-		// All created, none failed
-		s.Respond(ctx, http.StatusCreated, fmt.Sprintf("items %s created", itemsCreatedMsg), len(itemsCreatedData), itemsCreatedData, w)
+		field := fmt.Sprintf("[%d]", res.Index)
+		if res.Code == apierr.AlreadyExists {
+			field += ".name"
+		}
+		failed.Add(field, "%s", res.Detail)
 	}
+
+	switch {
+	// Some created, none failed
+	case len(failed) == 0 && created:
+		s.Respond(ctx, http.StatusCreated, "items created", len(results), results, w)
+
+	// All updated
+	case len(failed) == 0:
+		s.Respond(ctx, http.StatusOK, "items updated", len(results), results, w)
+
+	// All failed for the same reason
+	case len(failed) == len(results) && !mixed:
+		return apierr.New(code, "%s", errMsg).WithFields(failed)
+
+	// Some created, some failed
+	default:
+		log.Warnw("some items couldn't be created",
+			"errors", failed,
+		)
+		s.Respond(ctx, http.StatusMultiStatus, "some items couldn't be created", len(results), results, w)
+	}
+	return nil
 }
 
 // getItem retrieves a single Item by ID from Redis.
-func (s *Server) getItem() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getItem() util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "getItem")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
-
-		pr := mux.Vars(r)
-		key := pr["id"]
 
+		key := mux.Vars(r)["id"]
 		item, err := s.RedisGetItem(ctx, key)
 		if err != nil {
-			log.Errorw("unable to get key from redis",
-				"key", key,
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, "unable to retreive item", 0, nil, w)
-			return
+			return apierr.Wrap(errors.Wrapf(err, "unable to get key %s from redis", key), apierr.Internal, "unable to retrieve item")
 		}
 		if item == nil {
-			s.Respond(ctx, http.StatusNotFound, fmt.Sprintf("item with ID %s doesn't exist", key), 0, nil, w)
-			return
+			return apierr.New(apierr.NotFound, "item with ID %s doesn't exist", key)
 		}
 		s.Respond(ctx, http.StatusOK, "item retrieved", 1, []*Item{item}, w)
+		return nil
 	}
 }
 
 // delItem deletes a single item by ID.
-func (s *Server) delItem() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) delItem() util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "delItem")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
 
-		pr := mux.Vars(r)
-		key := pr["id"]
-
-		err := s.RedisDelItem(ctx, key)
-		if err != nil {
-			log.Errorw("unable to delete key from redis",
-				"key", key,
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, "an error occured while tring to delete item", 0, nil, w)
-			return
+		key := mux.Vars(r)["id"]
+		if err := s.RedisDelItem(ctx, key); err != nil {
+			return apierr.Wrap(errors.Wrapf(err, "unable to delete key %s from redis", key), apierr.Internal, "unable to delete item")
 		}
 		s.Respond(ctx, http.StatusOK, "item deleted", 0, nil, w)
-	}
-}
-
-// delay returns after a random period to simulate reequest delay.
-func (s *Server) delay() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		span, ctx := ot.StartSpanFromContext(r.Context(), "delay")
-		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
-
-		// Simulate delay between 10ms - 500ms
-		t := time.Duration((rand.Float64()*500)+10) * time.Millisecond
-
-		span.SetTag("wait", t)
-		log.Debugw("Waiting",
-			"time", t,
-		)
-
-		time.Sleep(t)
-
-		s.Respond(ctx, http.StatusOK, fmt.Sprintf("waited %v", t), 0, nil, w)
-	}
-}
-
-// simulateError returns a 500
-func (s *Server) simulateError() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		span, ctx := ot.StartSpanFromContext(r.Context(), "simulateError")
-		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
-
-		log.Errorw("Error occured",
-			"error", errors.New("nasty error message"),
-		)
-
-		s.Respond(ctx, http.StatusInternalServerError, "simulated error", 0, nil, w)
+		return nil
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -12,6 +13,13 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Limits enforced when validating an Item.
+const (
+	MaxNameLength = 128
+	MaxDescLength = 1024
+	MaxQty        = math.MaxInt32
+)
+
 // Item defines a shop item with attributes. ID should be a HashID of the name.
 // // See https://hashids.org for more info.
 type Item struct {
@@ -40,6 +48,43 @@ func NewItem(name, desc string, qty int) (*Item, error) {
 	}, nil
 }
 
+// Validate checks the fields of an Item. Field names in the returned errors are prefixed with path.
+func (i *Item) Validate(path string) util.FieldErrors {
+	var errs util.FieldErrors
+	errs.CheckRequired(path+".name", i.Name)
+	errs.CheckLength(path+".name", i.Name, MaxNameLength)
+	errs.CheckLength(path+".desc", i.Desc, MaxDescLength)
+	errs.CheckRange(path+".qty", i.Qty, 0, MaxQty)
+	return errs
+}
+
+// ValidateItems checks every Item of a request payload. Since the ID is derived from the name, two
+// items with the same lowercase name are rejected as duplicates.
+func ValidateItems(items []*Item) util.FieldErrors {
+	var (
+		errs util.FieldErrors
+		seen = make(map[string]int)
+	)
+
+	for n, i := range items {
+		path := fmt.Sprintf("[%d]", n)
+		if i == nil {
+			errs.Add(path, "must not be null")
+			continue
+		}
+		errs = append(errs, i.Validate(path)...)
+
+		name := strings.ToLower(i.Name)
+		if first, ok := seen[name]; ok && name != "" {
+			errs.Add(path+".name", "duplicate of [%d].name", first)
+			continue
+		}
+		seen[name] = n
+	}
+
+	return errs
+}
+
 // DataToItems takes a JSON-encoded byte array and marshals it into a list of item.Items
 func DataToItems(data []byte) ([]*Item, error) {
 	items := []*Item{}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/obitech/micro-obs/util"
@@ -149,3 +150,31 @@ func TestDataToItems(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateItems(t *testing.T) {
+	var tests = []struct {
+		items  []*Item
+		fields []string
+	}{
+		{[]*Item{{Name: "orange", Desc: "a juicy fruit", Qty: 100}}, nil},
+		{[]*Item{{Name: "orange"}, {Name: "apple", Qty: MaxQty}}, nil},
+		{[]*Item{nil}, []string{"[0]"}},
+		{[]*Item{{Desc: "no name"}}, []string{"[0].name"}},
+		{[]*Item{{Name: strings.Repeat("😍", MaxNameLength+1)}}, []string{"[0].name"}},
+		{[]*Item{{Name: "orange", Desc: strings.Repeat("a", MaxDescLength+1)}}, []string{"[0].desc"}},
+		{[]*Item{{Name: "orange", Qty: -1}}, []string{"[0].qty"}},
+		{[]*Item{{Name: "orange"}, {Name: "apple"}, {Name: "Orange"}}, []string{"[2].name"}},
+		{[]*Item{{Name: "orange", Qty: -1}, {Desc: "no name"}}, []string{"[0].qty", "[1].name"}},
+	}
+
+	for _, tt := range tests {
+		var fields []string
+		for _, fe := range ValidateItems(tt.items) {
+			fields = append(fields, fe.Field)
+		}
+
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("ValidateItems(%v) returned errors for %v, want %v", tt.items, fields, tt.fields)
+		}
+	}
+}
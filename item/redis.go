@@ -3,23 +3,36 @@ package item
 import (
 	"context"
 
+	"github.com/go-redis/redis"
 	ot "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 )
 
+var (
+	errInsufficientQty = errors.New("not enough units available")
+	errItemsExist      = errors.New("items exist already")
+	errReserveConflict = errors.New("item was modified concurrently too often")
+	errItemsConflict   = errors.New("items were modified concurrently too often")
+)
+
+// txAttempts is how often RedisReserveItem and RedisSetItemsAtomic retry their transaction if the
+// Items were modified concurrently, before giving up with errReserveConflict or errItemsConflict.
+const txAttempts = 10
+
 // RedisScanKeys retrieves all keys from a redis instance.
 // This uses the SCAN command so it's save to use on large database & in production.
 func (s *Server) RedisScanKeys(ctx context.Context) ([]string, error) {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisScanKeys")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisScanKeys")
 	defer span.Finish()
 
+	rc := s.RedisContext(ctx)
 	var cursor uint64
 	var keys []string
 	var err error
 
 	for {
 		var k []string
-		k, cursor, err = s.redis.Scan(cursor, "", 10).Result()
+		k, cursor, err = rc.Scan(cursor, "", 10).Result()
 		if err != nil {
 			return nil, err
 		}
@@ -34,10 +47,10 @@ func (s *Server) RedisScanKeys(ctx context.Context) ([]string, error) {
 
 // RedisGetItem retrieves an Item from Redis.
 func (s *Server) RedisGetItem(ctx context.Context, k string) (*Item, error) {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisGetItem")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisGetItem")
 	defer span.Finish()
 
-	r, err := s.redis.HGetAll(k).Result()
+	r, err := s.RedisContext(ctx).HGetAll(k).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -54,12 +67,13 @@ func (s *Server) RedisGetItem(ctx context.Context, k string) (*Item, error) {
 
 // RedisSetItem sets an Item as a hash in Redis.
 func (s *Server) RedisSetItem(ctx context.Context, i *Item) error {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisSetItem")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisSetItem")
 	defer span.Finish()
 
+	rc := s.RedisContext(ctx)
 	k, fv := i.MarshalRedis()
 	for f, v := range fv {
-		_, err := s.redis.HSet(k, f, v).Result()
+		_, err := rc.HSet(k, f, v).Result()
 		if err != nil {
 			return errors.Errorf("unable to HSET %s %s %s", k, f, v)
 		}
@@ -68,9 +82,74 @@ func (s *Server) RedisSetItem(ctx context.Context, i *Item) error {
 	return nil
 }
 
+// RedisSetItemsAtomic sets all Items in a single transaction and reports which of them existed
+// before, including repeated IDs within items. Unless update is set, nothing is written if any of
+// them exists and errItemsExist is returned. If any of them is modified while writing, the
+// transaction is retried up to txAttempts times before errItemsConflict is returned.
+func (s *Server) RedisSetItemsAtomic(ctx context.Context, items []*Item, update bool) ([]bool, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisSetItemsAtomic")
+	defer span.Finish()
+
+	for attempt := 1; attempt <= txAttempts; attempt++ {
+		existed, err := s.redisSetItemsAtomicOnce(ctx, items, update)
+		if err != redis.TxFailedErr {
+			return existed, err
+		}
+		span.LogKV(
+			"event", "conflict",
+			"attempt", attempt,
+		)
+	}
+	return nil, errItemsConflict
+}
+
+// redisSetItemsAtomicOnce runs the transaction of RedisSetItemsAtomic, which fails with
+// redis.TxFailedErr if any of the Items was modified concurrently.
+func (s *Server) redisSetItemsAtomicOnce(ctx context.Context, items []*Item, update bool) ([]bool, error) {
+	var keys = make([]string, len(items))
+	for i, v := range items {
+		keys[i] = v.ID
+	}
+
+	var existed []bool
+	err := s.RedisWatch(ctx, func(tx *redis.Tx) error {
+		existed = make([]bool, len(items))
+		seen := make(map[string]bool, len(items))
+		conflict := false
+		for n, item := range items {
+			c, err := tx.Exists(item.ID).Result()
+			if err != nil {
+				return err
+			}
+			existed[n] = c > 0 || seen[item.ID]
+			seen[item.ID] = true
+			conflict = conflict || existed[n]
+		}
+
+		if conflict && !update {
+			return errItemsExist
+		}
+
+		_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+			for _, item := range items {
+				k, fv := item.MarshalRedis()
+				fields := make(map[string]interface{}, len(fv))
+				for f, v := range fv {
+					fields[f] = v
+				}
+				pipe.HMSet(k, fields)
+			}
+			return nil
+		})
+		return err
+	}, keys...)
+
+	return existed, err
+}
+
 // RedisDelItems deletes one or more Items from Redis.
 func (s *Server) RedisDelItems(ctx context.Context, items []*Item) error {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisDelItems")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisDelItems")
 	defer span.Finish()
 
 	var keys = make([]string, len(items))
@@ -78,14 +157,76 @@ func (s *Server) RedisDelItems(ctx context.Context, items []*Item) error {
 		keys[i] = v.ID
 	}
 
-	err := s.redis.Del(keys...).Err()
+	err := s.RedisContext(ctx).Del(keys...).Err()
 	return err
 }
 
 // RedisDelItem deletes a single Item by ID.
 func (s *Server) RedisDelItem(ctx context.Context, id string) error {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisDelItems")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisDelItems")
 	defer span.Finish()
 
-	return s.redis.Del(id).Err()
+	return s.RedisContext(ctx).Del(id).Err()
+}
+
+// RedisReserveItem atomically decreases the quantity of an Item by n and returns the updated Item.
+// Returns nil if the Item doesn't exist and errInsufficientQty if not enough units are available.
+// If the Item is modified while reserving, the reservation is retried up to txAttempts times
+// before errReserveConflict is returned.
+func (s *Server) RedisReserveItem(ctx context.Context, id string, n int) (*Item, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisReserveItem")
+	defer span.Finish()
+
+	for attempt := 1; attempt <= txAttempts; attempt++ {
+		item, err := s.redisReserveItemOnce(ctx, id, n)
+		if err != redis.TxFailedErr {
+			return item, err
+		}
+		span.LogKV(
+			"event", "conflict",
+			"attempt", attempt,
+		)
+	}
+	return nil, errReserveConflict
+}
+
+// redisReserveItemOnce runs the transaction of RedisReserveItem, which fails with
+// redis.TxFailedErr if the Item was modified concurrently.
+func (s *Server) redisReserveItemOnce(ctx context.Context, id string, n int) (*Item, error) {
+	var item *Item
+	err := s.RedisWatch(ctx, func(tx *redis.Tx) error {
+		r, err := tx.HGetAll(id).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(r) == 0 {
+			item = nil
+			return nil
+		}
+
+		item = &Item{}
+		if err := UnmarshalRedis(id, r, item); err != nil {
+			return err
+		}
+
+		if item.Qty < n {
+			return errInsufficientQty
+		}
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(id, "qty", int64(-n))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		item.Qty -= n
+		return nil
+	}, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
 }
@@ -4,10 +4,12 @@ import (
 	"context"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis"
+	"github.com/obitech/micro-obs/util"
 )
 
 func helperPrepareMiniredis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
@@ -26,7 +28,7 @@ func TestItemRedis(t *testing.T) {
 
 	addr := strings.Join([]string{"redis://", mr.Addr()}, "")
 	s, err := NewServer(
-		SetRedisAddress(addr),
+		util.SetRedisAddress(addr),
 	)
 	if err != nil {
 		t.Errorf("unable to create server: %s", err)
@@ -34,7 +36,7 @@ func TestItemRedis(t *testing.T) {
 
 	var sampleKeys []string
 	t.Run("Pinging miniredis with Server", func(t *testing.T) {
-		if _, err := s.redis.Ping().Result(); err != nil {
+		if _, err := s.Redis.Ping().Result(); err != nil {
 			t.Errorf("unable to ping miniredis: %s", err)
 		}
 	})
@@ -115,3 +117,87 @@ func TestItemRedis(t *testing.T) {
 		}
 	})
 }
+
+func TestRedisReserveItem(t *testing.T) {
+	_, mr := helperPrepareMiniredis(t)
+	defer mr.Close()
+
+	s, err := NewServer(
+		util.SetRedisAddress("redis://" + mr.Addr()),
+	)
+	if err != nil {
+		t.Fatalf("unable to create server: %s", err)
+	}
+
+	i, _ := NewItem("test", "test", 100)
+	if err := s.RedisSetItem(context.Background(), i); err != nil {
+		t.Fatalf("unable to set item: %s", err)
+	}
+
+	// Every failed transaction means another reservation succeeded, so txAttempts concurrent
+	// reservations can't run out of attempts.
+	errs := make(chan error, txAttempts)
+	var wg sync.WaitGroup
+	for n := 0; n < txAttempts; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.RedisReserveItem(context.Background(), i.ID, 3)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent reservation failed: %s", err)
+		}
+	}
+	if got, _ := s.RedisGetItem(context.Background(), i.ID); got.Qty != 100-3*txAttempts {
+		t.Errorf("qty after concurrent reservations is %d, want %d", got.Qty, 100-3*txAttempts)
+	}
+}
+
+func TestRedisSetItemsAtomic(t *testing.T) {
+	_, mr := helperPrepareMiniredis(t)
+	defer mr.Close()
+
+	s, err := NewServer(
+		util.SetRedisAddress("redis://" + mr.Addr()),
+	)
+	if err != nil {
+		t.Fatalf("unable to create server: %s", err)
+	}
+
+	a, _ := NewItem("a", "first", 1)
+	b, _ := NewItem("b", "second", 1)
+
+	// Like with reservations, txAttempts concurrent writes can't run out of attempts.
+	errs := make(chan error, txAttempts)
+	var wg sync.WaitGroup
+	for n := 0; n < txAttempts; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			items := []*Item{{ID: a.ID, Name: a.Name, Desc: a.Desc, Qty: n}, {ID: b.ID, Name: b.Name, Desc: b.Desc, Qty: n}}
+			_, err := s.RedisSetItemsAtomic(context.Background(), items, true)
+			errs <- err
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent atomic write failed: %s", err)
+		}
+	}
+
+	// Both items are always written together
+	gotA, _ := s.RedisGetItem(context.Background(), a.ID)
+	gotB, _ := s.RedisGetItem(context.Background(), b.ID)
+	if gotA == nil || gotB == nil || gotA.Qty != gotB.Qty {
+		t.Errorf("items after concurrent atomic writes are %+v and %+v, want the same qty", gotA, gotB)
+	}
+}
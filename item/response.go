@@ -1,33 +1,28 @@
 package item
 
 import (
-	"encoding/json"
-	"net/http"
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/obitech/micro-obs/util"
 )
 
 // Response defines an API response.
-type Response struct {
-	Status  int     `json:"status"`
-	Message string  `json:"message"`
-	Count   int     `json:"count"`
-	Data    []*Item `json:"data"`
-}
+type Response = util.Envelope[[]*Item]
 
 // NewResponse returns a Response with a passed message string and slice of Data.
-// TODO: make d variadic
 func NewResponse(s int, m string, c int, d []*Item) (Response, error) {
-	return Response{
-		Status:  s,
-		Message: m,
-		Count:   c,
-		Data:    d,
-	}, nil
+	return util.NewEnvelope(s, m, c, d), nil
 }
 
-// SendJSON encodes a Response as JSON and sends it on a passed http.ResponseWriter.
-func (r Response) SendJSON(w http.ResponseWriter) error {
-	w.Header().Set("Content-Type", "application/JSON; charset=UTF-8")
-	w.WriteHeader(r.Status)
-	err := json.NewEncoder(w).Encode(r)
-	return err
+// Result is the outcome of writing a single Item of a bulk request. Index refers to the position
+// of the Item in the request, Code and Detail are only set if writing it failed.
+type Result struct {
+	Index  int         `json:"index"`
+	ID     string      `json:"id"`
+	Status int         `json:"status"`
+	Code   apierr.Code `json:"code,omitempty"`
+	Detail string      `json:"detail,omitempty"`
+	Item   *Item       `json:"item,omitempty"`
 }
+
+// BulkResponse defines the API response of bulk writes, holding one Result per Item.
+type BulkResponse = util.Envelope[[]*Result]
@@ -1,116 +1,79 @@
 package item
 
 import (
-	"github.com/obitech/micro-obs/util"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-)
+	"net/http"
 
-var rm = util.NewRequestMetricHistogram(
-	append([]float64{.001, .003}, prometheus.DefBuckets...),
-	[]float64{1, 5, 10, 50, 100},
+	"github.com/obitech/micro-obs/util"
 )
 
-// Routes defines all HTTP routes, hanging off the main Server struct.
+// routes defines all HTTP routes of the service, hanging off the main Server struct.
 // Like that, all routes have access to the Server's dependencies.
-func (s *Server) createRoutes() {
-	var routes = util.Routes{
+func (s *Server) routes() util.Routes {
+	res := Response{}
+	bulk := BulkResponse{}
+	problem := util.Problem{}
+	read := util.Access{Roles: []string{util.RoleReader}, Scopes: []string{ScopeRead}}
+	write := util.Access{Roles: []string{util.RoleOperator}, Scopes: []string{ScopeWrite}}
+	del := util.Access{Roles: []string{util.RoleAdmin}, Scopes: []string{ScopeWrite}}
+	atomic := []util.Parameter{{Name: "atomic", Description: "Write all items in a single transaction or none at all", Schema: &util.Schema{Type: "boolean"}}}
+	return util.Routes{
 		util.Route{
 			Name:        "pong",
 			Method:      "GET",
 			Pattern:     "/",
 			HandlerFunc: s.pong(),
-		},
-		util.Route{
-			Name:        "healthz",
-			Method:      "GET",
-			Pattern:     "/healthz",
-			HandlerFunc: util.Healthz(),
+			Summary:     "Ping the service",
+			Responses:   map[int]interface{}{http.StatusOK: res},
+			Access:      util.Access{Public: true},
 		},
 		util.Route{
 			Name:        "getAllItems",
 			Method:      "GET",
 			Pattern:     "/items",
-			HandlerFunc: s.getAllItems(),
+			HandlerFunc: s.Handle(s.getAllItems()),
+			Summary:     "Retrieve all items",
+			Responses:   map[int]interface{}{http.StatusOK: res, http.StatusNotFound: problem, http.StatusInternalServerError: problem},
+			Access:      read,
 		},
 		util.Route{
 			Name:        "setItemsPOST",
 			Method:      "POST",
 			Pattern:     "/items",
-			HandlerFunc: s.setItem(false),
+			HandlerFunc: s.Handle(s.setItem(false)),
+			Summary:     "Create items, with ?atomic=true in a single transaction",
+			Request:     []*Item{},
+			Query:       atomic,
+			Responses:   map[int]interface{}{http.StatusCreated: bulk, http.StatusMultiStatus: bulk, http.StatusBadRequest: problem, http.StatusConflict: problem, http.StatusUnprocessableEntity: problem, http.StatusInternalServerError: problem},
+			Access:      write,
 		},
 		util.Route{
 			Name:        "setItemsPUT",
 			Method:      "PUT",
 			Pattern:     "/items",
-			HandlerFunc: s.setItem(true),
+			HandlerFunc: s.Handle(s.setItem(true)),
+			Summary:     "Create or update items, with ?atomic=true in a single transaction",
+			Request:     []*Item{},
+			Query:       atomic,
+			Responses:   map[int]interface{}{http.StatusOK: bulk, http.StatusCreated: bulk, http.StatusMultiStatus: bulk, http.StatusBadRequest: problem, http.StatusConflict: problem, http.StatusUnprocessableEntity: problem, http.StatusInternalServerError: problem},
+			Access:      write,
 		},
 		util.Route{
 			Name:        "getItem",
 			Method:      "GET",
 			Pattern:     "/items/{id:[a-zA-Z0-9]+}",
-			HandlerFunc: s.getItem(),
+			HandlerFunc: s.Handle(s.getItem()),
+			Summary:     "Retrieve a single item",
+			Responses:   map[int]interface{}{http.StatusOK: res, http.StatusNotFound: problem, http.StatusInternalServerError: problem},
+			Access:      read,
 		},
 		util.Route{
 			Name:        "delItem",
 			Method:      "DELETE",
 			Pattern:     "/items/{id:[a-zA-Z0-9]+}",
-			HandlerFunc: s.delItem(),
-		},
-		util.Route{
-			Name:        "delay",
-			Method:      "GET",
-			Pattern:     "/delay",
-			HandlerFunc: s.delay(),
-		},
-		util.Route{
-			Name:        "simulateError",
-			Method:      "GET",
-			Pattern:     "/error",
-			HandlerFunc: s.simulateError(),
+			HandlerFunc: s.Handle(s.delItem()),
+			Summary:     "Delete a single item",
+			Responses:   map[int]interface{}{http.StatusOK: res, http.StatusInternalServerError: problem},
+			Access:      del,
 		},
 	}
-
-	for _, route := range routes {
-		h := route.HandlerFunc
-
-		// Tracing each request
-		h = util.TracerMiddleware(h, route)
-
-		// Logging each request
-		h = util.LoggerMiddleware(h, s.logger)
-
-		// Assign requestID to each request
-		h = util.AssignRequestID(h, s.logger)
-
-		// Monitoring each request
-		// TODO: pass proper handler
-		promHandler := util.PrometheusMiddleware(h, route.Pattern, rm)
-
-		s.router.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(promHandler)
-	}
-
-	// Prometheus endpoint
-	route := util.Route{
-		Name:        "metrics",
-		Method:      "GET",
-		Pattern:     "/metrics",
-		HandlerFunc: nil,
-	}
-
-	promHandler := promhttp.HandlerFor(s.promReg, promhttp.HandlerOpts{})
-	promHandler = promhttp.InstrumentMetricHandler(s.promReg, promHandler)
-	s.router.
-		Methods(route.Method).
-		Path(route.Pattern).
-		Name(route.Name).
-		Handler(promHandler)
-
-	// 404 handler
-	notFound := util.PrometheusMiddleware(s.notFound(), "metrics", rm)
-	s.router.NotFoundHandler = notFound
 }
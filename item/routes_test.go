@@ -0,0 +1,241 @@
+package item
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/obitech/micro-obs/util"
+	"github.com/obitech/micro-obs/util/utiltest"
+)
+
+func TestOpenAPI(t *testing.T) {
+	mr, s := helperPrepareRedis(t)
+	defer mr.Close()
+
+	t.Run("Serve document", func(t *testing.T) {
+		b := helperSendSimpleRequest(s, "GET", "/openapi.json", http.StatusOK, t)
+
+		var doc struct {
+			OpenAPI string                            `json:"openapi"`
+			Paths   map[string]map[string]interface{} `json:"paths"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatalf("unable to parse OpenAPI document: %s", err)
+		}
+
+		if doc.OpenAPI == "" {
+			t.Errorf("OpenAPI document has no version")
+		}
+
+		for _, tt := range []struct {
+			path   string
+			method string
+		}{
+			{"/items", "get"},
+			{"/items", "post"},
+			{"/items", "put"},
+			{"/items/{id}", "get"},
+			{"/items/{id}", "delete"},
+			{"/openapi.json", "get"},
+			{"/admin/loglevel", "get"},
+			{"/admin/loglevel", "put"},
+		} {
+			if _, ok := doc.Paths[tt.path][tt.method]; !ok {
+				t.Errorf("OpenAPI document is missing %s %s", tt.method, tt.path)
+			}
+		}
+	})
+
+	t.Run("Contract", func(t *testing.T) {
+		item, _ := NewItem("😍aa", "yes", 42)
+		newItem := `[{"name": "contract", "qty": 1, "desc": "new"}]`
+		partial := `[{"name": "contract", "qty": 1, "desc": "new"}, {"name": "partial", "qty": 2, "desc": "new"}]`
+
+		var tests = []struct {
+			method string
+			path   string
+			body   string
+			want   int
+		}{
+			{"GET", "/", "", http.StatusOK},
+			{"GET", "/healthz", "", http.StatusOK},
+			{"GET", "/livez", "", http.StatusOK},
+			{"GET", "/readyz", "", http.StatusOK},
+			{"GET", "/openapi.json", "", http.StatusOK},
+			{"GET", "/items", "", http.StatusNotFound},
+			{"POST", "/items", validJSON[0], http.StatusCreated},
+			{"POST", "/items", validJSON[0], http.StatusUnprocessableEntity},
+			{"POST", "/items", newItem, http.StatusCreated},
+			{"POST", "/items", partial, http.StatusMultiStatus},
+			{"POST", "/items?atomic=true", partial, http.StatusUnprocessableEntity},
+			{"PUT", "/items?atomic=true", partial, http.StatusOK},
+			{"PUT", "/items?atomic=maybe", partial, http.StatusBadRequest},
+			{"POST", "/items", `[`, http.StatusBadRequest},
+			{"POST", "/items", `[{}]`, http.StatusUnprocessableEntity},
+			{"PUT", "/items", validJSON[1], http.StatusCreated},
+			{"PUT", "/items", `{}`, http.StatusBadRequest},
+			{"GET", "/items", "", http.StatusOK},
+			{"GET", fmt.Sprintf("/items/%s", item.ID), "", http.StatusOK},
+			{"GET", "/items/unknown", "", http.StatusNotFound},
+			{"DELETE", fmt.Sprintf("/items/%s", item.ID), "", http.StatusOK},
+			{"GET", "/admin/faults", "", http.StatusOK},
+			{"POST", "/admin/faults", `[{"name": "slow", "routes": ["getItem"], "latency": {"mean": "1ms"}}]`, http.StatusOK},
+			{"POST", "/admin/faults", `[{"name": "nothing"}]`, http.StatusUnprocessableEntity},
+			{"POST", "/admin/faults", `[`, http.StatusBadRequest},
+			{"DELETE", "/admin/faults", "", http.StatusOK},
+			{"GET", "/admin/loglevel", "", http.StatusOK},
+			{"PUT", "/admin/loglevel", `{"level": "debug", "overrides": {"getItem": "warn"}}`, http.StatusOK},
+			{"PUT", "/admin/loglevel", `{"level": "verbose"}`, http.StatusUnprocessableEntity},
+			{"PUT", "/admin/loglevel", `[`, http.StatusBadRequest},
+		}
+
+		for _, tt := range tests {
+			utiltest.CheckContract(s, s.Router, s.OpenAPI, tt.method, tt.path, tt.body, nil, tt.want, t)
+		}
+	})
+
+	t.Run("Contract on Redis failure", func(t *testing.T) {
+		mr, s := helperPrepareRedis(t)
+		defer mr.Close()
+		s.Redis.Close()
+
+		var tests = []struct {
+			method string
+			path   string
+			body   string
+		}{
+			{"GET", "/items", ""},
+			{"POST", "/items", validJSON[0]},
+			{"GET", "/items/unknown", ""},
+			{"DELETE", "/items/unknown", ""},
+		}
+
+		for _, tt := range tests {
+			utiltest.CheckContract(s, s.Router, s.OpenAPI, tt.method, tt.path, tt.body, nil, http.StatusInternalServerError, t)
+		}
+		utiltest.CheckContract(s, s.Router, s.OpenAPI, "GET", "/readyz", "", nil, http.StatusServiceUnavailable, t)
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	f, err := ioutil.TempFile("", "apikeys")
+	if err != nil {
+		t.Fatalf("unable to create API keys file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[
+		{"key": "reader-secret", "subject": "dashboard", "roles": ["reader"]},
+		{"key": "operator-secret", "subject": "order", "roles": ["operator"]},
+		{"key": "admin-secret", "subject": "ops", "roles": ["admin"]}
+	]`)
+	f.Close()
+
+	_, mr := helperPrepareMiniredis(t)
+	defer mr.Close()
+	s, err := NewServer(
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		util.SetAPIKeysFile(f.Name()),
+	)
+	if err != nil {
+		t.Fatalf("unable to create server: %s", err)
+	}
+	s.InitPromReg()
+
+	item, _ := NewItem("authz", "protected", 1)
+	body := fmt.Sprintf(`[{"name": "%s", "qty": %d, "desc": "%s"}]`, item.Name, item.Qty, item.Desc)
+
+	var tests = []struct {
+		key    string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"reader-secret", "POST", "/items", body, http.StatusForbidden},
+		{"operator-secret", "POST", "/items", body, http.StatusCreated},
+		{"reader-secret", "GET", "/items", "", http.StatusOK},
+		{"reader-secret", "GET", fmt.Sprintf("/items/%s", item.ID), "", http.StatusOK},
+		{"operator-secret", "DELETE", fmt.Sprintf("/items/%s", item.ID), "", http.StatusForbidden},
+		{"operator-secret", "POST", "/admin/faults", "[]", http.StatusForbidden},
+		{"operator-secret", "GET", "/admin/loglevel", "", http.StatusForbidden},
+		{"admin-secret", "GET", "/admin/loglevel", "", http.StatusOK},
+		{"admin-secret", "DELETE", fmt.Sprintf("/items/%s", item.ID), "", http.StatusOK},
+		{"", "GET", "/admin/faults", "", http.StatusUnauthorized},
+		{"", "GET", "/", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.key != "" {
+			header.Set("X-API-Key", tt.key)
+		}
+		utiltest.CheckContract(s, s.Router, s.OpenAPI, tt.method, tt.path, tt.body, header, tt.want, t)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("X-API-Key", "reader-secret")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	for _, want := range []string{
+		`authz_denied_total{role="reader",route="setItemsPOST",service="item"} 1`,
+		`authz_denied_total{role="operator",route="delItem",service="item"} 1`,
+		`authz_denied_total{role="operator",route="getLogLevel",service="item"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+}
+
+func TestNoCredentials(t *testing.T) {
+	_, mr := helperPrepareMiniredis(t)
+	defer mr.Close()
+	s, err := NewServer(
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+	)
+	if err != nil {
+		t.Fatalf("unable to create server: %s", err)
+	}
+
+	item, _ := NewItem("unprotected", "no credentials configured", 1)
+	if err := s.RedisSetItem(context.Background(), item); err != nil {
+		t.Fatalf("unable to set item: %s", err)
+	}
+
+	utiltest.CheckContract(s, s.Router, s.OpenAPI, "DELETE", fmt.Sprintf("/items/%s", item.ID), "", nil, http.StatusUnauthorized, t)
+	utiltest.CheckContract(s, s.Router, s.OpenAPI, "GET", "/", "", nil, http.StatusOK, t)
+
+	if got, err := s.RedisGetItem(context.Background(), item.ID); err != nil || got == nil {
+		t.Errorf("item was deleted without credentials: %v", err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	mr, s := helperPrepareRedis(t)
+	defer mr.Close()
+	s.InitPromReg()
+
+	helperSendSimpleRequest(s, "GET", "/asdasd", http.StatusNotFound, t)
+	helperSendSimpleRequest(s, "GET", "/items", http.StatusNotFound, t)
+	helperSendSimpleRequest(s, "GET", "/", http.StatusOK, t)
+
+	b := helperSendSimpleRequest(s, "GET", "/metrics", http.StatusOK, t)
+	for _, want := range []string{
+		`api_requests_total{method="GET",route="notFound",service="item",status_class="4xx"} 1`,
+		`api_requests_total{method="GET",route="getAllItems",service="item",status_class="4xx"} 1`,
+		`api_requests_total{method="GET",route="pong",service="item",status_class="2xx"} 1`,
+		`redis_command_duration_seconds_count{command="scan",outcome="success",service="item"} 1`,
+		`redis_pool_connections{service="item"} 1`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+}
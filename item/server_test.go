@@ -6,13 +6,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/obitech/micro-obs/util"
 )
 
 var (
@@ -75,12 +79,12 @@ var (
 		{"GET", "/asdasd", http.StatusNotFound},
 		{"GET", "/metrics", http.StatusOK},
 		{"GET", "/items", http.StatusNotFound},
-		{"GET", "/delay", http.StatusOK},
+		{"GET", "/admin/faults", http.StatusOK},
 		{"POST", "/items", http.StatusBadRequest},
 		{"PUT", "/items", http.StatusBadRequest},
 		{"DELETE", "/", http.StatusMethodNotAllowed},
 		{"DELETE", "/items", http.StatusMethodNotAllowed},
-		{"GET", "/error", http.StatusInternalServerError},
+		{"GET", "/error", http.StatusNotFound},
 	}
 
 	validJSON = []string{
@@ -101,6 +105,11 @@ var (
 		{`{"name": "😍", "qty": 42, "desc": "yes"}`, http.StatusBadRequest},
 		{`[{}]`, http.StatusUnprocessableEntity},
 		{`[]`, http.StatusUnprocessableEntity},
+		{`[null]`, http.StatusUnprocessableEntity},
+		{`[{"name": "orange", "desc": "test", "qty": -1}]`, http.StatusUnprocessableEntity},
+		{`[{"name": "orange", "desc": "test", "qty": "1"}]`, http.StatusUnprocessableEntity},
+		{`[{"name": "orange", "desc": "test", "qty": 1, "color": "orange"}]`, http.StatusUnprocessableEntity},
+		{`[{"name": "orange", "qty": 1}, {"name": "Orange", "qty": 2}]`, http.StatusUnprocessableEntity},
 	}
 )
 
@@ -134,7 +143,8 @@ func helperPrepareRedis(t *testing.T) (*miniredis.Miniredis, *Server) {
 	_, mr := helperPrepareMiniredis(t)
 
 	s, err := NewServer(
-		SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		util.SetInsecureNoAuth(true),
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
 	)
 	if err != nil {
 		t.Errorf("unable to create server: %s", err)
@@ -178,16 +188,103 @@ func TestNewServer(t *testing.T) {
 
 	t.Run("Creating new default server with custom log levels", func(t *testing.T) {
 		for _, tt := range logLevels {
-			if _, err := NewServer(SetLogLevel(tt.level)); tt.want != nil {
+			if _, err := NewServer(util.SetLogLevel(tt.level)); tt.want != nil {
 				t.Errorf("error while creating new item server: %#v", err)
 			}
 		}
 	})
 
+	t.Run("Creating new default server with log config", func(t *testing.T) {
+		cfg := util.DefaultLogConfig()
+		cfg.Encoding = util.LogEncodingConsole
+		s, err := NewServer(util.SetLogLevel("debug"), util.SetLogConfig(cfg))
+		if err != nil {
+			t.Fatalf("error while creating new %s server: %#v", serviceName, err)
+		}
+		if got := s.Logger.Levels().Level(); got != "debug" {
+			t.Errorf("log level is %s after setting log config, want debug", got)
+		}
+
+		cfg.Encoding = "logfmt"
+		if _, err := NewServer(util.SetLogConfig(cfg)); err == nil {
+			t.Errorf("expected error while setting log encoding to %#v", cfg.Encoding)
+		}
+	})
+
+	t.Run("Creating new default server with log level config", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "loglevel")
+		if err != nil {
+			t.Fatalf("unable to create temp file: %s", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(`{"level": "warn", "overrides": {"createOrder": "debug"}}`)
+		f.Close()
+
+		s, err := NewServer(util.SetLogLevel("debug"), util.SetLogLevelConfig(f.Name()))
+		if err != nil {
+			t.Fatalf("error while creating new %s server: %#v", serviceName, err)
+		}
+		if got := s.Logger.Levels().Level(); got != "warn" {
+			t.Errorf("log level is %s, want warn", got)
+		}
+		if _, err := NewServer(util.SetLogLevelConfig(f.Name() + ".missing")); err == nil {
+			t.Errorf("expected error while setting missing log level config")
+		}
+	})
+
+	t.Run("Creating new default server with redaction config", func(t *testing.T) {
+		if _, err := NewServer(util.SetRedactionConfig("")); err != nil {
+			t.Errorf("error while creating new item server: %#v", err)
+		}
+		if _, err := NewServer(util.SetRedactionConfig("does/not/exist.json")); err == nil {
+			t.Errorf("expected error while loading missing redaction config, got %#v", err)
+		}
+	})
+
+	t.Run("Creating new default server with tracing backends", func(t *testing.T) {
+		for _, backend := range []string{"jaeger", "otlp", "none"} {
+			if _, err := NewServer(util.SetTracing(backend)); err != nil {
+				t.Errorf("error while creating new %s server: %#v", serviceName, err)
+			}
+		}
+		if _, err := NewServer(util.SetTracing("zipkin")); err == nil {
+			t.Errorf("expected error while setting tracing backend to zipkin, got %#v", err)
+		}
+	})
+
+	t.Run("Creating new default server with propagation formats", func(t *testing.T) {
+		for _, formats := range []string{"w3c", "b3,b3multi", "jaeger, w3c"} {
+			if _, err := NewServer(util.SetPropagation(formats)); err != nil {
+				t.Errorf("error while creating new %s server: %#v", serviceName, err)
+			}
+		}
+		for _, formats := range []string{"", "zipkin"} {
+			if _, err := NewServer(util.SetPropagation(formats)); err == nil {
+				t.Errorf("expected error while setting propagation to %#v, got %#v", formats, err)
+			}
+		}
+	})
+
+	t.Run("Creating new default server with samplers", func(t *testing.T) {
+		if _, err := NewServer(
+			util.SetSampler("probabilistic", 0.1, ""),
+			util.SetSamplingOverrides("/healthz=never,getItem=always"),
+			util.SetSampleErrors(false),
+		); err != nil {
+			t.Errorf("error while creating new %s server: %#v", serviceName, err)
+		}
+		if _, err := NewServer(util.SetSampler("probabilistic", 2, "")); err == nil {
+			t.Errorf("expected error while setting sampler param to 2")
+		}
+		if _, err := NewServer(util.SetSamplingOverrides("/healthz=sometimes")); err == nil {
+			t.Errorf("expected error while setting invalid sampling override")
+		}
+	})
+
 	t.Run("Creating new default server with custom redis address", func(t *testing.T) {
 		t.Run("Checking valid addresses", func(t *testing.T) {
 			for _, v := range validRedisAddr {
-				if _, err := NewServer(SetRedisAddress(v)); err != nil {
+				if _, err := NewServer(util.SetRedisAddress(v)); err != nil {
 					t.Errorf("error while creating new item server: %#v", err)
 				}
 			}
@@ -195,7 +292,7 @@ func TestNewServer(t *testing.T) {
 
 		t.Run("Checking invalid addresses", func(t *testing.T) {
 			for _, v := range invalidRedisAddr {
-				if _, err := NewServer(SetRedisAddress(v)); err == nil {
+				if _, err := NewServer(util.SetRedisAddress(v)); err == nil {
 					t.Errorf("expected error while setting redis address to %#v, got %#v", v, err)
 				}
 			}
@@ -207,8 +304,8 @@ func TestNewServer(t *testing.T) {
 			for _, listen := range validListeningAddr {
 				for _, ep := range validEndpointAddr {
 					_, err := NewServer(
-						SetServerAddress(listen),
-						SetServerEndpoint(ep),
+						util.SetServerAddress(listen),
+						util.SetServerEndpoint(ep),
 					)
 					if err != nil {
 						t.Errorf("error while creating new item server: %#v", err)
@@ -219,8 +316,8 @@ func TestNewServer(t *testing.T) {
 		t.Run("Checking invalid addresses", func(t *testing.T) {
 			for _, tt := range invalidListeningAddr {
 				if _, err := NewServer(
-					SetServerAddress(tt),
-					SetServerEndpoint(tt),
+					util.SetServerAddress(tt),
+					util.SetServerEndpoint(tt),
 				); err == nil {
 					t.Errorf("expected error when creating item server with listening address %#v, got %#v", tt, err)
 				}
@@ -235,7 +332,8 @@ func TestEndpoints(t *testing.T) {
 		defer mr.Close()
 
 		s, err := NewServer(
-			SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+			util.SetInsecureNoAuth(true),
+			util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
 		)
 		if err != nil {
 			t.Errorf("unable to create server: %s", err)
@@ -381,7 +479,7 @@ func TestEndpoints(t *testing.T) {
 
 			t.Run("PUT existing item", func(t *testing.T) {
 				method = "PUT"
-				want := http.StatusCreated
+				want := http.StatusOK
 
 				for _, js := range validJSON {
 					helperSendJSON(js, s, method, path, want, t)
@@ -470,5 +568,184 @@ func TestEndpoints(t *testing.T) {
 				}
 			})
 		})
+
+		t.Run("Problem responses", func(t *testing.T) {
+			mr, s := helperPrepareRedis(t)
+			defer mr.Close()
+
+			var tests = []struct {
+				js     string
+				want   int
+				fields []string
+			}{
+				{`[]`, http.StatusUnprocessableEntity, nil},
+				{`[`, http.StatusBadRequest, nil},
+				{`[{"name": "orange", "qty": -1, "color": "orange"}]`, http.StatusUnprocessableEntity, []string{"[0].color", "[0].qty"}},
+			}
+
+			for _, tt := range tests {
+				b := helperSendJSON(tt.js, s, "POST", path, tt.want, t)
+
+				// Exactly one problem needs to be sent
+				var p util.Problem
+				dec := json.NewDecoder(bytes.NewReader(b))
+				if err := dec.Decode(&p); err != nil {
+					t.Errorf("unable to parse problem: %s", err)
+				}
+				if dec.More() {
+					t.Errorf("more than one response sent for %s: %s", tt.js, b)
+				}
+
+				if p.Status != tt.want {
+					t.Errorf("problem status is %d, want %d", p.Status, tt.want)
+				}
+
+				var fields []string
+				for _, fe := range p.Errors {
+					fields = append(fields, fe.Field)
+				}
+				if !reflect.DeepEqual(fields, tt.fields) {
+					t.Errorf("problem has errors for %v, want %v", fields, tt.fields)
+				}
+			}
+		})
+
+		t.Run("Bulk results", func(t *testing.T) {
+			mr, s := helperPrepareRedis(t)
+			defer mr.Close()
+
+			var (
+				banana = `{"name": "banana", "qty": 5}`
+				water  = `{"name": "water", "qty": 10}`
+				apple  = `{"name": "apple", "qty": 15}`
+			)
+
+			var tests = []struct {
+				name     string
+				method   string
+				path     string
+				js       string
+				want     int
+				statuses []int
+			}{
+				{"Create", "POST", "/items", "[" + banana + "]", http.StatusCreated, []int{http.StatusCreated}},
+				{"Partial", "POST", "/items", "[" + banana + "," + water + "]", http.StatusMultiStatus, []int{http.StatusUnprocessableEntity, http.StatusCreated}},
+				{"Atomic conflict", "POST", "/items?atomic=true", "[" + apple + "," + banana + "]", http.StatusUnprocessableEntity, nil},
+				{"Atomic duplicate", "POST", "/items?atomic=1", "[" + apple + "," + apple + "]", http.StatusUnprocessableEntity, nil},
+				{"Atomic upsert", "PUT", "/items?atomic=true", "[" + banana + "," + apple + "]", http.StatusCreated, []int{http.StatusOK, http.StatusCreated}},
+				{"Invalid atomic", "PUT", "/items?atomic=maybe", "[" + banana + "]", http.StatusBadRequest, nil},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					b := helperSendJSON(tt.js, s, tt.method, tt.path, tt.want, t)
+					if tt.statuses == nil {
+						return
+					}
+
+					var res BulkResponse
+					if err := json.Unmarshal(b, &res); err != nil {
+						t.Fatalf("unable to parse response: %s", err)
+					}
+
+					var statuses []int
+					for n, r := range res.Data {
+						statuses = append(statuses, r.Status)
+						if r.Index != n || r.ID == "" {
+							t.Errorf("result %d has index %d and ID %#v", n, r.Index, r.ID)
+						}
+						if (r.Status >= http.StatusBadRequest) != (r.Code != "") {
+							t.Errorf("result %d with status %d has code %#v", n, r.Status, r.Code)
+						}
+					}
+					if !reflect.DeepEqual(statuses, tt.statuses) {
+						t.Errorf("results have statuses %v, want %v", statuses, tt.statuses)
+					}
+				})
+			}
+
+			// Failed atomic writes mustn't store anything
+			apples, _ := NewItem("apple", "", 15)
+			if i, _ := s.RedisGetItem(context.Background(), apples.ID); i == nil || i.Qty != 15 {
+				t.Errorf("apple is %+v after atomic upsert", i)
+			}
+			oranges, _ := NewItem("orange", "", 1)
+			helperSendJSON(`[{"name": "orange", "qty": 1}, {"name": "banana", "qty": 1}]`, s, "POST", "/items?atomic=true", http.StatusUnprocessableEntity, t)
+			if i, _ := s.RedisGetItem(context.Background(), oranges.ID); i != nil {
+				t.Errorf("orange was stored by failed atomic write")
+			}
+		})
 	})
 }
+
+// helperFreeAddress returns a local TCP address nobody listens on.
+func helperFreeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create listener: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRunContext(t *testing.T) {
+	_, mr := helperPrepareMiniredis(t)
+	defer mr.Close()
+
+	address := helperFreeAddress(t)
+	s, err := NewServer(
+		util.SetServerAddress(address),
+		util.SetGRPCAddress(helperFreeAddress(t)),
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		util.SetTracing(util.TracingNone),
+		util.SetDrainPeriod(200*time.Millisecond),
+		util.SetShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("unable to create server: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.RunContext(ctx) }()
+
+	// helperReadyz returns the status of /readyz, or 0 if the server can't be reached
+	helperReadyz := func() int {
+		resp, err := http.Get("http://" + address + "/readyz")
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for helperReadyz() != http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := helperReadyz(); got != http.StatusOK {
+		t.Fatalf("/readyz returned %d after start, want %d", got, http.StatusOK)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if got := helperReadyz(); got != http.StatusServiceUnavailable {
+		t.Errorf("/readyz returned %d while draining, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunContext returned %s after clean shutdown", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("RunContext didn't return after shutdown")
+	}
+
+	if _, err := NewServer(util.SetDrainPeriod(-time.Second)); err == nil {
+		t.Errorf("expected error when setting negative drain period")
+	}
+	if _, err := NewServer(util.SetShutdownTimeout(0)); err == nil {
+		t.Errorf("expected error when setting shutdown timeout of 0")
+	}
+}
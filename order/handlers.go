@@ -1,88 +1,66 @@
 package order
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/obitech/micro-obs/apierr"
 	"github.com/obitech/micro-obs/util"
 	ot "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 )
 
-// NotFound is a 404 Message according to the Response type
-func (s *Server) notFound() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		span, ctx := ot.StartSpanFromContext(r.Context(), "notFound")
-		defer span.Finish()
-		s.Respond(ctx, http.StatusNotFound, "resource not found", 0, nil, w)
-	}
-}
-
 // pong sends a simple JSON response.
 func (s *Server) pong() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "pong")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
+		log := util.RequestIDLogger(s.Logger, r)
 		log.Info("pong")
 		s.Respond(ctx, http.StatusOK, "pong", 0, nil, w)
 	}
 }
 
 // getAllOrders retrieves all orders from Redis
-func (s *Server) getAllOrders() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getAllOrders() util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "getAllOrders")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
 
-		defaultErrMsg := "unable to retrieve orders"
+		const defaultErrMsg = "unable to retrieve orders"
 		keys, err := s.RedisScanOrders(ctx)
 		if err != nil {
-			log.Errorw("unable to SCAN redis for keys",
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-			return
+			return apierr.Wrap(errors.Wrap(err, "unable to SCAN redis for keys"), apierr.Internal, defaultErrMsg)
 		}
 
 		var orders = []*Order{}
 		for _, k := range keys {
 			o, err := s.RedisGetOrder(ctx, k)
 			if err != nil {
-				log.Errorw("unable to get get order",
-					"key", k,
-					"error", err,
-				)
-				s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-				return
+				return apierr.Wrap(errors.Wrapf(err, "unable to get order %d", k), apierr.Internal, defaultErrMsg)
 			}
 			orders = append(orders, o)
 		}
 
 		l := len(orders)
 		if l == 0 {
-			s.Respond(ctx, http.StatusNotFound, "no orders present", 0, nil, w)
-			return
+			return apierr.New(apierr.NotFound, "no orders present")
 		}
 
 		s.Respond(ctx, http.StatusOK, "orders retrieved", l, orders, w)
+		return nil
 	}
 }
 
 // setOrder creates a new Order in Redis, regardless if the items are present in the Item service.
-func (s *Server) setOrder(update bool) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) setOrder(update bool) util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "setNewOrder")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
 
 		var (
 			defaultErrMsg string
@@ -101,213 +79,124 @@ func (s *Server) setOrder(update bool) http.HandlerFunc {
 
 		// Accept payload
 		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+		r.Body.Close()
 		if err != nil {
-			log.Errorw("unable to read request body",
-				"error", err,
-			)
-			r.Body.Close()
-			s.Respond(ctx, http.StatusInternalServerError, "unable to read payload", 0, nil, w)
-			return
+			return apierr.Wrap(err, apierr.Internal, "unable to read payload")
 		}
-		defer r.Body.Close()
 
 		// Parse payload
 		// TODO: handle multiple orders
-		if err := json.Unmarshal(body, order); err != nil {
-			log.Errorw("unable to parse payload",
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusBadRequest, "unable to parse payload", 0, nil, w)
-			return
+		errs, err := util.DecodeJSON(body, order)
+		if err != nil {
+			return apierr.Wrap(err, apierr.InvalidPayload, "unable to parse payload")
 		}
 
-		// Check if order contains items
-		if len(order.Items) == 0 {
-			s.Respond(ctx, http.StatusUnprocessableEntity, "order needs items", 0, nil, w)
-			return
+		// Verify sent order
+		if errs = append(errs, order.Validate()...); len(errs) > 0 {
+			return apierr.New(apierr.ValidationFailed, "invalid order").WithFields(errs)
 		}
 
 		// Check for existence
 		i, err := s.RedisGetOrder(ctx, order.ID)
 		if err != nil {
-			log.Errorw("unable to retrieve Item from Redis",
-				"key", order.ID,
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-			return
+			return apierr.Wrap(errors.Wrapf(err, "unable to retrieve order %d", order.ID), apierr.Internal, "%s", defaultErrMsg)
 		}
-		if i != nil {
-			if !update {
-				s.Respond(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("order with ID %d already exists", order.ID), 0, nil, w)
-				return
-			}
+		if i != nil && !update {
+			errs.Add("id", "order %d already exists", order.ID)
+			return apierr.New(apierr.AlreadyExists, "%s", defaultErrMsg).WithFields(errs)
 		}
 
 		// Create Order in Redis
-		err = s.RedisSetOrder(ctx, order)
-		if err != nil {
-			log.Errorw("unable to create order in redis",
-				"key", order.ID,
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, defaultErrMsg, 0, nil, w)
-			return
+		if err := s.RedisSetOrder(ctx, order); err != nil {
+			return apierr.Wrap(errors.Wrapf(err, "unable to store order %d", order.ID), apierr.Internal, "%s", defaultErrMsg)
 		}
 
 		s.Respond(ctx, defaultStatus, fmt.Sprintf("order %d created", order.ID), 1, []*Order{order}, w)
+		return nil
 	}
 }
 
-func (s *Server) createOrder() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createOrder() util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "createOrder")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
 
 		// Accept payload
 		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+		r.Body.Close()
 		if err != nil {
-			log.Errorw("unable to read request body",
-				"error", err,
-			)
-			r.Body.Close()
-			s.Respond(ctx, http.StatusInternalServerError, "unable to read payload", 0, nil, w)
-			return
+			return apierr.Wrap(err, apierr.Internal, "unable to read payload")
 		}
-		defer r.Body.Close()
 
 		// Parse payload
-		var order *Order
-		if err := json.Unmarshal(body, &order); err != nil {
-			log.Errorw("unable to parse payload",
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusBadRequest, "unable to parse payload", 0, nil, w)
-			return
+		order := &Order{}
+		errs, err := util.DecodeJSON(body, order)
+		if err != nil {
+			return apierr.Wrap(err, apierr.InvalidPayload, "unable to parse payload")
 		}
 
-		// Check if order contains items
-		if len(order.Items) == 0 {
-			s.Respond(ctx, http.StatusUnprocessableEntity, "order needs items", 0, nil, w)
-			return
+		// Verify sent order
+		if errs = append(errs, order.Validate()...); len(errs) > 0 {
+			return apierr.New(apierr.ValidationFailed, "invalid order").WithFields(errs)
 		}
 
 		// Get requested items from item service
 		// TODO: Send & process in bulk
-		for _, orderItem := range order.Items {
+		for n, orderItem := range order.Items {
 			itemItem, err := s.getItem(ctx, orderItem.ID)
-			if err != nil {
-				if err, ok := err.(notFoundError); ok {
-					s.Respond(ctx, http.StatusNotFound, err.Error(), 0, nil, w)
-					return
-				}
-
-				log.Errorw("unable to retrieve item from item service",
-					"itemID", orderItem.ID,
-					"error", err,
-				)
-				msg := fmt.Sprintf("unable to retrieve item %s from item service", orderItem.ID)
-				s.Respond(ctx, http.StatusInternalServerError, msg, 0, nil, w)
-				return
+			switch {
+			case apierr.Is(err, apierr.NotFound):
+				return apierr.Wrap(err, apierr.NotFound, "item %s doesn't exist", orderItem.ID)
+			case apierr.Is(err, apierr.Unavailable):
+				return apierr.Wrap(err, apierr.Unavailable, "item service is unavailable")
+			case err != nil:
+				return apierr.Wrap(err, apierr.Internal, "unable to retrieve item %s from item service", orderItem.ID)
 			}
 
 			if itemItem.Qty < orderItem.Qty {
-				msg := fmt.Sprintf("not enough units of %s available (%d avail, %d requested)", orderItem.ID, orderItem.Qty, itemItem.Qty)
-				s.Respond(ctx, http.StatusUnprocessableEntity, msg, 0, nil, w)
-				return
+				errs.Add(fmt.Sprintf("items[%d].qty", n), "not enough units of %s available (%d avail, %d requested)", orderItem.ID, itemItem.Qty, orderItem.Qty)
+				return apierr.New(apierr.ValidationFailed, "unable to create order").WithFields(errs)
 			}
 		}
 
 		// Get OrderID from Redis
 		id, err := s.RedisGetNextOrderID(ctx)
 		if err != nil {
-			log.Errorw("unable to get next order ID",
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, "unable to create order", 0, nil, w)
-			return
+			return apierr.Wrap(errors.Wrap(err, "unable to get next order ID"), apierr.Internal, "unable to create order")
 		}
 		order.ID = id
 
 		// Create order
-		err = s.RedisSetOrder(ctx, order)
-		if err != nil {
-			log.Errorw("unable to create order in redis",
-				"error", err,
-			)
+		if err := s.RedisSetOrder(ctx, order); err != nil {
+			return apierr.Wrap(errors.Wrapf(err, "unable to store order %d", order.ID), apierr.Internal, "unable to create order")
 		}
 
 		// Respond
 		msg := fmt.Sprintf("order %d created", order.ID)
 		s.Respond(ctx, http.StatusCreated, msg, 1, []*Order{order}, w)
+		return nil
 	}
 }
 
-func (s *Server) getOrder() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getOrder() util.ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		span, ctx := ot.StartSpanFromContext(r.Context(), "getOrder")
 		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
 
-		pr := mux.Vars(r)
-		id, err := strconv.ParseInt(pr["id"], 10, 64)
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 		if err != nil {
-			s.Respond(ctx, http.StatusBadRequest, "unable to parse ID", 0, nil, w)
-			return
+			return apierr.Wrap(err, apierr.InvalidArgument, "unable to parse ID")
 		}
 
 		order, err := s.RedisGetOrder(ctx, id)
 		if err != nil {
-			log.Errorw("unable to get key from redis",
-				"key", id,
-				"error", err,
-			)
-			s.Respond(ctx, http.StatusInternalServerError, "unable to retreive item", 0, nil, w)
-			return
+			return apierr.Wrap(errors.Wrapf(err, "unable to get order %d from redis", id), apierr.Internal, "unable to retrieve order")
 		}
-
 		if order == nil {
-			s.Respond(ctx, http.StatusNotFound, fmt.Sprintf("order %d doesn't exist", id), 0, nil, w)
-			return
+			return apierr.New(apierr.NotFound, "order %d doesn't exist", id)
 		}
 
 		s.Respond(ctx, http.StatusOK, "order retrieved", 1, []*Order{order}, w)
-	}
-}
-
-// delay returns after a random period to simulate reequest delay.
-func (s *Server) delay() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		span, ctx := ot.StartSpanFromContext(r.Context(), "delay")
-		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
-
-		// Simulate delay between 30ms - 800ms
-		t := time.Duration((rand.Float64()*800)+30) * time.Millisecond
-
-		span.SetTag("wait", t)
-		log.Debugw("Waiting",
-			"time", t,
-		)
-
-		time.Sleep(t)
-
-		s.Respond(ctx, http.StatusOK, fmt.Sprintf("waited %v", t), 0, nil, w)
-	}
-}
-
-// simulateError returns a 500
-func (s *Server) simulateError() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		span, ctx := ot.StartSpanFromContext(r.Context(), "simulateError")
-		defer span.Finish()
-		log := util.RequestIDLogger(s.logger, r)
-
-		log.Errorw("Error occured",
-			"error", errors.New("nasty error message"),
-		)
-
-		s.Respond(ctx, http.StatusInternalServerError, "simulated error", 0, nil, w)
+		return nil
 	}
 }
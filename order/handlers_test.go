@@ -0,0 +1,118 @@
+package order
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/util"
+	ot "github.com/opentracing/opentracing-go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// helperFindSpan returns the single recorded span with the passed name and kind.
+func helperFindSpan(spans tracetest.SpanStubs, name string, kind trace.SpanKind, t *testing.T) tracetest.SpanStub {
+	var found []tracetest.SpanStub
+	for _, s := range spans {
+		if s.Name == name && s.SpanKind == kind {
+			found = append(found, s)
+		}
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("found %d %s spans named %s, want 1", len(found), kind, name)
+	}
+	return found[0]
+}
+
+func TestCreateOrderTrace(t *testing.T) {
+	httpAddr, _, done := helperPrepareItemService(t, util.SetInsecureNoAuth(true))
+	defer done()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unable to start miniredis: %s", err)
+	}
+	defer mr.Close()
+
+	s, err := NewServer(
+		util.SetInsecureNoAuth(true),
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		SetItemServiceAddress(httpAddr),
+	)
+	if err != nil {
+		t.Fatalf("unable to create server: %s", err)
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, closer, err := util.InitOTelTracer(serviceName, util.TracerConfig{}, s.Logger, sdktrace.NewSimpleSpanProcessor(exporter))
+	if err != nil {
+		t.Fatalf("unable to create tracer: %s", err)
+	}
+	defer closer.Close()
+
+	prev := ot.GlobalTracer()
+	ot.SetGlobalTracer(tracer)
+	defer ot.SetGlobalTracer(prev)
+
+	banana, _ := item.NewItem("banana", "", 5)
+	body := fmt.Sprintf(`{"items": [{"id": %q, "qty": 1}]}`, banana.ID)
+	req := httptest.NewRequest("POST", "/orders/create", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /orders/create returned %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	spans := exporter.GetSpans()
+	root := helperFindSpan(spans, "POST /orders/create", trace.SpanKindServer, t)
+	handler := helperFindSpan(spans, "createOrder", trace.SpanKindInternal, t)
+	client := helperFindSpan(spans, "getItem", trace.SpanKindClient, t)
+	itemRoot := helperFindSpan(spans, "GET /items/{id}", trace.SpanKindServer, t)
+	itemHandler := helperFindSpan(spans, "getItem", trace.SpanKindInternal, t)
+
+	if root.Parent.IsValid() {
+		t.Errorf("root span has parent %s", root.Parent.SpanID())
+	}
+
+	var tests = []struct {
+		child  tracetest.SpanStub
+		parent tracetest.SpanStub
+	}{
+		{handler, root},
+		{client, handler},
+		{itemRoot, client},
+		{itemHandler, itemRoot},
+	}
+
+	for _, tt := range tests {
+		if tt.child.Parent.SpanID() != tt.parent.SpanContext.SpanID() {
+			t.Errorf("parent of %s is %s, want %s", tt.child.Name, tt.child.Parent.SpanID(), tt.parent.Name)
+		}
+	}
+
+	// Both services respond in a span of the same name, so children are told apart by their parent
+	for _, name := range []string{"RedisGetNextOrderID", "RedisSetOrder", "Respond"} {
+		var found bool
+		for _, s := range spans {
+			if s.Name == name && s.Parent.SpanID() == handler.SpanContext.SpanID() {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("createOrder has no child span named %s", name)
+		}
+	}
+
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("span %s belongs to trace %s, want %s", s.Name, s.SpanContext.TraceID(), root.SpanContext.TraceID())
+		}
+	}
+}
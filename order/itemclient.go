@@ -0,0 +1,225 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/item/itempb"
+	"github.com/obitech/micro-obs/util"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	transportHTTP = "http"
+	transportGRPC = "grpc"
+)
+
+// newItemClientDuration creates the histogram observing the latency of calls to the item service
+// of a service, partitioned by transport.
+func newItemClientDuration(serviceName string) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        "item_client_request_duration_seconds",
+			Help:        "A histogram for latencies of requests to the item service.",
+			Buckets:     append([]float64{.001, .003}, prometheus.DefBuckets...),
+			ConstLabels: prometheus.Labels{"service": serviceName},
+		},
+		[]string{"transport", "code"},
+	)
+}
+
+// itemClient retrieves items from the item service.
+type itemClient interface {
+	// getItem returns the item with the passed ID. Errors of the item service are returned as
+	// apierr.Error, e.g. apierr.NotFound if the item doesn't exist or apierr.Unavailable if the
+	// service can't be reached.
+	getItem(ctx context.Context, itemID string) (*Item, error)
+
+	// ping returns an error if the item service can't be reached.
+	ping(ctx context.Context) error
+
+	// Close releases resources held by the client.
+	Close() error
+}
+
+// observeItemCall records the duration of a call to the item service in duration.
+func observeItemCall(duration *prometheus.HistogramVec, transport string, start time.Time, code int) {
+	duration.With(prometheus.Labels{
+		"transport": transport,
+		"code":      fmt.Sprintf("%d", code),
+	}).Observe(time.Since(start).Seconds())
+}
+
+// httpItemClient queries the item service via its HTTP API.
+type httpItemClient struct {
+	address  string
+	token    string
+	client   *http.Client
+	duration *prometheus.HistogramVec
+}
+
+func newHTTPItemClient(address, token string, duration *prometheus.HistogramVec) *httpItemClient {
+	return &httpItemClient{
+		address:  address,
+		token:    token,
+		client:   &http.Client{},
+		duration: duration,
+	}
+}
+
+func (c *httpItemClient) getItem(ctx context.Context, itemID string) (*Item, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "getItem", ext.SpanKindRPCClient)
+	defer span.Finish()
+	start := time.Now()
+
+	// Create item service request
+	url := fmt.Sprintf("%s/items/%s", c.address, itemID)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create request to item service")
+	}
+
+	// Inject requestID
+	reqID := util.RequestIDFromContext(ctx)
+	req.Header.Add("X-Request-ID", reqID)
+
+	// Inject credentials
+	util.SetAuthorization(ctx, req.Header, c.token)
+
+	// Inject tracer
+	ext.HTTPMethod.Set(span, "GET")
+	span.Tracer().Inject(
+		span.Context(),
+		ot.HTTPHeaders,
+		ot.HTTPHeadersCarrier(req.Header),
+	)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		observeItemCall(c.duration, transportHTTP, start, http.StatusServiceUnavailable)
+		return nil, apierr.Wrap(err, apierr.Unavailable, "unable to connect to item service")
+	}
+	defer resp.Body.Close()
+	observeItemCall(c.duration, transportHTTP, start, resp.StatusCode)
+
+	if err := apierr.FromResponse(resp); err != nil {
+		return nil, err
+	}
+
+	// Read response
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read response from item service")
+	}
+
+	// Parse respone
+	var r item.Response
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse respone from item service")
+	}
+
+	if r.Count == 0 || r.Data == nil {
+		return nil, errors.New("no items returned from item service")
+	}
+
+	// Retrieve items from response
+	for _, item := range r.Data {
+		if itemID == item.ID {
+			return &Item{
+				ID:  itemID,
+				Qty: item.Qty,
+			}, nil
+		}
+	}
+	return nil, errors.New("no items to yield")
+}
+
+// ping requests the liveness endpoint of the item service.
+func (c *httpItemClient) ping(ctx context.Context) error {
+	req, err := http.NewRequest("GET", c.address+"/livez", nil)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create request to item service")
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "unable to connect to item service")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("invalid status code from item service: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *httpItemClient) Close() error {
+	return nil
+}
+
+// grpcItemClient queries the item service via its gRPC API.
+type grpcItemClient struct {
+	conn     *grpc.ClientConn
+	client   itempb.ItemServiceClient
+	health   healthpb.HealthClient
+	duration *prometheus.HistogramVec
+}
+
+func newGRPCItemClient(address, token string, duration *prometheus.HistogramVec) (*grpcItemClient, error) {
+	conn, err := util.DialGRPC(address, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcItemClient{
+		conn:     conn,
+		client:   itempb.NewItemServiceClient(conn),
+		health:   healthpb.NewHealthClient(conn),
+		duration: duration,
+	}, nil
+}
+
+func (c *grpcItemClient) getItem(ctx context.Context, itemID string) (*Item, error) {
+	span, ctx := ot.StartSpanFromContext(ctx, "getItem")
+	defer span.Finish()
+	start := time.Now()
+
+	i, err := c.client.Get(ctx, &itempb.GetRequest{Id: itemID})
+	observeItemCall(c.duration, transportGRPC, start, apierr.StatusFromGRPC(err))
+	if err != nil {
+		return nil, apierr.FromGRPC(err)
+	}
+
+	return &Item{
+		ID:  i.GetId(),
+		Qty: int(i.GetQty()),
+	}, nil
+}
+
+// ping queries the standard gRPC health service of the item service.
+func (c *grpcItemClient) ping(ctx context.Context) error {
+	resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return errors.Wrapf(err, "unable to reach item service")
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return errors.Errorf("item service is %s", resp.GetStatus())
+	}
+	return nil
+}
+
+func (c *grpcItemClient) Close() error {
+	return c.conn.Close()
+}
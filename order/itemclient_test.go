@@ -0,0 +1,256 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// helperPrepareItemService starts an item service backed by miniredis, serving both HTTP and gRPC.
+// It returns the HTTP URL, the gRPC address and a function to tear everything down.
+func helperPrepareItemService(t *testing.T, options ...util.ServerOption) (string, string, func()) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unable to start miniredis: %s", err)
+	}
+
+	is, err := item.NewServer(append([]util.ServerOption{
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+	}, options...)...)
+	if err != nil {
+		t.Fatalf("unable to create item server: %s", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		qty  int
+	}{
+		{"banana", 5},
+		{"water", 10},
+	} {
+		i, err := item.NewItem(tt.name, "", tt.qty)
+		if err != nil {
+			t.Fatalf("unable to create item: %s", err)
+		}
+		if err := is.RedisSetItem(context.Background(), i); err != nil {
+			t.Fatalf("unable to store item: %s", err)
+		}
+	}
+
+	hs := httptest.NewServer(is)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create listener: %s", err)
+	}
+	go is.ServeGRPC(l)
+
+	return hs.URL, l.Addr().String(), func() {
+		hs.Close()
+		l.Close()
+		mr.Close()
+	}
+}
+
+func TestItemClient(t *testing.T) {
+	httpAddr, grpcAddr, done := helperPrepareItemService(t, util.SetInsecureNoAuth(true))
+	defer done()
+
+	banana, _ := item.NewItem("banana", "", 5)
+	water, _ := item.NewItem("water", "", 10)
+
+	for _, transport := range []string{transportHTTP, transportGRPC} {
+		t.Run(transport, func(t *testing.T) {
+			mr, _ := miniredis.Run()
+			defer mr.Close()
+
+			s, err := NewServer(
+				util.SetInsecureNoAuth(true),
+				util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+				SetItemServiceAddress(httpAddr),
+				SetItemServiceGRPCAddress(grpcAddr),
+				SetItemTransport(transport),
+			)
+			if err != nil {
+				t.Fatalf("unable to create server: %s", err)
+			}
+			defer s.items.Close()
+
+			t.Run("Ready", func(t *testing.T) {
+				helperSendSimpleRequest(s, "GET", "/readyz", http.StatusOK, t)
+			})
+
+			t.Run("Get existing item", func(t *testing.T) {
+				i, err := s.getItem(context.Background(), banana.ID)
+				if err != nil {
+					t.Fatalf("unable to get item: %s", err)
+				}
+				if i.ID != banana.ID || i.Qty != banana.Qty {
+					t.Errorf("getItem(%s) = %+v, want %+v", banana.ID, i, &Item{banana.ID, banana.Qty})
+				}
+			})
+
+			t.Run("Get missing item", func(t *testing.T) {
+				_, err := s.getItem(context.Background(), "unknown")
+				if !apierr.Is(err, apierr.NotFound) {
+					t.Errorf("getItem(unknown) returned %#v, want %s error", err, apierr.NotFound)
+				}
+			})
+
+			t.Run("Create orders", func(t *testing.T) {
+				var tests = []struct {
+					order *Order
+					want  int
+				}{
+					{&Order{Items: []*Item{{banana.ID, 2}, {water.ID, 10}}}, http.StatusCreated},
+					{&Order{Items: []*Item{{banana.ID, 6}}}, http.StatusUnprocessableEntity},
+					{&Order{Items: []*Item{{"unknown", 1}}}, http.StatusNotFound},
+				}
+
+				for _, tt := range tests {
+					js, err := json.Marshal(tt.order)
+					if err != nil {
+						t.Fatalf("unable to marshal %#v: %s", tt.order, err)
+					}
+					helperSendJSON(true, js, s, "POST", "/orders/create", tt.want, t)
+				}
+			})
+
+			t.Run("Metrics", func(t *testing.T) {
+				// Calls of servers using the other transport must not show up.
+				if got := testutil.CollectAndCount(s.itemDuration); got != 2 {
+					t.Errorf("item_client_request_duration_seconds has %d series, want 2", got)
+				}
+			})
+		})
+	}
+
+	t.Run("Unreachable item service", func(t *testing.T) {
+		// Reserve an address nobody listens on
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to create listener: %s", err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+
+		for _, transport := range []string{transportHTTP, transportGRPC} {
+			mr, _ := miniredis.Run()
+			defer mr.Close()
+
+			s, err := NewServer(
+				util.SetInsecureNoAuth(true),
+				util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+				SetItemServiceAddress("http://"+addr),
+				SetItemServiceGRPCAddress(addr),
+				SetItemTransport(transport),
+			)
+			if err != nil {
+				t.Fatalf("unable to create server: %s", err)
+			}
+			defer s.items.Close()
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			var report util.HealthReport
+			json.Unmarshal(w.Body.Bytes(), &report)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("%s: /readyz returned %d, want %d", transport, w.Code, http.StatusServiceUnavailable)
+			}
+			for _, c := range report.Checks {
+				if want := map[string]string{"item": util.HealthDown, "redis": util.HealthUp}[c.Name]; c.Status != want {
+					t.Errorf("%s: check %s is %s, want %s", transport, c.Name, c.Status, want)
+				}
+			}
+
+			w = httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("POST", "/orders/create", strings.NewReader(`{"items": [{"id": "abc", "qty": 1}]}`)))
+			if err := apierr.FromResponse(w.Result()); !apierr.Is(err, apierr.Unavailable) {
+				t.Errorf("%s: creating order returned %d with error %v, want %s problem", transport, w.Code, err, apierr.Unavailable)
+			}
+		}
+	})
+
+	t.Run("Authentication", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "apikeys")
+		if err != nil {
+			t.Fatalf("unable to create API keys file: %s", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(`[
+			{"key": "order-secret", "subject": "order", "roles": ["operator"]},
+			{"key": "user-secret", "subject": "user", "roles": ["operator"]},
+			{"key": "reader-secret", "subject": "reader", "roles": ["reader"]}
+		]`)
+		f.Close()
+
+		httpAddr, grpcAddr, done := helperPrepareItemService(t, util.SetAPIKeysFile(f.Name()))
+		defer done()
+
+		var tests = []struct {
+			name    string
+			token   string
+			headers map[string]string
+			want    int
+		}{
+			{"Service token", "order-secret", map[string]string{"X-API-Key": "user-secret"}, http.StatusCreated},
+			{"Forwarded credentials", "", map[string]string{"X-API-Key": "user-secret"}, http.StatusCreated},
+			{"Missing credentials", "", nil, http.StatusUnauthorized},
+			{"Missing role", "", map[string]string{"X-API-Key": "reader-secret"}, http.StatusForbidden},
+			{"Service token of reader", "reader-secret", map[string]string{"X-API-Key": "user-secret"}, http.StatusCreated},
+			{"Invalid service token", "guess", map[string]string{"X-API-Key": "user-secret"}, http.StatusInternalServerError},
+		}
+
+		for _, transport := range []string{transportHTTP, transportGRPC} {
+			for _, tt := range tests {
+				mr, _ := miniredis.Run()
+				defer mr.Close()
+
+				s, err := NewServer(
+					util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+					util.SetAPIKeysFile(f.Name()),
+					SetItemServiceAddress(httpAddr),
+					SetItemServiceGRPCAddress(grpcAddr),
+					SetItemTransport(transport),
+					SetItemServiceToken(tt.token),
+				)
+				if err != nil {
+					t.Fatalf("unable to create server: %s", err)
+				}
+				defer s.items.Close()
+
+				// Health checks are public
+				helperSendSimpleRequest(s, "GET", "/readyz", http.StatusOK, t)
+
+				req := httptest.NewRequest("POST", "/orders/create", strings.NewReader(fmt.Sprintf(`{"items": [{"id": "%s", "qty": 1}]}`, banana.ID)))
+				for k, v := range tt.headers {
+					req.Header.Set(k, v)
+				}
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, req)
+				if w.Code != tt.want {
+					t.Errorf("%s: %s: creating order returned %d, want %d: %s", transport, tt.name, w.Code, tt.want, w.Body)
+				}
+			}
+		}
+	})
+
+	t.Run("Invalid transport", func(t *testing.T) {
+		if _, err := NewServer(SetItemTransport("carrier-pigeon")); err == nil {
+			t.Errorf("expected error when setting item transport to carrier-pigeon")
+		}
+	})
+}
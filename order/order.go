@@ -2,18 +2,14 @@ package order
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/obitech/micro-obs/item"
 	"github.com/obitech/micro-obs/util"
-	ot "github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
 	"github.com/pkg/errors"
 )
 
@@ -30,13 +26,8 @@ type Item struct {
 	Qty int    `json:"qty"`
 }
 
-type notFoundError struct {
-	err string
-}
-
-func (e notFoundError) Error() string {
-	return e.err
-}
+// validItemID matches the IDs assigned by the item service.
+var validItemID = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
 
 func (o *Order) String() string {
 	return fmt.Sprintf("ID:%d Items:%+v", o.ID, o.Items)
@@ -54,6 +45,43 @@ func NewItem(item *item.Item) (*Item, error) {
 	}, nil
 }
 
+// Validate checks the fields of an Order. Every item needs a valid ID and may only appear once.
+func (o *Order) Validate() util.FieldErrors {
+	var (
+		errs util.FieldErrors
+		seen = make(map[string]int)
+	)
+
+	if len(o.Items) == 0 {
+		errs.Add("items", "is required")
+		return errs
+	}
+
+	for n, i := range o.Items {
+		path := fmt.Sprintf("items[%d]", n)
+		if i == nil {
+			errs.Add(path, "must not be null")
+			continue
+		}
+
+		switch {
+		case i.ID == "":
+			errs.Add(path+".id", "is required")
+		case !validItemID.MatchString(i.ID):
+			errs.Add(path+".id", "must be alphanumeric")
+		default:
+			if first, ok := seen[i.ID]; ok {
+				errs.Add(path+".id", "duplicate of items[%d].id", first)
+			} else {
+				seen[i.ID] = n
+			}
+		}
+		errs.CheckRange(path+".qty", i.Qty, 1, item.MaxQty)
+	}
+
+	return errs
+}
+
 // Sort will sort the order items according to ID
 func (o *Order) Sort() error {
 	if o.Items == nil || len(o.Items) == 0 {
@@ -132,65 +160,5 @@ func UnmarshalRedis(id string, items map[string]int, order *Order) error {
 
 // getItem will query the item service to retrieve a item for a specific quantity
 func (s *Server) getItem(ctx context.Context, itemID string) (*Item, error) {
-	span, ctx := ot.StartSpanFromContext(ctx, "getItem")
-	defer span.Finish()
-
-	// Create item service request
-	url := fmt.Sprintf("%s/items/%s", s.itemService, itemID)
-	req, err := http.NewRequest("GET", url, nil)
-
-	// Inject requestID
-	reqID := util.RequestIDFromContext(ctx)
-	req.Header.Add("X-Request-ID", reqID)
-
-	// Inect tracer
-	ext.SpanKindRPCClient.Set(span)
-	ext.HTTPMethod.Set(span, "GET")
-	span.Tracer().Inject(
-		span.Context(),
-		ot.HTTPHeaders,
-		ot.HTTPHeadersCarrier(req.Header),
-	)
-
-	c := &http.Client{}
-	resp, err := c.Do(req)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to connect to item service")
-	}
-
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, notFoundError{fmt.Sprintf("item id %s not found", itemID)}
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("invalid status code from item service: %d", resp.StatusCode)
-	}
-
-	// Read response
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to read response from item service")
-	}
-	defer resp.Body.Close()
-
-	// Parse respone
-	var r item.Response
-	err = json.Unmarshal(b, &r)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to parse respone from item service")
-	}
-
-	if r.Count == 0 || r.Data == nil {
-		return nil, errors.New("no items returned from item service")
-	}
-
-	// Retrieve items from response
-	for _, item := range r.Data {
-		if itemID == item.ID {
-			return &Item{
-				ID:  itemID,
-				Qty: item.Qty,
-			}, nil
-		}
-	}
-	return nil, errors.New("no items to yield")
+	return s.items.getItem(ctx, itemID)
 }
@@ -6,8 +6,9 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/util"
 )
 
 var (
@@ -16,11 +17,11 @@ var (
 		desc string
 		qty  int
 	}{
-		{"test", "test", 0},
+		{"test", "test", 1},
 		{"orange", "a juicy fruit", 100},
 		{"😍", "lovely smily", 999},
 		{"     ", "﷽", 249093419},
-		{" 123asd🙆   🙋 asdlloqwe", "test", 0},
+		{" 123asd🙆   🙋 asdlloqwe", "test", 1},
 	}
 
 	sampleOrderIDs = []int64{-1, 0, 12, 42, 1242352235}
@@ -35,7 +36,8 @@ func helperInitItemServer(t *testing.T) (*miniredis.Miniredis, *item.Server) {
 
 	// Setup server
 	s, err := item.NewServer(
-		item.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		util.SetInsecureNoAuth(true),
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
 	)
 	if err != nil {
 		mr.Close()
@@ -112,3 +114,31 @@ func TestMarshalRedis(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateOrder(t *testing.T) {
+	var tests = []struct {
+		order  *Order
+		fields []string
+	}{
+		{&Order{ID: 1, Items: []*Item{{"aab", 1}, {"asdyb", 2}}}, nil},
+		{&Order{ID: -1, Items: []*Item{{"aab", item.MaxQty}}}, nil},
+		{&Order{}, []string{"items"}},
+		{&Order{Items: []*Item{nil}}, []string{"items[0]"}},
+		{&Order{Items: []*Item{{"", 1}}}, []string{"items[0].id"}},
+		{&Order{Items: []*Item{{"a-b", 1}}}, []string{"items[0].id"}},
+		{&Order{Items: []*Item{{"aab", -1}}}, []string{"items[0].qty"}},
+		{&Order{Items: []*Item{{"aab", 0}}}, []string{"items[0].qty"}},
+		{&Order{Items: []*Item{{"aab", 1}, {"asdyb", 1}, {"aab", 2}}}, []string{"items[2].id"}},
+	}
+
+	for _, tt := range tests {
+		var fields []string
+		for _, fe := range tt.order.Validate() {
+			fields = append(fields, fe.Field)
+		}
+
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("%v.Validate() returned errors for %v, want %v", tt.order, fields, tt.fields)
+		}
+	}
+}
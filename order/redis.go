@@ -21,16 +21,17 @@ func removeNamespace(key string) string {
 
 // RedisScanOrders retrieves the IDs (keys) of all orders.
 func (s *Server) RedisScanOrders(ctx context.Context) ([]int64, error) {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisScanOrders")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisScanOrders")
 	defer span.Finish()
 
+	rc := s.RedisContext(ctx)
 	var cursor uint64
 	var keys []int64
 	var err error
 
 	for {
 		var ks []string
-		ks, cursor, err = s.redis.Scan(cursor, fmt.Sprintf("%s:*", orderKeyNamespace), 10).Result()
+		ks, cursor, err = rc.Scan(cursor, fmt.Sprintf("%s:*", orderKeyNamespace), 10).Result()
 		if err != nil {
 			return nil, err
 		}
@@ -53,10 +54,10 @@ func (s *Server) RedisScanOrders(ctx context.Context) ([]int64, error) {
 
 // RedisGetNextOrderID retrieves increments the order ID counter in redis and returns it.
 func (s *Server) RedisGetNextOrderID(ctx context.Context) (int64, error) {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisGetNextOrderID")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisGetNextOrderID")
 	defer span.Finish()
 
-	r, err := s.redis.Incr(nextIDKey).Result()
+	r, err := s.RedisContext(ctx).Incr(nextIDKey).Result()
 	if err != nil {
 		return -1, err
 	}
@@ -65,7 +66,7 @@ func (s *Server) RedisGetNextOrderID(ctx context.Context) (int64, error) {
 
 // RedisSetOrder creates or updates a new order in Redis.
 func (s *Server) RedisSetOrder(ctx context.Context, o *Order) error {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisScanOrders")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisSetOrder")
 	defer span.Finish()
 
 	if o.Items == nil {
@@ -74,8 +75,9 @@ func (s *Server) RedisSetOrder(ctx context.Context, o *Order) error {
 
 	id, items := o.MarshalRedis()
 	key := appendNamespace(id)
+	rc := s.RedisContext(ctx)
 	for k, v := range items {
-		if err := s.redis.HSet(key, k, v).Err(); err != nil {
+		if err := rc.HSet(key, k, v).Err(); err != nil {
 			return err
 		}
 	}
@@ -85,12 +87,12 @@ func (s *Server) RedisSetOrder(ctx context.Context, o *Order) error {
 
 // RedisGetOrder retrieves a single order from Redis.
 func (s *Server) RedisGetOrder(ctx context.Context, id int64) (*Order, error) {
-	span, _ := ot.StartSpanFromContext(ctx, "RedisGetOrder")
+	span, ctx := ot.StartSpanFromContext(ctx, "RedisGetOrder")
 	defer span.Finish()
 
 	key := strconv.FormatInt(id, 10)
 	key = appendNamespace(key)
-	r, err := s.redis.HGetAll(key).Result()
+	r, err := s.RedisContext(ctx).HGetAll(key).Result()
 	if err != nil {
 		return nil, err
 	}
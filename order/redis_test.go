@@ -8,9 +8,10 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis"
 	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/util"
 )
 
 func helperPrepareMiniredis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
@@ -42,14 +43,14 @@ func TestOrderRedis(t *testing.T) {
 	defer mr.Close()
 
 	s, err := NewServer(
-		SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
 	)
 	if err != nil {
 		t.Errorf("unable to create server: %s", err)
 	}
 
 	t.Run("Pinging miniredis with Server", func(t *testing.T) {
-		if _, err := s.redis.Ping().Result(); err != nil {
+		if _, err := s.Redis.Ping().Result(); err != nil {
 			t.Errorf("unable to ping miniredis: %s", err)
 		}
 	})
@@ -64,7 +65,7 @@ func TestOrderRedis(t *testing.T) {
 			t.Errorf("id mismatch, got: %d, want: %d", id, wantID)
 		}
 
-		r, err := s.redis.Get(nextIDKey).Result()
+		r, err := s.Redis.Get(nextIDKey).Result()
 		if err != nil {
 			t.Errorf("unable to GET %s: %s", nextIDKey, err)
 		}
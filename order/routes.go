@@ -1,115 +1,75 @@
 package order
 
 import (
-	"github.com/obitech/micro-obs/util"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-)
+	"net/http"
 
-var rm = util.NewRequestMetricHistogram(
-	append([]float64{.001, .003}, prometheus.DefBuckets...),
-	[]float64{1, 5, 10, 50, 100},
+	"github.com/obitech/micro-obs/util"
 )
 
-// Routes defines all HTTP routes, hanging off the main Server struct.
+// routes defines all HTTP routes of the service, hanging off the main Server struct.
 // Like that, all routes have access to the Server's dependencies.
-func (s *Server) createRoutes() {
-	var routes = util.Routes{
+func (s *Server) routes() util.Routes {
+	res := Response{}
+	problem := util.Problem{}
+	read := util.Access{Roles: []string{util.RoleReader}, Scopes: []string{ScopeRead}}
+	write := util.Access{Roles: []string{util.RoleOperator}, Scopes: []string{ScopeWrite}}
+	return util.Routes{
 		util.Route{
 			Name:        "pong",
 			Method:      "GET",
 			Pattern:     "/",
 			HandlerFunc: s.pong(),
-		},
-		util.Route{
-			Name:        "healthz",
-			Method:      "GET",
-			Pattern:     "/healthz",
-			HandlerFunc: util.Healthz(),
+			Summary:     "Ping the service",
+			Responses:   map[int]interface{}{http.StatusOK: res},
+			Access:      util.Access{Public: true},
 		},
 		util.Route{
 			Name:        "getAllOrders",
 			Method:      "GET",
 			Pattern:     "/orders",
-			HandlerFunc: s.getAllOrders(),
+			HandlerFunc: s.Handle(s.getAllOrders()),
+			Summary:     "Retrieve all orders",
+			Responses:   map[int]interface{}{http.StatusOK: res, http.StatusNotFound: problem, http.StatusInternalServerError: problem},
+			Access:      read,
 		},
 		util.Route{
-			Name:        "setOrder",
+			Name:        "setOrderPOST",
 			Method:      "POST",
 			Pattern:     "/orders",
-			HandlerFunc: s.setOrder(false),
+			HandlerFunc: s.Handle(s.setOrder(false)),
+			Summary:     "Store a new order without checking item availability",
+			Request:     Order{},
+			Responses:   map[int]interface{}{http.StatusCreated: res, http.StatusBadRequest: problem, http.StatusUnprocessableEntity: problem, http.StatusInternalServerError: problem},
+			Access:      write,
 		},
 		util.Route{
-			Name:        "setOrder",
+			Name:        "setOrderPUT",
 			Method:      "PUT",
 			Pattern:     "/orders",
-			HandlerFunc: s.setOrder(true),
+			HandlerFunc: s.Handle(s.setOrder(true)),
+			Summary:     "Store or replace an order without checking item availability",
+			Request:     Order{},
+			Responses:   map[int]interface{}{http.StatusOK: res, http.StatusBadRequest: problem, http.StatusUnprocessableEntity: problem, http.StatusInternalServerError: problem},
+			Access:      write,
 		},
 		util.Route{
 			Name:        "getOrder",
 			Method:      "GET",
 			Pattern:     "/orders/{id:-?[0-9]+}",
-			HandlerFunc: s.getOrder(),
+			HandlerFunc: s.Handle(s.getOrder()),
+			Summary:     "Retrieve a single order",
+			Responses:   map[int]interface{}{http.StatusOK: res, http.StatusBadRequest: problem, http.StatusNotFound: problem, http.StatusInternalServerError: problem},
+			Access:      read,
 		},
 		util.Route{
 			Name:        "createOrder",
 			Method:      "POST",
 			Pattern:     "/orders/create",
-			HandlerFunc: s.createOrder(),
-		},
-		util.Route{
-			Name:        "delay",
-			Method:      "GET",
-			Pattern:     "/delay",
-			HandlerFunc: s.delay(),
-		},
-		util.Route{
-			Name:        "simulateError",
-			Method:      "GET",
-			Pattern:     "/error",
-			HandlerFunc: s.simulateError(),
+			HandlerFunc: s.Handle(s.createOrder()),
+			Summary:     "Create an order after checking item availability",
+			Request:     Order{},
+			Responses:   map[int]interface{}{http.StatusCreated: res, http.StatusBadRequest: problem, http.StatusNotFound: problem, http.StatusUnprocessableEntity: problem, http.StatusInternalServerError: problem, http.StatusServiceUnavailable: problem},
+			Access:      write,
 		},
 	}
-
-	for _, route := range routes {
-		h := route.HandlerFunc
-
-		// Tracing each request
-		h = util.TracerMiddleware(h, route)
-
-		// Logging each request
-		h = util.LoggerMiddleware(h, s.logger)
-
-		// Assign requestID to each request
-		h = util.AssignRequestID(h, s.logger)
-
-		// Monitoring each request
-		// TODO: pass proper handler
-		promHandler := util.PrometheusMiddleware(h, route.Pattern, rm)
-
-		s.router.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(promHandler)
-	}
-
-	// Prometheus endpoint
-	route := util.Route{
-		Name:        "metrics",
-		Method:      "GET",
-		Pattern:     "/metrics",
-		HandlerFunc: nil,
-	}
-	promHandler := promhttp.HandlerFor(s.promReg, promhttp.HandlerOpts{})
-	promHandler = promhttp.InstrumentMetricHandler(s.promReg, promHandler)
-	s.router.
-		Methods(route.Method).
-		Path(route.Pattern).
-		Name(route.Name).
-		Handler(promHandler)
-
-	// 404 handler
-	notFound := util.PrometheusMiddleware(s.notFound(), "metrics", rm)
-	s.router.NotFoundHandler = notFound
 }
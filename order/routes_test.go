@@ -0,0 +1,99 @@
+package order
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/obitech/micro-obs/item"
+	"github.com/obitech/micro-obs/util"
+	"github.com/obitech/micro-obs/util/utiltest"
+)
+
+func TestOpenAPI(t *testing.T) {
+	httpAddr, _, done := helperPrepareItemService(t, util.SetInsecureNoAuth(true))
+	defer done()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unable to start miniredis: %s", err)
+	}
+	defer mr.Close()
+
+	s, err := NewServer(
+		util.SetInsecureNoAuth(true),
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		SetItemServiceAddress(httpAddr),
+	)
+	if err != nil {
+		t.Fatalf("unable to create server: %s", err)
+	}
+
+	t.Run("Serve document", func(t *testing.T) {
+		for _, name := range []string{"getAllOrders", "setOrderPOST", "setOrderPUT", "getOrder", "createOrder", "openAPI"} {
+			if _, ok := s.OpenAPI.Operation(name); !ok {
+				t.Errorf("OpenAPI document is missing operation %s", name)
+			}
+		}
+		helperSendSimpleRequest(s, "GET", "/openapi.json", http.StatusOK, t)
+	})
+
+	t.Run("Contract", func(t *testing.T) {
+		banana, _ := item.NewItem("banana", "", 5)
+
+		var tests = []struct {
+			method string
+			path   string
+			body   string
+			want   int
+		}{
+			{"GET", "/", "", http.StatusOK},
+			{"GET", "/healthz", "", http.StatusOK},
+			{"GET", "/livez", "", http.StatusOK},
+			{"GET", "/openapi.json", "", http.StatusOK},
+			{"GET", "/orders", "", http.StatusNotFound},
+			{"POST", "/orders", validJSON[0], http.StatusCreated},
+			{"POST", "/orders", validJSON[0], http.StatusUnprocessableEntity},
+			{"POST", "/orders", `{`, http.StatusBadRequest},
+			{"PUT", "/orders", validJSON[0], http.StatusOK},
+			{"PUT", "/orders", `{}`, http.StatusUnprocessableEntity},
+			{"GET", "/orders", "", http.StatusOK},
+			{"GET", "/orders/99", "", http.StatusOK},
+			{"GET", "/orders/1", "", http.StatusNotFound},
+			{"GET", "/orders/99999999999999999999", "", http.StatusBadRequest},
+			{"POST", "/orders/create", fmt.Sprintf(`{"items": [{"id": %q, "qty": 1}]}`, banana.ID), http.StatusCreated},
+			{"POST", "/orders/create", fmt.Sprintf(`{"items": [{"id": %q, "qty": 6}]}`, banana.ID), http.StatusUnprocessableEntity},
+			{"POST", "/orders/create", `{"items": [{"id": "unknown", "qty": 1}]}`, http.StatusNotFound},
+			{"POST", "/orders/create", `[`, http.StatusBadRequest},
+			{"GET", "/admin/faults", "", http.StatusOK},
+			{"POST", "/admin/faults", `[{"name": "failing", "routes": ["createOrder"], "status": 503, "percentage": 50}]`, http.StatusOK},
+			{"DELETE", "/admin/faults", "", http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			utiltest.CheckContract(s, s.Router, s.OpenAPI, tt.method, tt.path, tt.body, nil, tt.want, t)
+		}
+	})
+
+	t.Run("Contract on Redis failure", func(t *testing.T) {
+		mr, s := helperPrepareRedis(t)
+		defer mr.Close()
+		s.Redis.Close()
+
+		var tests = []struct {
+			method string
+			path   string
+			body   string
+		}{
+			{"GET", "/orders", ""},
+			{"POST", "/orders", validJSON[0]},
+			{"GET", "/orders/99", ""},
+		}
+
+		for _, tt := range tests {
+			utiltest.CheckContract(s, s.Router, s.OpenAPI, tt.method, tt.path, tt.body, nil, http.StatusInternalServerError, t)
+		}
+	})
+}
@@ -7,11 +7,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/obitech/micro-obs/util"
 )
 
@@ -84,19 +85,20 @@ var (
 	}{
 		{"GET", "/", http.StatusOK},
 		{"GET", "/healthz", http.StatusOK},
+		{"GET", "/livez", http.StatusOK},
 		{"GET", "/asdasd", http.StatusNotFound},
 		// {"GET", "/metrics", http.StatusOK},
-		{"GET", "/delay", http.StatusOK},
+		{"GET", "/admin/faults", http.StatusOK},
 		{"GET", "/orders", http.StatusNotFound},
 		{"POST", "/orders", http.StatusBadRequest},
 		{"PUT", "/orders", http.StatusBadRequest},
-		{"GET", "/error", http.StatusInternalServerError},
+		{"GET", "/error", http.StatusNotFound},
 	}
 
 	validJSON = []string{
 		`{"id": 99, "items": [{"id": "aab", "qty": 1000}]}`,
-		`{"items": [{"id": "asdyb", "qty": 0}], "id": 98} `,
-		`{"id": 2018, "items": [{"id": "aab", "qty": 1000}, {"id": "asdyb", "qty": 0}]}`,
+		`{"items": [{"id": "asdyb", "qty": 1}], "id": 98} `,
+		`{"id": 2018, "items": [{"id": "aab", "qty": 1000}, {"id": "asdyb", "qty": 1}]}`,
 	}
 
 	invalidJSON = []struct {
@@ -117,7 +119,8 @@ func helperPrepareRedis(t *testing.T) (*miniredis.Miniredis, *Server) {
 	_, mr := helperPrepareMiniredis(t)
 
 	s, err := NewServer(
-		SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+		util.SetInsecureNoAuth(true),
+		util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
 	)
 	if err != nil {
 		t.Errorf("unable to create server: %s", err)
@@ -303,16 +306,94 @@ func TestNewServer(t *testing.T) {
 
 	t.Run("Creating new default server with custom log levels", func(t *testing.T) {
 		for _, tt := range logLevels {
-			if _, err := NewServer(SetLogLevel(tt.level)); tt.want != nil {
+			if _, err := NewServer(util.SetLogLevel(tt.level)); tt.want != nil {
 				t.Errorf("error while creating new item server: %#v", err)
 			}
 		}
 	})
 
+	t.Run("Creating new default server with log config", func(t *testing.T) {
+		cfg := util.DefaultLogConfig()
+		cfg.Encoding = util.LogEncodingConsole
+		s, err := NewServer(util.SetLogLevel("debug"), util.SetLogConfig(cfg))
+		if err != nil {
+			t.Fatalf("error while creating new %s server: %#v", serviceName, err)
+		}
+		if got := s.Logger.Levels().Level(); got != "debug" {
+			t.Errorf("log level is %s after setting log config, want debug", got)
+		}
+
+		cfg.Encoding = "logfmt"
+		if _, err := NewServer(util.SetLogConfig(cfg)); err == nil {
+			t.Errorf("expected error while setting log encoding to %#v", cfg.Encoding)
+		}
+	})
+
+	t.Run("Creating new default server with log level config", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "loglevel")
+		if err != nil {
+			t.Fatalf("unable to create temp file: %s", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(`{"level": "warn", "overrides": {"createOrder": "debug"}}`)
+		f.Close()
+
+		s, err := NewServer(util.SetLogLevel("debug"), util.SetLogLevelConfig(f.Name()))
+		if err != nil {
+			t.Fatalf("error while creating new %s server: %#v", serviceName, err)
+		}
+		if got := s.Logger.Levels().Level(); got != "warn" {
+			t.Errorf("log level is %s, want warn", got)
+		}
+		if _, err := NewServer(util.SetLogLevelConfig(f.Name() + ".missing")); err == nil {
+			t.Errorf("expected error while setting missing log level config")
+		}
+	})
+
+	t.Run("Creating new default server with tracing backends", func(t *testing.T) {
+		for _, backend := range []string{"jaeger", "otlp", "none"} {
+			if _, err := NewServer(util.SetTracing(backend)); err != nil {
+				t.Errorf("error while creating new %s server: %#v", serviceName, err)
+			}
+		}
+		if _, err := NewServer(util.SetTracing("zipkin")); err == nil {
+			t.Errorf("expected error while setting tracing backend to zipkin, got %#v", err)
+		}
+	})
+
+	t.Run("Creating new default server with propagation formats", func(t *testing.T) {
+		for _, formats := range []string{"w3c", "b3,b3multi", "jaeger, w3c"} {
+			if _, err := NewServer(util.SetPropagation(formats)); err != nil {
+				t.Errorf("error while creating new %s server: %#v", serviceName, err)
+			}
+		}
+		for _, formats := range []string{"", "zipkin"} {
+			if _, err := NewServer(util.SetPropagation(formats)); err == nil {
+				t.Errorf("expected error while setting propagation to %#v, got %#v", formats, err)
+			}
+		}
+	})
+
+	t.Run("Creating new default server with samplers", func(t *testing.T) {
+		if _, err := NewServer(
+			util.SetSampler("probabilistic", 0.1, ""),
+			util.SetSamplingOverrides("/healthz=never,getItem=always"),
+			util.SetSampleErrors(false),
+		); err != nil {
+			t.Errorf("error while creating new %s server: %#v", serviceName, err)
+		}
+		if _, err := NewServer(util.SetSampler("probabilistic", 2, "")); err == nil {
+			t.Errorf("expected error while setting sampler param to 2")
+		}
+		if _, err := NewServer(util.SetSamplingOverrides("/healthz=sometimes")); err == nil {
+			t.Errorf("expected error while setting invalid sampling override")
+		}
+	})
+
 	t.Run("Creating new default server with custom redis address", func(t *testing.T) {
 		t.Run("Checking valid addresses", func(t *testing.T) {
 			for _, v := range validRedisAddr {
-				if _, err := NewServer(SetRedisAddress(v)); err != nil {
+				if _, err := NewServer(util.SetRedisAddress(v)); err != nil {
 					t.Errorf("error while creating new item server: %#v", err)
 				}
 			}
@@ -320,7 +401,7 @@ func TestNewServer(t *testing.T) {
 
 		t.Run("Checking invalid addresses", func(t *testing.T) {
 			for _, v := range invalidRedisAddr {
-				if _, err := NewServer(SetRedisAddress(v)); err == nil {
+				if _, err := NewServer(util.SetRedisAddress(v)); err == nil {
 					t.Errorf("expected error while setting redis address to %#v, got %#v", v, err)
 				}
 			}
@@ -332,8 +413,8 @@ func TestNewServer(t *testing.T) {
 			for _, listen := range validListeningAddr {
 				for _, ep := range validEndpointAddr {
 					_, err := NewServer(
-						SetServerAddress(listen),
-						SetServerEndpoint(ep),
+						util.SetServerAddress(listen),
+						util.SetServerEndpoint(ep),
 					)
 					if err != nil {
 						t.Errorf("error while creating new item server: %#v", err)
@@ -344,8 +425,8 @@ func TestNewServer(t *testing.T) {
 		t.Run("Checking invalid addresses", func(t *testing.T) {
 			for _, tt := range invalidListeningAddr {
 				if _, err := NewServer(
-					SetServerAddress(tt),
-					SetServerEndpoint(tt),
+					util.SetServerAddress(tt),
+					util.SetServerEndpoint(tt),
 				); err == nil {
 					t.Errorf("expected error when creating item server with listening address %#v, got %#v", tt, err)
 				}
@@ -378,7 +459,8 @@ func TestEndpoints(t *testing.T) {
 		defer mr.Close()
 
 		s, err := NewServer(
-			SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
+			util.SetInsecureNoAuth(true),
+			util.SetRedisAddress(strings.Join([]string{"redis://", mr.Addr()}, "")),
 		)
 		if err != nil {
 			t.Errorf("unable to create server: %s", err)
@@ -0,0 +1,340 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/obitech/micro-obs/apierr"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Methods a Principal can be authenticated with.
+const (
+	AuthAPIKey = "apikey"
+	AuthJWT    = "jwt"
+)
+
+const (
+	// apiKeyHeader carries an API key as alternative to the Authorization header.
+	apiKeyHeader = "X-API-Key"
+
+	// bearerPrefix precedes the token in the Authorization header.
+	bearerPrefix = "bearer "
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string
+	Method  string
+	Roles   []string
+
+	// Scopes restrict a Principal authenticated with a JWT carrying a scope claim to routes
+	// requiring only those scopes. It's nil for unrestricted Principals.
+	Scopes []string
+
+	// token is the credential the Principal authenticated with, see OutgoingToken.
+	token string
+}
+
+// ContextWithPrincipal returns a copy of ctx holding p.
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the Principal of an authenticated request, nil if there is none.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+// OutgoingToken returns the token calls to other services are authenticated with: token if set,
+// otherwise the one the Principal of ctx authenticated with. It's empty for unauthenticated
+// requests.
+func OutgoingToken(ctx context.Context, token string) string {
+	if token != "" {
+		return token
+	}
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.token
+	}
+	return ""
+}
+
+// SetAuthorization sets the Authorization header of a request to another service to the bearer
+// token returned by OutgoingToken, if any.
+func SetAuthorization(ctx context.Context, h http.Header, token string) {
+	if t := OutgoingToken(ctx, token); t != "" {
+		h.Set("Authorization", "Bearer "+t)
+	}
+}
+
+// APIKey maps a static API key to the subject and roles of its Principal.
+type APIKey struct {
+	Key     string   `json:"key"`
+	Subject string   `json:"subject"`
+	Roles   []string `json:"roles"`
+}
+
+// LoadAPIKeys reads a JSON array of APIKeys from a file.
+func LoadAPIKeys(file string) ([]APIKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read API keys %s", file)
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse API keys %s", file)
+	}
+	return keys, nil
+}
+
+// Authenticator authenticates requests with static API keys or JWTs signed by a key of a JWKS.
+// Both are sent as bearer token in the Authorization header, API keys in the X-API-Key header as
+// well. An Authenticator without API keys and JWKS rejects every request, unless it's insecure.
+type Authenticator struct {
+	apiKeys  map[[sha256.Size]byte]APIKey
+	jwks     *JWKS
+	issuer   string
+	audience string
+	insecure bool
+	now      func() time.Time
+}
+
+// NewAuthenticator returns an Authenticator accepting no credentials.
+func NewAuthenticator() *Authenticator {
+	return &Authenticator{now: time.Now}
+}
+
+// SetAPIKeys replaces the API keys accepted by the Authenticator. Keys are only held as hashes.
+func (a *Authenticator) SetAPIKeys(keys []APIKey) error {
+	m := make(map[[sha256.Size]byte]APIKey, len(keys))
+	for n, k := range keys {
+		if k.Key == "" || k.Subject == "" {
+			return errors.Errorf("API key %d needs both key and subject", n)
+		}
+		h := sha256.Sum256([]byte(k.Key))
+		if _, ok := m[h]; ok {
+			return errors.Errorf("API key %d of %s is used twice", n, k.Subject)
+		}
+		m[h] = APIKey{Subject: k.Subject, Roles: k.Roles}
+	}
+	a.apiKeys = m
+	return nil
+}
+
+// SetJWKS sets the keys JWTs are verified with. Tokens need to be issued by issuer for audience,
+// unless they are empty.
+func (a *Authenticator) SetJWKS(jwks *JWKS, issuer, audience string) {
+	a.jwks = jwks
+	a.issuer = issuer
+	a.audience = audience
+}
+
+// SetInsecure disables authentication, letting all requests pass without credentials.
+func (a *Authenticator) SetInsecure(insecure bool) {
+	a.insecure = insecure
+}
+
+// Insecure returns true if authentication is disabled.
+func (a *Authenticator) Insecure() bool {
+	return a.insecure
+}
+
+// HasCredentials returns true if API keys or a JWKS are set.
+func (a *Authenticator) HasCredentials() bool {
+	return len(a.apiKeys) > 0 || a.jwks != nil
+}
+
+// Authenticate returns the Principal of a bearer token, which is a JWT if it consists of three
+// dot-separated parts and an API key otherwise. Failures are apierr.Unauthenticated errors,
+// the reason is only part of the wrapped error.
+func (a *Authenticator) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, apierr.New(apierr.Unauthenticated, "missing credentials")
+	}
+
+	if strings.Count(token, ".") == 2 {
+		p, err := a.authenticateJWT(token)
+		if err != nil {
+			return nil, apierr.Wrap(err, apierr.Unauthenticated, "invalid credentials")
+		}
+		return p, nil
+	}
+
+	k, ok := a.apiKeys[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, apierr.Wrap(errors.New("unknown API key"), apierr.Unauthenticated, "invalid credentials")
+	}
+	return &Principal{Subject: k.Subject, Method: AuthAPIKey, Roles: k.Roles, token: token}, nil
+}
+
+func (a *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	if a.jwks == nil {
+		return nil, errors.New("JWTs aren't accepted")
+	}
+
+	payload, err := a.jwks.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Wrap(err, "malformed claims")
+	}
+	if err := claims.check(a.now(), a.issuer, a.audience); err != nil {
+		return nil, err
+	}
+	p := &Principal{Subject: claims.Subject, Method: AuthJWT, Roles: claims.Roles, token: token}
+	if claims.Scope != nil {
+		p.Scopes = strings.Fields(*claims.Scope)
+	}
+	return p, nil
+}
+
+// tokenFromHeaders returns the token of an API key header or a bearer Authorization header.
+func tokenFromHeaders(authorization, apiKey string) string {
+	if apiKey != "" {
+		return apiKey
+	}
+	if len(authorization) > len(bearerPrefix) && strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+		return strings.TrimSpace(authorization[len(bearerPrefix):])
+	}
+	return ""
+}
+
+// tagPrincipal adds the Principal to the span of ctx, if any.
+func tagPrincipal(ctx context.Context, p *Principal) {
+	if span := ot.SpanFromContext(ctx); span != nil {
+		span.SetTag("auth.subject", p.Subject)
+		span.SetTag("auth.method", p.Method)
+	}
+}
+
+// AuthMiddleware authenticates and authorizes requests to routes that aren't public, unless auth is
+// insecure. The Principal is added to the request context, which adds it to request loggers and
+// the message of LoggerMiddleware, and tagged on the span. Requests without valid credentials are
+// rejected with a 401 problem, those lacking a role or scope of the route with a 403 problem
+// counted by denied.
+func AuthMiddleware(inner http.Handler, route Route, auth *Authenticator, denied *prometheus.CounterVec, logger *Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route.Public || auth.Insecure() {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		token := tokenFromHeaders(r.Header.Get("Authorization"), r.Header.Get(apiKeyHeader))
+		ctx, err := authenticate(r.Context(), auth, route.Access, route.Name, token, denied, logger)
+		if err != nil {
+			if apierr.Is(err, apierr.Unauthenticated) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			NewErrorProblem(err).SendJSON(w)
+			return
+		}
+		inner.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GRPCAuthInterceptor is the gRPC equivalent of AuthMiddleware for unary calls. The Access of
+// methods is looked up by their full name, methods missing in access are denied to everyone, even
+// if authentication is disabled.
+func GRPCAuthInterceptor(auth *Authenticator, access map[string]Access, denied *prometheus.CounterVec, logger *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticateGRPCCall(ctx, auth, access, info.FullMethod, denied, logger)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamAuthInterceptor is the gRPC equivalent of AuthMiddleware for streaming calls.
+func GRPCStreamAuthInterceptor(auth *Authenticator, access map[string]Access, denied *prometheus.CounterVec, logger *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateGRPCCall(ss.Context(), auth, access, info.FullMethod, denied, logger)
+		if err != nil {
+			return err
+		}
+		return handler(srv, wrapServerStream(ss, ctx))
+	}
+}
+
+func authenticateGRPCCall(ctx context.Context, auth *Authenticator, methods map[string]Access, fullMethod string, denied *prometheus.CounterVec, logger *Logger) (context.Context, error) {
+	access, ok := methods[fullMethod]
+	if !ok {
+		log := RequestIDLoggerFromContext(ctx, logger)
+		log.Warnw("authorization denied, method has no access declared",
+			"route", fullMethod,
+		)
+		return ctx, apierr.GRPCStatus(apierr.New(apierr.PermissionDenied, "access to %s isn't declared", fullMethod))
+	}
+
+	if access.Public || auth.Insecure() {
+		return ctx, nil
+	}
+
+	var authorization, apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			authorization = v[0]
+		}
+		if v := md.Get(apiKeyHeader); len(v) > 0 {
+			apiKey = v[0]
+		}
+	}
+
+	ctx, err := authenticate(ctx, auth, access, fullMethod, tokenFromHeaders(authorization, apiKey), denied, logger)
+	return ctx, apierr.GRPCStatus(err)
+}
+
+// authenticate authenticates the caller of route with token and checks it's granted access. The
+// returned context holds the Principal, which is added to the "request completed" message as well.
+// Failures are logged, denied access is counted.
+func authenticate(ctx context.Context, auth *Authenticator, access Access, route, token string, denied *prometheus.CounterVec, logger *Logger) (context.Context, error) {
+	p, err := auth.Authenticate(token)
+	if err != nil {
+		log := RequestIDLoggerFromContext(ctx, logger)
+		log.Infow("authentication failed",
+			"route", route,
+			"error", err,
+		)
+		return ctx, err
+	}
+
+	ctx = ContextWithPrincipal(ctx, p)
+	tagPrincipal(ctx, p)
+	addRequestLogFields(ctx, "principal", p.Subject)
+
+	if err := access.authorize(p); err != nil {
+		denied.WithLabelValues(route, p.roleLabel()).Inc()
+		log := RequestIDLoggerFromContext(ctx, logger)
+		log.Infow("authorization denied",
+			"route", route,
+			"roles", p.Roles,
+			"error", err,
+		)
+		return ctx, err
+	}
+	return ctx, nil
+}
+
+// GRPCClientAuthInterceptor authenticates calls to other services with the token returned by
+// OutgoingToken.
+func GRPCClientAuthInterceptor(token string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if t := OutgoingToken(ctx, token); t != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+t)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,334 @@
+package util
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/obitech/micro-obs/apierr"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// helperAuthenticator returns an Authenticator accepting the API key "secret" of subject order with
+// role operator and JWTs for audience item signed by the returned key.
+func helperAuthenticator(t *testing.T) (*Authenticator, crypto.Signer) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	a := NewAuthenticator()
+	if err := a.SetAPIKeys([]APIKey{{Key: "secret", Subject: "order", Roles: []string{RoleOperator}}}); err != nil {
+		t.Fatalf("unable to set API keys: %s", err)
+	}
+	a.SetJWKS(helperJWKS(map[string]crypto.Signer{"k1": key}, t), "", "item")
+	return a, key
+}
+
+func TestAuthenticator(t *testing.T) {
+	a, key := helperAuthenticator(t)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	var tests = []struct {
+		name    string
+		token   string
+		subject string
+		method  string
+		roles   []string
+		scopes  []string
+	}{
+		{"API key", "secret", "order", AuthAPIKey, []string{RoleOperator}, nil},
+		{"JWT", helperSignJWT(key, "ES256", "k1", map[string]interface{}{"sub": "alice", "aud": "item", "exp": exp}, t), "alice", AuthJWT, nil, nil},
+		{"JWT with roles and scopes", helperSignJWT(key, "ES256", "k1", map[string]interface{}{"sub": "alice", "aud": "item", "exp": exp, "roles": []string{RoleReader}, "scope": "items:read orders:read"}, t), "alice", AuthJWT, []string{RoleReader}, []string{"items:read", "orders:read"}},
+		{"JWT with empty scope", helperSignJWT(key, "ES256", "k1", map[string]interface{}{"sub": "alice", "aud": "item", "exp": exp, "scope": ""}, t), "alice", AuthJWT, nil, []string{}},
+		{"Missing credentials", "", "", "", nil, nil},
+		{"Unknown API key", "guess", "", "", nil, nil},
+		{"JWT for other audience", helperSignJWT(key, "ES256", "k1", map[string]interface{}{"sub": "alice", "aud": "order", "exp": exp}, t), "", "", nil, nil},
+		{"Expired JWT", helperSignJWT(key, "ES256", "k1", map[string]interface{}{"sub": "alice", "aud": "item", "exp": 1}, t), "", "", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authenticate(tt.token)
+			if tt.subject == "" {
+				if !apierr.Is(err, apierr.Unauthenticated) {
+					t.Errorf("Authenticate() returned %v, want %s error", err, apierr.Unauthenticated)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() returned %s", err)
+			}
+			if p.Subject != tt.subject || p.Method != tt.method || p.token != tt.token {
+				t.Errorf("Authenticate() = %+v, want %s via %s", p, tt.subject, tt.method)
+			}
+			if !reflect.DeepEqual(p.Roles, tt.roles) || !reflect.DeepEqual(p.Scopes, tt.scopes) {
+				t.Errorf("Authenticate() = %+v, want roles %v and scopes %#v", p, tt.roles, tt.scopes)
+			}
+		})
+	}
+
+	t.Run("Invalid API keys", func(t *testing.T) {
+		for _, keys := range [][]APIKey{
+			{{Key: "secret"}},
+			{{Subject: "order"}},
+			{{Key: "secret", Subject: "order"}, {Key: "secret", Subject: "gateway"}},
+		} {
+			if err := NewAuthenticator().SetAPIKeys(keys); err == nil {
+				t.Errorf("expected error setting API keys %+v", keys)
+			}
+		}
+	})
+
+	t.Run("Load API keys", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "auth")
+		if err != nil {
+			t.Fatalf("unable to create temp dir: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "keys.json")
+		ioutil.WriteFile(file, []byte(`[{"key": "secret", "subject": "order", "roles": ["reader"]}]`), 0600)
+		s, err := helperTestService("", t, SetAPIKeysFile(file))
+		if err != nil {
+			t.Fatalf("unable to create service: %s", err)
+		}
+		if p, err := s.Auth.Authenticate("secret"); err != nil || p.Subject != "order" || !p.HasRole(RoleReader) {
+			t.Errorf("Authenticate() = %+v, %v, want order with role reader", p, err)
+		}
+
+		if _, err := helperTestService("", t, SetAPIKeysFile(file), SetInsecureNoAuth(true)); err == nil {
+			t.Errorf("expected error disabling authentication with API keys")
+		}
+
+		ioutil.WriteFile(file, []byte(`[{"key": "secret"}]`), 0600)
+		for _, opt := range []ServerOption{SetAPIKeysFile(file), SetAPIKeysFile(filepath.Join(dir, "missing.json")), SetJWKSFile(file, "", "")} {
+			if _, err := helperTestService("", t, opt); err == nil {
+				t.Errorf("expected error for invalid auth config")
+			}
+		}
+	})
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	a, key := helperAuthenticator(t)
+	tracer := mocktracer.New()
+	exp := time.Now().Add(time.Hour).Unix()
+	reader := helperSignJWT(key, "ES256", "k1", map[string]interface{}{"sub": "alice", "aud": "item", "exp": exp, "roles": []string{RoleReader}, "scope": "items:read"}, t)
+
+	public := Access{Public: true}
+	read := Access{Roles: []string{RoleReader}, Scopes: []string{"items:read"}}
+	write := Access{Roles: []string{RoleOperator}, Scopes: []string{"items:write"}}
+	admin := Access{Roles: []string{RoleAdmin}}
+	insecure := NewAuthenticator()
+	insecure.SetInsecure(true)
+
+	var tests = []struct {
+		name    string
+		access  Access
+		auth    *Authenticator
+		headers map[string]string
+		want    int
+		subject string
+	}{
+		{"Public route", public, a, nil, http.StatusOK, ""},
+		{"Insecure", admin, insecure, nil, http.StatusOK, ""},
+		{"No credentials configured", admin, NewAuthenticator(), nil, http.StatusUnauthorized, ""},
+		{"No credentials configured with key", Access{}, NewAuthenticator(), map[string]string{"X-API-Key": "secret"}, http.StatusUnauthorized, ""},
+		{"Missing credentials", Access{}, a, nil, http.StatusUnauthorized, ""},
+		{"Invalid credentials", Access{}, a, map[string]string{"Authorization": "Bearer guess"}, http.StatusUnauthorized, ""},
+		{"Basic auth", Access{}, a, map[string]string{"Authorization": "Basic c2VjcmV0"}, http.StatusUnauthorized, ""},
+		{"Bearer API key", Access{}, a, map[string]string{"Authorization": "bearer secret"}, http.StatusOK, "order"},
+		{"API key header", Access{}, a, map[string]string{"X-API-Key": "secret"}, http.StatusOK, "order"},
+		{"Role", write, a, map[string]string{"X-API-Key": "secret"}, http.StatusOK, "order"},
+		{"Implied role", read, a, map[string]string{"X-API-Key": "secret"}, http.StatusOK, "order"},
+		{"Missing role", admin, a, map[string]string{"X-API-Key": "secret"}, http.StatusForbidden, "order"},
+		{"Scope", read, a, map[string]string{"Authorization": "Bearer " + reader}, http.StatusOK, "alice"},
+		{"Missing scope", Access{Scopes: []string{"items:write"}}, a, map[string]string{"Authorization": "Bearer " + reader}, http.StatusForbidden, "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer.Reset()
+			logger, logs := helperObservedLogger()
+			denied := NewAuthzDeniedCounter("item")
+
+			var principal *Principal
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal = PrincipalFromContext(r.Context())
+				RequestIDLogger(logger, r).Infow("handled")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			span := tracer.StartSpan("request")
+			req := httptest.NewRequest("GET", "/items", nil)
+			req = req.WithContext(ot.ContextWithSpan(req.Context(), span))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			route := Route{Name: "getItems", Access: tt.access}
+			LoggerMiddleware(AuthMiddleware(h, route, tt.auth, denied, logger), route, logger)(w, req)
+			span.Finish()
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+			completed := logs.FilterMessage("request completed").All()
+			if len(completed) != 1 {
+				t.Fatalf("request completed logged %d times, want once", len(completed))
+			}
+			if got := completed[0].ContextMap()["status"]; got != int64(tt.want) {
+				t.Errorf("logged status is %v, want %d", got, tt.want)
+			}
+			if got, _ := completed[0].ContextMap()["principal"].(string); got != tt.subject {
+				t.Errorf("request completed logged principal %#v, want %#v", got, tt.subject)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if err := apierr.FromResponse(w.Result()); !apierr.Is(err, apierr.Unauthenticated) {
+					t.Errorf("response is %v, want %s problem", err, apierr.Unauthenticated)
+				}
+				if w.Header().Get("WWW-Authenticate") != "Bearer" {
+					t.Errorf("WWW-Authenticate header is %#v", w.Header().Get("WWW-Authenticate"))
+				}
+				if logs.FilterMessage("authentication failed").Len() != 1 {
+					t.Errorf("failed authentication wasn't logged")
+				}
+				return
+			}
+
+			if tt.want == http.StatusForbidden {
+				if err := apierr.FromResponse(w.Result()); !apierr.Is(err, apierr.PermissionDenied) {
+					t.Errorf("response is %v, want %s problem", err, apierr.PermissionDenied)
+				}
+				if principal != nil {
+					t.Errorf("denied request reached handler with principal %+v", principal)
+				}
+				if got := logs.FilterMessage("authorization denied").All(); len(got) != 1 || got[0].ContextMap()["principal"] != tt.subject {
+					t.Errorf("denied authorization of %s wasn't logged: %v", tt.subject, got)
+				}
+				if got := testutil.ToFloat64(denied); got != 1 {
+					t.Errorf("authz_denied_total is %v, want 1", got)
+				}
+				return
+			}
+			if got := testutil.CollectAndCount(denied); got != 0 {
+				t.Errorf("authz_denied_total has %d series, want none", got)
+			}
+
+			if tt.subject == "" {
+				if principal != nil {
+					t.Errorf("unauthenticated request has principal %+v", principal)
+				}
+				return
+			}
+			if principal == nil || principal.Subject != tt.subject {
+				t.Fatalf("principal is %+v, want %s", principal, tt.subject)
+			}
+			if got := tracer.FinishedSpans()[0].Tag("auth.subject"); got != tt.subject {
+				t.Errorf("span tag auth.subject is %v, want %s", got, tt.subject)
+			}
+			if got := logs.FilterMessage("handled").All()[0].ContextMap()["principal"]; got != tt.subject {
+				t.Errorf("log field principal is %v, want %s", got, tt.subject)
+			}
+		})
+	}
+}
+
+func TestGRPCAuthInterceptor(t *testing.T) {
+	a, _ := helperAuthenticator(t)
+	logger, _ := helperObservedLogger()
+	denied := NewAuthzDeniedCounter("item")
+	interceptor := GRPCAuthInterceptor(a, map[string]Access{
+		"/item.ItemService/Get":     {Public: true},
+		"/item.ItemService/List":    {},
+		"/item.ItemService/Reserve": {Roles: []string{RoleOperator}},
+		"/item.ItemService/Delete":  {Roles: []string{RoleAdmin}},
+	}, denied, logger)
+
+	var tests = []struct {
+		method string
+		md     metadata.MD
+		want   codes.Code
+	}{
+		{"/item.ItemService/Get", nil, codes.OK},
+		{"/item.ItemService/List", nil, codes.Unauthenticated},
+		{"/item.ItemService/List", metadata.Pairs("authorization", "Bearer guess"), codes.Unauthenticated},
+		{"/item.ItemService/List", metadata.Pairs("authorization", "Bearer secret"), codes.OK},
+		{"/item.ItemService/Reserve", metadata.Pairs("x-api-key", "secret"), codes.OK},
+		{"/item.ItemService/Delete", metadata.Pairs("x-api-key", "secret"), codes.PermissionDenied},
+		{"/item.ItemService/Purge", nil, codes.PermissionDenied},
+		{"/item.ItemService/Purge", metadata.Pairs("x-api-key", "secret"), codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if tt.md != nil && PrincipalFromContext(ctx) == nil {
+				t.Errorf("%s with %v: no principal in context", tt.method, tt.md)
+			}
+			return nil, nil
+		})
+		if status.Code(err) != tt.want {
+			t.Errorf("%s with %v returned %s, want %s", tt.method, tt.md, status.Code(err), tt.want)
+		}
+	}
+
+	if got := testutil.ToFloat64(denied.WithLabelValues("/item.ItemService/Delete", RoleOperator)); got != 1 {
+		t.Errorf("authz_denied_total of Delete by operator is %v, want 1", got)
+	}
+
+	a.SetInsecure(true)
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/item.ItemService/Purge"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("/item.ItemService/Purge without authentication returned %s, want %s", status.Code(err), codes.PermissionDenied)
+	}
+}
+
+func TestOutgoingToken(t *testing.T) {
+	ctx := ContextWithPrincipal(context.Background(), &Principal{Subject: "alice", Method: AuthJWT, token: "caller"})
+
+	var tests = []struct {
+		ctx   context.Context
+		token string
+		want  string
+	}{
+		{context.Background(), "", ""},
+		{context.Background(), "service", "Bearer service"},
+		{ctx, "", "Bearer caller"},
+		{ctx, "service", "Bearer service"},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		SetAuthorization(tt.ctx, h, tt.token)
+		if got := h.Get("Authorization"); got != tt.want {
+			t.Errorf("Authorization header is %#v, want %#v", got, tt.want)
+		}
+
+		var md metadata.MD
+		GRPCClientAuthInterceptor(tt.token)(tt.ctx, "/item.ItemService/Get", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+		if got := md.Get("authorization"); (len(got) == 0 && tt.want != "") || (len(got) > 0 && got[0] != tt.want) {
+			t.Errorf("authorization metadata is %v, want %#v", got, tt.want)
+		}
+	}
+}
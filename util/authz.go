@@ -0,0 +1,109 @@
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Roles of a Principal. Each role includes the ones below it: admins are operators, operators
+// are readers.
+const (
+	RoleReader   = "reader"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// impliedRoles lists the roles included in a role.
+var impliedRoles = map[string][]string{
+	RoleOperator: {RoleReader},
+	RoleAdmin:    {RoleOperator, RoleReader},
+}
+
+// Access declares who may call a route or gRPC method once an Authenticator is enabled.
+type Access struct {
+	// Public routes are served without authentication, all others require credentials, see
+	// AuthMiddleware.
+	Public bool
+
+	// Roles the Principal needs one of. Empty allows any authenticated Principal.
+	Roles []string
+
+	// Scopes the Principal needs all of, if it's restricted to scopes, see Principal.
+	Scopes []string
+}
+
+// authorize returns an apierr.PermissionDenied error if p isn't allowed access.
+func (a Access) authorize(p *Principal) error {
+	if len(a.Roles) > 0 {
+		allowed := false
+		for _, r := range a.Roles {
+			allowed = allowed || p.HasRole(r)
+		}
+		if !allowed {
+			return apierr.New(apierr.PermissionDenied, "requires role %s", strings.Join(a.Roles, " or "))
+		}
+	}
+
+	if p.Scopes != nil {
+		for _, s := range a.Scopes {
+			if !p.HasScope(s) {
+				return apierr.New(apierr.PermissionDenied, "requires scope %s", s)
+			}
+		}
+	}
+	return nil
+}
+
+// HasRole returns true if p has role, directly or included in another role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+		for _, implied := range impliedRoles[r] {
+			if implied == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasScope returns true if p isn't restricted to scopes or has scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// roleLabel returns the roles of p as label value of authz_denied_total.
+func (p *Principal) roleLabel() string {
+	if len(p.Roles) == 0 {
+		return "none"
+	}
+	roles := append([]string{}, p.Roles...)
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+// NewAuthzDeniedCounter creates the authz_denied_total counter of a service, labeled with the
+// route name and the roles of the denied Principal.
+func NewAuthzDeniedCounter(serviceName string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "authz_denied_total",
+			Help:        "A counter for requests denied because of missing roles or scopes.",
+			ConstLabels: prometheus.Labels{"service": serviceName},
+		},
+		[]string{"route", "role"},
+	)
+}
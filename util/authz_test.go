@@ -0,0 +1,56 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/obitech/micro-obs/apierr"
+)
+
+func TestAccess(t *testing.T) {
+	var tests = []struct {
+		name    string
+		access  Access
+		roles   []string
+		scopes  []string
+		allowed bool
+	}{
+		{"Any principal", Access{}, nil, nil, true},
+		{"Role", Access{Roles: []string{RoleReader}}, []string{RoleReader}, nil, true},
+		{"One of roles", Access{Roles: []string{RoleAdmin, RoleOperator}}, []string{RoleOperator}, nil, true},
+		{"Operator is reader", Access{Roles: []string{RoleReader}}, []string{RoleOperator}, nil, true},
+		{"Admin is operator", Access{Roles: []string{RoleOperator}}, []string{RoleAdmin}, nil, true},
+		{"Reader isn't operator", Access{Roles: []string{RoleOperator}}, []string{RoleReader}, nil, false},
+		{"Operator isn't admin", Access{Roles: []string{RoleAdmin}}, []string{RoleOperator}, nil, false},
+		{"No roles", Access{Roles: []string{RoleReader}}, nil, nil, false},
+		{"Unknown role", Access{Roles: []string{RoleReader}}, []string{"guest"}, nil, false},
+		{"Unrestricted scopes", Access{Scopes: []string{"items:write"}}, nil, nil, true},
+		{"Scope", Access{Scopes: []string{"items:write"}}, nil, []string{"items:read", "items:write"}, true},
+		{"Missing scope", Access{Scopes: []string{"items:write"}}, nil, []string{"items:read"}, false},
+		{"Empty scopes", Access{Scopes: []string{"items:read"}}, nil, []string{}, false},
+		{"Role without scope", Access{Roles: []string{RoleReader}, Scopes: []string{"items:read"}}, []string{RoleAdmin}, []string{"orders:read"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.access.authorize(&Principal{Subject: "alice", Roles: tt.roles, Scopes: tt.scopes})
+			if tt.allowed && err != nil {
+				t.Errorf("authorize() returned %s", err)
+			}
+			if !tt.allowed && !apierr.Is(err, apierr.PermissionDenied) {
+				t.Errorf("authorize() returned %v, want %s error", err, apierr.PermissionDenied)
+			}
+		})
+	}
+
+	t.Run("Role label", func(t *testing.T) {
+		for roles, want := range map[*Principal]string{
+			{}:                                       "none",
+			{Roles: []string{RoleReader}}:            "reader",
+			{Roles: []string{RoleReader, RoleAdmin}}: "admin,reader",
+		} {
+			if got := roles.roleLabel(); got != want {
+				t.Errorf("roleLabel() of %v is %s, want %s", roles.Roles, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Envelope is the JSON response of all service APIs, wrapping the returned resources with a status,
+// a message and their count. Services declare it for their resources, e.g. Envelope[[]*Item].
+type Envelope[T any] struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+	Data    T      `json:"data"`
+}
+
+// NewEnvelope returns an Envelope with a passed message string and data.
+func NewEnvelope[T any](s int, m string, c int, d T) Envelope[T] {
+	return Envelope[T]{
+		Status:  s,
+		Message: m,
+		Count:   c,
+		Data:    d,
+	}
+}
+
+// SendJSON encodes an Envelope as JSON and sends it on a passed http.ResponseWriter. Nothing is sent
+// if the Envelope can't be encoded.
+func (e Envelope[T]) SendJSON(w http.ResponseWriter) error {
+	return sendJSON(w, "application/JSON; charset=UTF-8", e.Status, e)
+}
+
+// sendJSON encodes v before sending the header, so a failed encoding leaves w untouched.
+func sendJSON(w http.ResponseWriter, contentType string, status int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
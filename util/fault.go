@@ -0,0 +1,529 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/go-redis/redis"
+	"github.com/obitech/micro-obs/apierr"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// Latency distributions of injected delays.
+const (
+	// LatencyFixed delays by Mean.
+	LatencyFixed = "fixed"
+	// LatencyUniform delays by a duration between Min and Max.
+	LatencyUniform = "uniform"
+	// LatencyNormal delays by a normally distributed duration around Mean, deviating by Stddev.
+	LatencyNormal = "normal"
+	// LatencyExponential delays by an exponentially distributed duration averaging Mean.
+	LatencyExponential = "exponential"
+)
+
+// Kinds of injected faults, used as kind label of faults_injected_total.
+const (
+	FaultLatency = "latency"
+	FaultStatus  = "status"
+	FaultDrop    = "drop"
+	FaultError   = "error"
+)
+
+// StatusDropped is recorded as status of requests whose connection was dropped by a fault, like
+// nginx does for connections closed without response.
+const StatusDropped = 444
+
+// Latency is the delay injected by a Fault. Durations are strings like 250ms, sampled delays are
+// kept between Min and Max, unless they are empty.
+type Latency struct {
+	Distribution string `json:"distribution,omitempty"`
+	Mean         string `json:"mean,omitempty"`
+	Stddev       string `json:"stddev,omitempty"`
+	Min          string `json:"min,omitempty"`
+	Max          string `json:"max,omitempty"`
+
+	mean, stddev, min, max time.Duration
+}
+
+// parse checks the distribution and parses the durations of l.
+func (l *Latency) parse() error {
+	for _, d := range []struct {
+		s string
+		d *time.Duration
+	}{{l.Mean, &l.mean}, {l.Stddev, &l.stddev}, {l.Min, &l.min}, {l.Max, &l.max}} {
+		if d.s == "" {
+			*d.d = 0
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil {
+			return err
+		}
+		if v < 0 {
+			return errors.Errorf("negative duration %s", d.s)
+		}
+		*d.d = v
+	}
+	if l.Max != "" && l.max < l.min {
+		return errors.Errorf("max %s is less than min %s", l.Max, l.Min)
+	}
+
+	switch l.Distribution {
+	case "", LatencyFixed, LatencyExponential:
+		if l.mean == 0 {
+			return errors.Errorf("distribution %s needs a mean", l.distribution())
+		}
+	case LatencyNormal:
+		if l.mean == 0 || l.stddev == 0 {
+			return errors.Errorf("distribution %s needs a mean and stddev", l.Distribution)
+		}
+	case LatencyUniform:
+		if l.max == 0 {
+			return errors.Errorf("distribution %s needs a max", l.Distribution)
+		}
+	default:
+		return errors.Errorf("unknown distribution %#v, want fixed, uniform, normal or exponential", l.Distribution)
+	}
+	return nil
+}
+
+func (l *Latency) distribution() string {
+	if l.Distribution == "" {
+		return LatencyFixed
+	}
+	return l.Distribution
+}
+
+// sample returns a delay of the distribution.
+func (l *Latency) sample() time.Duration {
+	var d float64
+	switch l.distribution() {
+	case LatencyFixed:
+		d = float64(l.mean)
+	case LatencyUniform:
+		d = float64(l.min) + rand.Float64()*float64(l.max-l.min)
+	case LatencyNormal:
+		d = float64(l.mean) + rand.NormFloat64()*float64(l.stddev)
+	case LatencyExponential:
+		d = rand.ExpFloat64() * float64(l.mean)
+	}
+
+	d = math.Max(d, float64(l.min))
+	if l.Max != "" {
+		d = math.Min(d, float64(l.max))
+	}
+	return time.Duration(d)
+}
+
+// RedisFault makes a Fault hit commands sent to Redis instead of requests. Commands are matched by
+// name, e.g. hgetall, all commands if there are none. Commands hit by the Fault are delayed by its
+// Latency and fail if Fail is set.
+type RedisFault struct {
+	Commands []string `json:"commands,omitempty"`
+	Fail     bool     `json:"fail,omitempty"`
+}
+
+// Fault describes an error injected into requests: a Latency, a Status sent instead of the response
+// of the route or a connection dropped without response. Requests are targeted by the name or
+// pattern of their route or the full method of gRPC calls, by header values and by Percentage.
+type Fault struct {
+	Name string `json:"name"`
+
+	// Routes are the routes hit by the Fault, every route that isn't Unlimited if there are none.
+	Routes []string `json:"routes,omitempty"`
+
+	// Headers need to be present in requests hit by the Fault with the given value, e.g.
+	// X-Chaos: true, letting single clients opt into it.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Percentage of matching requests hit by the Fault, above 0 and at most 100. All of them are
+	// hit if it's unset.
+	Percentage *float64 `json:"percentage,omitempty"`
+
+	Latency *Latency    `json:"latency,omitempty"`
+	Status  int         `json:"status,omitempty"`
+	Drop    bool        `json:"drop,omitempty"`
+	Redis   *RedisFault `json:"redis,omitempty"`
+}
+
+// check validates f and parses its Latency.
+func (f *Fault) check() error {
+	if f.Name == "" {
+		return errors.New("fault needs a name")
+	}
+	if f.Percentage != nil && (*f.Percentage <= 0 || *f.Percentage > 100) {
+		return errors.Errorf("percentage must be above 0 and at most 100, got %v", *f.Percentage)
+	}
+	if f.Latency != nil {
+		if err := f.Latency.parse(); err != nil {
+			return errors.Wrap(err, "invalid latency")
+		}
+	}
+
+	if f.Redis != nil {
+		// Commands are matched by name only, many of them aren't sent for a request
+		if len(f.Routes) > 0 || len(f.Headers) > 0 {
+			return errors.New("redis faults can't target routes or headers")
+		}
+		if f.Status != 0 || f.Drop {
+			return errors.New("redis faults can't send a status or drop connections")
+		}
+		if f.Latency == nil && !f.Redis.Fail {
+			return errors.New("redis fault needs a latency or fail")
+		}
+		return nil
+	}
+
+	if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+		return errors.Errorf("status must be between 400 and 599, got %d", f.Status)
+	}
+	if f.Status != 0 && f.Drop {
+		return errors.New("fault can't send a status and drop the connection")
+	}
+	if f.Latency == nil && f.Status == 0 && !f.Drop {
+		return errors.New("fault needs a latency, status, drop or redis")
+	}
+	return nil
+}
+
+// hits rolls whether a request to a route identified by one of routes is hit by f.
+func (f *Fault) hits(routes []string, header func(string) string) bool {
+	if len(f.Routes) > 0 && !containsAny(f.Routes, routes) {
+		return false
+	}
+	for k, v := range f.Headers {
+		if header(k) != v {
+			return false
+		}
+	}
+	return f.Percentage == nil || rand.Float64()*100 < *f.Percentage
+}
+
+// FaultInjector holds the Faults injected into the requests of a service and the commands it sends
+// to Redis. Faults are replaced at runtime via /admin/faults, see FaultHandler.
+type FaultInjector struct {
+	mu       sync.RWMutex
+	faults   []Fault
+	injected *prometheus.CounterVec
+}
+
+// NewFaultInjector returns a FaultInjector of a service injecting no faults.
+func NewFaultInjector(serviceName string) *FaultInjector {
+	return &FaultInjector{
+		injected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "faults_injected_total",
+				Help:        "A counter for faults injected into requests and Redis commands.",
+				ConstLabels: prometheus.Labels{"service": serviceName},
+			},
+			[]string{"fault", "target", "kind"},
+		),
+	}
+}
+
+// Collectors returns the Prometheus collectors of the injector for registration.
+func (fi *FaultInjector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{fi.injected}
+}
+
+// Faults returns the injected Faults.
+func (fi *FaultInjector) Faults() []Fault {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	return append([]Fault{}, fi.faults...)
+}
+
+// SetFaults replaces the injected Faults, unless one of them is invalid.
+func (fi *FaultInjector) SetFaults(faults []Fault) error {
+	names := make(map[string]bool, len(faults))
+	for n := range faults {
+		f := &faults[n]
+		if err := f.check(); err != nil {
+			return errors.Wrapf(err, "fault %d", n)
+		}
+		if names[f.Name] {
+			return errors.Errorf("fault %d: name %#v is used twice", n, f.Name)
+		}
+		names[f.Name] = true
+		if f.Redis != nil {
+			for i, c := range f.Redis.Commands {
+				f.Redis.Commands[i] = strings.ToLower(c)
+			}
+		}
+	}
+
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.faults = append([]Fault{}, faults...)
+	return nil
+}
+
+// matching returns the Faults hitting a request to a route identified by one of routes, or a Redis
+// command if command isn't empty.
+func (fi *FaultInjector) matching(routes []string, command string, header func(string) string) []Fault {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	var hit []Fault
+	for _, f := range fi.faults {
+		if (f.Redis != nil) != (command != "") {
+			continue
+		}
+		if f.Redis != nil && len(f.Redis.Commands) > 0 && !containsAny(f.Redis.Commands, []string{command}) {
+			continue
+		}
+		if f.hits(routes, header) {
+			hit = append(hit, f)
+		}
+	}
+	return hit
+}
+
+// inject delays a request by the Latency of all Faults hitting it, then returns the first one
+// sending a status or dropping the connection, if any. The route of the request is identified by
+// its name first, which it's counted by, followed by its pattern. Injected faults are counted,
+// logged on debug level and tagged on the span of ctx.
+func (fi *FaultInjector) inject(ctx context.Context, routes []string, header func(string) string, logger *Logger) *Fault {
+	hit := fi.matching(routes, "", header)
+	if len(hit) == 0 {
+		return nil
+	}
+
+	var failed *Fault
+	var delay time.Duration
+	for n := range hit {
+		f := &hit[n]
+		var kinds []string
+		if f.Latency != nil {
+			delay += f.Latency.sample()
+			kinds = append(kinds, FaultLatency)
+		}
+		if failed == nil && (f.Status != 0 || f.Drop) {
+			failed = f
+			if f.Drop {
+				kinds = append(kinds, FaultDrop)
+			} else {
+				kinds = append(kinds, FaultStatus)
+			}
+		}
+		fi.record(ctx, f.Name, routes[0], kinds, logger)
+	}
+
+	sleepContext(ctx, delay)
+	return failed
+}
+
+// sleepContext sleeps for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// record counts the kinds of a fault injected into target and logs them.
+func (fi *FaultInjector) record(ctx context.Context, fault, target string, kinds []string, logger *Logger) {
+	for _, k := range kinds {
+		fi.injected.WithLabelValues(fault, target, k).Inc()
+	}
+	if span := ot.SpanFromContext(ctx); span != nil {
+		span.SetTag("fault", fault)
+		span.LogKV(
+			"event", "fault injected",
+			"fault", fault,
+			"kinds", strings.Join(kinds, ","),
+		)
+	}
+	log := RequestIDLoggerFromContext(ctx, logger)
+	log.Debugw("fault injected",
+		"fault", fault,
+		"target", target,
+		"kinds", kinds,
+	)
+}
+
+// injectRedis delays cmds sent on behalf of ctx by the Latency of all Faults hitting one of them
+// and fails all of them if one of those Faults fails commands.
+func (fi *FaultInjector) injectRedis(ctx context.Context, cmds []redis.Cmder, logger *Logger) error {
+	var failed *Fault
+	var delay time.Duration
+	for _, cmd := range cmds {
+		for _, f := range fi.matching(nil, cmd.Name(), nil) {
+			var kinds []string
+			if f.Latency != nil {
+				delay += f.Latency.sample()
+				kinds = append(kinds, FaultLatency)
+			}
+			if f.Redis.Fail && failed == nil {
+				f := f
+				failed = &f
+				kinds = append(kinds, FaultError)
+			}
+			fi.record(ctx, f.Name, cmd.Name(), kinds, logger)
+		}
+	}
+
+	sleepContext(ctx, delay)
+	if failed == nil {
+		return nil
+	}
+	return failRedis(cmds, errors.Errorf("injected fault %s", failed.Name))
+}
+
+// failRedis sets err as the error of cmds and returns it. go-redis only sets errors of commands
+// within its package, so the err field all commands embed is set by reflection.
+func failRedis(cmds []redis.Cmder, err error) error {
+	for _, cmd := range cmds {
+		v := reflect.ValueOf(cmd).Elem().FieldByName("err")
+		if !v.IsValid() {
+			continue
+		}
+		reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem().Set(reflect.ValueOf(err))
+	}
+	return err
+}
+
+// FaultMiddleware injects the Faults of fi into requests to routes that aren't Unlimited. Faults
+// sending a status respond with a problem of that status instead of the route, dropping the
+// connection closes it without response and records StatusDropped as status.
+func FaultMiddleware(inner http.Handler, route Route, fi *FaultInjector, logger *Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route.Unlimited {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		f := fi.inject(r.Context(), []string{route.Name, route.Pattern}, r.Header.Get, logger)
+		switch {
+		case f == nil:
+			inner.ServeHTTP(w, r)
+		case f.Drop:
+			dropConnection(w)
+		default:
+			p := NewProblem(f.Status, "injected fault "+f.Name, nil)
+			p.Code = apierr.CodeFromStatus(f.Status)
+			p.SendJSON(w)
+		}
+	})
+}
+
+// dropConnection closes the connection of a request without responding. Connections that can't be
+// hijacked, e.g. of HTTP/2 requests, are aborted by net/http.
+func dropConnection(w http.ResponseWriter) {
+	rw := WrapResponseWriter(w)
+	conn, _, err := rw.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+	rw.status = StatusDropped
+}
+
+// GRPCFaultInterceptor is the gRPC equivalent of FaultMiddleware for unary calls, targeting methods
+// by their full name. Statuses are sent as the matching gRPC code, dropped connections as
+// Unavailable. Calls of the health service are never hit.
+func GRPCFaultInterceptor(fi *FaultInjector, logger *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := injectGRPCCall(ctx, fi, info.FullMethod, logger); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamFaultInterceptor is the gRPC equivalent of FaultMiddleware for streaming calls.
+func GRPCStreamFaultInterceptor(fi *FaultInjector, logger *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := injectGRPCCall(ss.Context(), fi, info.FullMethod, logger); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func injectGRPCCall(ctx context.Context, fi *FaultInjector, fullMethod string, logger *Logger) error {
+	if strings.HasPrefix(fullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(k string) string {
+		if v := md.Get(k); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	f := fi.inject(ctx, []string{fullMethod}, header, logger)
+	switch {
+	case f == nil:
+		return nil
+	case f.Drop:
+		return apierr.GRPCStatus(apierr.New(apierr.Unavailable, "connection dropped by injected fault %s", f.Name))
+	default:
+		return apierr.GRPCStatus(apierr.New(apierr.CodeFromStatus(f.Status), "injected fault %s", f.Name))
+	}
+}
+
+// FaultHandler serves the Faults of fi: GET returns them, POST replaces them with a JSON array of
+// Faults and DELETE removes all of them.
+func FaultHandler(fi *FaultInjector, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var faults []Fault
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&faults); err != nil {
+				NewProblem(http.StatusBadRequest, "Invalid JSON: "+err.Error(), nil).SendJSON(w)
+				return
+			}
+			if err := fi.SetFaults(faults); err != nil {
+				NewProblem(http.StatusUnprocessableEntity, err.Error(), nil).SendJSON(w)
+				return
+			}
+			RequestIDLogger(logger, r).Infow("faults changed",
+				"faults", faults,
+			)
+		case http.MethodDelete:
+			if err := fi.SetFaults(nil); err != nil {
+				NewErrorProblem(err).SendJSON(w)
+				return
+			}
+			RequestIDLogger(logger, r).Infow("faults cleared")
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(fi.Faults())
+	}
+}
+
+// containsAny returns true if one of vs is in ss.
+func containsAny(ss, vs []string) bool {
+	for _, s := range ss {
+		for _, v := range vs {
+			if s == v {
+				return true
+			}
+		}
+	}
+	return false
+}
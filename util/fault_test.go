@@ -0,0 +1,379 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// helperFaultInjector returns a FaultInjector injecting faults.
+func helperFaultInjector(faults []Fault, t *testing.T) *FaultInjector {
+	fi := NewFaultInjector("test")
+	if err := fi.SetFaults(faults); err != nil {
+		t.Fatalf("unable to set faults: %s", err)
+	}
+	return fi
+}
+
+// helperPercentage returns a pointer to p, for Fault.Percentage.
+func helperPercentage(p float64) *float64 {
+	return &p
+}
+
+func TestFault(t *testing.T) {
+	var tests = []struct {
+		name  string
+		fault Fault
+		valid bool
+	}{
+		{"Status", Fault{Name: "a", Status: 503}, true},
+		{"Drop", Fault{Name: "a", Drop: true, Percentage: helperPercentage(10)}, true},
+		{"Latency", Fault{Name: "a", Latency: &Latency{Mean: "100ms"}}, true},
+		{"Uniform latency", Fault{Name: "a", Latency: &Latency{Distribution: LatencyUniform, Min: "10ms", Max: "1s"}}, true},
+		{"Normal latency", Fault{Name: "a", Latency: &Latency{Distribution: LatencyNormal, Mean: "100ms", Stddev: "20ms"}}, true},
+		{"Redis", Fault{Name: "a", Redis: &RedisFault{Commands: []string{"GET"}, Fail: true}}, true},
+		{"Slow redis", Fault{Name: "a", Latency: &Latency{Mean: "10ms"}, Redis: &RedisFault{}}, true},
+		{"No name", Fault{Status: 503}, false},
+		{"No effect", Fault{Name: "a"}, false},
+		{"Success status", Fault{Name: "a", Status: 200}, false},
+		{"Status and drop", Fault{Name: "a", Status: 503, Drop: true}, false},
+		{"Percentage", Fault{Name: "a", Status: 503, Percentage: helperPercentage(120)}, false},
+		{"Zero percentage", Fault{Name: "a", Status: 503, Percentage: helperPercentage(0)}, false},
+		{"Invalid duration", Fault{Name: "a", Latency: &Latency{Mean: "soon"}}, false},
+		{"Negative duration", Fault{Name: "a", Latency: &Latency{Mean: "-1s"}}, false},
+		{"Unknown distribution", Fault{Name: "a", Latency: &Latency{Distribution: "pareto", Mean: "1s"}}, false},
+		{"Uniform without max", Fault{Name: "a", Latency: &Latency{Distribution: LatencyUniform, Min: "1s"}}, false},
+		{"Max below min", Fault{Name: "a", Latency: &Latency{Mean: "1s", Min: "2s", Max: "1s"}}, false},
+		{"Normal without stddev", Fault{Name: "a", Latency: &Latency{Distribution: LatencyNormal, Mean: "1s"}}, false},
+		{"Redis targeting route", Fault{Name: "a", Routes: []string{"getItem"}, Redis: &RedisFault{Fail: true}}, false},
+		{"Redis with status", Fault{Name: "a", Status: 503, Redis: &RedisFault{Fail: true}}, false},
+		{"Redis without effect", Fault{Name: "a", Redis: &RedisFault{}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewFaultInjector("test").SetFaults([]Fault{tt.fault})
+			if tt.valid && err != nil {
+				t.Errorf("SetFaults() returned %s", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected error for invalid fault")
+			}
+		})
+	}
+
+	t.Run("Duplicate names", func(t *testing.T) {
+		fi := helperFaultInjector([]Fault{{Name: "a", Status: 500}}, t)
+		if err := fi.SetFaults([]Fault{{Name: "b", Status: 500}, {Name: "b", Drop: true}}); err == nil {
+			t.Errorf("expected error for faults with the same name")
+		}
+		if faults := fi.Faults(); len(faults) != 1 || faults[0].Name != "a" {
+			t.Errorf("invalid faults replaced %v", faults)
+		}
+	})
+}
+
+func TestLatency(t *testing.T) {
+	var tests = []struct {
+		name     string
+		latency  Latency
+		min, max time.Duration
+	}{
+		{"Fixed", Latency{Mean: "100ms"}, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"Uniform", Latency{Distribution: LatencyUniform, Min: "10ms", Max: "20ms"}, 10 * time.Millisecond, 20 * time.Millisecond},
+		{"Normal", Latency{Distribution: LatencyNormal, Mean: "100ms", Stddev: "1s", Max: "200ms"}, 0, 200 * time.Millisecond},
+		{"Exponential", Latency{Distribution: LatencyExponential, Mean: "100ms", Min: "50ms", Max: "1s"}, 50 * time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.latency.parse(); err != nil {
+				t.Fatalf("parse() returned %s", err)
+			}
+			for n := 0; n < 1000; n++ {
+				if d := tt.latency.sample(); d < tt.min || d > tt.max {
+					t.Fatalf("sample() = %s, want between %s and %s", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestFaultMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	route := Route{Name: "createOrder", Pattern: "/orders/create"}
+
+	t.Run("Targeting", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		fi := helperFaultInjector([]Fault{
+			{Name: "by-name", Routes: []string{"createOrder"}, Headers: map[string]string{"X-Chaos": "name"}, Status: 503},
+			{Name: "by-pattern", Routes: []string{"/orders/create"}, Headers: map[string]string{"X-Chaos": "pattern"}, Status: 404},
+			{Name: "other-route", Routes: []string{"getOrder"}, Status: 500},
+			{Name: "everywhere", Headers: map[string]string{"X-Chaos": "all"}, Status: 502},
+		}, t)
+
+		var tests = []struct {
+			name   string
+			route  Route
+			header string
+			want   int
+			code   apierr.Code
+		}{
+			{"Route name", route, "name", http.StatusServiceUnavailable, apierr.Unavailable},
+			{"Route pattern", route, "pattern", http.StatusNotFound, apierr.NotFound},
+			{"No header", route, "", http.StatusOK, ""},
+			{"All routes", route, "all", http.StatusBadGateway, apierr.Unavailable},
+			{"Unlimited route", Route{Name: "readyz", Unlimited: true}, "all", http.StatusOK, ""},
+		}
+
+		for _, tt := range tests {
+			req := httptest.NewRequest("POST", "/orders/create", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Chaos", tt.header)
+			}
+			w := httptest.NewRecorder()
+			FaultMiddleware(ok, tt.route, fi, l).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("%s: status is %d, want %d", tt.name, w.Code, tt.want)
+			}
+			if tt.code != "" {
+				if err := apierr.FromResponse(w.Result()); !apierr.Is(err, tt.code) {
+					t.Errorf("%s: response is %v, want %s problem", tt.name, err, tt.code)
+				}
+			}
+		}
+
+		if got := testutil.ToFloat64(fi.injected.WithLabelValues("by-pattern", "createOrder", FaultStatus)); got != 1 {
+			t.Errorf("faults_injected_total is %v, want 1", got)
+		}
+	})
+
+	t.Run("Percentage", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		fi := helperFaultInjector([]Fault{{Name: "half", Status: 500, Percentage: helperPercentage(50)}}, t)
+		h := FaultMiddleware(ok, route, fi, l)
+
+		var failed int
+		for n := 0; n < 1000; n++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", "/orders/create", nil))
+			if w.Code == http.StatusInternalServerError {
+				failed++
+			}
+		}
+		if failed < 350 || failed > 650 {
+			t.Errorf("%d of 1000 requests failed, want about 500", failed)
+		}
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		fi := helperFaultInjector([]Fault{
+			{Name: "slow", Latency: &Latency{Mean: "20ms"}},
+			{Name: "slower", Latency: &Latency{Mean: "30ms"}, Status: 504},
+		}, t)
+
+		start := time.Now()
+		w := httptest.NewRecorder()
+		FaultMiddleware(ok, route, fi, l).ServeHTTP(w, httptest.NewRequest("POST", "/orders/create", nil))
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("request took %s, want at least 50ms", d)
+		}
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("status is %d, want %d", w.Code, http.StatusGatewayTimeout)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start = time.Now()
+		FaultMiddleware(ok, route, fi, l).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/orders/create", nil).WithContext(ctx))
+		if d := time.Since(start); d >= 50*time.Millisecond {
+			t.Errorf("cancelled request took %s", d)
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		fi := helperFaultInjector([]Fault{{Name: "drop", Drop: true}}, t)
+
+		recorded := make(chan int, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := WrapResponseWriter(w)
+			FaultMiddleware(ok, route, fi, l).ServeHTTP(rw, r)
+			recorded <- rw.Status()
+		}))
+		defer srv.Close()
+
+		if res, err := http.Post(srv.URL+"/orders/create", "application/json", nil); err == nil {
+			res.Body.Close()
+			t.Errorf("expected error for dropped connection, got %d", res.StatusCode)
+		}
+		if got := <-recorded; got != StatusDropped {
+			t.Errorf("recorded status is %d, want %d", got, StatusDropped)
+		}
+	})
+}
+
+func TestGRPCFaultInterceptor(t *testing.T) {
+	l, _ := helperObservedLogger()
+	fi := helperFaultInjector([]Fault{
+		{Name: "missing", Routes: []string{"/item.ItemService/Get"}, Status: 404},
+		{Name: "drop", Routes: []string{"/item.ItemService/Reserve"}, Drop: true},
+		{Name: "opt-in", Headers: map[string]string{"X-Chaos": "true"}, Status: 503},
+	}, t)
+	interceptor := GRPCFaultInterceptor(fi, l)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	chaos := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-chaos", "true"))
+
+	var tests = []struct {
+		ctx    context.Context
+		method string
+		want   codes.Code
+	}{
+		{context.Background(), "/item.ItemService/Get", codes.NotFound},
+		{context.Background(), "/item.ItemService/Reserve", codes.Unavailable},
+		{context.Background(), "/item.ItemService/List", codes.OK},
+		{chaos, "/item.ItemService/List", codes.Unavailable},
+		{chaos, "/grpc.health.v1.Health/Check", codes.OK},
+	}
+
+	for _, tt := range tests {
+		_, err := interceptor(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+		if status.Code(err) != tt.want {
+			t.Errorf("%s returned %s, want %s", tt.method, status.Code(err), tt.want)
+		}
+	}
+}
+
+func TestRedisFaults(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unable to start miniredis: %s", err)
+	}
+	defer mr.Close()
+
+	s, err := helperTestService("redis://"+mr.Addr(), t, SetInsecureNoAuth(true))
+	if err != nil {
+		t.Fatalf("unable to create service: %s", err)
+	}
+	if err := s.Faults.SetFaults([]Fault{
+		{Name: "no-get", Redis: &RedisFault{Commands: []string{"GET"}, Fail: true}},
+		{Name: "slow-set", Latency: &Latency{Mean: "20ms"}, Redis: &RedisFault{Commands: []string{"set"}}},
+	}); err != nil {
+		t.Fatalf("unable to set faults: %s", err)
+	}
+
+	start := time.Now()
+	if err := s.Redis.Set("key", "value", 0).Err(); err != nil {
+		t.Errorf("SET returned %s", err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("SET took %s, want at least 20ms", d)
+	}
+	if v, _ := mr.Get("key"); v != "value" {
+		t.Errorf("SET wasn't sent, key is %#v", v)
+	}
+
+	if err := s.Redis.Get("key").Err(); err == nil || !strings.Contains(err.Error(), "injected fault no-get") {
+		t.Errorf("GET returned %v, want injected fault", err)
+	}
+
+	var get *redis.StringCmd
+	_, err = s.Redis.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Exists("key")
+		get = pipe.Get("key")
+		return nil
+	})
+	if err == nil || get.Err() == nil {
+		t.Errorf("pipeline with GET returned %v, want injected fault", err)
+	}
+	if err := s.Redis.Exists("key").Err(); err != nil {
+		t.Errorf("EXISTS returned %s", err)
+	}
+
+	if got := testutil.ToFloat64(s.Faults.injected.WithLabelValues("no-get", "get", FaultError)); got != 2 {
+		t.Errorf("faults_injected_total is %v, want 2", got)
+	}
+}
+
+func TestRedisFaultCanceled(t *testing.T) {
+	l, _ := helperObservedLogger()
+	fi := helperFaultInjector([]Fault{{Name: "slow", Latency: &Latency{Mean: "10s"}, Redis: &RedisFault{}}}, t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := fi.injectRedis(ctx, []redis.Cmder{redis.NewStringCmd("get", "key")}, l); err != nil {
+		t.Errorf("injectRedis() returned %s", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("canceled command was delayed by %s", d)
+	}
+}
+
+func TestFaultHandler(t *testing.T) {
+	s, err := helperTestService("", t, SetInsecureNoAuth(true))
+	if err != nil {
+		t.Fatalf("unable to create service: %s", err)
+	}
+	s.InitPromReg()
+
+	var tests = []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"GET", "/admin/faults", "", http.StatusOK},
+		{"POST", "/admin/faults", `[{"name": "down", "routes": ["greet"], "status": 503}]`, http.StatusOK},
+		{"GET", "/greet", "", http.StatusServiceUnavailable},
+		{"GET", "/readyz", "", http.StatusOK},
+		{"POST", "/admin/faults", `[{"name": "down", "status": 200}]`, http.StatusUnprocessableEntity},
+		{"POST", "/admin/faults", `[{"name": "down", "delay": "1s"}]`, http.StatusBadRequest},
+		{"GET", "/greet", "", http.StatusServiceUnavailable},
+		{"DELETE", "/admin/faults", "", http.StatusOK},
+		{"GET", "/greet", "", http.StatusOK},
+	}
+
+	for n, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("request %d: %s %s returned %d, want %d", n, tt.method, tt.path, w.Code, tt.want)
+		}
+		if strings.HasPrefix(tt.path, "/admin") {
+			if err := s.OpenAPI.ValidateResponse(map[string]string{"GET": "getFaults", "POST": "setFaults", "DELETE": "clearFaults"}[tt.method], w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+				t.Errorf("request %d: %s %s drifted from OpenAPI document: %s", n, tt.method, tt.path, err)
+			}
+		}
+	}
+
+	if faults := s.Faults.Faults(); len(faults) != 0 {
+		t.Errorf("faults left after DELETE: %v", faults)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`faults_injected_total{fault="down",kind="status",service="test",target="greet"} 2`,
+		`api_requests_total{method="GET",route="greet",service="test",status_class="5xx"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+}
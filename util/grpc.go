@@ -0,0 +1,380 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/obitech/micro-obs/apierr"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// requestIDMetadataKey is the gRPC metadata equivalent of the X-Request-ID header.
+	requestIDMetadataKey = "x-request-id"
+
+	// grpcMethod is used in place of the HTTP method for labels and tags of gRPC calls.
+	grpcMethod = "grpc"
+)
+
+// metadataCarrier satisfies both ot.TextMapWriter and ot.TextMapReader for gRPC metadata.
+type metadataCarrier metadata.MD
+
+// Set implements ot.TextMapWriter.
+func (c metadataCarrier) Set(key, val string) {
+	key = strings.ToLower(key)
+	c[key] = append(c[key], val)
+}
+
+// ForeachKey implements ot.TextMapReader.
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vs := range c {
+		for _, v := range vs {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wrappedServerStream allows interceptors to replace the context of a grpc.ServerStream.
+type wrappedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the replaced context.
+func (w *wrappedServerStream) Context() context.Context {
+	return w.ctx
+}
+
+func wrapServerStream(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &wrappedServerStream{ServerStream: ss, ctx: ctx}
+}
+
+// GRPCTracerInterceptor is the gRPC equivalent of TracerMiddleware for unary calls.
+func GRPCTracerInterceptor(redact *RedactionPolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		span, ctx := startGRPCServerSpan(ctx, info.FullMethod, redact)
+		defer span.Finish()
+		defer func() {
+			if p := recover(); p != nil {
+				tagPanic(span, p)
+				panic(p)
+			}
+		}()
+
+		resp, err := handler(ctx, req)
+		SetSpanStatus(span, apierr.StatusFromGRPC(err))
+		span.SetTag("grpc.code", status.Code(err).String())
+		return resp, err
+	}
+}
+
+// GRPCStreamTracerInterceptor is the gRPC equivalent of TracerMiddleware for streaming calls.
+func GRPCStreamTracerInterceptor(redact *RedactionPolicy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		span, ctx := startGRPCServerSpan(ss.Context(), info.FullMethod, redact)
+		defer span.Finish()
+		defer func() {
+			if p := recover(); p != nil {
+				tagPanic(span, p)
+				panic(p)
+			}
+		}()
+
+		err := handler(srv, wrapServerStream(ss, ctx))
+		SetSpanStatus(span, apierr.StatusFromGRPC(err))
+		span.SetTag("grpc.code", status.Code(err).String())
+		return err
+	}
+}
+
+// startGRPCServerSpan creates the root span of a gRPC call, continuing a trace passed via metadata.
+// Like the root spans of routes, it's named after the method, e.g. /item.ItemService/Get.
+func startGRPCServerSpan(ctx context.Context, fullMethod string, redact *RedactionPolicy) (ot.Span, context.Context) {
+	var span ot.Span
+	tracer := ot.GlobalTracer()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	spanCtx, _ := tracer.Extract(ot.TextMap, metadataCarrier(md))
+	if spanCtx == nil {
+		span = tracer.StartSpan(fullMethod, ext.SpanKindRPCServer)
+	} else {
+		span = tracer.StartSpan(fullMethod, ext.RPCServerOption(spanCtx))
+	}
+
+	redact.TagHeaders(span, md)
+
+	span.SetTag("method", grpcMethod)
+	span.SetTag("url", fullMethod)
+	span.SetTag("handler", path.Base(fullMethod))
+
+	return span, ot.ContextWithSpan(ctx, span)
+}
+
+// GRPCLoggerInterceptor is the gRPC equivalent of LoggerMiddleware for unary calls. The route of
+// log level overrides is the full method name.
+func GRPCLoggerInterceptor(logger *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var resp interface{}
+		ctx = context.WithValue(ctx, routeKey, info.FullMethod)
+		ctx = context.WithValue(ctx, requestLogKey, &requestLog{})
+		err := logGRPCCall(ctx, logger, info.FullMethod, func() error {
+			var err error
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// GRPCStreamLoggerInterceptor is the gRPC equivalent of LoggerMiddleware for streaming calls.
+func GRPCStreamLoggerInterceptor(logger *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := context.WithValue(ss.Context(), routeKey, info.FullMethod)
+		ctx = context.WithValue(ctx, requestLogKey, &requestLog{})
+		return logGRPCCall(ctx, logger, info.FullMethod, func() error {
+			return handler(srv, wrapServerStream(ss, ctx))
+		})
+	}
+}
+
+func logGRPCCall(ctx context.Context, logger *Logger, fullMethod string, call func() error) error {
+	start := time.Now()
+	log := RequestIDLoggerFromContext(ctx, logger)
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+
+	log.Debugw("request received",
+		"address", addr,
+		"method", grpcMethod,
+		"path", fullMethod,
+	)
+	err := call()
+	kv := []interface{}{
+		"address", addr,
+		"method", grpcMethod,
+		"path", fullMethod,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	}
+	if rl, ok := ctx.Value(requestLogKey).(*requestLog); ok {
+		kv = append(kv, rl.fields...)
+	}
+	log.Infow("request completed", kv...)
+	return err
+}
+
+// GRPCErrorInterceptor is the gRPC equivalent of BaseServer.Handle for unary calls. Errors returned
+// by the handler are sent as status with apierr.GRPCStatus, errors not wrapping an apierr.Error as
+// Internal. Server errors are logged with their cause. Status errors are passed on as they are.
+func GRPCErrorInterceptor(logger *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, grpcError(ctx, err, logger)
+	}
+}
+
+// GRPCStreamErrorInterceptor is the gRPC equivalent of BaseServer.Handle for streaming calls.
+func GRPCStreamErrorInterceptor(logger *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return grpcError(ss.Context(), handler(srv, ss), logger)
+	}
+}
+
+func grpcError(ctx context.Context, err error, logger *Logger) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		return err
+	}
+
+	e := apierr.From(err)
+	if e.Status() >= http.StatusInternalServerError {
+		log := RequestIDLoggerFromContext(ctx, logger)
+		log.Errorw("request failed",
+			"code", e.Code,
+			"error", err,
+		)
+	}
+	return apierr.GRPCStatus(e)
+}
+
+// GRPCRequestIDInterceptor is the gRPC equivalent of AssignRequestID for unary calls.
+func GRPCRequestIDInterceptor(logger *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(assignGRPCRequestID(ctx, logger), req)
+	}
+}
+
+// GRPCStreamRequestIDInterceptor is the gRPC equivalent of AssignRequestID for streaming calls.
+func GRPCStreamRequestIDInterceptor(logger *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := assignGRPCRequestID(ss.Context(), logger)
+		return handler(srv, wrapServerStream(ss, ctx))
+	}
+}
+
+// assignGRPCRequestID checks the incoming metadata for a request ID and creates one if none is present.
+// The request ID is added to the context and sent back as header metadata.
+func assignGRPCRequestID(ctx context.Context, logger *Logger) context.Context {
+	var reqID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(requestIDMetadataKey); len(v) > 0 {
+			reqID = v[0]
+		}
+	}
+
+	if reqID == "" {
+		reqID = uuid.Must(uuid.NewV4()).String()
+		logger.Debugw("assigned new requestID",
+			"requestID", reqID,
+		)
+	} else {
+		logger.Debugw("found existing requestID",
+			"requestID", reqID,
+		)
+	}
+
+	if span := ot.SpanFromContext(ctx); span != nil {
+		span.SetTag("requestID", reqID)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, reqID))
+	return context.WithValue(ctx, requestIDKey, reqID)
+}
+
+// GRPCPrometheusInterceptor is the gRPC equivalent of PrometheusMiddleware for unary calls.
+func GRPCPrometheusInterceptor(rm *RequestMetricHistogram) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var resp interface{}
+		err := observeGRPCCall(ctx, rm, info.FullMethod, func() (int, error) {
+			var err error
+			resp, err = handler(ctx, req)
+			if m, ok := resp.(proto.Message); ok && err == nil {
+				return proto.Size(m), nil
+			}
+			return 0, err
+		})
+		return resp, err
+	}
+}
+
+// GRPCStreamPrometheusInterceptor is the gRPC equivalent of PrometheusMiddleware for streaming calls.
+func GRPCStreamPrometheusInterceptor(rm *RequestMetricHistogram) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return observeGRPCCall(ss.Context(), rm, info.FullMethod, func() (int, error) {
+			cs := &countingServerStream{ServerStream: ss}
+			err := handler(srv, cs)
+			return cs.size, err
+		})
+	}
+}
+
+// countingServerStream sums up the size of all messages sent on a stream.
+type countingServerStream struct {
+	grpc.ServerStream
+	size int
+}
+
+// SendMsg sends a message and records its size.
+func (c *countingServerStream) SendMsg(m interface{}) error {
+	err := c.ServerStream.SendMsg(m)
+	if pm, ok := m.(proto.Message); ok && err == nil {
+		c.size += proto.Size(pm)
+	}
+	return err
+}
+
+func observeGRPCCall(ctx context.Context, rm *RequestMetricHistogram, fullMethod string, call func() (int, error)) error {
+	start := time.Now()
+	inFlight := rm.InFlightGauge.With(prometheus.Labels{"route": fullMethod, "method": grpcMethod})
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	defer func() {
+		// Panics are recovered by the outermost interceptor, they are observed as Internal here
+		if p := recover(); p != nil {
+			rm.Observe(ctx, fullMethod, grpcMethod, apierr.Internal.Status(), 0, time.Since(start))
+			panic(p)
+		}
+	}()
+
+	size, err := call()
+	rm.Observe(ctx, fullMethod, grpcMethod, apierr.StatusFromGRPC(err), size, time.Since(start))
+
+	return err
+}
+
+// GRPCClientTracerInterceptor injects the span of the calling context into the outgoing metadata, so
+// the trace is continued by the server.
+func GRPCClientTracerInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		span := ot.SpanFromContext(ctx)
+		if span == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		ext.SpanKindRPCClient.Set(span)
+		span.SetTag("method", grpcMethod)
+		span.SetTag("url", method)
+
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if !ok {
+			md = metadata.MD{}
+		} else {
+			md = md.Copy()
+		}
+		span.Tracer().Inject(span.Context(), ot.TextMap, metadataCarrier(md))
+
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		SetSpanStatus(span, apierr.StatusFromGRPC(err))
+		span.SetTag("grpc.code", status.Code(err).String())
+		return err
+	}
+}
+
+// GRPCClientRequestIDInterceptor passes the request ID of the calling context on as metadata.
+func GRPCClientRequestIDInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if reqID := RequestIDFromContext(ctx); reqID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, reqID)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// DialGRPC creates an insecure client connection to another service, propagating request IDs and
+// trace context. Calls are authenticated with token, or the credentials of the calling request if
+// it's empty, see OutgoingToken.
+func DialGRPC(address, token string) (*grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(
+			GRPCClientRequestIDInterceptor(),
+			GRPCClientAuthInterceptor(token),
+			GRPCClientTracerInterceptor(),
+		),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create gRPC client for %s", address)
+	}
+	return conn, nil
+}
@@ -8,6 +8,7 @@ import (
 // Healthz responds to a HTTP healthcheck
 func Healthz() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		fmt.Fprintf(w, "OK\n")
 	}
 }
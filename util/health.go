@@ -0,0 +1,245 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultHealthCheckTimeout is the time a single check may take before it's considered down.
+	DefaultHealthCheckTimeout = 2 * time.Second
+
+	// DefaultHealthCheckCacheTTL is the time the result of a check is reused, so frequent probes
+	// don't put load on dependencies.
+	DefaultHealthCheckCacheTTL = 5 * time.Second
+)
+
+// Health statuses of HealthReport and CheckResult.
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// HealthCheck returns an error if a dependency is unavailable. It should respect the deadline of ctx.
+type HealthCheck func(ctx context.Context) error
+
+// CheckResult is the outcome of a single HealthCheck.
+type CheckResult struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// HealthReport is the JSON response of the liveness and readiness handlers.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+// registeredCheck caches the last result of a HealthCheck.
+type registeredCheck struct {
+	name  string
+	check HealthCheck
+
+	mu     sync.Mutex
+	result CheckResult
+}
+
+// HealthRegistry holds the named dependency checks of a service and decides whether it's ready to
+// serve traffic. Check results are cached and exported as dependency_up gauge.
+type HealthRegistry struct {
+	// Timeout limits the duration of each check.
+	Timeout time.Duration
+
+	// CacheTTL is the time a check result is reused. Results are never cached if it's 0.
+	CacheTTL time.Duration
+
+	mu     sync.RWMutex
+	checks []*registeredCheck
+
+	shuttingDown int32
+	onShutdown   []func()
+	up           *prometheus.GaugeVec
+}
+
+// NewHealthRegistry creates a HealthRegistry for a service with default timeout and cache TTL.
+func NewHealthRegistry(serviceName string) *HealthRegistry {
+	return &HealthRegistry{
+		Timeout:  DefaultHealthCheckTimeout,
+		CacheTTL: DefaultHealthCheckCacheTTL,
+		up: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "dependency_up",
+				Help:        "Whether a dependency passed its last health check (1) or not (0).",
+				ConstLabels: prometheus.Labels{"service": serviceName},
+			},
+			[]string{"dependency"},
+		),
+	}
+}
+
+// Collectors returns the dependency_up gauge to be registered with a Prometheus registry.
+func (h *HealthRegistry) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{h.up}
+}
+
+// Register adds a named check which has to pass for the service to be ready. Registering a name
+// again replaces its check.
+func (h *HealthRegistry) Register(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rc := &registeredCheck{name: name, check: check}
+	for i, c := range h.checks {
+		if c.name == name {
+			h.checks[i] = rc
+			return
+		}
+	}
+	h.checks = append(h.checks, rc)
+	sort.Slice(h.checks, func(i, j int) bool { return h.checks[i].name < h.checks[j].name })
+}
+
+// OnShutdown registers fn to be called by Shutdown, e.g. to fail the readiness of other protocols.
+func (h *HealthRegistry) OnShutdown(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onShutdown = append(h.onShutdown, fn)
+}
+
+// Shutdown marks the service as shutting down, after which it's never ready again.
+func (h *HealthRegistry) Shutdown() {
+	if !atomic.CompareAndSwapInt32(&h.shuttingDown, 0, 1) {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.onShutdown {
+		fn()
+	}
+}
+
+// ShuttingDown returns true once Shutdown has been called.
+func (h *HealthRegistry) ShuttingDown() bool {
+	return atomic.LoadInt32(&h.shuttingDown) == 1
+}
+
+// Check runs all registered checks concurrently, reusing cached results, and reports the service
+// as up if all of them passed and it isn't shutting down.
+func (h *HealthRegistry) Check() HealthReport {
+	if h.ShuttingDown() {
+		return HealthReport{
+			Status: HealthDown,
+			Checks: []CheckResult{{
+				Name:      "shutdown",
+				Status:    HealthDown,
+				Error:     "service is shutting down",
+				Duration:  "0s",
+				CheckedAt: time.Now(),
+			}},
+		}
+	}
+
+	h.mu.RLock()
+	checks := make([]*registeredCheck, len(h.checks))
+	copy(checks, h.checks)
+	h.mu.RUnlock()
+
+	report := HealthReport{
+		Status: HealthUp,
+		Checks: make([]CheckResult, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *registeredCheck) {
+			defer wg.Done()
+			report.Checks[i] = h.run(c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, r := range report.Checks {
+		if r.Status != HealthUp {
+			report.Status = HealthDown
+		}
+	}
+	return report
+}
+
+// run returns the cached result of c or executes it with the registry's timeout. Concurrent probes
+// wait for a single execution. Checks don't inherit the context of a probe, since their results are
+// shared and shouldn't be traced as part of it.
+func (h *HealthRegistry) run(c *registeredCheck) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.result.CheckedAt.IsZero() && time.Since(c.result.CheckedAt) < h.CacheTTL {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.Errorf("check timed out after %s", h.Timeout)
+	}
+
+	c.result = CheckResult{
+		Name:      c.name,
+		Status:    HealthUp,
+		Duration:  time.Since(start).String(),
+		CheckedAt: time.Now(),
+	}
+	up := 1.0
+	if err != nil {
+		c.result.Status = HealthDown
+		c.result.Error = err.Error()
+		up = 0
+	}
+	h.up.WithLabelValues(c.name).Set(up)
+	return c.result
+}
+
+// LivezHandler reports the service as alive as long as it's able to serve requests. Dependencies
+// aren't checked, since restarting the service wouldn't fix them.
+func (h *HealthRegistry) LivezHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendHealthReport(w, HealthReport{Status: HealthUp})
+	}
+}
+
+// ReadyzHandler reports the result of all checks, responding with 503 if the service isn't ready.
+func (h *HealthRegistry) ReadyzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendHealthReport(w, h.Check())
+	}
+}
+
+func sendHealthReport(w http.ResponseWriter, report HealthReport) {
+	status := http.StatusOK
+	if report.Status != HealthUp {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// helperProbe sends a request to h and returns the decoded HealthReport.
+func helperProbe(h http.HandlerFunc, want int, t *testing.T) HealthReport {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != want {
+		t.Errorf("probe returned status %d, want %d: %s", rec.Code, want, rec.Body.String())
+	}
+
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("unable to parse health report: %s", err)
+	}
+	return report
+}
+
+func TestHealthRegistry(t *testing.T) {
+	h := NewHealthRegistry("test")
+	var redisErr atomic.Value
+	redisErr.Store("")
+	h.Register("redis", func(ctx context.Context) error {
+		if msg := redisErr.Load().(string); msg != "" {
+			return errors.New(msg)
+		}
+		return nil
+	})
+	h.Register("item", func(ctx context.Context) error { return nil })
+
+	t.Run("Ready", func(t *testing.T) {
+		report := helperProbe(h.ReadyzHandler(), http.StatusOK, t)
+		if report.Status != HealthUp || len(report.Checks) != 2 {
+			t.Fatalf("report is %+v, want both checks up", report)
+		}
+		if report.Checks[0].Name != "item" || report.Checks[1].Name != "redis" {
+			t.Errorf("checks aren't sorted by name: %+v", report.Checks)
+		}
+		if got := testutil.ToFloat64(h.up.WithLabelValues("redis")); got != 1 {
+			t.Errorf("dependency_up{dependency=\"redis\"} is %v, want 1", got)
+		}
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		redisErr.Store("connection refused")
+		if report := helperProbe(h.ReadyzHandler(), http.StatusOK, t); report.Status != HealthUp {
+			t.Errorf("report is %+v, want cached result", report)
+		}
+	})
+
+	t.Run("Not ready", func(t *testing.T) {
+		h.CacheTTL = 0
+		report := helperProbe(h.ReadyzHandler(), http.StatusServiceUnavailable, t)
+		if report.Status != HealthDown || report.Checks[1].Status != HealthDown || report.Checks[1].Error != "connection refused" {
+			t.Errorf("report is %+v, want redis down", report)
+		}
+		if report.Checks[0].Status != HealthUp {
+			t.Errorf("failing redis check took item down: %+v", report.Checks[0])
+		}
+		if got := testutil.ToFloat64(h.up.WithLabelValues("redis")); got != 0 {
+			t.Errorf("dependency_up{dependency=\"redis\"} is %v, want 0", got)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		h := NewHealthRegistry("test")
+		h.Timeout = 20 * time.Millisecond
+		h.Register("slow", func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		})
+
+		start := time.Now()
+		report := helperProbe(h.ReadyzHandler(), http.StatusServiceUnavailable, t)
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("probe took %s despite timeout", d)
+		}
+		if !strings.Contains(report.Checks[0].Error, "timed out") {
+			t.Errorf("report is %+v, want timeout", report)
+		}
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		redisErr.Store("")
+		h.Shutdown()
+		report := helperProbe(h.ReadyzHandler(), http.StatusServiceUnavailable, t)
+		if len(report.Checks) != 1 || report.Checks[0].Name != "shutdown" {
+			t.Errorf("report is %+v, want shutdown check only", report)
+		}
+		if report := helperProbe(h.LivezHandler(), http.StatusOK, t); report.Status != HealthUp {
+			t.Errorf("liveness report is %+v while shutting down, want up", report)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the supported JWT algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jwtLeeway is the clock skew tolerated when checking the exp and nbf claims of a JWT.
+const jwtLeeway = 30 * time.Second
+
+// jwtMinRSABits is the minimum size of RSA keys JWTs are verified with.
+const jwtMinRSABits = 2048
+
+// jwtAlgorithms maps the supported signing algorithms of JWTs to their hash.
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+}
+
+// JWKS holds the public keys JWTs are verified with, by key ID.
+type JWKS struct {
+	keys map[string]jwtKey
+}
+
+// jwtKey is a public key of a JWKS, restricted to the algorithm alg if it's set.
+type jwtKey struct {
+	pub crypto.PublicKey
+	alg string
+}
+
+// jwk holds the members of a JSON Web Key needed to restore an RSA or EC public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads a JSON Web Key Set from a file.
+func LoadJWKS(file string) (*JWKS, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read JWKS %s", file)
+	}
+	jwks, err := ParseJWKS(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid JWKS %s", file)
+	}
+	return jwks, nil
+}
+
+// ParseJWKS parses a JSON Web Key Set of RSA keys of at least 2048 bits and EC P-256 or P-384
+// keys. Keys not meant for signatures are skipped, keys naming an alg only verify tokens signed
+// with it.
+func ParseJWKS(b []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, errors.Wrap(err, "unable to parse JWKS")
+	}
+
+	jwks := &JWKS{keys: make(map[string]jwtKey)}
+	for n, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if _, ok := jwks.keys[k.Kid]; ok {
+			return nil, errors.Errorf("key %d: duplicate key ID %#v", n, k.Kid)
+		}
+
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %d", n)
+		}
+		if k.Alg != "" {
+			if err := checkJWTAlgorithm(k.Alg, pub); err != nil {
+				return nil, errors.Wrapf(err, "key %d", n)
+			}
+		}
+		jwks.keys[k.Kid] = jwtKey{pub: pub, alg: k.Alg}
+	}
+
+	if len(jwks.keys) == 0 {
+		return nil, errors.New("JWKS holds no signing keys")
+	}
+	return jwks, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid modulus")
+		}
+		if n.BitLen() < jwtMinRSABits {
+			return nil, errors.Errorf("RSA key has %d bits, want at least %d", n.BitLen(), jwtMinRSABits)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Bit(0) == 0 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.Errorf("unsupported curve %#v", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid x coordinate")
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid y coordinate")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point isn't on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, errors.Errorf("unsupported key type %#v", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwtClaims are the registered claims of a JWT checked by the Authenticator, next to the roles
+// and space-separated scopes of the Principal.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+	Roles     []string    `json:"roles"`
+	Scope     *string     `json:"scope"`
+}
+
+// jwtAudience is the aud claim, which is either a single string or an array of strings.
+type jwtAudience []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = jwtAudience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = ss
+	return nil
+}
+
+func (a jwtAudience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature of a compact serialized JWT and returns its decoded payload. Tokens
+// without a key ID are verified with the only key of the set, if there is just one.
+func (j *JWKS) Verify(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(h, &header); err != nil {
+		return nil, errors.Wrap(err, "malformed header")
+	}
+
+	key, ok := j.keys[header.Kid]
+	if !ok && header.Kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		return nil, errors.Errorf("unknown key ID %#v", header.Kid)
+	}
+	if key.alg != "" && header.Alg != key.alg {
+		return nil, errors.Errorf("algorithm %#v doesn't match %s of key", header.Alg, key.alg)
+	}
+	if err := checkJWTAlgorithm(header.Alg, key.pub); err != nil {
+		return nil, err
+	}
+	hash := jwtAlgorithms[header.Alg]
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed signature")
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	digest := hasher.Sum(nil)
+
+	switch k := key.pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return nil, errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return nil, errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return nil, errors.New("invalid signature")
+		}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed payload")
+	}
+	return payload, nil
+}
+
+// checkJWTAlgorithm returns an error unless alg is supported and signs with the type of key, for
+// EC keys with a hash of the size of their curve.
+func checkJWTAlgorithm(alg string, key crypto.PublicKey) error {
+	hash, ok := jwtAlgorithms[alg]
+	if !ok {
+		return errors.Errorf("unsupported algorithm %#v", alg)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return errors.Errorf("algorithm %s doesn't match RSA key", alg)
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") || hash.Size() != (k.Curve.Params().BitSize+7)/8 {
+			return errors.Errorf("algorithm %s doesn't match %s key", alg, k.Curve.Params().Name)
+		}
+	default:
+		return errors.Errorf("algorithm %s doesn't match key of type %T", alg, key)
+	}
+	return nil
+}
+
+// check validates the registered claims at a point in time. Empty issuer or audience aren't checked.
+func (c jwtClaims) check(now time.Time, issuer, audience string) error {
+	switch {
+	case c.Subject == "":
+		return errors.New("token has no subject")
+	case c.ExpiresAt == 0:
+		return errors.New("token doesn't expire")
+	case now.After(time.Unix(c.ExpiresAt, 0).Add(jwtLeeway)):
+		return errors.New("token is expired")
+	case c.NotBefore != 0 && now.Before(time.Unix(c.NotBefore, 0).Add(-jwtLeeway)):
+		return errors.New("token isn't valid yet")
+	case issuer != "" && c.Issuer != issuer:
+		return errors.Errorf("token issued by %#v, want %#v", c.Issuer, issuer)
+	case audience != "" && !c.Audience.contains(audience):
+		return errors.Errorf("token isn't meant for audience %#v", audience)
+	}
+	return nil
+}
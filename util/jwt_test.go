@@ -0,0 +1,209 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperJWK encodes the public key of an RSA or EC private key as JWK.
+func helperJWK(key crypto.Signer, kid string) map[string]string {
+	enc := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": enc(k.N), "e": enc(big.NewInt(int64(k.E)))}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"crv": k.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(x),
+			"y":   base64.RawURLEncoding.EncodeToString(y),
+		}
+	}
+	return nil
+}
+
+// helperJWKS returns a JWKS holding the public keys of the passed keys by key ID.
+func helperJWKS(keys map[string]crypto.Signer, t *testing.T) *JWKS {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, k := range keys {
+		set.Keys = append(set.Keys, helperJWK(k, kid))
+	}
+	b, _ := json.Marshal(set)
+	jwks, err := ParseJWKS(b)
+	if err != nil {
+		t.Fatalf("unable to parse JWKS: %s", err)
+	}
+	return jwks
+}
+
+// helperSignJWT returns a compact serialized JWT of claims signed with key.
+func helperSignJWT(key crypto.Signer, alg, kid string, claims map[string]interface{}, t *testing.T) string {
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+
+	hash := jwtAlgorithms[alg]
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest); err != nil {
+			t.Fatalf("unable to sign token: %s", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatalf("unable to sign token: %s", err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := helperJWKS(map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey}, t)
+	claims := map[string]interface{}{"sub": "alice"}
+
+	jwk := func(key crypto.Signer, kid, alg string) string {
+		k := helperJWK(key, kid)
+		if alg != "" {
+			k["alg"] = alg
+		}
+		b, _ := json.Marshal(k)
+		return string(b)
+	}
+
+	t.Run("Parse", func(t *testing.T) {
+		weakKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+		var tests = []struct {
+			name string
+			js   string
+		}{
+			{"Invalid JSON", `{"keys": [`},
+			{"No keys", `{"keys": []}`},
+			{"Only encryption keys", `{"keys": [{"kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"}]}`},
+			{"Unsupported key type", `{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`},
+			{"Unsupported curve", `{"keys": [{"kty": "EC", "crv": "P-521", "x": "AQ", "y": "AQ"}]}`},
+			{"Point not on curve", `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`},
+			{"Empty modulus", `{"keys": [{"kty": "RSA", "n": "", "e": "AQAB"}]}`},
+			{"Duplicate key ID", `{"keys": [` + jwk(rsaKey, "a", "") + `, ` + jwk(otherKey, "a", "") + `]}`},
+			{"Weak RSA key", `{"keys": [` + jwk(weakKey, "a", "") + `]}`},
+			{"Algorithm of other key type", `{"keys": [` + jwk(rsaKey, "a", "ES256") + `]}`},
+			{"Algorithm of other curve", `{"keys": [` + jwk(ecKey, "a", "ES384") + `]}`},
+			{"Unsupported algorithm", `{"keys": [` + jwk(rsaKey, "a", "HS256") + `]}`},
+		}
+
+		for _, tt := range tests {
+			if _, err := ParseJWKS([]byte(tt.js)); err == nil {
+				t.Errorf("%s: expected error parsing %s", tt.name, tt.js)
+			}
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		rs256 := helperSignJWT(rsaKey, "RS256", "rsa", claims, t)
+		parts := strings.Split(rs256, ".")
+		none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg": "none", "kid": "rsa"}`)) + "." + parts[1] + "."
+
+		var tests = []struct {
+			name  string
+			token string
+			valid bool
+		}{
+			{"RS256", rs256, true},
+			{"RS512", helperSignJWT(rsaKey, "RS512", "rsa", claims, t), true},
+			{"ES256", helperSignJWT(ecKey, "ES256", "ec", claims, t), true},
+			{"Unknown key", helperSignJWT(otherKey, "RS256", "other", claims, t), false},
+			{"Wrong key", helperSignJWT(otherKey, "RS256", "rsa", claims, t), false},
+			{"Missing key ID", helperSignJWT(rsaKey, "RS256", "", claims, t), false},
+			{"Algorithm mismatch", helperSignJWT(ecKey, "ES384", "ec", claims, t), false},
+			{"Algorithm none", none, false},
+			{"Tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub": "mallory"}`)) + "." + parts[2], false},
+			{"Malformed", "a.b", false},
+		}
+
+		for _, tt := range tests {
+			payload, err := jwks.Verify(tt.token)
+			if (err == nil) != tt.valid {
+				t.Errorf("%s: Verify() returned %v, want valid: %v", tt.name, err, tt.valid)
+			}
+			if tt.valid && !strings.Contains(string(payload), "alice") {
+				t.Errorf("%s: Verify() returned payload %s", tt.name, payload)
+			}
+		}
+
+		pinned, err := ParseJWKS([]byte(`{"keys": [` + jwk(rsaKey, "rsa", "RS256") + `]}`))
+		if err != nil {
+			t.Fatalf("unable to parse JWKS with alg: %s", err)
+		}
+		if _, err := pinned.Verify(rs256); err != nil {
+			t.Errorf("token signed with alg of key isn't verified: %s", err)
+		}
+		if _, err := pinned.Verify(helperSignJWT(rsaKey, "RS512", "rsa", claims, t)); err == nil {
+			t.Errorf("token signed with other alg than the one of the key was verified")
+		}
+
+		single := helperJWKS(map[string]crypto.Signer{"rsa": rsaKey}, t)
+		if _, err := single.Verify(helperSignJWT(rsaKey, "RS256", "", claims, t)); err != nil {
+			t.Errorf("token without key ID isn't verified with single key: %s", err)
+		}
+	})
+
+	t.Run("Claims", func(t *testing.T) {
+		now := time.Unix(1000000, 0)
+		var tests = []struct {
+			name   string
+			claims jwtClaims
+			valid  bool
+		}{
+			{"Valid", jwtClaims{Subject: "alice", Issuer: "auth", Audience: jwtAudience{"other", "item"}, ExpiresAt: now.Unix() + 60}, true},
+			{"Within leeway", jwtClaims{Subject: "alice", Issuer: "auth", Audience: jwtAudience{"item"}, ExpiresAt: now.Unix() - 10, NotBefore: now.Unix() + 10}, true},
+			{"No subject", jwtClaims{Issuer: "auth", Audience: jwtAudience{"item"}, ExpiresAt: now.Unix() + 60}, false},
+			{"No expiry", jwtClaims{Subject: "alice", Issuer: "auth", Audience: jwtAudience{"item"}}, false},
+			{"Expired", jwtClaims{Subject: "alice", Issuer: "auth", Audience: jwtAudience{"item"}, ExpiresAt: now.Unix() - 60}, false},
+			{"Not yet valid", jwtClaims{Subject: "alice", Issuer: "auth", Audience: jwtAudience{"item"}, ExpiresAt: now.Unix() + 120, NotBefore: now.Unix() + 60}, false},
+			{"Wrong issuer", jwtClaims{Subject: "alice", Issuer: "evil", Audience: jwtAudience{"item"}, ExpiresAt: now.Unix() + 60}, false},
+			{"Wrong audience", jwtClaims{Subject: "alice", Issuer: "auth", Audience: jwtAudience{"order"}, ExpiresAt: now.Unix() + 60}, false},
+		}
+
+		for _, tt := range tests {
+			if err := tt.claims.check(now, "auth", "item"); (err == nil) != tt.valid {
+				t.Errorf("%s: check() returned %v, want valid: %v", tt.name, err, tt.valid)
+			}
+		}
+
+		var c jwtClaims
+		if err := json.Unmarshal([]byte(`{"aud": "item"}`), &c); err != nil || !c.Audience.contains("item") {
+			t.Errorf("single audience parsed as %v: %v", c.Audience, err)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultDrainPeriod is the time between failing readiness and shutting down the servers, giving
+	// load balancers the chance to stop sending new requests.
+	DefaultDrainPeriod = 5 * time.Second
+
+	// DefaultShutdownTimeout is the time in-flight requests and workers have to finish during a
+	// shutdown.
+	DefaultShutdownTimeout = 10 * time.Second
+)
+
+// lifecycleServer serves requests until it's shut down.
+type lifecycleServer struct {
+	name     string
+	serve    func() error
+	shutdown func(ctx context.Context) error
+}
+
+// lifecycleWorker runs in the background until its context is cancelled.
+type lifecycleWorker struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// lifecycleCloser releases a resource after all servers and workers have stopped.
+type lifecycleCloser struct {
+	name   string
+	closer io.Closer
+}
+
+// Lifecycle runs the servers and background workers of a service and shuts them down gracefully:
+// readiness is failed first, then connections are drained for DrainPeriod before the servers are
+// shut down. After that, workers are stopped in the order they were added and closers, e.g. the
+// tracer, are closed in reverse order. The Logger is closed last.
+type Lifecycle struct {
+	// DrainPeriod is the time waited after failing readiness before the servers are shut down.
+	DrainPeriod time.Duration
+
+	// ShutdownTimeout limits the time servers and workers have to stop.
+	ShutdownTimeout time.Duration
+
+	logger  *Logger
+	health  *HealthRegistry
+	servers []lifecycleServer
+	workers []lifecycleWorker
+	closers []lifecycleCloser
+}
+
+// NewLifecycle creates a Lifecycle with default drain period and shutdown timeout. Readiness of
+// health, which may be nil, is failed as soon as the shutdown begins.
+func NewLifecycle(logger *Logger, health *HealthRegistry) *Lifecycle {
+	return &Lifecycle{
+		DrainPeriod:     DefaultDrainPeriod,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		logger:          logger,
+		health:          health,
+	}
+}
+
+// AddServer adds a server. serve blocks until the server stops, shutdown stops accepting new
+// connections and waits for in-flight requests until ctx expires.
+func (l *Lifecycle) AddServer(name string, serve func() error, shutdown func(ctx context.Context) error) {
+	l.servers = append(l.servers, lifecycleServer{name: name, serve: serve, shutdown: shutdown})
+}
+
+// AddHTTPServer adds a HTTP server accepting connections on lis.
+func (l *Lifecycle) AddHTTPServer(name string, srv *http.Server, lis net.Listener) {
+	l.AddServer(name, func() error { return srv.Serve(lis) }, srv.Shutdown)
+}
+
+// AddGRPCServer adds a gRPC server accepting connections on lis. Calls still running when the
+// shutdown times out are cancelled.
+func (l *Lifecycle) AddGRPCServer(name string, srv *grpc.Server, lis net.Listener) {
+	l.AddServer(name, func() error { return srv.Serve(lis) }, func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			srv.Stop()
+			return errors.Wrap(ctx.Err(), "gRPC calls didn't finish in time")
+		}
+	})
+}
+
+// AddWorker adds a background worker which runs until its context is cancelled.
+func (l *Lifecycle) AddWorker(name string, run func(ctx context.Context) error) {
+	l.workers = append(l.workers, lifecycleWorker{name: name, run: run})
+}
+
+// AddCloser adds a resource which is closed after all servers and workers have stopped.
+func (l *Lifecycle) AddCloser(name string, c io.Closer) {
+	l.closers = append(l.closers, lifecycleCloser{name: name, closer: c})
+}
+
+// Run starts all servers and workers and blocks until ctx is cancelled or a server fails, then
+// shuts everything down. The error of a failed server is returned.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	failed := make(chan error, len(l.servers))
+	for _, srv := range l.servers {
+		go func(srv lifecycleServer) {
+			err := srv.serve()
+			if err == nil || err == http.ErrServerClosed || err == grpc.ErrServerStopped {
+				return
+			}
+			failed <- errors.Wrapf(err, "%s server failed", srv.name)
+		}(srv)
+	}
+
+	stops := make([]func(context.Context), len(l.workers))
+	for i, w := range l.workers {
+		stops[i] = l.startWorker(w)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		l.logger.Info("Shutting down")
+	case err = <-failed:
+		l.logger.Errorw("Shutting down after server failure",
+			"error", err,
+		)
+	}
+
+	l.shutdown(stops)
+	return err
+}
+
+// startWorker runs w in the background and returns a function stopping it.
+func (l *Lifecycle) startWorker(w lifecycleWorker) func(context.Context) {
+	wctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := w.run(wctx); err != nil && wctx.Err() == nil {
+			l.logger.Errorw("worker failed",
+				"worker", w.name,
+				"error", err,
+			)
+		}
+	}()
+
+	return func(ctx context.Context) {
+		cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			l.logger.Warnw("worker didn't stop in time",
+				"worker", w.name,
+			)
+		}
+	}
+}
+
+// shutdown stops everything in order, see Lifecycle.
+func (l *Lifecycle) shutdown(stopWorkers []func(context.Context)) {
+	if l.health != nil {
+		l.health.Shutdown()
+	}
+
+	if l.DrainPeriod > 0 {
+		l.logger.Infow("Draining connections",
+			"period", l.DrainPeriod.String(),
+		)
+		time.Sleep(l.DrainPeriod)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range l.servers {
+		wg.Add(1)
+		go func(srv lifecycleServer) {
+			defer wg.Done()
+			if err := srv.shutdown(ctx); err != nil {
+				l.logger.Errorw("server shutdown",
+					"server", srv.name,
+					"error", err,
+				)
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	for _, stop := range stopWorkers {
+		stop(ctx)
+	}
+
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		if err := l.closers[i].closer.Close(); err != nil {
+			l.logger.Errorw("unable to close resource",
+				"resource", l.closers[i].name,
+				"error", err,
+			)
+		}
+	}
+
+	l.logger.Info("Shutdown complete")
+	l.logger.Close()
+}
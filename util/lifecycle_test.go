@@ -0,0 +1,159 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// helperRunLifecycle runs lc until cancel is called and returns a channel receiving its result.
+func helperRunLifecycle(lc *Lifecycle) (context.CancelFunc, chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- lc.Run(ctx) }()
+	return cancel, done
+}
+
+// helperWaitLifecycle fails if done doesn't receive within a second.
+func helperWaitLifecycle(done chan error, t *testing.T) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatalf("lifecycle didn't stop")
+		return nil
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	t.Run("Shutdown order", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		health := NewHealthRegistry("test")
+		lc := NewLifecycle(l, health)
+		lc.DrainPeriod = 30 * time.Millisecond
+
+		var mu sync.Mutex
+		var events []string
+		record := func(e string) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		}
+
+		var cancelled time.Time
+		stopped := make(chan struct{})
+		lc.AddServer("test", func() error {
+			<-stopped
+			return http.ErrServerClosed
+		}, func(ctx context.Context) error {
+			if !health.ShuttingDown() {
+				t.Errorf("server shut down before readiness failed")
+			}
+			if d := time.Since(cancelled); d < lc.DrainPeriod {
+				t.Errorf("server shut down after %s, want drain period of %s", d, lc.DrainPeriod)
+			}
+			record("server")
+			close(stopped)
+			return nil
+		})
+		for _, name := range []string{"first", "second"} {
+			name := name
+			lc.AddWorker(name, func(ctx context.Context) error {
+				<-ctx.Done()
+				record("worker " + name)
+				return nil
+			})
+			lc.AddCloser(name, closerFunc(func() error {
+				record("closer " + name)
+				return nil
+			}))
+		}
+
+		cancel, done := helperRunLifecycle(lc)
+		cancelled = time.Now()
+		cancel()
+		if err := helperWaitLifecycle(done, t); err != nil {
+			t.Errorf("Run returned %s after clean shutdown", err)
+		}
+
+		want := []string{"server", "worker first", "worker second", "closer second", "closer first"}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("shutdown order is %v, want %v", events, want)
+		}
+	})
+
+	t.Run("Server failure", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		lc := NewLifecycle(l, nil)
+		lc.DrainPeriod = 0
+		lc.AddServer("broken", func() error {
+			return errors.New("address in use")
+		}, func(ctx context.Context) error { return nil })
+
+		cancel, done := helperRunLifecycle(lc)
+		defer cancel()
+		if err := helperWaitLifecycle(done, t); err == nil {
+			t.Errorf("Run didn't return error of failed server")
+		}
+	})
+
+	t.Run("Draining HTTP requests", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		lc := NewLifecycle(l, NewHealthRegistry("test"))
+		lc.DrainPeriod = 0
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to create listener: %s", err)
+		}
+		received := make(chan struct{})
+		lc.AddHTTPServer("HTTP", &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(received)
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("done"))
+		})}, lis)
+
+		cancel, done := helperRunLifecycle(lc)
+		res := make(chan string, 1)
+		go func() {
+			resp, err := http.Get("http://" + lis.Addr().String())
+			if err != nil {
+				res <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			b, _ := ioutil.ReadAll(resp.Body)
+			res <- string(b)
+		}()
+
+		<-received
+		cancel()
+		if err := helperWaitLifecycle(done, t); err != nil {
+			t.Errorf("Run returned %s after clean shutdown", err)
+		}
+		if got := <-res; got != "done" {
+			t.Errorf("in-flight request returned %#v, want done", got)
+		}
+	})
+
+	t.Run("Shutdown timeout", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		lc := NewLifecycle(l, nil)
+		lc.DrainPeriod = 0
+		lc.ShutdownTimeout = 20 * time.Millisecond
+		lc.AddWorker("stuck", func(ctx context.Context) error {
+			select {}
+		})
+
+		cancel, done := helperRunLifecycle(lc)
+		cancel()
+		helperWaitLifecycle(done, t)
+	})
+}
@@ -0,0 +1,471 @@
+package util
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Reasons requests are rejected for, used as reason label of requests_rejected_total.
+const (
+	// RejectRateLimited requests exceeded the rate limit of their client and route.
+	RejectRateLimited = "rate_limited"
+	// RejectOverloaded requests arrived while the service was serving MaxInFlight requests.
+	RejectOverloaded = "overloaded"
+)
+
+// overloadRetryAfter is the time clients are asked to wait after being shed for overload.
+const overloadRetryAfter = time.Second
+
+// RateLimit configures a token bucket holding up to Burst tokens, refilled with Rate tokens per
+// second. Each request takes a token and is rejected if there is none left. The zero value
+// doesn't limit requests.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled returns true if the RateLimit limits requests.
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// wait returns the time until a bucket holding tokens has a full token again.
+func (l RateLimit) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / l.Rate * float64(time.Second)))
+}
+
+// RateLimiter holds the token buckets of clients.
+type RateLimiter interface {
+	// Take takes a token from the bucket of key. If there is none, it returns the time until the
+	// next one is available.
+	Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error)
+}
+
+// LocalRateLimiter holds token buckets in memory, limiting each replica of a service on its own.
+type LocalRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+// localLimiterSweep is the number of buckets after which full ones are removed, at most once per
+// second.
+const localLimiterSweep = 10000
+
+// NewLocalRateLimiter returns an empty LocalRateLimiter.
+func NewLocalRateLimiter() *LocalRateLimiter {
+	return &LocalRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Take implements RateLimiter.
+func (l *LocalRateLimiter) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.buckets) >= localLimiterSweep && now.Sub(l.swept) >= time.Second {
+		l.sweep(now)
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*limit.Rate, float64(limit.Burst))
+	b.last = now
+	b.limit = limit
+
+	if b.tokens < 1 {
+		return limit.wait(b.tokens), nil
+	}
+	b.tokens--
+	return 0, nil
+}
+
+// sweep removes buckets which would be full again by the limit they were last taken from, since
+// they are equal to new ones.
+func (l *LocalRateLimiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		full := time.Duration(float64(b.limit.Burst) / b.limit.Rate * float64(time.Second))
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// redisTokenBucket takes a token from the bucket stored as hash in KEYS[1], with ARGV holding
+// rate, burst and the current time in milliseconds. It returns 1 and 0 if a token was taken,
+// otherwise 0 and the tokens left as string, since Redis truncates numbers returned by scripts.
+// Buckets expire once they would be full again.
+var redisTokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+tokens = math.min(tokens + math.max(now - last, 0) * rate / 1000, burst)
+
+local taken = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000))
+return {taken, tostring(tokens)}
+`)
+
+// RedisRateLimiter holds token buckets in Redis, so all replicas of a service share the limit of
+// a client. Buckets are refilled by the clock of the replicas, which should be synchronized.
+type RedisRateLimiter struct {
+	client *redis.Client
+	prefix string
+	now    func() time.Time
+}
+
+// NewRedisRateLimiter returns a RedisRateLimiter storing the buckets of a service in client.
+func NewRedisRateLimiter(client *redis.Client, serviceName string) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		client: client,
+		prefix: "ratelimit:" + serviceName + ":",
+		now:    time.Now,
+	}
+}
+
+// Take implements RateLimiter.
+func (l *RedisRateLimiter) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	now := l.now().UnixNano() / int64(time.Millisecond)
+	res, err := redisTokenBucket.Run(l.client, []string{l.prefix + key}, limit.Rate, limit.Burst, now).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, apierr.New(apierr.Internal, "unexpected rate limiter result %v", res)
+	}
+	if taken, _ := vals[0].(int64); taken == 1 {
+		return 0, nil
+	}
+	s, _ := vals[1].(string)
+	tokens, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, apierr.Wrap(err, apierr.Internal, "unexpected rate limiter result %v", res)
+	}
+	return limit.wait(tokens), nil
+}
+
+// LimitPolicy protects a service from bursts: requests exceeding the rate limit of their client
+// and route are rejected with a 429 problem, requests arriving while MaxInFlight requests are
+// being served are shed with a 503 problem. Both carry a Retry-After header and are counted by
+// requests_rejected_total.
+type LimitPolicy struct {
+	// RateLimit is the default limit of each client per route, see Route.RateLimit. Clients are
+	// identified by the subject of their Principal, or by their IP address if unauthenticated.
+	RateLimit RateLimit
+
+	// ClientRateLimit is the limit of each IP address across all routes. It's applied before
+	// authentication, so floods of invalid credentials are limited as well.
+	ClientRateLimit RateLimit
+
+	// MaxInFlight is the number of requests served concurrently, counting the same requests as
+	// the in_flight_requests gauge. Zero serves any number of requests.
+	MaxInFlight int
+
+	// Limiter holds the token buckets, in memory by default.
+	Limiter RateLimiter
+
+	inFlight int64
+	rejected *prometheus.CounterVec
+}
+
+// NewLimitPolicy returns a LimitPolicy of a service limiting nothing.
+func NewLimitPolicy(serviceName string) *LimitPolicy {
+	return &LimitPolicy{
+		Limiter: NewLocalRateLimiter(),
+		rejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "requests_rejected_total",
+				Help:        "A counter for requests rejected by rate limits or load shedding.",
+				ConstLabels: prometheus.Labels{"service": serviceName},
+			},
+			[]string{"route", "reason"},
+		),
+	}
+}
+
+// Collectors returns the Prometheus collectors of the policy for registration.
+func (p *LimitPolicy) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.rejected}
+}
+
+// acquire counts a request as in flight unless MaxInFlight requests are, release needs to be
+// called once it's served.
+func (p *LimitPolicy) acquire() (release func(), err error) {
+	n := atomic.AddInt64(&p.inFlight, 1)
+	release = func() { atomic.AddInt64(&p.inFlight, -1) }
+	if p.MaxInFlight > 0 && n > int64(p.MaxInFlight) {
+		release()
+		return nil, apierr.New(apierr.Unavailable, "service is overloaded, retry later")
+	}
+	return release, nil
+}
+
+// take takes a token of client for route, limited by limit or RateLimit if limit isn't enabled.
+func (p *LimitPolicy) take(ctx context.Context, route string, limit RateLimit, client string, logger *Logger) (time.Duration, error) {
+	if !limit.Enabled() {
+		limit = p.RateLimit
+	}
+	return p.takeToken(ctx, route, route+"|"+client, limit, logger)
+}
+
+// takeClient takes a token of the IP address addr, limited by ClientRateLimit.
+func (p *LimitPolicy) takeClient(ctx context.Context, route, addr string, logger *Logger) (time.Duration, error) {
+	return p.takeToken(ctx, route, "*|"+addrKey(addr), p.ClientRateLimit, logger)
+}
+
+// takeToken takes a token from the bucket of key, unless limit isn't enabled. Failures of the
+// Limiter let requests pass, so an unreachable Redis doesn't take the service down.
+func (p *LimitPolicy) takeToken(ctx context.Context, route, key string, limit RateLimit, logger *Logger) (time.Duration, error) {
+	if !limit.Enabled() {
+		return 0, nil
+	}
+
+	wait, err := p.Limiter.Take(ctx, key, limit)
+	if err != nil {
+		log := RequestIDLoggerFromContext(ctx, logger)
+		log.Warnw("rate limiter failed, letting request pass",
+			"route", route,
+			"error", err,
+		)
+		return 0, nil
+	}
+	if wait > 0 {
+		return wait, apierr.New(apierr.ResourceExhausted, "rate limit of %v requests per second exceeded, retry later", limit.Rate)
+	}
+	return 0, nil
+}
+
+// reject counts a rejected request and logs it on debug level, since rejections come in bursts.
+func (p *LimitPolicy) reject(ctx context.Context, route, reason string, logger *Logger) {
+	p.rejected.WithLabelValues(route, reason).Inc()
+	log := RequestIDLoggerFromContext(ctx, logger)
+	log.Debugw("request rejected",
+		"route", route,
+		"reason", reason,
+	)
+}
+
+// retryAfter formats a wait as value of the Retry-After header, in whole seconds.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// clientKey identifies the client of a request by the subject of its Principal, or by addr.
+func clientKey(ctx context.Context, addr string) string {
+	if p := PrincipalFromContext(ctx); p != nil {
+		return "subject:" + p.Subject
+	}
+	return addrKey(addr)
+}
+
+// addrKey identifies the client of a request by the IP address of addr.
+func addrKey(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "ip:" + addr
+}
+
+// LoadShedMiddleware rejects requests to routes that aren't Unlimited with a 503 problem while
+// MaxInFlight requests are being served.
+func LoadShedMiddleware(inner http.Handler, route Route, p *LimitPolicy, logger *Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route.Unlimited {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		release, err := p.acquire()
+		if err != nil {
+			p.reject(r.Context(), route.Name, RejectOverloaded, logger)
+			w.Header().Set("Retry-After", retryAfter(overloadRetryAfter))
+			NewErrorProblem(err).SendJSON(w)
+			return
+		}
+		defer release()
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitMiddleware rejects requests to routes that aren't Unlimited with a 429 problem once
+// their client exceeded the rate limit of the route. It needs to run after AuthMiddleware, so
+// authenticated clients are identified by their Principal.
+func RateLimitMiddleware(inner http.Handler, route Route, p *LimitPolicy, logger *Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route.Unlimited {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		wait, err := p.take(ctx, route.Name, route.RateLimit, clientKey(ctx, r.RemoteAddr), logger)
+		if err != nil {
+			p.reject(ctx, route.Name, RejectRateLimited, logger)
+			w.Header().Set("Retry-After", retryAfter(wait))
+			NewErrorProblem(err).SendJSON(w)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// ClientRateLimitMiddleware rejects requests to routes that aren't Unlimited with a 429 problem once
+// their IP address exceeded the ClientRateLimit across all routes. It runs before AuthMiddleware,
+// so requests are limited before their credentials are verified.
+func ClientRateLimitMiddleware(inner http.Handler, route Route, p *LimitPolicy, logger *Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route.Unlimited {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		wait, err := p.takeClient(ctx, route.Name, r.RemoteAddr, logger)
+		if err != nil {
+			p.reject(ctx, route.Name, RejectRateLimited, logger)
+			w.Header().Set("Retry-After", retryAfter(wait))
+			NewErrorProblem(err).SendJSON(w)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// GRPCClientRateLimitInterceptor is the gRPC equivalent of ClientRateLimitMiddleware for unary
+// calls.
+func GRPCClientRateLimitInterceptor(p *LimitPolicy, logger *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := limitGRPCClient(ctx, p, info.FullMethod, logger); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamClientRateLimitInterceptor is the gRPC equivalent of ClientRateLimitMiddleware for
+// streaming calls.
+func GRPCStreamClientRateLimitInterceptor(p *LimitPolicy, logger *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := limitGRPCClient(ss.Context(), p, info.FullMethod, logger); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func limitGRPCClient(ctx context.Context, p *LimitPolicy, fullMethod string, logger *Logger) error {
+	if isGRPCHealthMethod(fullMethod) {
+		return nil
+	}
+
+	wait, err := p.takeClient(ctx, fullMethod, grpcPeerAddr(ctx), logger)
+	if err != nil {
+		p.reject(ctx, fullMethod, RejectRateLimited, logger)
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(wait)))
+		return apierr.GRPCStatus(err)
+	}
+	return nil
+}
+
+// GRPCLimitInterceptor is the gRPC equivalent of LoadShedMiddleware and RateLimitMiddleware for
+// unary calls, limiting each method with the default RateLimit. Calls of the health service are
+// never limited.
+func GRPCLimitInterceptor(p *LimitPolicy, logger *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := limitGRPCCall(ctx, p, info.FullMethod, logger)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamLimitInterceptor is the gRPC equivalent of LoadShedMiddleware and RateLimitMiddleware
+// for streaming calls.
+func GRPCStreamLimitInterceptor(p *LimitPolicy, logger *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := limitGRPCCall(ss.Context(), p, info.FullMethod, logger)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+func limitGRPCCall(ctx context.Context, p *LimitPolicy, fullMethod string, logger *Logger) (func(), error) {
+	if isGRPCHealthMethod(fullMethod) {
+		return func() {}, nil
+	}
+
+	release, err := p.acquire()
+	if err != nil {
+		p.reject(ctx, fullMethod, RejectOverloaded, logger)
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(overloadRetryAfter)))
+		return nil, apierr.GRPCStatus(err)
+	}
+
+	wait, err := p.take(ctx, fullMethod, RateLimit{}, clientKey(ctx, grpcPeerAddr(ctx)), logger)
+	if err != nil {
+		release()
+		p.reject(ctx, fullMethod, RejectRateLimited, logger)
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(wait)))
+		return nil, apierr.GRPCStatus(err)
+	}
+	return release, nil
+}
+
+// isGRPCHealthMethod returns true for methods of the health service, which are never limited.
+func isGRPCHealthMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
+// grpcPeerAddr returns the address of the client of a gRPC call.
+func grpcPeerAddr(ctx context.Context) string {
+	if pr, ok := peer.FromContext(ctx); ok {
+		return pr.Addr.String()
+	}
+	return ""
+}
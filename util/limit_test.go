@@ -0,0 +1,338 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingRateLimiter fails to take tokens, like a RedisRateLimiter with Redis being down.
+type failingRateLimiter struct{}
+
+func (failingRateLimiter) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	return 0, errors.New("connection refused")
+}
+
+// helperLimitedRequest sends a request from addr to h and returns the response.
+func helperLimitedRequest(h http.Handler, addr string, p *Principal) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.RemoteAddr = addr
+	if p != nil {
+		req = req.WithContext(ContextWithPrincipal(req.Context(), p))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimiter(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unable to start miniredis: %s", err)
+	}
+	defer mr.Close()
+	rc, _ := NewRedisClient("redis://" + mr.Addr())
+
+	now := time.Unix(1000000, 0)
+	clock := func() time.Time { return now }
+
+	local := NewLocalRateLimiter()
+	local.now = clock
+	shared := NewRedisRateLimiter(rc, "item")
+	shared.now = clock
+
+	limit := RateLimit{Rate: 2, Burst: 2}
+	for name, l := range map[string]RateLimiter{"local": local, "redis": shared} {
+		now = time.Unix(1000000, 0)
+
+		var tests = []struct {
+			advance time.Duration
+			key     string
+			wait    time.Duration
+		}{
+			{0, "a", 0},
+			{0, "a", 0},
+			{0, "a", 500 * time.Millisecond},
+			{0, "b", 0},
+			{200 * time.Millisecond, "a", 300 * time.Millisecond},
+			{300 * time.Millisecond, "a", 0},
+			{0, "a", 500 * time.Millisecond},
+			{10 * time.Second, "a", 0},
+			{0, "a", 0},
+			{0, "a", 500 * time.Millisecond},
+		}
+
+		for n, tt := range tests {
+			now = now.Add(tt.advance)
+			wait, err := l.Take(context.Background(), tt.key, limit)
+			if err != nil {
+				t.Fatalf("%s: request %d: Take() returned %s", name, n, err)
+			}
+			if wait != tt.wait {
+				t.Errorf("%s: request %d: Take() = %s, want %s", name, n, wait, tt.wait)
+			}
+		}
+	}
+
+	if !mr.Exists("ratelimit:item:a") || mr.TTL("ratelimit:item:a") != time.Second {
+		t.Errorf("bucket in redis doesn't expire once full, TTL is %s", mr.TTL("ratelimit:item:a"))
+	}
+
+	t.Run("Sweep", func(t *testing.T) {
+		l := NewLocalRateLimiter()
+		l.now = clock
+		for n := 0; n < localLimiterSweep; n++ {
+			l.Take(context.Background(), fmt.Sprint(n), limit)
+		}
+		now = now.Add(time.Second)
+		l.Take(context.Background(), "new", limit)
+		if len(l.buckets) != 1 {
+			t.Errorf("%d buckets left after sweep, want 1", len(l.buckets))
+		}
+	})
+
+	t.Run("Sweep keeps buckets of slower limits", func(t *testing.T) {
+		l := NewLocalRateLimiter()
+		l.now = clock
+		slow := RateLimit{Rate: 0.1, Burst: 1}
+		for n := 0; n < localLimiterSweep; n++ {
+			l.Take(context.Background(), fmt.Sprint(n), slow)
+		}
+		now = now.Add(time.Second)
+		l.Take(context.Background(), "new", limit)
+		if len(l.buckets) != localLimiterSweep+1 {
+			t.Errorf("%d buckets left after sweep, want %d", len(l.buckets), localLimiterSweep+1)
+		}
+		if wait, _ := l.Take(context.Background(), "0", slow); wait == 0 {
+			t.Errorf("empty bucket of slower limit was swept")
+		}
+	})
+
+	t.Run("Redis down", func(t *testing.T) {
+		mr.Close()
+		if _, err := shared.Take(context.Background(), "a", limit); err == nil {
+			t.Errorf("expected error taking token from unreachable redis")
+		}
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("Limits per client and route", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		p := NewLimitPolicy("item")
+		p.RateLimit = RateLimit{Rate: 1, Burst: 2}
+		h := RateLimitMiddleware(ok, Route{Name: "getAllItems"}, p, l)
+		other := RateLimitMiddleware(ok, Route{Name: "getItem"}, p, l)
+		alice := &Principal{Subject: "alice"}
+
+		var tests = []struct {
+			name    string
+			h       http.Handler
+			addr    string
+			p       *Principal
+			want    int
+			retries string
+		}{
+			{"First request", h, "10.0.0.1:1234", nil, http.StatusOK, ""},
+			{"Burst", h, "10.0.0.1:2345", nil, http.StatusOK, ""},
+			{"Exceeding", h, "10.0.0.1:3456", nil, http.StatusTooManyRequests, "1"},
+			{"Other client", h, "10.0.0.2:1234", nil, http.StatusOK, ""},
+			{"Other route", other, "10.0.0.1:1234", nil, http.StatusOK, ""},
+			{"Principal", h, "10.0.0.1:1234", alice, http.StatusOK, ""},
+			{"Principal from other address", h, "10.0.0.3:1234", alice, http.StatusOK, ""},
+			{"Principal exceeding", h, "10.0.0.4:1234", alice, http.StatusTooManyRequests, "1"},
+		}
+
+		for _, tt := range tests {
+			w := helperLimitedRequest(tt.h, tt.addr, tt.p)
+			if w.Code != tt.want {
+				t.Errorf("%s: status is %d, want %d", tt.name, w.Code, tt.want)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retries {
+				t.Errorf("%s: Retry-After is %#v, want %#v", tt.name, got, tt.retries)
+			}
+			if tt.want == http.StatusTooManyRequests {
+				if err := apierr.FromResponse(w.Result()); !apierr.Is(err, apierr.ResourceExhausted) {
+					t.Errorf("%s: response is %v, want %s problem", tt.name, err, apierr.ResourceExhausted)
+				}
+			}
+		}
+
+		if got := testutil.ToFloat64(p.rejected.WithLabelValues("getAllItems", RejectRateLimited)); got != 2 {
+			t.Errorf("requests_rejected_total is %v, want 2", got)
+		}
+	})
+
+	t.Run("Route limits", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		p := NewLimitPolicy("item")
+		p.RateLimit = RateLimit{Rate: 1, Burst: 1}
+
+		var tests = []struct {
+			name  string
+			route Route
+			want  []int
+		}{
+			{"Default", Route{Name: "getAllItems"}, []int{http.StatusOK, http.StatusTooManyRequests}},
+			{"Override", Route{Name: "getItem", RateLimit: RateLimit{Rate: 1, Burst: 2}}, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+			{"Unlimited", Route{Name: "readyz", Unlimited: true}, []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+		}
+
+		for _, tt := range tests {
+			h := RateLimitMiddleware(ok, tt.route, p, l)
+			for n, want := range tt.want {
+				if w := helperLimitedRequest(h, "10.0.0.1:1234", nil); w.Code != want {
+					t.Errorf("%s: request %d returned %d, want %d", tt.name, n, w.Code, want)
+				}
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		h := RateLimitMiddleware(ok, Route{Name: "getAllItems"}, NewLimitPolicy("item"), l)
+		for n := 0; n < 100; n++ {
+			if w := helperLimitedRequest(h, "10.0.0.1:1234", nil); w.Code != http.StatusOK {
+				t.Fatalf("request %d returned %d without rate limit", n, w.Code)
+			}
+		}
+	})
+
+	t.Run("Failing limiter", func(t *testing.T) {
+		l, logs := helperObservedLogger()
+		p := NewLimitPolicy("item")
+		p.RateLimit = RateLimit{Rate: 1, Burst: 1}
+		p.Limiter = failingRateLimiter{}
+		h := RateLimitMiddleware(ok, Route{Name: "getAllItems"}, p, l)
+
+		for n := 0; n < 2; n++ {
+			if w := helperLimitedRequest(h, "10.0.0.1:1234", nil); w.Code != http.StatusOK {
+				t.Errorf("request %d returned %d, want %d", n, w.Code, http.StatusOK)
+			}
+		}
+		if logs.FilterMessage("rate limiter failed, letting request pass").Len() != 2 {
+			t.Errorf("limiter failures weren't logged")
+		}
+	})
+}
+
+func TestLoadShedMiddleware(t *testing.T) {
+	l, _ := helperObservedLogger()
+	p := NewLimitPolicy("item")
+	p.MaxInFlight = 1
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	blocking := LoadShedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}), Route{Name: "getAllItems"}, p, l)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	done := make(chan struct{})
+	go func() {
+		helperLimitedRequest(blocking, "10.0.0.1:1234", nil)
+		close(done)
+	}()
+	<-entered
+
+	w := helperLimitedRequest(LoadShedMiddleware(ok, Route{Name: "getItem"}, p, l), "10.0.0.2:1234", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status while overloaded is %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After is %#v, want 1", got)
+	}
+	if err := apierr.FromResponse(w.Result()); !apierr.Is(err, apierr.Unavailable) {
+		t.Errorf("response is %v, want %s problem", err, apierr.Unavailable)
+	}
+	if w := helperLimitedRequest(LoadShedMiddleware(ok, Route{Name: "readyz", Unlimited: true}, p, l), "10.0.0.2:1234", nil); w.Code != http.StatusOK {
+		t.Errorf("unlimited route returned %d while overloaded", w.Code)
+	}
+
+	close(release)
+	<-done
+	if w := helperLimitedRequest(LoadShedMiddleware(ok, Route{Name: "getItem"}, p, l), "10.0.0.2:1234", nil); w.Code != http.StatusOK {
+		t.Errorf("status after load dropped is %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := testutil.ToFloat64(p.rejected.WithLabelValues("getItem", RejectOverloaded)); got != 1 {
+		t.Errorf("requests_rejected_total is %v, want 1", got)
+	}
+}
+
+func TestGRPCLimitInterceptor(t *testing.T) {
+	l, _ := helperObservedLogger()
+	p := NewLimitPolicy("item")
+	p.RateLimit = RateLimit{Rate: 1, Burst: 1}
+	interceptor := GRPCLimitInterceptor(p, l)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+
+	var tests = []struct {
+		method string
+		want   codes.Code
+	}{
+		{"/item.ItemService/Get", codes.OK},
+		{"/item.ItemService/Get", codes.ResourceExhausted},
+		{"/item.ItemService/List", codes.OK},
+		{"/grpc.health.v1.Health/Check", codes.OK},
+		{"/grpc.health.v1.Health/Check", codes.OK},
+	}
+
+	for _, tt := range tests {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+		if status.Code(err) != tt.want {
+			t.Errorf("%s returned %s, want %s", tt.method, status.Code(err), tt.want)
+		}
+	}
+
+	p.MaxInFlight = 1
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/item.ItemService/Delete"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/item.ItemService/Upsert"}, handler)
+	})
+	if status.Code(err) != codes.Unavailable || !strings.Contains(status.Convert(err).Message(), "overloaded") {
+		t.Errorf("nested call while overloaded returned %v, want %s", err, codes.Unavailable)
+	}
+}
+
+func TestGRPCClientRateLimitInterceptor(t *testing.T) {
+	l, _ := helperObservedLogger()
+	p := NewLimitPolicy("item")
+	p.ClientRateLimit = RateLimit{Rate: 1, Burst: 2}
+	interceptor := GRPCClientRateLimitInterceptor(p, l)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+
+	var tests = []struct {
+		method string
+		want   codes.Code
+	}{
+		{"/item.ItemService/Get", codes.OK},
+		{"/item.ItemService/List", codes.OK},
+		{"/item.ItemService/Delete", codes.ResourceExhausted},
+		{"/grpc.health.v1.Health/Check", codes.OK},
+	}
+
+	for _, tt := range tests {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+		if status.Code(err) != tt.want {
+			t.Errorf("%s returned %s, want %s", tt.method, status.Code(err), tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(p.rejected.WithLabelValues("/item.ItemService/Delete", RejectRateLimited)); got != 1 {
+		t.Errorf("requests_rejected_total is %v, want 1", got)
+	}
+}
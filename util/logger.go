@@ -2,96 +2,198 @@ package util
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
+	ot "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // Logger is an adapter type for zap's SugaredLogger
 type Logger struct {
 	logger *zap.SugaredLogger
+	redact *RedactionPolicy
+	span   ot.Span
+	levels *LogLevels
+	sinks  *logSinks
+	routes *routeLoggers
 }
 
-// NewLogger creates a new Logger.
+// routeLoggers caches the loggers of routes with an overridden level, built for the version of the
+// overrides.
+type routeLoggers struct {
+	mu      sync.Mutex
+	version uint64
+	loggers map[string]*zap.SugaredLogger
+}
+
+// NewLogger creates a new Logger using DefaultLogConfig. Its level can be changed at runtime via
+// Levels.
 func NewLogger(level, serviceName string) (*Logger, error) {
-	atom := zap.NewAtomicLevel()
+	cfg := DefaultLogConfig()
+	cfg.Level = level
+	return NewLoggerWithConfig(serviceName, cfg)
+}
 
-	switch level {
-	case "debug":
-		atom.SetLevel(zap.DebugLevel)
-	case "warn":
-		atom.SetLevel(zap.WarnLevel)
-	case "error":
-		atom.SetLevel(zap.ErrorLevel)
-	default:
-		level = "info"
-		atom.SetLevel(zap.InfoLevel)
+// NewLoggerWithConfig creates a new Logger writing to the outputs of cfg. Invalid levels fall back
+// to DefaultLogLevel. Close should be called on shutdown if files are written.
+func NewLoggerWithConfig(serviceName string, cfg LogConfig) (*Logger, error) {
+	if err := CheckLogConfig(cfg); err != nil {
+		return nil, errors.Wrap(err, "Unable to initialize zap Logger")
 	}
 
-	cfg := zap.Config{
-		Development:       false,
-		DisableCaller:     true,
-		DisableStacktrace: false,
-		EncoderConfig:     zap.NewProductionEncoderConfig(),
-		Encoding:          "json",
-		ErrorOutputPaths:  []string{"stdout"},
-		Level:             atom,
-		OutputPaths:       []string{"stdout"},
+	level := cfg.Level
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		level = DefaultLogLevel
 	}
+	levels := newLogLevels(zap.NewAtomicLevelAt(lvl))
 
-	if cfg.InitialFields == nil {
-		cfg.InitialFields = make(map[string]interface{})
+	// The core accepts all levels, which are filtered by levelCore instead, so request loggers
+	// can override them per route
+	sinks := &logSinks{}
+	core := zapcore.NewCore(newLogEncoder(cfg.Encoding), sinks.open(cfg.Outputs, cfg.Rotation), zap.DebugLevel)
+	if cfg.Sampling.Initial > 0 {
+		core = zapcore.NewSampler(core, time.Second, cfg.Sampling.Initial, cfg.Sampling.Thereafter)
 	}
-	cfg.InitialFields["service"] = serviceName
 
-	l, err := cfg.Build()
-	if err != nil {
-		return nil, errors.Wrap(err, "Unable to initialize zap Logger")
+	opts := []zap.Option{
+		zap.AddStacktrace(zap.ErrorLevel),
+		zap.Fields(zap.String("service", serviceName)),
+	}
+	if len(cfg.ErrorOutputs) > 0 {
+		opts = append(opts, zap.ErrorOutput(sinks.open(cfg.ErrorOutputs, cfg.Rotation)))
 	}
+	l := zap.New(&levelCore{Core: core, enabler: levels.route("")}, opts...)
+	sinks.rotateEvery(cfg.Rotation.Interval)
 
 	l.Debug("Logger created",
 		zap.String("level", level),
+		zap.String("encoding", cfg.Encoding),
+		zap.Strings("outputs", cfg.Outputs),
 	)
 
-	return &Logger{logger: l.Sugar()}, nil
+	return &Logger{logger: l.Sugar(), levels: levels, sinks: sinks, routes: &routeLoggers{}}, nil
 }
 
-// LoggerMiddleware is a decorator for a HTTP Request, adding structured logging functionality
-func LoggerMiddleware(inner http.Handler, logger *Logger) http.HandlerFunc {
+// LoggerMiddleware is a decorator for a HTTP Request, adding structured logging functionality.
+// Request loggers of the route use the log level overridden for its name, if any. Middlewares
+// inside it may add fields to the "request completed" message with addRequestLogFields.
+func LoggerMiddleware(inner http.Handler, route Route, logger *Logger) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		rl := &requestLog{}
+		ctx := context.WithValue(r.Context(), routeKey, route.Name)
+		r = r.WithContext(context.WithValue(ctx, requestLogKey, rl))
 		log := RequestIDLogger(logger, r)
 		log.Debugw("request received",
 			"address", r.RemoteAddr,
 			"method", r.Method,
 			"path", r.RequestURI,
 		)
-		inner.ServeHTTP(w, r)
-		log.Infow("request completed",
+		rw := WrapResponseWriter(w)
+		inner.ServeHTTP(rw, r)
+		log.Infow("request completed", append([]interface{}{
 			"address", r.RemoteAddr,
 			"method", r.Method,
 			"path", r.RequestURI,
+			"status", rw.Status(),
+			"size", rw.Size(),
 			"duration", time.Since(start),
-		)
+		}, rl.fields...)...)
 	})
 }
 
+// requestLog holds the fields added to the "request completed" message of a request.
+type requestLog struct {
+	fields []interface{}
+}
+
+// addRequestLogFields adds key-value pairs to the "request completed" message of the request of
+// ctx, if it's logged by LoggerMiddleware or the gRPC logger interceptors.
+func addRequestLogFields(ctx context.Context, kv ...interface{}) {
+	if rl, ok := ctx.Value(requestLogKey).(*requestLog); ok {
+		rl.fields = append(rl.fields, kv...)
+	}
+}
+
 // RequestIDLogger extracts the requestID from the request context, adds it to
-// a child logger and then returns the child logger.
+// a child logger and then returns the child logger. If the request is traced,
+// the traceID and spanID of the active span are added as well.
 func RequestIDLogger(l *Logger, r *http.Request) *Logger {
-	reqID := RequestIDFromContext(r.Context())
-	log := l.logger.With("requestID", reqID)
-	return &Logger{log}
+	return RequestIDLoggerFromContext(r.Context(), l)
 }
 
 // RequestIDLoggerFromContext extract the requestID from a passed context, adds
-// it to a child logger and then returns the child logger.
+// it to a child logger and then returns the child logger. If the context holds
+// a span, its traceID and spanID are added as well and error messages are
+// mirrored as span events. The subject of an authenticated Principal is added
+// as principal. Inside LoggerMiddleware, the level overridden for
+// the route is used.
 func RequestIDLoggerFromContext(ctx context.Context, l *Logger) *Logger {
-	reqID := RequestIDFromContext(ctx)
-	log := l.logger.With("requestID", reqID)
-	return &Logger{log}
+	kv := []interface{}{"requestID", RequestIDFromContext(ctx)}
+	span := ot.SpanFromContext(ctx)
+	if traceID, spanID, ok := SpanIDs(span); ok {
+		kv = append(kv, "traceID", traceID, "spanID", spanID)
+	}
+	if p := PrincipalFromContext(ctx); p != nil {
+		kv = append(kv, "principal", p.Subject)
+	}
+	log := l.logger
+	if route, ok := ctx.Value(routeKey).(string); ok && route != "" && l.levels != nil {
+		log = l.routeLogger(route)
+	}
+	return &Logger{logger: log.With(kv...), redact: l.redact, span: span, levels: l.levels, sinks: l.sinks}
+}
+
+// routeLogger returns the logger of route, using the level overridden for it. Loggers of the
+// Logger returned by NewLogger are cached per route until the overrides change.
+func (l *Logger) routeLogger(route string) *zap.SugaredLogger {
+	lvl, version, ok := l.levels.override(route)
+	if !ok {
+		return l.logger
+	}
+	if l.routes == nil {
+		return l.withLevel(lvl)
+	}
+
+	l.routes.mu.Lock()
+	defer l.routes.mu.Unlock()
+	if l.routes.loggers == nil || l.routes.version != version {
+		l.routes.loggers = make(map[string]*zap.SugaredLogger)
+		l.routes.version = version
+	}
+	log, ok := l.routes.loggers[route]
+	if !ok {
+		log = l.withLevel(lvl)
+		l.routes.loggers[route] = log
+	}
+	return log
+}
+
+// withLevel returns the logger of l writing entries of lvl and above.
+func (l *Logger) withLevel(lvl zapcore.Level) *zap.SugaredLogger {
+	return l.logger.Desugar().WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		if lc, ok := c.(*levelCore); ok {
+			return &levelCore{Core: lc.Core, enabler: lvl}
+		}
+		return c
+	})).Sugar()
+}
+
+// Levels returns the levels shared by the Logger and its children.
+func (l *Logger) Levels() *LogLevels {
+	return l.levels
+}
+
+// SetRedactionPolicy sets the RedactionPolicy applied to the key-value pairs of structured log
+// messages. Child loggers created afterwards inherit the policy.
+func (l *Logger) SetRedactionPolicy(p *RedactionPolicy) {
+	l.redact = p
 }
 
 // Info uses fmt.Sprint to log a templated message.
@@ -106,18 +208,34 @@ func (l *Logger) Infof(msg string, args ...interface{}) {
 
 // Infow logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
 func (l *Logger) Infow(msg string, kv ...interface{}) {
-	l.logger.Infow(msg, kv...)
+	l.logger.Infow(msg, l.redact.Fields(kv)...)
 }
 
 // Error uses fmt.Sprint to construct and log a message.
 func (l *Logger) Error(msg string) {
 	l.logger.Error(msg)
+	l.logSpanEvent(msg, nil)
 }
 
 // Errorw logs a message with some additional context. The variadic key-value
 // pairs are treated as they are in With.
 func (l *Logger) Errorw(msg string, kv ...interface{}) {
+	kv = l.redact.Fields(kv)
 	l.logger.Errorw(msg, kv...)
+	l.logSpanEvent(msg, kv)
+}
+
+// logSpanEvent mirrors an error message as event on the span of a request logger.
+func (l *Logger) logSpanEvent(msg string, kv []interface{}) {
+	if l.span == nil {
+		return
+	}
+
+	fields := []interface{}{"event", "error", "message", msg}
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields = append(fields, fmt.Sprint(kv[i]), kv[i+1])
+	}
+	l.span.LogKV(fields...)
 }
 
 // Warn uses fmt.Sprint to log a templated message.
@@ -132,7 +250,7 @@ func (l *Logger) Warnf(msg string, args ...interface{}) {
 
 // Warnw logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
 func (l *Logger) Warnw(msg string, kv ...interface{}) {
-	l.logger.Warnw(msg, kv...)
+	l.logger.Warnw(msg, l.redact.Fields(kv)...)
 }
 
 // Debug uses fmt.Sprint to log a templated message.
@@ -147,7 +265,7 @@ func (l *Logger) Debugf(msg string, args ...interface{}) {
 
 // Debugw logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
 func (l *Logger) Debugw(msg string, kv ...interface{}) {
-	l.logger.Debugw(msg, kv...)
+	l.logger.Debugw(msg, l.redact.Fields(kv)...)
 }
 
 // Panic uses fmt.Sprint to log a templated message, then panics.
@@ -162,7 +280,7 @@ func (l *Logger) Panicf(msg string, args ...interface{}) {
 
 // Panicw logs a message with some additional context, then panics The variadic key-value pairs are treated as they are in With.
 func (l *Logger) Panicw(msg string, kv ...interface{}) {
-	l.logger.Panicw(msg, kv...)
+	l.logger.Panicw(msg, l.redact.Fields(kv)...)
 }
 
 // Fatal uses fmt.Sprint to log a templated message, then calls os.Exit.
@@ -177,10 +295,19 @@ func (l *Logger) Fatalf(msg string, args ...interface{}) {
 
 // Fatalw logs a message with some additional context, then calls os.Exit. The variadic key-value pairs are treated as they are in With.
 func (l *Logger) Fatalw(msg string, kv ...interface{}) {
-	l.logger.Fatalw(msg, kv...)
+	l.logger.Fatalw(msg, l.redact.Fields(kv)...)
 }
 
 // Sync flushes any buffered log entries.
 func (l *Logger) Sync() {
 	l.logger.Sync()
 }
+
+// Close flushes any buffered log entries and closes the log files of the Logger and its children.
+func (l *Logger) Close() error {
+	l.Sync()
+	if l.sinks == nil {
+		return nil
+	}
+	return l.sinks.Close()
+}
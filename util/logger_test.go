@@ -0,0 +1,95 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// helperObservedLogger returns a Logger recording all messages.
+func helperObservedLogger() (*Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return &Logger{logger: zap.New(core).Sugar()}, logs
+}
+
+func TestRequestIDLogger(t *testing.T) {
+	for _, backend := range []string{TracingJaeger, TracingOTLP} {
+		t.Run(backend, func(t *testing.T) {
+			tracer, closer := helperNewTracer(backend, nil, t)
+			defer closer.Close()
+
+			l, logs := helperObservedLogger()
+			span := tracer.StartSpan("request")
+			defer span.Finish()
+			ctx := context.WithValue(ot.ContextWithSpan(context.Background(), span), requestIDKey, "abc")
+
+			RequestIDLoggerFromContext(ctx, l).Infow("request completed")
+
+			traceID, spanID, ok := SpanIDs(span)
+			if !ok || len(traceID) != 32 || len(spanID) != 16 {
+				t.Fatalf("SpanIDs returned %#v, %#v, %v", traceID, spanID, ok)
+			}
+			fields := logs.All()[0].ContextMap()
+			for k, want := range map[string]string{"requestID": "abc", "traceID": traceID, "spanID": spanID} {
+				if fields[k] != want {
+					t.Errorf("log field %s = %#v, want %#v", k, fields[k], want)
+				}
+			}
+		})
+	}
+
+	t.Run("Without span", func(t *testing.T) {
+		l, logs := helperObservedLogger()
+		RequestIDLoggerFromContext(context.Background(), l).Errorw("failed")
+
+		fields := logs.All()[0].ContextMap()
+		if _, ok := fields["traceID"]; ok {
+			t.Errorf("untraced log has traceID %#v", fields["traceID"])
+		}
+		if _, _, ok := SpanIDs(nil); ok {
+			t.Errorf("SpanIDs returned ok for nil span")
+		}
+	})
+
+	t.Run("Error span events", func(t *testing.T) {
+		l, _ := NewLogger("error", "test")
+		exporter := tracetest.NewInMemoryExporter()
+		tracer, closer, err := InitOTelTracer("test", TracerConfig{}, l, sdktrace.NewSimpleSpanProcessor(exporter))
+		if err != nil {
+			t.Fatalf("unable to create tracer: %s", err)
+		}
+		defer closer.Close()
+
+		ol, _ := helperObservedLogger()
+		redact, _ := NewRedactionPolicy(RedactionPolicy{MaskFields: []string{"password"}})
+		ol.SetRedactionPolicy(redact)
+		span := tracer.StartSpan("request")
+		log := RequestIDLoggerFromContext(ot.ContextWithSpan(context.Background(), span), ol)
+		log.Infow("request received")
+		log.Errorw("unable to get item",
+			"id", "1",
+			"password", "hunter2",
+		)
+		span.Finish()
+
+		events := exporter.GetSpans()[0].Events
+		if len(events) != 1 {
+			t.Fatalf("span has %d events, want 1 for the error message", len(events))
+		}
+		attrs := make(map[string]string)
+		for _, a := range events[0].Attributes {
+			attrs[string(a.Key)] = a.Value.Emit()
+		}
+		for k, want := range map[string]string{"message": "unable to get item", "id": "1", "password": "[REDACTED]"} {
+			if attrs[k] != want {
+				t.Errorf("span event attribute %s = %#v, want %#v", k, attrs[k], want)
+			}
+		}
+	})
+}
@@ -0,0 +1,271 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultLogLevel is used if no or an invalid level is passed to NewLogger.
+const DefaultLogLevel = "info"
+
+// LogLevelConfig is the JSON representation of LogLevels, as served by LogLevelHandler and read by
+// LoadLogLevelConfig.
+type LogLevelConfig struct {
+	// Level is one of debug, info, warn or error.
+	Level string `json:"level,omitempty"`
+
+	// Overrides maps route names, e.g. createOrder, to the level used while serving them. For
+	// gRPC calls, the route is the full method name.
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// LoadLogLevelConfig reads a LogLevelConfig from a JSON file.
+func LoadLogLevelConfig(file string) (LogLevelConfig, error) {
+	var cfg LogLevelConfig
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cfg, errors.Wrapf(err, "unable to read log level config %s", file)
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, errors.Wrapf(err, "unable to parse log level config %s", file)
+	}
+	return cfg, nil
+}
+
+// parseLogLevel returns the zap level of debug, info, warn or error.
+func parseLogLevel(level string) (zapcore.Level, error) {
+	switch level {
+	case "debug":
+		return zap.DebugLevel, nil
+	case "info":
+		return zap.InfoLevel, nil
+	case "warn":
+		return zap.WarnLevel, nil
+	case "error":
+		return zap.ErrorLevel, nil
+	default:
+		return zap.InfoLevel, errors.Errorf("invalid log level %#v, must be one of debug, info, warn or error", level)
+	}
+}
+
+// LogLevels holds the level of a Logger and all of its children, which can be changed at runtime,
+// as well as per-route overrides applied to request loggers.
+type LogLevels struct {
+	level zap.AtomicLevel
+
+	mu        sync.RWMutex
+	overrides map[string]zapcore.Level
+	// version counts replacements of overrides, invalidating cached route loggers
+	version uint64
+}
+
+func newLogLevels(level zap.AtomicLevel) *LogLevels {
+	return &LogLevels{
+		level:     level,
+		overrides: make(map[string]zapcore.Level),
+	}
+}
+
+// Level returns the current level.
+func (l *LogLevels) Level() string {
+	return l.level.Level().String()
+}
+
+// SetLevel changes the level of all loggers sharing l.
+func (l *LogLevels) SetLevel(level string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	l.level.SetLevel(lvl)
+	return nil
+}
+
+// Config returns the current level and overrides.
+func (l *LogLevels) Config() LogLevelConfig {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	cfg := LogLevelConfig{
+		Level:     l.Level(),
+		Overrides: make(map[string]string),
+	}
+	for route, lvl := range l.overrides {
+		cfg.Overrides[route] = lvl.String()
+	}
+	return cfg
+}
+
+// Apply sets the level of cfg, if any, and replaces all overrides if cfg.Overrides is not nil.
+// Nothing is changed if cfg is invalid.
+func (l *LogLevels) Apply(cfg LogLevelConfig) error {
+	lvl := l.level.Level()
+	if cfg.Level != "" {
+		var err error
+		if lvl, err = parseLogLevel(cfg.Level); err != nil {
+			return err
+		}
+	}
+
+	var overrides map[string]zapcore.Level
+	if cfg.Overrides != nil {
+		overrides = make(map[string]zapcore.Level)
+		for route, level := range cfg.Overrides {
+			if route == "" {
+				return errors.New("log level override without route name")
+			}
+			o, err := parseLogLevel(level)
+			if err != nil {
+				return errors.Wrapf(err, "invalid override for route %s", route)
+			}
+			overrides[route] = o
+		}
+	}
+
+	l.level.SetLevel(lvl)
+	if overrides != nil {
+		l.mu.Lock()
+		l.overrides = overrides
+		l.version++
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// ReloadOnSignal applies the config of file whenever one of sigs is received. If file is empty,
+// the levels are reset to the ones at the time of the call instead. Calling the returned function
+// stops listening for signals.
+func (l *LogLevels) ReloadOnSignal(file string, logger *Logger, sigs ...os.Signal) func() {
+	defaults := l.Config()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-c:
+			}
+
+			cfg := defaults
+			if file != "" {
+				var err error
+				if cfg, err = LoadLogLevelConfig(file); err != nil {
+					logger.Errorw("unable to reload log levels",
+						"error", err,
+					)
+					continue
+				}
+			}
+			if err := l.Apply(cfg); err != nil {
+				logger.Errorw("unable to reload log levels",
+					"error", err,
+				)
+				continue
+			}
+			logger.Infow("log levels reloaded",
+				"levels", l.Config(),
+			)
+		}
+	}()
+
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// route returns the LevelEnabler of loggers serving route.
+func (l *LogLevels) route(route string) zapcore.LevelEnabler {
+	return routeLevel{levels: l, route: route}
+}
+
+// override returns the level overridden for route, if any, and the version of the overrides.
+func (l *LogLevels) override(route string) (zapcore.Level, uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	lvl, ok := l.overrides[route]
+	return lvl, l.version, ok
+}
+
+// routeLevel enables the levels of an override for its route, falling back to the shared level.
+type routeLevel struct {
+	levels *LogLevels
+	route  string
+}
+
+// Enabled implements zapcore.LevelEnabler.
+func (r routeLevel) Enabled(lvl zapcore.Level) bool {
+	if r.route != "" {
+		r.levels.mu.RLock()
+		o, ok := r.levels.overrides[r.route]
+		r.levels.mu.RUnlock()
+		if ok {
+			return o.Enabled(lvl)
+		}
+	}
+	return r.levels.level.Enabled(lvl)
+}
+
+// levelCore decides which entries are written by a zapcore.Core which itself accepts all levels.
+type levelCore struct {
+	zapcore.Core
+	enabler zapcore.LevelEnabler
+}
+
+// Enabled implements zapcore.Core.
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.enabler.Enabled(lvl)
+}
+
+// With implements zapcore.Core.
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), enabler: c.enabler}
+}
+
+// Check implements zapcore.Core. Enabled entries are checked by the wrapped core, so samplers
+// still apply.
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return c.Core.Check(e, ce)
+	}
+	return ce
+}
+
+// LogLevelHandler serves the levels of logger on GET and changes them on PUT, taking a
+// LogLevelConfig. Overrides passed on PUT replace all existing ones.
+func LogLevelHandler(logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		levels := logger.Levels()
+
+		if r.Method == http.MethodPut {
+			var cfg LogLevelConfig
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&cfg); err != nil {
+				NewProblem(http.StatusBadRequest, "Invalid JSON: "+err.Error(), nil).SendJSON(w)
+				return
+			}
+			if err := levels.Apply(cfg); err != nil {
+				NewProblem(http.StatusUnprocessableEntity, err.Error(), nil).SendJSON(w)
+				return
+			}
+			RequestIDLogger(logger, r).Infow("log levels changed",
+				"levels", levels.Config(),
+			)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(levels.Config())
+	}
+}
@@ -0,0 +1,195 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// helperLeveledLogger returns a Logger at level recording all messages it lets pass.
+func helperLeveledLogger(level string, t *testing.T) (*Logger, *observer.ObservedLogs) {
+	atom := zap.NewAtomicLevel()
+	levels := newLogLevels(atom)
+	if err := levels.SetLevel(level); err != nil {
+		t.Fatalf("unable to set level: %s", err)
+	}
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := zap.New(&levelCore{Core: core, enabler: levels.route("")})
+	return &Logger{logger: l.Sugar(), levels: levels, routes: &routeLoggers{}}, logs
+}
+
+func TestLogLevels(t *testing.T) {
+	l, logs := helperLeveledLogger("info", t)
+	levels := l.Levels()
+
+	l.Debugw("hidden")
+	if err := levels.SetLevel("debug"); err != nil {
+		t.Fatalf("unable to set level: %s", err)
+	}
+	l.Debugw("shown")
+	if logs.Len() != 1 || logs.All()[0].Message != "shown" {
+		t.Errorf("logged %v after changing the level, want only the message after the change", logs.All())
+	}
+
+	var invalid = []LogLevelConfig{
+		{Level: "verbose"},
+		{Overrides: map[string]string{"createOrder": "trace"}},
+		{Overrides: map[string]string{"": "debug"}},
+	}
+	for _, cfg := range invalid {
+		if err := levels.Apply(cfg); err == nil {
+			t.Errorf("expected error when applying %+v", cfg)
+		}
+	}
+	if levels.Level() != "debug" {
+		t.Errorf("invalid config changed level to %s", levels.Level())
+	}
+
+	t.Run("Route overrides", func(t *testing.T) {
+		l, logs := helperLeveledLogger("warn", t)
+		if err := l.Levels().Apply(LogLevelConfig{Overrides: map[string]string{"createOrder": "debug"}}); err != nil {
+			t.Fatalf("unable to apply overrides: %s", err)
+		}
+
+		for _, route := range []string{"createOrder", "getOrder"} {
+			h := LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				RequestIDLogger(l, r).Debugw("handling " + route)
+			}), Route{Name: route}, l)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+
+		var msgs []string
+		for _, e := range logs.All() {
+			msgs = append(msgs, e.Message)
+		}
+		want := []string{"request received", "handling createOrder", "request completed"}
+		if !reflect.DeepEqual(msgs, want) {
+			t.Errorf("logged %v, want %v", msgs, want)
+		}
+
+		// Overrides may raise the level as well
+		l.Levels().Apply(LogLevelConfig{Level: "debug", Overrides: map[string]string{"getOrder": "error"}})
+		ctx := context.WithValue(context.Background(), routeKey, "getOrder")
+		RequestIDLoggerFromContext(ctx, l).Warnw("hidden")
+		if logs.Len() != len(want) {
+			t.Errorf("override to error level let a warning pass")
+		}
+	})
+
+	t.Run("Route loggers are cached", func(t *testing.T) {
+		l, logs := helperLeveledLogger("info", t)
+		l.Levels().Apply(LogLevelConfig{Overrides: map[string]string{"createOrder": "debug"}})
+
+		first := l.routeLogger("createOrder")
+		if l.routeLogger("createOrder") != first {
+			t.Errorf("logger of route was rebuilt without changed overrides")
+		}
+		if l.routeLogger("getOrder") != l.logger {
+			t.Errorf("logger was built for route without override")
+		}
+
+		l.Levels().Apply(LogLevelConfig{Overrides: map[string]string{"createOrder": "error"}})
+		if l.routeLogger("createOrder") == first {
+			t.Errorf("logger of route wasn't rebuilt after overrides changed")
+		}
+		ctx := context.WithValue(context.Background(), routeKey, "createOrder")
+		RequestIDLoggerFromContext(ctx, l).Warnw("hidden")
+		if logs.Len() != 0 {
+			t.Errorf("cached logger ignored the changed override, logged %v", logs.All())
+		}
+	})
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	l, _ := helperLeveledLogger("info", t)
+	h := LogLevelHandler(l)
+
+	var tests = []struct {
+		method string
+		body   string
+		status int
+		want   string
+	}{
+		{"GET", "", http.StatusOK, `{"level":"info"}`},
+		{"PUT", `{"level":"debug","overrides":{"createOrder":"warn"}}`, http.StatusOK, `{"level":"debug","overrides":{"createOrder":"warn"}}`},
+		{"PUT", `{"overrides":{}}`, http.StatusOK, `{"level":"debug"}`},
+		{"PUT", `{"level":"verbose"}`, http.StatusUnprocessableEntity, ""},
+		{"PUT", `{"lvl":"debug"}`, http.StatusBadRequest, ""},
+		{"PUT", `{`, http.StatusBadRequest, ""},
+		{"GET", "", http.StatusOK, `{"level":"debug"}`},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/admin/loglevel", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s returned status %d, want %d", tt.method, tt.body, rec.Code, tt.status)
+		}
+		if tt.want != "" && strings.TrimSpace(rec.Body.String()) != tt.want {
+			t.Errorf("%s %s returned %s, want %s", tt.method, tt.body, rec.Body.String(), tt.want)
+		}
+		if tt.status >= 400 && rec.Header().Get("Content-Type") != ProblemContentType {
+			t.Errorf("%s %s returned Content-Type %s, want %s", tt.method, tt.body, rec.Header().Get("Content-Type"), ProblemContentType)
+		}
+	}
+}
+
+func TestReloadLogLevels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loglevel")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "loglevel.json")
+
+	l, _ := helperLeveledLogger("info", t)
+	levels := l.Levels()
+
+	// helperReload sends a SIGHUP and waits for levels to reach want
+	helperReload := func(want string) {
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		deadline := time.Now().Add(time.Second)
+		for levels.Level() != want && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if levels.Level() != want {
+			t.Errorf("level after SIGHUP is %s, want %s", levels.Level(), want)
+		}
+	}
+
+	t.Run("Without file", func(t *testing.T) {
+		stop := levels.ReloadOnSignal("", l, syscall.SIGHUP)
+		defer stop()
+
+		levels.SetLevel("debug")
+		helperReload("info")
+	})
+
+	t.Run("With file", func(t *testing.T) {
+		stop := levels.ReloadOnSignal(file, l, syscall.SIGHUP)
+		defer stop()
+
+		ioutil.WriteFile(file, []byte(`{"level":"error","overrides":{"getItem":"debug"}}`), 0644)
+		helperReload("error")
+		if got := levels.Config().Overrides["getItem"]; got != "debug" {
+			t.Errorf("override of getItem after SIGHUP is %#v, want debug", got)
+		}
+	})
+
+	if _, err := LoadLogLevelConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected error when loading missing file")
+	}
+}
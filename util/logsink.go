@@ -0,0 +1,197 @@
+package util
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Log encodings which can be set in LogConfig.
+const (
+	// LogEncodingJSON writes one JSON object per line, suited for log shippers.
+	LogEncodingJSON = "json"
+	// LogEncodingConsole writes human readable lines for local development.
+	LogEncodingConsole = "console"
+)
+
+// Special log outputs, every other output is treated as file path.
+const (
+	LogOutputStdout = "stdout"
+	LogOutputStderr = "stderr"
+)
+
+// LogConfig selects where and how a Logger writes its entries.
+type LogConfig struct {
+	// Level is one of debug, info, warn or error, defaults to DefaultLogLevel.
+	Level string
+
+	// Encoding is either json or console.
+	Encoding string
+
+	// Outputs receive all log entries. Each is either stdout, stderr or the path of a file,
+	// which is rotated according to Rotation.
+	Outputs []string
+
+	// ErrorOutputs receive errors of the Logger itself, e.g. failed writes.
+	ErrorOutputs []string
+
+	// Rotation applies to all file outputs.
+	Rotation LogRotation
+
+	// Sampling limits repeated entries with the same level and message.
+	Sampling LogSampling
+}
+
+// LogRotation configures the rotation of log files.
+type LogRotation struct {
+	// MaxSize is the size in megabytes after which a file is rotated.
+	MaxSize int
+
+	// Interval rotates files periodically if greater than 0, regardless of their size.
+	Interval time.Duration
+
+	// MaxAge removes rotated files older than this if greater than 0. It is rounded up to full days.
+	MaxAge time.Duration
+
+	// MaxBackups is the number of rotated files kept if greater than 0.
+	MaxBackups int
+
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// LogSampling configures zap's sampling: every second, the first Initial entries with the same
+// level and message are logged, and every Thereafter-th entry after that. Sampling is disabled if
+// Initial is 0.
+type LogSampling struct {
+	Initial    int
+	Thereafter int
+}
+
+// DefaultLogConfig writes JSON to stdout and errors of the Logger to stderr. Lines logged more than
+// 100 times per second, e.g. request completed under load, are sampled.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		Level:        DefaultLogLevel,
+		Encoding:     LogEncodingJSON,
+		Outputs:      []string{LogOutputStdout},
+		ErrorOutputs: []string{LogOutputStderr},
+		Rotation: LogRotation{
+			MaxSize: 100,
+		},
+		Sampling: LogSampling{
+			Initial:    100,
+			Thereafter: 100,
+		},
+	}
+}
+
+// CheckLogConfig returns an error if the encoding, outputs, rotation or sampling of cfg are invalid.
+func CheckLogConfig(cfg LogConfig) error {
+	switch cfg.Encoding {
+	case LogEncodingJSON, LogEncodingConsole:
+	default:
+		return errors.Errorf("invalid log encoding %#v, must be one of %s or %s", cfg.Encoding, LogEncodingJSON, LogEncodingConsole)
+	}
+
+	if len(cfg.Outputs) == 0 {
+		return errors.New("no log output set")
+	}
+
+	switch {
+	case cfg.Rotation.MaxSize <= 0:
+		return errors.Errorf("max size of log files must be positive, got %d", cfg.Rotation.MaxSize)
+	case cfg.Rotation.Interval < 0, cfg.Rotation.MaxAge < 0, cfg.Rotation.MaxBackups < 0:
+		return errors.New("rotation interval, max age and max backups of log files must not be negative")
+	case cfg.Sampling.Initial < 0, cfg.Sampling.Thereafter < 0:
+		return errors.New("log sampling must not be negative")
+	case cfg.Sampling.Initial > 0 && cfg.Sampling.Thereafter == 0:
+		return errors.New("log sampling needs to log every n-th entry after the initial ones")
+	}
+	return nil
+}
+
+// newLogEncoder returns the encoder of a LogConfig encoding.
+func newLogEncoder(encoding string) zapcore.Encoder {
+	if encoding == LogEncodingConsole {
+		ec := zap.NewDevelopmentEncoderConfig()
+		ec.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		return zapcore.NewConsoleEncoder(ec)
+	}
+	return zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+}
+
+// logSinks are the opened outputs of a Logger.
+type logSinks struct {
+	files []*lumberjack.Logger
+	done  chan struct{}
+	once  sync.Once
+}
+
+// open returns a WriteSyncer writing to all outputs. Files are rotated according to rotation.
+func (s *logSinks) open(outputs []string, rotation LogRotation) zapcore.WriteSyncer {
+	var ws []zapcore.WriteSyncer
+	for _, o := range outputs {
+		switch o {
+		case LogOutputStdout:
+			ws = append(ws, zapcore.Lock(os.Stdout))
+		case LogOutputStderr:
+			ws = append(ws, zapcore.Lock(os.Stderr))
+		default:
+			f := &lumberjack.Logger{
+				Filename:   o,
+				MaxSize:    rotation.MaxSize,
+				MaxAge:     int((rotation.MaxAge + 24*time.Hour - 1) / (24 * time.Hour)),
+				MaxBackups: rotation.MaxBackups,
+				Compress:   rotation.Compress,
+			}
+			s.files = append(s.files, f)
+			ws = append(ws, zapcore.AddSync(f))
+		}
+	}
+	return zapcore.NewMultiWriteSyncer(ws...)
+}
+
+// rotateEvery rotates all files periodically until the sinks are closed.
+func (s *logSinks) rotateEvery(interval time.Duration) {
+	if interval <= 0 || len(s.files) == 0 {
+		return
+	}
+
+	s.done = make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-t.C:
+				for _, f := range s.files {
+					f.Rotate()
+				}
+			}
+		}
+	}()
+}
+
+// Close stops rotation and closes all files.
+func (s *logSinks) Close() error {
+	var err error
+	s.once.Do(func() {
+		if s.done != nil {
+			close(s.done)
+		}
+		for _, f := range s.files {
+			if e := f.Close(); e != nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
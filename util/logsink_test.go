@@ -0,0 +1,156 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperLogFile creates a Logger writing to a file in a temporary directory according to cfg and
+// returns it with the path of the file. The directory is removed by the returned function.
+func helperLogFile(cfg LogConfig, t *testing.T) (*Logger, string, func()) {
+	dir, err := ioutil.TempDir("", "logsink")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	file := filepath.Join(dir, "test.log")
+	cfg.Outputs = []string{file}
+
+	l, err := NewLoggerWithConfig("test", cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to create logger: %s", err)
+	}
+	return l, file, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// helperReadLines returns the non-empty lines of a file.
+func helperReadLines(file string, t *testing.T) []string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", file, err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestLogConfig(t *testing.T) {
+	valid := DefaultLogConfig()
+	if err := CheckLogConfig(valid); err != nil {
+		t.Errorf("default config is invalid: %s", err)
+	}
+
+	var tests = []func(*LogConfig){
+		func(c *LogConfig) { c.Encoding = "logfmt" },
+		func(c *LogConfig) { c.Outputs = nil },
+		func(c *LogConfig) { c.Rotation.MaxSize = 0 },
+		func(c *LogConfig) { c.Rotation.Interval = -time.Hour },
+		func(c *LogConfig) { c.Rotation.MaxBackups = -1 },
+		func(c *LogConfig) { c.Sampling.Initial = -1 },
+		func(c *LogConfig) { c.Sampling.Thereafter = 0 },
+	}
+	for i, modify := range tests {
+		cfg := DefaultLogConfig()
+		modify(&cfg)
+		if err := CheckLogConfig(cfg); err == nil {
+			t.Errorf("expected error for invalid config %d: %+v", i, cfg)
+		}
+		if _, err := NewLoggerWithConfig("test", cfg); err == nil {
+			t.Errorf("expected error when creating logger with invalid config %d", i)
+		}
+	}
+}
+
+func TestLogSinks(t *testing.T) {
+	t.Run("JSON file", func(t *testing.T) {
+		l, file, cleanup := helperLogFile(DefaultLogConfig(), t)
+		defer cleanup()
+
+		l.Infow("request completed", "status", 200)
+		l.Debugw("hidden")
+		l.Close()
+
+		lines := helperReadLines(file, t)
+		if len(lines) != 1 {
+			t.Fatalf("logged %d lines, want 1: %v", len(lines), lines)
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+			t.Fatalf("log line isn't JSON: %s", err)
+		}
+		if entry["service"] != "test" || entry["msg"] != "request completed" || entry["status"] != float64(200) {
+			t.Errorf("logged %v", entry)
+		}
+	})
+
+	t.Run("Console encoding", func(t *testing.T) {
+		cfg := DefaultLogConfig()
+		cfg.Encoding = LogEncodingConsole
+		l, file, cleanup := helperLogFile(cfg, t)
+		defer cleanup()
+
+		l.Warnw("disk almost full", "free", "1%")
+		l.Close()
+
+		lines := helperReadLines(file, t)
+		if len(lines) != 1 || !strings.Contains(lines[0], "WARN") || !strings.Contains(lines[0], "disk almost full") {
+			t.Errorf("logged %v, want a single console line", lines)
+		}
+		if strings.HasPrefix(lines[0], "{") {
+			t.Errorf("console line is JSON: %s", lines[0])
+		}
+	})
+
+	t.Run("Sampling", func(t *testing.T) {
+		cfg := DefaultLogConfig()
+		cfg.Sampling = LogSampling{Initial: 3, Thereafter: 100}
+		l, file, cleanup := helperLogFile(cfg, t)
+		defer cleanup()
+
+		for i := 0; i < 50; i++ {
+			l.Infow("request completed")
+		}
+		l.Infow("item created")
+		l.Close()
+
+		lines := helperReadLines(file, t)
+		if len(lines) != 4 {
+			t.Errorf("logged %d lines, want 3 sampled and 1 other", len(lines))
+		}
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		cfg := DefaultLogConfig()
+		cfg.Rotation.Interval = 20 * time.Millisecond
+		l, file, cleanup := helperLogFile(cfg, t)
+		defer cleanup()
+
+		l.Infow("before rotation")
+		deadline := time.Now().Add(time.Second)
+		var files []os.FileInfo
+		for len(files) < 2 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			files, _ = ioutil.ReadDir(filepath.Dir(file))
+		}
+		if len(files) < 2 {
+			t.Fatalf("log file wasn't rotated, found %d files", len(files))
+		}
+
+		l.Close()
+		if err := l.Close(); err != nil {
+			t.Errorf("closing logger twice returned error: %s", err)
+		}
+	})
+}
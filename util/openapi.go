@@ -0,0 +1,444 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const openAPIVersion = "3.0.3"
+
+// pathParam matches gorilla/mux path variables like {id} or {id:[0-9]+}.
+var pathParam = regexp.MustCompile(`\{([^:}]+)(?::([^}]+))?\}`)
+
+// OpenAPI is an OpenAPI 3 document describing the routes of a service.
+type OpenAPI struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       OpenAPIInfo          `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components,omitempty"`
+}
+
+// OpenAPIInfo holds the metadata of an OpenAPI document.
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Components holds the security schemes referred to by operations.
+type Components struct {
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes a way of authenticating requests.
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// SecurityRequirement names the security schemes satisfying an operation.
+type SecurityRequirement map[string][]string
+
+// PathItem describes the operations available on a single path, indexed by lowercase HTTP method.
+type PathItem map[string]*Operation
+
+// Operation describes a single API operation on a path.
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+}
+
+// Parameter describes a single operation parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes a single response of an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a request or response body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of the OpenAPI schema object needed to describe the API types.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+}
+
+// NewOpenAPI generates an OpenAPI document from the schema metadata of the passed routes. Routes
+// that aren't public require a bearer token or API key and may respond with a 401 problem, those
+// requiring roles or scopes with a 403 problem as well. Routes that aren't unlimited may respond
+// with a 429 or 503 problem when rate limited or shedding load.
+func NewOpenAPI(title, version string, routes Routes) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:   title,
+			Version: version,
+		},
+		Paths: make(map[string]*PathItem),
+	}
+
+	for _, route := range routes {
+		path, params := openAPIPath(route.Pattern)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+
+		for _, q := range route.Query {
+			q.In = "query"
+			params = append(params, &q)
+		}
+
+		op := &Operation{
+			OperationID: route.Name,
+			Summary:     route.Summary,
+			Parameters:  params,
+			Responses:   make(map[string]*Response),
+		}
+
+		if route.Request != nil {
+			s := SchemaOf(route.Request)
+			s.stripRequired()
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{"application/json": {Schema: s}},
+			}
+		}
+
+		for status, body := range route.Responses {
+			op.Responses[strconv.Itoa(status)] = newResponse(status, body)
+		}
+
+		if !route.Unlimited {
+			op.Responses[strconv.Itoa(http.StatusTooManyRequests)] = newResponse(http.StatusTooManyRequests, Problem{})
+			op.Responses[strconv.Itoa(http.StatusServiceUnavailable)] = newResponse(http.StatusServiceUnavailable, Problem{})
+		}
+
+		if !route.Public {
+			op.Responses[strconv.Itoa(http.StatusUnauthorized)] = newResponse(http.StatusUnauthorized, Problem{})
+			if len(route.Roles) > 0 || len(route.Scopes) > 0 {
+				op.Responses[strconv.Itoa(http.StatusForbidden)] = newResponse(http.StatusForbidden, Problem{})
+			}
+			op.Security = []SecurityRequirement{{"bearerAuth": {}}, {"apiKeyAuth": {}}}
+			doc.Components = &Components{
+				SecuritySchemes: map[string]*SecurityScheme{
+					"bearerAuth": {Type: "http", Scheme: "bearer"},
+					"apiKeyAuth": {Type: "apiKey", In: "header", Name: apiKeyHeader},
+				},
+			}
+		}
+
+		(*item)[strings.ToLower(route.Method)] = op
+	}
+
+	return doc
+}
+
+// openAPIPath converts a gorilla/mux pattern into an OpenAPI path and its path parameters.
+func openAPIPath(pattern string) (string, []*Parameter) {
+	var params []*Parameter
+	for _, m := range pathParam.FindAllStringSubmatch(pattern, -1) {
+		s := &Schema{Type: "string"}
+		if m[2] != "" {
+			s.Pattern = fmt.Sprintf("^%s$", m[2])
+		}
+		params = append(params, &Parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   s,
+		})
+	}
+
+	return pathParam.ReplaceAllString(pattern, "{$1}"), params
+}
+
+func newResponse(status int, body interface{}) *Response {
+	res := &Response{Description: http.StatusText(status)}
+	switch body.(type) {
+	case nil:
+	case string:
+		res.Content = map[string]*MediaType{"text/plain": {Schema: &Schema{Type: "string"}}}
+	case Problem:
+		res.Content = map[string]*MediaType{ProblemContentType: {Schema: SchemaOf(body)}}
+	default:
+		res.Content = map[string]*MediaType{"application/json": {Schema: SchemaOf(body)}}
+	}
+	return res
+}
+
+// timeType is encoded as RFC 3339 string instead of its struct fields.
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf derives a Schema from the type of the passed value, honouring json struct tags.
+func SchemaOf(v interface{}) *Schema {
+	return schemaOf(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+// schemaOf recursively builds the Schema of t. Recursive types are described as free-form values
+// once they are encountered a second time.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t == nil || seen[t] {
+		return &Schema{}
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaOf(t.Elem(), seen)
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), seen), Nullable: true}
+	case reflect.Struct:
+		seen[t] = true
+		defer delete(seen, t)
+
+		s := &Schema{
+			Type:                 "object",
+			Properties:           make(map[string]*Schema),
+			AdditionalProperties: false,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name, omitempty := jsonFieldName(f)
+			if name == "-" {
+				continue
+			}
+
+			s.Properties[name] = schemaOf(f.Type, seen)
+			if !omitempty {
+				s.Required = append(s.Required, name)
+			}
+		}
+		sort.Strings(s.Required)
+		return s
+	default:
+		return &Schema{}
+	}
+}
+
+// jsonFieldName returns the JSON name of a struct field and whether it's omitted when empty.
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	tag := strings.Split(f.Tag.Get("json"), ",")
+	name := tag[0]
+	if name == "" {
+		name = f.Name
+	}
+
+	for _, opt := range tag[1:] {
+		if opt == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// stripRequired removes all required constraints, since request bodies may omit fields that are
+// always present in responses.
+func (s *Schema) stripRequired() {
+	if s == nil {
+		return
+	}
+
+	s.Required = nil
+	s.Items.stripRequired()
+	for _, p := range s.Properties {
+		p.stripRequired()
+	}
+}
+
+// Validate checks whether a decoded JSON value conforms to the Schema.
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate("$", v)
+}
+
+func (s *Schema) validate(path string, v interface{}) error {
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return errors.Errorf("%s: unexpected null", path)
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return errors.Errorf("%s: expected boolean, got %T", path, v)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return errors.Errorf("%s: expected string, got %T", path, v)
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return errors.Errorf("%s: expected %s, got %T", path, s.Type, v)
+		}
+		if _, err := n.Int64(); s.Type == "integer" && err != nil {
+			return errors.Errorf("%s: expected integer, got %s", path, n)
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return errors.Errorf("%s: expected array, got %T", path, v)
+		}
+		for i, e := range a {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e); err != nil {
+				return err
+			}
+		}
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("%s: expected object, got %T", path, v)
+		}
+
+		for _, r := range s.Required {
+			if _, ok := o[r]; !ok {
+				return errors.Errorf("%s: missing required property %s", path, r)
+			}
+		}
+
+		for k, e := range o {
+			p := fmt.Sprintf("%s.%s", path, k)
+			if ps, ok := s.Properties[k]; ok {
+				if err := ps.validate(p, e); err != nil {
+					return err
+				}
+				continue
+			}
+
+			switch ap := s.AdditionalProperties.(type) {
+			case bool:
+				if !ap {
+					return errors.Errorf("%s: unexpected property", p)
+				}
+			case *Schema:
+				if err := ap.validate(p, e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Operation returns the Operation with the passed operationId.
+func (o *OpenAPI) Operation(operationID string) (*Operation, bool) {
+	for _, item := range o.Paths {
+		for _, op := range *item {
+			if op.OperationID == operationID {
+				return op, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ValidateResponse checks whether a response sent by an operation is described by the document.
+func (o *OpenAPI) ValidateResponse(operationID string, status int, contentType string, body []byte) error {
+	op, ok := o.Operation(operationID)
+	if !ok {
+		return errors.Errorf("operation %s not documented", operationID)
+	}
+
+	res, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return errors.Errorf("status %d of operation %s not documented", status, operationID)
+	}
+
+	if len(res.Content) == 0 {
+		if len(bytes.TrimSpace(body)) != 0 {
+			return errors.Errorf("operation %s responded %d with undocumented body", operationID, status)
+		}
+		return nil
+	}
+
+	mt := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	media, ok := res.Content[mt]
+	if !ok {
+		return errors.Errorf("content type %s of operation %s with status %d not documented", contentType, operationID, status)
+	}
+
+	if mt != "application/json" && mt != ProblemContentType {
+		return nil
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return errors.Wrapf(err, "unable to decode response of operation %s", operationID)
+	}
+
+	return errors.Wrapf(media.Schema.Validate(v), "response of operation %s with status %d", operationID, status)
+}
+
+// OpenAPIHandler serves the document returned by the passed function as JSON.
+func OpenAPIHandler(doc func() *OpenAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc())
+	}
+}
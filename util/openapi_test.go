@@ -0,0 +1,109 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type openAPITestItem struct {
+	ID   string   `json:"id"`
+	Qty  int      `json:"qty"`
+	Tags []string `json:"tags,omitempty"`
+	Skip string   `json:"-"`
+}
+
+type openAPITestResponse struct {
+	Status int                `json:"status"`
+	Data   []*openAPITestItem `json:"data"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	routes := Routes{
+		Route{
+			Name:      "getItem",
+			Method:    "GET",
+			Pattern:   "/items/{id:[a-z]+}",
+			Responses: map[int]interface{}{http.StatusOK: openAPITestResponse{}, http.StatusNoContent: nil},
+		},
+		Route{
+			Name:      "setItems",
+			Method:    "POST",
+			Pattern:   "/items",
+			Request:   []*openAPITestItem{},
+			Query:     []Parameter{{Name: "atomic", Schema: &Schema{Type: "boolean"}}},
+			Responses: map[int]interface{}{http.StatusOK: "", http.StatusCreated: openAPITestResponse{}},
+		},
+	}
+	doc := NewOpenAPI("test", "1.0.0", routes)
+
+	t.Run("Generate paths", func(t *testing.T) {
+		op, ok := doc.Operation("getItem")
+		if !ok {
+			t.Fatalf("operation getItem not found")
+		}
+		if _, ok := (*doc.Paths["/items/{id}"])["get"]; !ok {
+			t.Errorf("path /items/{id} not found in %v", doc.Paths)
+		}
+		if len(op.Parameters) != 1 || op.Parameters[0].Name != "id" || op.Parameters[0].Schema.Pattern != "^[a-z]+$" {
+			t.Errorf("unexpected parameters %+v", op.Parameters)
+		}
+
+		op, _ = doc.Operation("setItems")
+		if len(op.Parameters) != 1 || op.Parameters[0].Name != "atomic" || op.Parameters[0].In != "query" || op.Parameters[0].Required {
+			t.Errorf("unexpected query parameters %+v", op.Parameters)
+		}
+	})
+
+	t.Run("Generate schemas", func(t *testing.T) {
+		op, _ := doc.Operation("getItem")
+		s := op.Responses["200"].Content["application/json"].Schema.Properties["data"].Items
+		if _, ok := s.Properties["-"]; ok {
+			t.Errorf("ignored field present in schema")
+		}
+		if len(s.Required) != 2 || s.Required[0] != "id" || s.Required[1] != "qty" {
+			t.Errorf("required properties are %v, want %v", s.Required, []string{"id", "qty"})
+		}
+
+		if s := SchemaOf(time.Time{}); s.Type != "string" || s.Format != "date-time" {
+			t.Errorf("schema of time.Time is %+v, want date-time string", s)
+		}
+
+		op, _ = doc.Operation("setItems")
+		if r := op.RequestBody.Content["application/json"].Schema.Items.Required; r != nil {
+			t.Errorf("request schema shouldn't require properties, got %v", r)
+		}
+	})
+
+	t.Run("Validate responses", func(t *testing.T) {
+		var tests = []struct {
+			op     string
+			status int
+			ct     string
+			body   string
+			valid  bool
+		}{
+			{"getItem", http.StatusOK, "application/json", `{"status": 200, "data": null}`, true},
+			{"getItem", http.StatusOK, "application/JSON; charset=UTF-8", `{"status": 200, "data": [{"id": "a", "qty": 1, "tags": ["b"]}]}`, true},
+			{"getItem", http.StatusNoContent, "", ``, true},
+			{"setItems", http.StatusOK, "text/plain; charset=utf-8", "OK\n", true},
+			{"getItem", http.StatusOK, "application/json", `{"status": 200}`, false},
+			{"getItem", http.StatusOK, "application/json", `{"status": 200, "data": null, "extra": 1}`, false},
+			{"getItem", http.StatusOK, "application/json", `{"status": "200", "data": null}`, false},
+			{"getItem", http.StatusOK, "application/json", `{"status": 200.5, "data": null}`, false},
+			{"getItem", http.StatusOK, "application/json", `{"status": 200, "data": [{"id": "a"}]}`, false},
+			{"getItem", http.StatusOK, "application/json", `{"status": 200, "data": [{"id": "a", "qty": 1, "tags": [1]}]}`, false},
+			{"getItem", http.StatusOK, "text/plain", `{"status": 200, "data": null}`, false},
+			{"getItem", http.StatusNoContent, "", `{}`, false},
+			{"getItem", http.StatusTeapot, "application/json", `{"status": 418, "data": null}`, false},
+			{"delItem", http.StatusOK, "application/json", `{}`, false},
+		}
+
+		for _, tt := range tests {
+			err := doc.ValidateResponse(tt.op, tt.status, tt.ct, []byte(tt.body))
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateResponse(%s, %d, %s, %s) = %v, want valid: %v", tt.op, tt.status, tt.ct, tt.body, err, tt.valid)
+			}
+		}
+	})
+}
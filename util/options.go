@@ -0,0 +1,355 @@
+package util
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ServerOption sets options when creating a new service, see Configure. The options of this
+// package apply to all services, services define their own with ServiceOption.
+type ServerOption func(Service) error
+
+// ServiceOption returns a ServerOption applying fn to services of type S. Passing it to another
+// service returns an error.
+func ServiceOption[S Service](fn func(S) error) ServerOption {
+	return func(svc Service) error {
+		s, ok := svc.(S)
+		if !ok {
+			var want S
+			return errors.Errorf("option for %T can't be applied to %T", want, svc)
+		}
+		return fn(s)
+	}
+}
+
+// baseOption returns a ServerOption applying fn to the BaseServer of any service.
+func baseOption(fn func(*BaseServer) error) ServerOption {
+	return func(svc Service) error {
+		return fn(svc.Base())
+	}
+}
+
+// SetServerAddress sets the server address.
+func SetServerAddress(address string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if err := CheckTCPAddress(address); err != nil {
+			return err
+		}
+
+		b.address = address
+		return nil
+	})
+}
+
+// SetGRPCAddress sets the listening address of the gRPC server of services serving gRPC.
+func SetGRPCAddress(address string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if b.grpcAddress == "" {
+			return errors.Errorf("%s doesn't serve gRPC", b.name)
+		}
+		if err := CheckTCPAddress(address); err != nil {
+			return err
+		}
+
+		b.grpcAddress = address
+		return nil
+	})
+}
+
+// SetServerEndpoint sets the server endpoint address for other services to call it.
+func SetServerEndpoint(address string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		b.endpoint = address
+		return nil
+	})
+}
+
+// SetLogLevel sets the log level to either debug, warn, error or info. Info is default, which is
+// used for invalid levels as well. The level can be changed at runtime via /admin/loglevel.
+func SetLogLevel(level string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if err := b.Logger.Levels().SetLevel(level); err != nil {
+			b.Logger.Levels().SetLevel(DefaultLogLevel)
+		}
+		return nil
+	})
+}
+
+// SetLogConfig replaces the logger by one writing to the outputs of cfg, e.g. rotated files, with
+// the encoding and sampling of cfg. Log levels set before are kept.
+func SetLogConfig(cfg LogConfig) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		cfg.Level = b.Logger.Levels().Level()
+		l, err := NewLoggerWithConfig(b.name, cfg)
+		if err != nil {
+			return err
+		}
+		l.Levels().Apply(b.Logger.Levels().Config())
+
+		b.Logger.Close()
+		b.Logger = l
+		return nil
+	})
+}
+
+// SetLogLevelConfig applies the log level and per-route overrides of a JSON file, see
+// LogLevelConfig. The file is read again on SIGHUP. An empty path keeps the levels set
+// otherwise, which are restored on SIGHUP instead.
+func SetLogLevelConfig(file string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if file == "" {
+			return nil
+		}
+
+		cfg, err := LoadLogLevelConfig(file)
+		if err != nil {
+			return err
+		}
+		if err := b.Logger.Levels().Apply(cfg); err != nil {
+			return errors.Wrapf(err, "invalid log level config %s", file)
+		}
+		b.logLevelConfig = file
+		return nil
+	})
+}
+
+// SetTracing sets the tracing backend to either jaeger, otlp or none. Jaeger is default.
+func SetTracing(backend string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if err := CheckTracingBackend(backend); err != nil {
+			return err
+		}
+		b.tracing.Backend = backend
+		return nil
+	})
+}
+
+// SetPropagation sets the comma-separated formats trace context is extracted from, in order of
+// precedence, and injected in. Valid formats are w3c, b3, b3multi and jaeger. Defaults to jaeger,w3c.
+func SetPropagation(formats string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		f, err := ParsePropagation(formats)
+		if err != nil {
+			return err
+		}
+		b.tracing.Propagation = f
+		return nil
+	})
+}
+
+// SetSampler sets the sampler of traces started by the service. Type is one of const,
+// probabilistic, ratelimiting or remote, see SamplerConfig. An empty type keeps the sampler
+// of the JAEGER_SAMPLER_* environment variables, or samples all traces.
+func SetSampler(samplerType string, param float64, serverURL string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		cfg := SamplerConfig{
+			Type:      samplerType,
+			Param:     param,
+			ServerURL: serverURL,
+		}
+		if err := CheckSamplerConfig(cfg); err != nil {
+			return err
+		}
+		b.tracing.Sampler = cfg
+		return nil
+	})
+}
+
+// SetSamplingOverrides sets comma-separated route=always|never pairs, where route is either the
+// pattern or the name of a route. Defaults to /healthz=never,/livez=never,/readyz=never.
+func SetSamplingOverrides(overrides string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		o, err := ParseSamplingOverrides(overrides)
+		if err != nil {
+			return err
+		}
+		b.Sampling.Overrides = o
+		return nil
+	})
+}
+
+// SetSampleErrors sets whether requests failing with a server error are sampled even if their
+// trace wasn't. Enabled by default.
+func SetSampleErrors(enabled bool) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		b.Sampling.Errors = enabled
+		return nil
+	})
+}
+
+// SetDrainPeriod sets the time between failing readiness and shutting down the server, so load
+// balancers can stop sending requests. Defaults to 5s.
+func SetDrainPeriod(d time.Duration) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if d < 0 {
+			return errors.Errorf("drain period must not be negative, got %s", d)
+		}
+		b.drainPeriod = d
+		return nil
+	})
+}
+
+// SetShutdownTimeout sets the time in-flight requests have to finish during a shutdown. Defaults
+// to 10s.
+func SetShutdownTimeout(d time.Duration) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if d <= 0 {
+			return errors.Errorf("shutdown timeout must be positive, got %s", d)
+		}
+		b.shutdownTimeout = d
+		return nil
+	})
+}
+
+// SetRedactionConfig loads the policy used to redact headers and fields from traces and logs
+// from a JSON file. An empty path keeps the default policy.
+func SetRedactionConfig(file string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if file == "" {
+			return nil
+		}
+
+		p, err := LoadRedactionPolicy(file)
+		if err != nil {
+			return err
+		}
+		b.Redact = p
+		return nil
+	})
+}
+
+// SetRedisAddress sets a custom address for the redis connection of services using Redis.
+func SetRedisAddress(address string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if b.Redis == nil {
+			return errors.Errorf("%s doesn't use redis", b.name)
+		}
+
+		rc, err := NewRedisClient(address)
+		if err != nil {
+			return err
+		}
+
+		// Close old client
+		if err := b.Redis.Close(); err != nil {
+			b.Logger.Warnw("Error while closing old redis client",
+				"error", err,
+			)
+		}
+		b.Redis = rc
+		return nil
+	})
+}
+
+// SetAPIKeysFile loads the API keys requests can authenticate with from a JSON file, see APIKey.
+// An empty path accepts no API keys.
+func SetAPIKeysFile(file string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if file == "" {
+			return nil
+		}
+
+		keys, err := LoadAPIKeys(file)
+		if err != nil {
+			return err
+		}
+		if err := b.Auth.SetAPIKeys(keys); err != nil {
+			return errors.Wrapf(err, "invalid API keys %s", file)
+		}
+		return nil
+	})
+}
+
+// SetJWKSFile loads the JSON Web Key Set JWTs are verified with from a file. Tokens need to be
+// issued by issuer for audience, unless they are empty. An empty path accepts no JWTs.
+func SetJWKSFile(file, issuer, audience string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if file == "" {
+			return nil
+		}
+
+		jwks, err := LoadJWKS(file)
+		if err != nil {
+			return err
+		}
+		b.Auth.SetJWKS(jwks, issuer, audience)
+		return nil
+	})
+}
+
+// SetInsecureNoAuth disables authentication, so private routes and RPCs are served without
+// credentials. It can't be combined with API keys or a JWKS.
+func SetInsecureNoAuth(insecure bool) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		b.Auth.SetInsecure(insecure)
+		return nil
+	})
+}
+
+// SetRateLimit sets the default rate of requests per second each client may send to a route,
+// with bursts of up to burst requests. A burst of 0 allows a second worth of requests, a rate
+// of 0 disables rate limiting.
+func SetRateLimit(rate float64, burst int) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if rate < 0 || burst < 0 {
+			return errors.Errorf("rate limit must not be negative, got %v with burst %d", rate, burst)
+		}
+		if burst == 0 {
+			burst = int(math.Ceil(rate))
+		}
+
+		b.Limits.RateLimit = RateLimit{Rate: rate, Burst: burst}
+		return nil
+	})
+}
+
+// SetClientRateLimit sets the rate of requests per second each IP address may send across all
+// routes, checked before authentication. Like with SetRateLimit, a burst of 0 allows a second
+// worth of requests and a rate of 0 disables the limit.
+func SetClientRateLimit(rate float64, burst int) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if rate < 0 || burst < 0 {
+			return errors.Errorf("client rate limit must not be negative, got %v with burst %d", rate, burst)
+		}
+		if burst == 0 {
+			burst = int(math.Ceil(rate))
+		}
+
+		b.Limits.ClientRateLimit = RateLimit{Rate: rate, Burst: burst}
+		return nil
+	})
+}
+
+// SetMaxInFlight sets the number of requests served concurrently before further ones are shed.
+// 0 serves any number of requests.
+func SetMaxInFlight(n int) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if n < 0 {
+			return errors.Errorf("max in-flight requests must not be negative, got %d", n)
+		}
+
+		b.Limits.MaxInFlight = n
+		return nil
+	})
+}
+
+// SetRateLimitRedis keeps the token buckets of rate limits in Redis at address, so replicas of a
+// service share the limit of a client. It should be a database not holding data of the service.
+// An empty address keeps the buckets in memory.
+func SetRateLimitRedis(address string) ServerOption {
+	return baseOption(func(b *BaseServer) error {
+		if address == "" {
+			return nil
+		}
+
+		rc, err := NewRedisClient(address)
+		if err != nil {
+			return errors.Wrap(err, "unable to create rate limiter redis client")
+		}
+		b.Limits.Limiter = NewRedisRateLimiter(rc, b.name)
+		b.AddCloser("ratelimit-redis", rc)
+		return nil
+	})
+}
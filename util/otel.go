@@ -0,0 +1,242 @@
+package util
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	otelprom "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Tracing backends which can be passed to NewTracer.
+const (
+	TracingJaeger = "jaeger"
+	TracingOTLP   = "otlp"
+	TracingNone   = "none"
+)
+
+// OTLP protocols as used by the OTEL_EXPORTER_OTLP_PROTOCOL environment variable.
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+// tracerShutdownTimeout limits how long pending spans, or metrics, are flushed when a tracer, or
+// MeterProvider, is closed.
+const tracerShutdownTimeout = 5 * time.Second
+
+// CheckTracingBackend returns an error if backend is not one of jaeger, otlp or none.
+func CheckTracingBackend(backend string) error {
+	switch backend {
+	case TracingJaeger, TracingOTLP, TracingNone:
+		return nil
+	default:
+		return errors.Errorf("invalid tracing backend %#v, must be one of %s, %s or %s", backend, TracingJaeger, TracingOTLP, TracingNone)
+	}
+}
+
+// TracerConfig configures the tracers created by NewTracer.
+type TracerConfig struct {
+	// Backend is one of jaeger, otlp or none.
+	Backend string
+
+	// Propagation lists the formats span contexts are extracted from, in order of precedence,
+	// and injected in. Defaults to DefaultPropagation.
+	Propagation []string
+
+	// Sampler selects which traces are sampled.
+	Sampler SamplerConfig
+}
+
+// NewTracer returns an opentracing Tracer according to cfg. The io.Closer flushes pending spans
+// and should be called on shutdown.
+func NewTracer(serviceName string, cfg TracerConfig, logger *Logger) (ot.Tracer, io.Closer, error) {
+	switch cfg.Backend {
+	case TracingJaeger:
+		return InitTracer(serviceName, cfg, logger)
+	case TracingOTLP:
+		exporter, err := NewOTLPExporter(context.Background())
+		if err != nil {
+			return nil, nil, err
+		}
+		return InitOTelTracer(serviceName, cfg, logger, sdktrace.NewBatchSpanProcessor(exporter))
+	case TracingNone:
+		return ot.NoopTracer{}, closerFunc(func() error { return nil }), nil
+	default:
+		return nil, nil, CheckTracingBackend(cfg.Backend)
+	}
+}
+
+// NewOTLPExporter creates an OTLP span exporter. The protocol is taken from
+// OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL and is either grpc or
+// http/protobuf, which is default. Endpoint, headers and TLS settings are read from the standard
+// OTEL_EXPORTER_OTLP_* environment variables by the exporters.
+func NewOTLPExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	protocol, err := otlpProtocol("TRACES")
+	if err != nil {
+		return nil, err
+	}
+	if protocol == OTLPProtocolGRPC {
+		return otlptracegrpc.New(ctx)
+	}
+	return otlptracehttp.New(ctx)
+}
+
+// NewOTLPMetricExporter creates an OTLP metric exporter. Like NewOTLPExporter, the protocol is
+// taken from OTEL_EXPORTER_OTLP_METRICS_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL.
+func NewOTLPMetricExporter(ctx context.Context) (sdkmetric.Exporter, error) {
+	protocol, err := otlpProtocol("METRICS")
+	if err != nil {
+		return nil, err
+	}
+	if protocol == OTLPProtocolGRPC {
+		return otlpmetricgrpc.New(ctx)
+	}
+	return otlpmetrichttp.New(ctx)
+}
+
+// otlpProtocol returns the OTLP protocol of a signal, e.g. TRACES, set in the environment.
+func otlpProtocol(signal string) (string, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_" + signal + "_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	switch protocol {
+	case OTLPProtocolGRPC:
+		return protocol, nil
+	case OTLPProtocolHTTP, "":
+		return OTLPProtocolHTTP, nil
+	default:
+		return "", errors.Errorf("unsupported OTLP protocol %#v, must be one of %s or %s", protocol, OTLPProtocolGRPC, OTLPProtocolHTTP)
+	}
+}
+
+// NewMetricExporter pushes the metrics gathered by gatherer, e.g. the Prometheus registry of a
+// service, to the tracing backend of cfg if it accepts metrics. That's only the case for otlp,
+// where they're exported every 60 seconds or OTEL_METRIC_EXPORT_INTERVAL milliseconds. Metrics
+// are scraped from /metrics regardless. The io.Closer pushes pending metrics and should be
+// called on shutdown.
+func NewMetricExporter(serviceName string, cfg TracerConfig, gatherer prometheus.Gatherer) (io.Closer, error) {
+	switch cfg.Backend {
+	case TracingOTLP:
+		exporter, err := NewOTLPMetricExporter(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return InitOTelMetrics(serviceName, sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithProducer(otelprom.NewMetricProducer(otelprom.WithGatherer(gatherer))),
+		))
+	case TracingJaeger, TracingNone:
+		return closerFunc(func() error { return nil }), nil
+	default:
+		return nil, CheckTracingBackend(cfg.Backend)
+	}
+}
+
+// InitOTelMetrics creates an OpenTelemetry SDK MeterProvider collecting metrics with reader,
+// e.g. a sdkmetric.NewPeriodicReader bridging a Prometheus registry. The MeterProvider is
+// registered globally with OpenTelemetry.
+func InitOTelMetrics(serviceName string, reader sdkmetric.Reader) (io.Closer, error) {
+	res, err := newOTelResource(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(reader),
+	)
+	otel.SetMeterProvider(mp)
+
+	return closerFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+		defer cancel()
+		return mp.Shutdown(ctx)
+	}), nil
+}
+
+// newOTelResource describes a service to OpenTelemetry backends.
+func newOTelResource(serviceName string) (*resource.Resource, error) {
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName(serviceName)),
+	)
+	return res, errors.Wrap(err, "unable to create OpenTelemetry resource")
+}
+
+// InitOTelTracer returns an opentracing Tracer bridged to an OpenTelemetry SDK TracerProvider.
+// Traces are sampled according to cfg.Sampler, which defaults to all traces, and passed to
+// processor, e.g. a sdktrace.NewBatchSpanProcessor. Span contexts are injected and extracted in the
+// formats of cfg.Propagation. The TracerProvider and propagator are registered globally with
+// OpenTelemetry as well, so spans started with either API end up in the same trace.
+func InitOTelTracer(serviceName string, cfg TracerConfig, logger *Logger, processor sdktrace.SpanProcessor) (ot.Tracer, io.Closer, error) {
+	res, err := newOTelResource(serviceName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	samplerCfg, err := resolveSamplerConfig(cfg.Sampler)
+	if err != nil {
+		return nil, nil, err
+	}
+	sampler, err := newOTelSampler(serviceName, samplerCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	propagator, err := NewPropagator(cfg.Propagation...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithSpanProcessor(newErrorSpanProcessor(processor)),
+	)
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warnw("OpenTelemetry error",
+			"error", err,
+		)
+	}))
+
+	bridge, wrapper := otbridge.NewTracerPair(tp.Tracer(serviceName))
+	bridge.SetTextMapPropagator(propagator)
+	bridge.SetWarningHandler(func(msg string) {
+		logger.Debugw("OpenTracing bridge warning",
+			"message", msg,
+		)
+	})
+
+	otel.SetTracerProvider(wrapper)
+	otel.SetTextMapPropagator(propagator)
+
+	return bridge, closerFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+		defer cancel()
+		return tp.Shutdown(ctx)
+	}), nil
+}
+
+// closerFunc adapts a function to io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,203 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	otelprom "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewTracer(t *testing.T) {
+	l, _ := NewLogger("error", "test")
+
+	for _, backend := range []string{TracingJaeger, TracingOTLP, TracingNone} {
+		if err := CheckTracingBackend(backend); err != nil {
+			t.Errorf("CheckTracingBackend(%s) returned error: %s", backend, err)
+		}
+	}
+
+	for _, backend := range []string{"", "zipkin", "OTLP"} {
+		if err := CheckTracingBackend(backend); err == nil {
+			t.Errorf("expected error for tracing backend %#v", backend)
+		}
+		if _, _, err := NewTracer("test", TracerConfig{Backend: backend}, l); err == nil {
+			t.Errorf("expected error when creating tracer for backend %#v", backend)
+		}
+	}
+
+	tracer, closer, err := NewTracer("test", TracerConfig{Backend: TracingNone}, l)
+	if err != nil {
+		t.Fatalf("unable to create tracer: %s", err)
+	}
+	if _, ok := tracer.(ot.NoopTracer); !ok {
+		t.Errorf("tracer for backend none is %T, want ot.NoopTracer", tracer)
+	}
+	if err := closer.Close(); err != nil {
+		t.Errorf("closing tracer returned error: %s", err)
+	}
+
+	t.Run("OTLP protocols", func(t *testing.T) {
+		defer os.Unsetenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+
+		for _, protocol := range []string{"", OTLPProtocolGRPC, OTLPProtocolHTTP} {
+			os.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", protocol)
+			exporter, err := NewOTLPExporter(context.Background())
+			if err != nil {
+				t.Errorf("unable to create exporter for protocol %#v: %s", protocol, err)
+				continue
+			}
+			exporter.Shutdown(context.Background())
+
+			metrics, err := NewOTLPMetricExporter(context.Background())
+			if err != nil {
+				t.Errorf("unable to create metric exporter for protocol %#v: %s", protocol, err)
+				continue
+			}
+			metrics.Shutdown(context.Background())
+		}
+
+		os.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+		if _, err := NewOTLPExporter(context.Background()); err == nil {
+			t.Errorf("expected error for unsupported OTLP protocol")
+		}
+		if _, err := NewOTLPMetricExporter(context.Background()); err == nil {
+			t.Errorf("expected error for unsupported OTLP metrics protocol")
+		}
+	})
+
+	t.Run("Metric exporter", func(t *testing.T) {
+		for _, backend := range []string{TracingJaeger, TracingNone} {
+			closer, err := NewMetricExporter("test", TracerConfig{Backend: backend}, prometheus.NewRegistry())
+			if err != nil {
+				t.Errorf("unable to create metric exporter for backend %s: %s", backend, err)
+				continue
+			}
+			closer.Close()
+		}
+		if _, err := NewMetricExporter("test", TracerConfig{Backend: "zipkin"}, prometheus.NewRegistry()); err == nil {
+			t.Errorf("expected error when creating metric exporter for backend zipkin")
+		}
+	})
+}
+
+func TestOTelMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "http_requests_total",
+		Help:        "A counter for requests.",
+		ConstLabels: prometheus.Labels{"service": "test"},
+	}, []string{"code"})
+	reg.MustRegister(requests)
+	requests.WithLabelValues("200").Add(3)
+
+	reader := sdkmetric.NewManualReader(sdkmetric.WithProducer(otelprom.NewMetricProducer(otelprom.WithGatherer(reg))))
+	closer, err := InitOTelMetrics("test", reader)
+	if err != nil {
+		t.Fatalf("unable to create meter provider: %s", err)
+	}
+	defer closer.Close()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("unable to collect metrics: %s", err)
+	}
+
+	if name, _ := rm.Resource.Set().Value("service.name"); name.AsString() != "test" {
+		t.Errorf("resource has service.name %#v, want test", name.Emit())
+	}
+
+	var sum metricdata.Sum[float64]
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == "http_requests_total" {
+				sum, _ = m.Data.(metricdata.Sum[float64])
+			}
+		}
+	}
+	if len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 3 || !sum.IsMonotonic {
+		t.Fatalf("http_requests_total wasn't exported as counter of 3: %+v", sum)
+	}
+	if code, _ := sum.DataPoints[0].Attributes.Value("code"); code.AsString() != "200" {
+		t.Errorf("http_requests_total has code %#v, want 200", code.Emit())
+	}
+}
+
+func TestOTelTracer(t *testing.T) {
+	l, _ := NewLogger("error", "test")
+	exporter := tracetest.NewInMemoryExporter()
+
+	tracer, closer, err := InitOTelTracer("test", TracerConfig{}, l, sdktrace.NewSimpleSpanProcessor(exporter))
+	if err != nil {
+		t.Fatalf("unable to create tracer: %s", err)
+	}
+	defer closer.Close()
+
+	prev := ot.GlobalTracer()
+	ot.SetGlobalTracer(tracer)
+	defer ot.SetGlobalTracer(prev)
+
+	route := Route{Name: "getItem", Method: "GET", Pattern: "/items/{id}"}
+	h := TracerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span, _ := ot.StartSpanFromContext(r.Context(), "getItem")
+		defer span.Finish()
+		w.WriteHeader(http.StatusNotFound)
+	}), route, nil, nil)
+
+	// Start a client span and pass it on like the order service does
+	client := tracer.StartSpan("client")
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	if err := tracer.Inject(client.Context(), ot.HTTPHeaders, ot.HTTPHeadersCarrier(req.Header)); err != nil {
+		t.Fatalf("unable to inject span context: %s", err)
+	}
+	if req.Header.Get("traceparent") == "" {
+		t.Errorf("traceparent header wasn't injected")
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	client.Finish()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+
+	root, handler, caller := byName["GET /items/{id}"], byName["getItem"], byName["client"]
+	if root.Parent.SpanID() != caller.SpanContext.SpanID() {
+		t.Errorf("server span isn't a child of the injected client span")
+	}
+	if handler.Parent.SpanID() != root.SpanContext.SpanID() {
+		t.Errorf("handler span isn't a child of the server span")
+	}
+	if root.SpanContext.TraceID() != caller.SpanContext.TraceID() {
+		t.Errorf("server span isn't part of the client trace")
+	}
+	if root.SpanKind != trace.SpanKindServer {
+		t.Errorf("server span has kind %s, want %s", root.SpanKind, trace.SpanKindServer)
+	}
+
+	var status attribute.Value
+	for _, a := range root.Attributes {
+		if a.Key == "http.status_code" {
+			status = a.Value
+		}
+	}
+	if status.AsInt64() != http.StatusNotFound {
+		t.Errorf("server span has http.status_code %v, want %d", status.Emit(), http.StatusNotFound)
+	}
+}
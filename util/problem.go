@@ -0,0 +1,52 @@
+package util
+
+import (
+	"net/http"
+
+	"github.com/obitech/micro-obs/apierr"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// FieldError describes why a single field of a request payload was rejected.
+type FieldError = apierr.FieldError
+
+// FieldErrors collects all FieldErrors found while validating a request payload.
+type FieldErrors = apierr.FieldErrors
+
+// Problem defines an RFC 7807 problem details response.
+// See https://tools.ietf.org/html/rfc7807 for more info.
+type Problem struct {
+	Type   string      `json:"type"`
+	Title  string      `json:"title"`
+	Status int         `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+	Code   apierr.Code `json:"code,omitempty"`
+	Errors FieldErrors `json:"errors,omitempty"`
+}
+
+// NewProblem returns a Problem for a status code with a passed detail message and field errors.
+func NewProblem(status int, detail string, errs FieldErrors) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Errors: errs,
+	}
+}
+
+// NewErrorProblem returns the Problem sent for an error. Errors not wrapping an apierr.Error are
+// internal errors, their message isn't exposed.
+func NewErrorProblem(err error) Problem {
+	e := apierr.From(err)
+	p := NewProblem(e.Status(), e.Detail, e.Fields)
+	p.Code = e.Code
+	return p
+}
+
+// SendJSON encodes a Problem as JSON and sends it on a passed http.ResponseWriter.
+func (p Problem) SendJSON(w http.ResponseWriter) error {
+	return sendJSON(w, ProblemContentType, p.Status, p)
+}
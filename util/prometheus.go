@@ -1,69 +1,118 @@
 package util
 
 import (
+	"context"
 	"net/http"
+	"time"
 
+	ot "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// RequestMetricHistogram defines a type of used metrics for a specific request, using Histograms for observations
+var (
+	// DefaultDurationBuckets are the default buckets for request latencies in seconds.
+	DefaultDurationBuckets = append([]float64{.001, .003}, prometheus.DefBuckets...)
+
+	// DefaultResponseSizeBuckets are the default buckets for response sizes in bytes.
+	DefaultResponseSizeBuckets = []float64{1, 5, 10, 50, 100}
+)
+
+// RequestMetricHistogram defines the RED metrics of a service, using Histograms for observations.
+// All metrics are labeled with the route name and method, finished requests additionally with
+// the status class. The service name is attached as constant label.
 type RequestMetricHistogram struct {
-	InFlightGauge prometheus.Gauge
+	InFlightGauge *prometheus.GaugeVec
 	Counter       *prometheus.CounterVec
 	Duration      *prometheus.HistogramVec
 	ResponseSize  *prometheus.HistogramVec
 }
 
-// NewRequestMetricHistogram creates a RequestMetricHistogram struct with sane defaults
-func NewRequestMetricHistogram(durationBuckets, responseSizeBuckets []float64) *RequestMetricHistogram {
+// NewRequestMetricHistogram creates a RequestMetricHistogram for a service with sane defaults.
+func NewRequestMetricHistogram(serviceName string, durationBuckets, responseSizeBuckets []float64) *RequestMetricHistogram {
+	service := prometheus.Labels{"service": serviceName}
+	labels := []string{"route", "method", "status_class"}
+
 	return &RequestMetricHistogram{
-		InFlightGauge: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "in_flight_requests",
-			Help: "A gauge of requests currently being served.",
-		}),
+		InFlightGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "in_flight_requests",
+				Help:        "A gauge of requests currently being served.",
+				ConstLabels: service,
+			},
+			[]string{"route", "method"},
+		),
 		Counter: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "api_requests_total",
-				Help: "A counter for requests to the wrapped handler.",
+				Name:        "api_requests_total",
+				Help:        "A counter for requests to the wrapped handler.",
+				ConstLabels: service,
 			},
-			[]string{"code", "method"},
+			labels,
 		),
 		Duration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:    "http_request_duration_seconds",
-				Help:    "A histogram for latencies for requests.",
-				Buckets: durationBuckets,
+				Name:        "http_request_duration_seconds",
+				Help:        "A histogram for latencies for requests.",
+				Buckets:     durationBuckets,
+				ConstLabels: service,
 			},
-			[]string{"handler", "method"},
+			labels,
 		),
 		ResponseSize: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:    "http_response_size_bytes",
-				Help:    "A histogram of response sizes for requests.",
-				Buckets: responseSizeBuckets,
+				Name:        "http_response_size_bytes",
+				Help:        "A histogram of response sizes for requests.",
+				Buckets:     responseSizeBuckets,
+				ConstLabels: service,
 			},
-			[]string{},
+			labels,
 		),
 	}
 }
 
+// Collectors returns all metrics, ready to be registered with a prometheus.Registerer.
+func (rm *RequestMetricHistogram) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{rm.InFlightGauge, rm.Counter, rm.Duration, rm.ResponseSize}
+}
+
+// Observe records a finished request to a route. If ctx holds a sampled span, its trace ID is
+// attached to the duration as exemplar.
+func (rm *RequestMetricHistogram) Observe(ctx context.Context, route, method string, status, size int, d time.Duration) {
+	l := prometheus.Labels{
+		"route":        route,
+		"method":       method,
+		"status_class": StatusClass(status),
+	}
+
+	rm.Counter.With(l).Inc()
+	observeWithTrace(ctx, rm.Duration.With(l), d.Seconds())
+	rm.ResponseSize.With(l).Observe(float64(size))
+}
+
+// observeWithTrace records v with the trace ID of the sampled span in ctx as exemplar, so
+// dashboards can link a bucket to a trace.
+func observeWithTrace(ctx context.Context, o prometheus.Observer, v float64) {
+	span := ot.SpanFromContext(ctx)
+	traceID, _, ok := SpanIDs(span)
+	eo, isExemplarObserver := o.(prometheus.ExemplarObserver)
+	if !ok || !isExemplarObserver || !isSampled(span) {
+		o.Observe(v)
+		return
+	}
+	eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": traceID})
+}
+
 // PrometheusMiddleware wraps a request for monitoring via Prometheus.
-func PrometheusMiddleware(h http.Handler, handler string, rm *RequestMetricHistogram) http.Handler {
-	// TODO: capture return code & handler for rm.Counter
-	promHandler := promhttp.InstrumentHandlerInFlight(
-		rm.InFlightGauge,
-		promhttp.InstrumentHandlerDuration(
-			rm.Duration.MustCurryWith(prometheus.Labels{"handler": handler}),
-			promhttp.InstrumentHandlerCounter(
-				rm.Counter,
-				promhttp.InstrumentHandlerResponseSize(
-					rm.ResponseSize,
-					h,
-				),
-			),
-		),
-	)
+func PrometheusMiddleware(h http.Handler, route Route, rm *RequestMetricHistogram) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inFlight := rm.InFlightGauge.With(prometheus.Labels{"route": route.Name, "method": r.Method})
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		rw := WrapResponseWriter(w)
+		h.ServeHTTP(rw, r)
 
-	return promHandler
+		rm.Observe(r.Context(), route.Name, r.Method, rw.Status(), rw.Size(), time.Since(start))
+	})
 }
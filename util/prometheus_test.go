@@ -0,0 +1,155 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusMiddleware(t *testing.T) {
+	rm := NewRequestMetricHistogram("test", DefaultDurationBuckets, DefaultResponseSizeBuckets)
+
+	var tests = []struct {
+		route  string
+		method string
+		status int
+		body   string
+	}{
+		{"getItem", "GET", http.StatusOK, "found"},
+		{"getItem", "GET", http.StatusNotFound, "missing"},
+		{"getItem", "GET", http.StatusNotFound, "missing"},
+		{"setItem", "POST", http.StatusCreated, ""},
+		{"notFound", "GET", 0, "implicit 200"},
+	}
+
+	for _, tt := range tests {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.status != 0 {
+				w.WriteHeader(tt.status)
+			}
+			w.Write([]byte(tt.body))
+		})
+
+		req := httptest.NewRequest(tt.method, "/", nil)
+		PrometheusMiddleware(h, Route{Name: tt.route}, rm).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var want = []struct {
+		route  string
+		method string
+		class  string
+		count  float64
+	}{
+		{"getItem", "GET", "2xx", 1},
+		{"getItem", "GET", "4xx", 2},
+		{"setItem", "POST", "2xx", 1},
+		{"notFound", "GET", "2xx", 1},
+		{"setItem", "POST", "5xx", 0},
+	}
+
+	for _, tt := range want {
+		c := rm.Counter.With(prometheus.Labels{"route": tt.route, "method": tt.method, "status_class": tt.class})
+		if got := testutil.ToFloat64(c); got != tt.count {
+			t.Errorf("api_requests_total{route=%q,method=%q,status_class=%q} = %v, want %v", tt.route, tt.method, tt.class, got, tt.count)
+		}
+	}
+
+	t.Run("Register multiple services", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		for _, service := range []string{"item", "order"} {
+			rm := NewRequestMetricHistogram(service, DefaultDurationBuckets, DefaultResponseSizeBuckets)
+			if err := registerAll(reg, rm.Collectors()); err != nil {
+				t.Errorf("unable to register metrics of %s: %s", service, err)
+			}
+		}
+	})
+}
+
+func registerAll(reg prometheus.Registerer, cs []prometheus.Collector) error {
+	for _, c := range cs {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := WrapResponseWriter(rec)
+
+	if WrapResponseWriter(rw) != rw {
+		t.Errorf("wrapping a ResponseWriter twice should return the same instance")
+	}
+
+	if rw.Written() || rw.Status() != http.StatusOK {
+		t.Errorf("fresh ResponseWriter reports written: %v, status: %d", rw.Written(), rw.Status())
+	}
+
+	rw.WriteHeader(http.StatusTeapot)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte("short and stout"))
+
+	if rw.Status() != http.StatusTeapot || rw.Size() != 15 {
+		t.Errorf("ResponseWriter recorded status %d and size %d, want %d and %d", rw.Status(), rw.Size(), http.StatusTeapot, 15)
+	}
+
+	if StatusClass(rw.Status()) != "4xx" {
+		t.Errorf("StatusClass(%d) = %s, want 4xx", rw.Status(), StatusClass(rw.Status()))
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	tracer, closer := helperNewTracer(TracingOTLP, nil, t)
+	defer closer.Close()
+
+	rm := NewRequestMetricHistogram("test", DefaultDurationBuckets, DefaultResponseSizeBuckets)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(rm.Collectors()...)
+
+	span := tracer.StartSpan("request")
+	defer span.Finish()
+	traceID, _, _ := SpanIDs(span)
+
+	rm.Observe(ot.ContextWithSpan(t.Context(), span), "getItem", "GET", http.StatusOK, 5, 2*time.Millisecond)
+	rm.Observe(t.Context(), "getItem", "GET", http.StatusNotFound, 5, 2*time.Millisecond)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %s", err)
+	}
+
+	exemplars := make(map[string]string)
+	for _, mf := range mfs {
+		if mf.GetName() != "http_request_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var class string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "status_class" {
+					class = l.GetValue()
+				}
+			}
+			for _, b := range m.GetHistogram().GetBucket() {
+				for _, l := range b.GetExemplar().GetLabel() {
+					if l.GetName() == "trace_id" {
+						exemplars[class] = l.GetValue()
+					}
+				}
+			}
+		}
+	}
+
+	if exemplars["2xx"] != traceID {
+		t.Errorf("traced request has exemplar trace_id %#v, want %#v", exemplars["2xx"], traceID)
+	}
+	if id, ok := exemplars["4xx"]; ok {
+		t.Errorf("untraced request has exemplar trace_id %#v", id)
+	}
+}
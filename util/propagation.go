@@ -0,0 +1,300 @@
+package util
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strings"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	jaeger "github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	jaegerprop "go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Propagation formats which can be passed to NewPropagator.
+const (
+	// PropagationW3C uses the traceparent and tracestate headers of W3C Trace Context.
+	PropagationW3C = "w3c"
+	// PropagationB3 uses the single b3 header.
+	PropagationB3 = "b3"
+	// PropagationB3Multi uses the X-B3-* headers.
+	PropagationB3Multi = "b3multi"
+	// PropagationJaeger uses the uber-trace-id header and uberctx-* headers for baggage.
+	PropagationJaeger = "jaeger"
+)
+
+// jaegerBaggagePrefix prefixes baggage items passed in Jaeger format.
+const jaegerBaggagePrefix = "uberctx-"
+
+// DefaultPropagation extracts Jaeger headers before W3C Trace Context and injects both.
+var DefaultPropagation = []string{PropagationJaeger, PropagationW3C}
+
+// ParsePropagation splits a comma-separated list of propagation formats, e.g. w3c,b3.
+func ParsePropagation(s string) ([]string, error) {
+	var formats []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if _, err := NewPropagator(f); err != nil {
+			return nil, err
+		}
+		formats = append(formats, f)
+	}
+
+	if len(formats) == 0 {
+		return nil, errors.Errorf("no propagation format in %#v", s)
+	}
+	return formats, nil
+}
+
+// NewPropagator returns a TextMapPropagator for the passed formats, or DefaultPropagation if none
+// are passed. Trace context is extracted from the first format present in a carrier and injected
+// in all formats. Baggage is always carried in the W3C baggage header, and additionally in
+// uberctx-* headers if the Jaeger format is used.
+func NewPropagator(formats ...string) (propagation.TextMapPropagator, error) {
+	if len(formats) == 0 {
+		formats = DefaultPropagation
+	}
+
+	p := &propagator{
+		baggage: []propagation.TextMapPropagator{propagation.Baggage{}},
+	}
+	for _, f := range formats {
+		switch f {
+		case PropagationW3C:
+			p.trace = append(p.trace, propagation.TraceContext{})
+		case PropagationB3:
+			p.trace = append(p.trace, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case PropagationB3Multi:
+			p.trace = append(p.trace, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case PropagationJaeger:
+			p.trace = append(p.trace, jaegerprop.Jaeger{})
+			p.baggage = append(p.baggage, jaegerBaggage{})
+		default:
+			return nil, errors.Errorf("invalid propagation format %#v, must be one of %s, %s, %s or %s", f, PropagationW3C, PropagationB3, PropagationB3Multi, PropagationJaeger)
+		}
+	}
+
+	return p, nil
+}
+
+// propagator combines several trace context formats, preferring them in order when extracting.
+type propagator struct {
+	trace   []propagation.TextMapPropagator
+	baggage []propagation.TextMapPropagator
+}
+
+// Inject implements propagation.TextMapPropagator.
+func (p *propagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	for _, tp := range p.trace {
+		tp.Inject(ctx, carrier)
+	}
+	for _, bp := range p.baggage {
+		bp.Inject(ctx, carrier)
+	}
+}
+
+// Extract implements propagation.TextMapPropagator.
+func (p *propagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	for _, tp := range p.trace {
+		if c := tp.Extract(ctx, carrier); trace.SpanContextFromContext(c).IsValid() {
+			ctx = c
+			break
+		}
+	}
+	for _, bp := range p.baggage {
+		ctx = bp.Extract(ctx, carrier)
+	}
+	return ctx
+}
+
+// Fields implements propagation.TextMapPropagator.
+func (p *propagator) Fields() []string {
+	var fields []string
+	for _, tp := range append(p.trace, p.baggage...) {
+		fields = append(fields, tp.Fields()...)
+	}
+	return fields
+}
+
+// jaegerBaggage carries baggage in uberctx-* headers, like jaeger-client-go does.
+type jaegerBaggage struct{}
+
+// Inject implements propagation.TextMapPropagator.
+func (jaegerBaggage) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	for _, m := range baggage.FromContext(ctx).Members() {
+		carrier.Set(jaegerBaggagePrefix+m.Key(), url.QueryEscape(m.Value()))
+	}
+}
+
+// Extract implements propagation.TextMapPropagator. Items are added to the baggage already present
+// in ctx.
+func (jaegerBaggage) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	bag := baggage.FromContext(ctx)
+	for _, k := range carrier.Keys() {
+		key := strings.ToLower(k)
+		if !strings.HasPrefix(key, jaegerBaggagePrefix) {
+			continue
+		}
+
+		v, err := url.QueryUnescape(carrier.Get(k))
+		if err != nil {
+			continue
+		}
+		m, err := baggage.NewMemberRaw(strings.TrimPrefix(key, jaegerBaggagePrefix), v)
+		if err != nil {
+			continue
+		}
+		if b, err := bag.SetMember(m); err == nil {
+			bag = b
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+// Fields implements propagation.TextMapPropagator.
+func (jaegerBaggage) Fields() []string {
+	return nil
+}
+
+// textMapCarrier adapts the carriers accepted by ot.Tracer.Inject and ot.Tracer.Extract to
+// propagation.TextMapCarrier.
+func textMapCarrier(carrier interface{}) (propagation.TextMapCarrier, error) {
+	switch c := carrier.(type) {
+	case ot.HTTPHeadersCarrier:
+		return propagation.HeaderCarrier(c), nil
+	case http.Header:
+		return propagation.HeaderCarrier(c), nil
+	case propagation.TextMapCarrier:
+		return c, nil
+	}
+
+	tc := &textMapAdapter{}
+	tc.reader, _ = carrier.(ot.TextMapReader)
+	tc.writer, _ = carrier.(ot.TextMapWriter)
+	if tc.reader == nil && tc.writer == nil {
+		return nil, ot.ErrInvalidCarrier
+	}
+	return tc, nil
+}
+
+// textMapAdapter turns an ot.TextMapReader or ot.TextMapWriter into a propagation.TextMapCarrier.
+// Keys are matched case-insensitively.
+type textMapAdapter struct {
+	reader ot.TextMapReader
+	writer ot.TextMapWriter
+}
+
+// Get implements propagation.TextMapCarrier.
+func (t *textMapAdapter) Get(key string) string {
+	var value string
+	if t.reader != nil {
+		t.reader.ForeachKey(func(k, v string) error {
+			if strings.EqualFold(k, key) {
+				value = v
+			}
+			return nil
+		})
+	}
+	return value
+}
+
+// Set implements propagation.TextMapCarrier.
+func (t *textMapAdapter) Set(key, value string) {
+	if t.writer != nil {
+		t.writer.Set(key, value)
+	}
+}
+
+// Keys implements propagation.TextMapCarrier.
+func (t *textMapAdapter) Keys() []string {
+	var keys []string
+	if t.reader != nil {
+		t.reader.ForeachKey(func(k, v string) error {
+			keys = append(keys, k)
+			return nil
+		})
+	}
+	return keys
+}
+
+// jaegerPropagator lets jaeger-client-go inject and extract span contexts with a
+// propagation.TextMapPropagator, so both tracing backends understand the same formats.
+type jaegerPropagator struct {
+	propagator propagation.TextMapPropagator
+}
+
+// Inject implements jaeger.Injector.
+func (j jaegerPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	c, err := textMapCarrier(carrier)
+	if err != nil {
+		return err
+	}
+
+	var traceID trace.TraceID
+	binary.BigEndian.PutUint64(traceID[:8], sc.TraceID().High)
+	binary.BigEndian.PutUint64(traceID[8:], sc.TraceID().Low)
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(spanID[:], uint64(sc.SpanID()))
+
+	var flags trace.TraceFlags
+	if sc.IsSampled() {
+		flags = trace.FlagsSampled
+	}
+
+	bag, _ := baggage.New()
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		if m, err := baggage.NewMemberRaw(k, v); err == nil {
+			bag, _ = bag.SetMember(m)
+		}
+		return true
+	})
+
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+	}))
+	ctx = baggage.ContextWithBaggage(ctx, bag)
+	j.propagator.Inject(ctx, c)
+	return nil
+}
+
+// Extract implements jaeger.Extractor.
+func (j jaegerPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	c, err := textMapCarrier(carrier)
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+
+	ctx := j.propagator.Extract(context.Background(), c)
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return jaeger.SpanContext{}, ot.ErrSpanContextNotFound
+	}
+
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+	items := make(map[string]string)
+	for _, m := range baggage.FromContext(ctx).Members() {
+		items[m.Key()] = m.Value()
+	}
+
+	return jaeger.NewSpanContext(
+		jaeger.TraceID{
+			High: binary.BigEndian.Uint64(traceID[:8]),
+			Low:  binary.BigEndian.Uint64(traceID[8:]),
+		},
+		jaeger.SpanID(binary.BigEndian.Uint64(spanID[:])),
+		0,
+		sc.IsSampled(),
+		items,
+	), nil
+}
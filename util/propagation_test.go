@@ -0,0 +1,188 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// helperNewTracer creates a tracer of the passed backend using the passed propagation formats.
+func helperNewTracer(backend string, formats []string, t *testing.T) (ot.Tracer, io.Closer) {
+	l, _ := NewLogger("error", "test")
+	cfg := TracerConfig{Propagation: formats}
+
+	var tracer ot.Tracer
+	var closer io.Closer
+	var err error
+	switch backend {
+	case TracingJaeger:
+		tracer, closer, err = InitTracer("test", cfg, l)
+	case TracingOTLP:
+		tracer, closer, err = InitOTelTracer("test", cfg, l, sdktrace.NewSimpleSpanProcessor(tracetest.NewInMemoryExporter()))
+	}
+	if err != nil {
+		t.Fatalf("unable to create %s tracer: %s", backend, err)
+	}
+	return tracer, closer
+}
+
+// helperTraceID returns the hex encoded trace ID of a span context of either backend.
+func helperTraceID(sc ot.SpanContext) string {
+	switch c := sc.(type) {
+	case jaeger.SpanContext:
+		return fmt.Sprintf("%016x%016x", c.TraceID().High, c.TraceID().Low)
+	case interface{ TraceID() trace.TraceID }:
+		return c.TraceID().String()
+	}
+	return ""
+}
+
+func TestPropagation(t *testing.T) {
+	var formats = []struct {
+		format  string
+		headers []string
+	}{
+		{PropagationW3C, []string{"Traceparent", "Baggage"}},
+		{PropagationB3, []string{"B3", "Baggage"}},
+		{PropagationB3Multi, []string{"X-B3-Traceid", "X-B3-Spanid", "X-B3-Sampled", "Baggage"}},
+		{PropagationJaeger, []string{"Uber-Trace-Id", "Uberctx-User", "Baggage"}},
+	}
+	backends := []string{TracingJaeger, TracingOTLP}
+
+	for _, f := range formats {
+		for _, from := range backends {
+			for _, to := range backends {
+				t.Run(fmt.Sprintf("%s from %s to %s", f.format, from, to), func(t *testing.T) {
+					client, cc := helperNewTracer(from, []string{f.format}, t)
+					defer cc.Close()
+					server, sc := helperNewTracer(to, []string{f.format}, t)
+					defer sc.Close()
+
+					span := client.StartSpan("client")
+					span.SetBaggageItem("user", "alice smith")
+					defer span.Finish()
+
+					h := http.Header{}
+					if err := client.Inject(span.Context(), ot.HTTPHeaders, ot.HTTPHeadersCarrier(h)); err != nil {
+						t.Fatalf("unable to inject span context: %s", err)
+					}
+					for _, name := range f.headers {
+						if h.Get(name) == "" {
+							t.Errorf("header %s wasn't injected, got %v", name, h)
+						}
+					}
+
+					extracted, err := server.Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(h))
+					if err != nil {
+						t.Fatalf("unable to extract span context from %v: %s", h, err)
+					}
+
+					if got, want := helperTraceID(extracted), helperTraceID(span.Context()); got != want {
+						t.Errorf("extracted trace ID %s, want %s", got, want)
+					}
+
+					bag := make(map[string]string)
+					extracted.ForeachBaggageItem(func(k, v string) bool {
+						bag[k] = v
+						return true
+					})
+					if !reflect.DeepEqual(bag, map[string]string{"user": "alice smith"}) {
+						t.Errorf("extracted baggage %v, want user=alice smith", bag)
+					}
+
+					// Baggage is passed on to the next hop
+					child := server.StartSpan("server", ot.ChildOf(extracted))
+					defer child.Finish()
+					if v := child.BaggageItem("user"); v != "alice smith" {
+						t.Errorf("child span has baggage user=%#v, want alice smith", v)
+					}
+				})
+			}
+		}
+	}
+
+	t.Run("Precedence", func(t *testing.T) {
+		h := http.Header{}
+		h.Set("uber-trace-id", "00000000000000000000000000000001:0000000000000001:0:1")
+		h.Set("traceparent", "00-00000000000000000000000000000002-0000000000000002-01")
+
+		var tests = []struct {
+			formats []string
+			want    string
+		}{
+			{[]string{PropagationJaeger, PropagationW3C}, "00000000000000000000000000000001"},
+			{[]string{PropagationW3C, PropagationJaeger}, "00000000000000000000000000000002"},
+			{[]string{PropagationB3, PropagationW3C}, "00000000000000000000000000000002"},
+		}
+
+		for _, tt := range tests {
+			p, _ := NewPropagator(tt.formats...)
+			ctx := p.Extract(t.Context(), propagation.HeaderCarrier(h))
+			if got := trace.SpanContextFromContext(ctx).TraceID().String(); got != tt.want {
+				t.Errorf("%v extracted trace ID %s, want %s", tt.formats, got, tt.want)
+			}
+		}
+
+		p, _ := NewPropagator(PropagationB3)
+		if ctx := p.Extract(t.Context(), propagation.HeaderCarrier(h)); trace.SpanContextFromContext(ctx).IsValid() {
+			t.Errorf("b3 extracted a span context from foreign headers")
+		}
+	})
+
+	t.Run("gRPC metadata", func(t *testing.T) {
+		client, cc := helperNewTracer(TracingJaeger, []string{PropagationW3C}, t)
+		defer cc.Close()
+		server, sc := helperNewTracer(TracingOTLP, []string{PropagationW3C}, t)
+		defer sc.Close()
+
+		span := client.StartSpan("client")
+		defer span.Finish()
+
+		md := metadataCarrier{}
+		if err := client.Inject(span.Context(), ot.TextMap, md); err != nil {
+			t.Fatalf("unable to inject span context: %s", err)
+		}
+		extracted, err := server.Extract(ot.TextMap, md)
+		if err != nil {
+			t.Fatalf("unable to extract span context from %v: %s", md, err)
+		}
+		if got, want := helperTraceID(extracted), helperTraceID(span.Context()); got != want {
+			t.Errorf("extracted trace ID %s, want %s", got, want)
+		}
+	})
+}
+
+func TestParsePropagation(t *testing.T) {
+	var tests = []struct {
+		in    string
+		want  []string
+		valid bool
+	}{
+		{"w3c", []string{"w3c"}, true},
+		{"W3C, b3 ,jaeger", []string{"w3c", "b3", "jaeger"}, true},
+		{"b3multi,", []string{"b3multi"}, true},
+		{"", nil, false},
+		{" , ", nil, false},
+		{"w3c,zipkin", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePropagation(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParsePropagation(%#v) returned error %v, want valid: %v", tt.in, err, tt.valid)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePropagation(%#v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/obitech/micro-obs/apierr"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// NewPanicCounter creates the panics_total counter of a service, labeled with the route name and
+// method, see RecoveryMiddleware.
+func NewPanicCounter(serviceName string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "panics_total",
+			Help:        "A counter for panics recovered while serving requests.",
+			ConstLabels: prometheus.Labels{"service": serviceName},
+		},
+		[]string{"route", "method"},
+	)
+}
+
+// RecoveryMiddleware recovers from panics of the inner handler, so a single bad request doesn't go
+// unnoticed. The panic is logged with its stack and the request ID, the span of the request is
+// marked as error and panics is incremented. Unless the inner handler has already started
+// responding, a problem+json 500 is sent. http.ErrAbortHandler is passed on to net/http.
+func RecoveryMiddleware(inner http.Handler, route Route, logger *Logger, panics *prometheus.CounterVec) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := WrapResponseWriter(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			panics.WithLabelValues(route.Name, r.Method).Inc()
+			if span := ot.SpanFromContext(r.Context()); span != nil {
+				ext.Error.Set(span, true)
+				span.SetTag("error.kind", "panic")
+			}
+
+			log := RequestIDLogger(logger, r)
+			log.Errorw("recovered from panic",
+				"panic", fmt.Sprint(p),
+				"stack", string(debug.Stack()),
+				"method", r.Method,
+				"path", r.RequestURI,
+			)
+
+			if rw.Written() {
+				return
+			}
+			NewProblem(http.StatusInternalServerError, "the server was unable to complete the request", nil).SendJSON(rw)
+		}()
+
+		inner.ServeHTTP(rw, r)
+	})
+}
+
+// GRPCRecoveryInterceptor is the gRPC equivalent of RecoveryMiddleware for unary calls. It's the
+// outermost interceptor, so panics of all other interceptors are recovered as well. The panic is
+// logged with its stack, panics is incremented and an Internal status is returned.
+func GRPCRecoveryInterceptor(logger *Logger, panics *prometheus.CounterVec) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recoverGRPCPanic(ctx, p, info.FullMethod, logger, panics)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamRecoveryInterceptor is the gRPC equivalent of RecoveryMiddleware for streaming calls.
+func GRPCStreamRecoveryInterceptor(logger *Logger, panics *prometheus.CounterVec) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recoverGRPCPanic(ss.Context(), p, info.FullMethod, logger, panics)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recoverGRPCPanic(ctx context.Context, p interface{}, fullMethod string, logger *Logger, panics *prometheus.CounterVec) error {
+	panics.WithLabelValues(fullMethod, grpcMethod).Inc()
+
+	log := RequestIDLoggerFromContext(ctx, logger)
+	log.Errorw("recovered from panic",
+		"panic", fmt.Sprint(p),
+		"stack", string(debug.Stack()),
+		"method", grpcMethod,
+		"path", fullMethod,
+	)
+
+	return apierr.GRPCStatus(apierr.New(apierr.Internal, "the server was unable to complete the request"))
+}
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	route := Route{Name: "explode", Method: "GET", Pattern: "/explode"}
+
+	t.Run("Problem response", func(t *testing.T) {
+		l, logs := helperObservedLogger()
+		panics := NewPanicCounter("test")
+		tracer := mocktracer.New()
+		span := tracer.StartSpan("GET /explode")
+
+		h := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}), route, l, panics)
+		h = AssignRequestID(h, l)
+
+		req := httptest.NewRequest("GET", "/explode", nil)
+		req = req.WithContext(ot.ContextWithSpan(req.Context(), span))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		span.Finish()
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status is %d, want %d", w.Code, http.StatusInternalServerError)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != ProblemContentType {
+			t.Errorf("Content-Type is %#v, want %#v", ct, ProblemContentType)
+		}
+		var p Problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatalf("unable to decode problem: %s", err)
+		}
+		if p.Status != http.StatusInternalServerError {
+			t.Errorf("problem status is %d, want %d", p.Status, http.StatusInternalServerError)
+		}
+
+		if got := testutil.ToFloat64(panics.WithLabelValues("explode", "GET")); got != 1 {
+			t.Errorf("panics_total is %v, want 1", got)
+		}
+
+		entries := logs.FilterMessage("recovered from panic").All()
+		if len(entries) != 1 {
+			t.Fatalf("panic logged %d times, want once", len(entries))
+		}
+		fields := entries[0].ContextMap()
+		if fields["panic"] != "boom" {
+			t.Errorf("logged panic is %#v, want boom", fields["panic"])
+		}
+		if id, _ := fields["requestID"].(string); id == "" {
+			t.Errorf("panic logged without requestID")
+		}
+		if stack, _ := fields["stack"].(string); !strings.Contains(stack, "recovery_test.go") {
+			t.Errorf("logged stack doesn't point to the panicking handler: %s", stack)
+		}
+
+		finished := tracer.FinishedSpans()
+		if len(finished) != 1 {
+			t.Fatalf("got %d finished spans, want 1", len(finished))
+		}
+		if e, _ := finished[0].Tag("error").(bool); !e {
+			t.Errorf("span isn't marked as error")
+		}
+	})
+
+	t.Run("Response already started", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		panics := NewPanicCounter("test")
+		h := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("boom")
+		}), route, l, panics)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/explode", nil))
+		if w.Code != http.StatusAccepted {
+			t.Errorf("status is %d, want the one sent before the panic", w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("problem was appended to started response: %s", w.Body.String())
+		}
+		if got := testutil.ToFloat64(panics.WithLabelValues("explode", "GET")); got != 1 {
+			t.Errorf("panics_total is %v, want 1", got)
+		}
+	})
+
+	t.Run("Aborted handler", func(t *testing.T) {
+		l, _ := helperObservedLogger()
+		h := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}), route, l, NewPanicCounter("test"))
+
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler to be passed on", p)
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/explode", nil))
+	})
+
+	t.Run("Unencodable response", func(t *testing.T) {
+		base, err := NewBaseServer("test", "1.0.0", ":0", "")
+		if err != nil {
+			t.Fatalf("unable to create base server: %s", err)
+		}
+		base.Auth.SetInsecure(true)
+		base.HandleRoutes(Routes{
+			Route{
+				Name:    "nan",
+				Method:  "GET",
+				Pattern: "/nan",
+				HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					base.Respond(r.Context(), http.StatusOK, "not a number", 1, []float64{math.NaN()}, w)
+				},
+			},
+		}, nil)
+
+		w := httptest.NewRecorder()
+		base.ServeHTTP(w, httptest.NewRequest("GET", "/nan", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status is %d, want %d", w.Code, http.StatusInternalServerError)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != ProblemContentType {
+			t.Errorf("Content-Type is %#v, want %#v", ct, ProblemContentType)
+		}
+		if got := testutil.ToFloat64(base.Panics.WithLabelValues("nan", "GET")); got != 1 {
+			t.Errorf("panics_total is %v, want 1", got)
+		}
+	})
+	t.Run("gRPC", func(t *testing.T) {
+		tracer := mocktracer.New()
+		ot.SetGlobalTracer(tracer)
+		defer ot.SetGlobalTracer(ot.NoopTracer{})
+
+		l, logs := helperObservedLogger()
+		panics := NewPanicCounter("test")
+		metrics := NewRequestMetricHistogram("test", nil, nil)
+		info := &grpc.UnaryServerInfo{FullMethod: "/item.ItemService/Get"}
+
+		recovery := GRPCRecoveryInterceptor(l, panics)
+		traced := GRPCTracerInterceptor(nil)
+		observed := GRPCPrometheusInterceptor(metrics)
+		_, err := recovery(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return traced(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return observed(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					panic("boom")
+				})
+			})
+		})
+
+		if status.Code(err) != codes.Internal {
+			t.Errorf("call returned %s, want %s", status.Code(err), codes.Internal)
+		}
+		if got := testutil.ToFloat64(panics.WithLabelValues("/item.ItemService/Get", grpcMethod)); got != 1 {
+			t.Errorf("panics_total is %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(metrics.Counter.WithLabelValues("/item.ItemService/Get", grpcMethod, "5xx")); got != 1 {
+			t.Errorf("api_requests_total of 5xx is %v, want 1", got)
+		}
+		if logs.FilterMessage("recovered from panic").Len() != 1 {
+			t.Errorf("panic wasn't logged")
+		}
+
+		finished := tracer.FinishedSpans()
+		if len(finished) != 1 {
+			t.Fatalf("got %d finished spans, want 1", len(finished))
+		}
+		if e, _ := finished[0].Tag("error").(bool); !e {
+			t.Errorf("span isn't marked as error")
+		}
+	})
+}
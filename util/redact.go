@@ -0,0 +1,288 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+)
+
+// DefaultMask replaces redacted values.
+const DefaultMask = "[REDACTED]"
+
+// RedactionPolicy decides which parts of headers and payloads may end up in span tags, span logs
+// and log fields. A nil *RedactionPolicy behaves like DefaultRedactionPolicy.
+type RedactionPolicy struct {
+	// AllowHeaders lists the only headers recorded verbatim. If empty, all headers which are not
+	// denied are recorded.
+	AllowHeaders []string `json:"allowHeaders"`
+
+	// DenyHeaders lists headers whose values are never recorded.
+	DenyHeaders []string `json:"denyHeaders"`
+
+	// MaskFields lists JSON paths of payload and log fields to mask, e.g. data[*].desc. A path
+	// segment of * matches every key of an object, [*] every element of an array.
+	MaskFields []string `json:"maskFields"`
+
+	// Mask replaces redacted values, defaults to DefaultMask.
+	Mask string `json:"mask"`
+
+	allow map[string]bool
+	deny  map[string]bool
+	paths [][]string
+}
+
+var defaultRedactionPolicy = DefaultRedactionPolicy()
+
+// DefaultRedactionPolicy returns a RedactionPolicy denying common credential headers.
+func DefaultRedactionPolicy() *RedactionPolicy {
+	p, _ := NewRedactionPolicy(RedactionPolicy{
+		DenyHeaders: []string{
+			"Authorization",
+			"Cookie",
+			"Proxy-Authorization",
+			"Set-Cookie",
+			"X-Api-Key",
+		},
+	})
+	return p
+}
+
+// NewRedactionPolicy validates and prepares a RedactionPolicy for use.
+func NewRedactionPolicy(cfg RedactionPolicy) (*RedactionPolicy, error) {
+	p := &RedactionPolicy{
+		AllowHeaders: cfg.AllowHeaders,
+		DenyHeaders:  cfg.DenyHeaders,
+		MaskFields:   cfg.MaskFields,
+		Mask:         cfg.Mask,
+		allow:        make(map[string]bool),
+		deny:         make(map[string]bool),
+	}
+
+	if p.Mask == "" {
+		p.Mask = DefaultMask
+	}
+
+	for _, h := range p.AllowHeaders {
+		p.allow[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, h := range p.DenyHeaders {
+		p.deny[http.CanonicalHeaderKey(h)] = true
+	}
+
+	for _, f := range p.MaskFields {
+		path, err := parseFieldPath(f)
+		if err != nil {
+			return nil, err
+		}
+		p.paths = append(p.paths, path)
+	}
+
+	return p, nil
+}
+
+// LoadRedactionPolicy reads a RedactionPolicy from a JSON file.
+func LoadRedactionPolicy(file string) (*RedactionPolicy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read redaction policy %s", file)
+	}
+
+	var cfg RedactionPolicy
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse redaction policy %s", file)
+	}
+
+	return NewRedactionPolicy(cfg)
+}
+
+// parseFieldPath splits a JSON path like $.data[*].desc into its segments data, [*] and desc.
+func parseFieldPath(f string) ([]string, error) {
+	f = strings.TrimPrefix(strings.TrimPrefix(f, "$"), ".")
+	if f == "" {
+		return nil, errors.New("empty field path")
+	}
+
+	var path []string
+	for _, seg := range strings.Split(f, ".") {
+		name := strings.TrimSuffix(seg, "[*]")
+		if name == "" && seg == "" {
+			return nil, errors.Errorf("empty segment in field path %s", f)
+		}
+		if name != "" {
+			path = append(path, name)
+		}
+		if name != seg {
+			path = append(path, "[*]")
+		}
+	}
+
+	return path, nil
+}
+
+func (p *RedactionPolicy) policy() *RedactionPolicy {
+	if p == nil {
+		return defaultRedactionPolicy
+	}
+	return p
+}
+
+// HeaderAllowed returns true if the values of a header may be recorded.
+func (p *RedactionPolicy) HeaderAllowed(name string) bool {
+	p = p.policy()
+	name = http.CanonicalHeaderKey(name)
+	if p.deny[name] {
+		return false
+	}
+	return len(p.allow) == 0 || p.allow[name]
+}
+
+// Header returns the values of a header, masked if they may not be recorded.
+func (p *RedactionPolicy) Header(name string, values []string) []string {
+	if p.HeaderAllowed(name) {
+		return values
+	}
+	return []string{p.policy().Mask}
+}
+
+// TagHeaders sets a header.<name> tag on span for every header, masking values which may not be
+// recorded.
+func (p *RedactionPolicy) TagHeaders(span ot.Span, headers map[string][]string) {
+	for k, v := range headers {
+		span.SetTag(fmt.Sprintf("header.%s", k), p.Header(k, v))
+	}
+}
+
+// Value returns a copy of v with all masked fields replaced. Values which need masking are
+// converted into their generic JSON representation first.
+func (p *RedactionPolicy) Value(v interface{}) interface{} {
+	p = p.policy()
+	if len(p.paths) == 0 || v == nil {
+		return v
+	}
+
+	g, err := toGeneric(v)
+	if err != nil {
+		return p.Mask
+	}
+	for _, path := range p.paths {
+		g = p.mask(g, path)
+	}
+	return g
+}
+
+// Fields redacts alternating key-value pairs as passed to the structured logging functions. A
+// key is treated as the root of a JSON path, so a mask for data[*].desc applies to the value
+// logged under the key data.
+func (p *RedactionPolicy) Fields(kv []interface{}) []interface{} {
+	p = p.policy()
+	if len(p.paths) == 0 {
+		return kv
+	}
+
+	out := make([]interface{}, 0, len(kv))
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			return kv
+		}
+
+		value := kv[i+1]
+		for _, path := range p.paths {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+			if len(path) == 1 {
+				value = p.Mask
+				break
+			}
+			if !isComposite(value) {
+				continue
+			}
+			g, err := toGeneric(value)
+			if err != nil {
+				value = p.Mask
+				break
+			}
+			value = p.mask(g, path[1:])
+		}
+
+		out = append(out, key, value)
+	}
+
+	// Keep a dangling key so zap can report it
+	if len(kv)%2 == 1 {
+		out = append(out, kv[len(kv)-1])
+	}
+	return out
+}
+
+// mask replaces every value matching path in the generic JSON value v.
+func (p *RedactionPolicy) mask(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return p.Mask
+	}
+
+	switch t := v.(type) {
+	case []interface{}:
+		if path[0] != "[*]" {
+			return v
+		}
+		for i := range t {
+			t[i] = p.mask(t[i], path[1:])
+		}
+	case map[string]interface{}:
+		for k := range t {
+			if path[0] == "*" || path[0] == k {
+				t[k] = p.mask(t[k], path[1:])
+			}
+		}
+	}
+
+	return v
+}
+
+// isComposite returns true if v is encoded as a JSON object or array. Errors and Stringers are
+// logged by their text and never traversed.
+func isComposite(v interface{}) bool {
+	switch v.(type) {
+	case error, fmt.Stringer:
+		return false
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// toGeneric converts v into the representation encoding/json would decode it to, so it can be
+// traversed by field name.
+func toGeneric(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case string, bool, float64, nil:
+		return v, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var g interface{}
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
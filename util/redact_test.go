@@ -0,0 +1,159 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+type redactTestItem struct {
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+}
+
+func TestRedactionPolicy(t *testing.T) {
+	t.Run("Headers", func(t *testing.T) {
+		allow, err := NewRedactionPolicy(RedactionPolicy{
+			AllowHeaders: []string{"content-type", "x-request-id", "authorization"},
+			DenyHeaders:  []string{"Authorization"},
+		})
+		if err != nil {
+			t.Fatalf("unable to create policy: %s", err)
+		}
+
+		var tests = []struct {
+			p      *RedactionPolicy
+			header string
+			want   bool
+		}{
+			{nil, "Authorization", false},
+			{nil, "cookie", false},
+			{nil, "X-Request-ID", true},
+			{DefaultRedactionPolicy(), "X-API-KEY", false},
+			{DefaultRedactionPolicy(), "Content-Type", true},
+			{allow, "Content-Type", true},
+			{allow, "x-request-id", true},
+			{allow, "Authorization", false},
+			{allow, "User-Agent", false},
+		}
+
+		for _, tt := range tests {
+			if got := tt.p.HeaderAllowed(tt.header); got != tt.want {
+				t.Errorf("HeaderAllowed(%s) = %v, want %v", tt.header, got, tt.want)
+			}
+		}
+
+		tracer := mocktracer.New()
+		span := tracer.StartSpan("test")
+		var p *RedactionPolicy
+		p.TagHeaders(span, map[string][]string{
+			"Authorization": {"Bearer secret"},
+			"Content-Type":  {"application/json"},
+		})
+		span.Finish()
+
+		tags := tracer.FinishedSpans()[0].Tags()
+		if got := tags["header.Authorization"]; !reflect.DeepEqual(got, []string{DefaultMask}) {
+			t.Errorf("header.Authorization tag is %v, want it masked", got)
+		}
+		if got := tags["header.Content-Type"]; !reflect.DeepEqual(got, []string{"application/json"}) {
+			t.Errorf("header.Content-Type tag is %v, want it verbatim", got)
+		}
+	})
+
+	t.Run("Fields", func(t *testing.T) {
+		p, err := NewRedactionPolicy(RedactionPolicy{
+			MaskFields: []string{"$.data[*].desc", "password", "*.token"},
+			Mask:       "***",
+		})
+		if err != nil {
+			t.Fatalf("unable to create policy: %s", err)
+		}
+
+		data := []*redactTestItem{{"banana", "yellow"}, {"apple", "red"}}
+		want := []interface{}{
+			map[string]interface{}{"name": "banana", "desc": "***"},
+			map[string]interface{}{"name": "apple", "desc": "***"},
+		}
+
+		got := p.Fields([]interface{}{
+			"data", data,
+			"password", "hunter2",
+			"session", map[string]string{"token": "abc", "user": "bob"},
+			"count", 2,
+		})
+		if !reflect.DeepEqual(got[1], want) {
+			t.Errorf("data logged as %v, want %v", got[1], want)
+		}
+		if got[3] != "***" {
+			t.Errorf("password logged as %v, want it masked", got[3])
+		}
+		if !reflect.DeepEqual(got[5], map[string]interface{}{"token": "***", "user": "bob"}) {
+			t.Errorf("session logged as %v, want token masked", got[5])
+		}
+		if got[7] != 2 {
+			t.Errorf("count logged as %v, want it untouched", got[7])
+		}
+
+		// The original value must not be modified
+		if data[0].Desc != "yellow" {
+			t.Errorf("Fields modified the logged value")
+		}
+
+		v := p.Value(map[string]interface{}{"data": data})
+		if !reflect.DeepEqual(v, map[string]interface{}{"data": want}) {
+			t.Errorf("Value returned %v, want %v", v, want)
+		}
+
+		if v := DefaultRedactionPolicy().Value(data); !reflect.DeepEqual(v, data) {
+			t.Errorf("default policy shouldn't mask fields, got %v", v)
+		}
+	})
+
+	t.Run("Invalid paths", func(t *testing.T) {
+		for _, f := range []string{"", "$", "data..desc"} {
+			if _, err := NewRedactionPolicy(RedactionPolicy{MaskFields: []string{f}}); err == nil {
+				t.Errorf("expected error for field path %#v", f)
+			}
+		}
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "redact")
+		if err != nil {
+			t.Fatalf("unable to create temp dir: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "redaction.json")
+		cfg := `{"denyHeaders": ["X-Secret"], "maskFields": ["data[*].desc"]}`
+		if err := ioutil.WriteFile(file, []byte(cfg), 0600); err != nil {
+			t.Fatalf("unable to write policy: %s", err)
+		}
+
+		p, err := LoadRedactionPolicy(file)
+		if err != nil {
+			t.Fatalf("unable to load policy: %s", err)
+		}
+		if p.HeaderAllowed("x-secret") {
+			t.Errorf("loaded policy allows denied header")
+		}
+		if p.Mask != DefaultMask {
+			t.Errorf("loaded policy has mask %s, want %s", p.Mask, DefaultMask)
+		}
+
+		if _, err := LoadRedactionPolicy(filepath.Join(dir, "missing.json")); err == nil {
+			t.Errorf("expected error for missing file")
+		}
+		if err := ioutil.WriteFile(file, []byte("{"), 0600); err != nil {
+			t.Fatalf("unable to write policy: %s", err)
+		}
+		if _, err := LoadRedactionPolicy(file); err == nil {
+			t.Errorf("expected error for invalid JSON")
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of Redis commands, used as outcome label of redis_command_duration_seconds.
+const (
+	RedisSuccess = "success"
+	// RedisNil commands found no value, e.g. GET of a missing key.
+	RedisNil   = "nil"
+	RedisError = "error"
+)
+
+// RedisPipeline is the command label of pipelines, which are traced and timed as a whole.
+const RedisPipeline = "pipeline"
+
+// redisStatementMaxLen is the length db.statement tags are truncated to, so large values don't
+// bloat spans.
+const redisStatementMaxLen = 512
+
+// DefaultRedisDurationBuckets are the default buckets for Redis command latencies in seconds.
+var DefaultRedisDurationBuckets = []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// redisHooks is implemented by redis.Client and redis.Tx, so both can be instrumented.
+type redisHooks interface {
+	WrapProcess(fn func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error)
+	WrapProcessPipeline(fn func(old func([]redis.Cmder) error) func([]redis.Cmder) error)
+}
+
+// RedisMetrics holds the latencies of Redis commands sent by a service and the statistics of its
+// connection pool.
+type RedisMetrics struct {
+	Duration *prometheus.HistogramVec
+	pool     *redisPoolCollector
+}
+
+// NewRedisMetrics creates the RedisMetrics of a service using client.
+func NewRedisMetrics(serviceName string, client *redis.Client, durationBuckets []float64) *RedisMetrics {
+	return &RedisMetrics{
+		Duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "redis_command_duration_seconds",
+				Help:        "A histogram for latencies of Redis commands, pipelines are observed as a whole.",
+				Buckets:     durationBuckets,
+				ConstLabels: prometheus.Labels{"service": serviceName},
+			},
+			[]string{"command", "outcome"},
+		),
+		pool: newRedisPoolCollector(serviceName, client),
+	}
+}
+
+// Collectors returns all metrics, ready to be registered with a prometheus.Registerer.
+func (rm *RedisMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{rm.Duration, rm.pool}
+}
+
+// Observe records a command, or pipeline, sent to Redis, which failed with err.
+func (rm *RedisMetrics) Observe(command string, err error, d time.Duration) {
+	rm.Duration.WithLabelValues(command, redisOutcome(err)).Observe(d.Seconds())
+}
+
+// redisPoolCollector exports the PoolStats of a client when collected.
+type redisPoolCollector struct {
+	client                                     *redis.Client
+	hits, misses, timeouts, conns, idle, stale *prometheus.Desc
+}
+
+func newRedisPoolCollector(serviceName string, client *redis.Client) *redisPoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, nil, prometheus.Labels{"service": serviceName})
+	}
+	return &redisPoolCollector{
+		client:   client,
+		hits:     desc("redis_pool_hits_total", "A counter for free connections found in the Redis connection pool."),
+		misses:   desc("redis_pool_misses_total", "A counter for connections dialed since none was free in the Redis connection pool."),
+		timeouts: desc("redis_pool_timeouts_total", "A counter for timeouts waiting for a connection of the Redis connection pool."),
+		conns:    desc("redis_pool_connections", "A gauge of connections in the Redis connection pool."),
+		idle:     desc("redis_pool_idle_connections", "A gauge of idle connections in the Redis connection pool."),
+		stale:    desc("redis_pool_stale_connections_total", "A counter for stale connections removed from the Redis connection pool."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.hits, c.misses, c.timeouts, c.conns, c.idle, c.stale} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.client.PoolStats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.stale, prometheus.CounterValue, float64(s.StaleConns))
+}
+
+// startRedisSpan starts the span of a command, or pipeline, sending cmds to the Redis of opt as
+// child of the span of ctx. It returns nil if there is none, so commands outside of requests,
+// e.g. readiness pings, don't start traces of their own.
+func startRedisSpan(ctx context.Context, command string, cmds []redis.Cmder, opt *redis.Options) (ot.Span, context.Context) {
+	parent := ot.SpanFromContext(ctx)
+	if parent == nil {
+		return nil, ctx
+	}
+
+	span := parent.Tracer().StartSpan("redis."+command, ot.ChildOf(parent.Context()), ext.SpanKindRPCClient)
+	ext.Component.Set(span, "go-redis")
+	ext.DBType.Set(span, "redis")
+	ext.DBInstance.Set(span, strconv.Itoa(opt.DB))
+	ext.DBStatement.Set(span, redisStatement(cmds))
+	ext.PeerAddress.Set(span, opt.Addr)
+	if command == RedisPipeline {
+		span.SetTag("db.redis.num_cmd", len(cmds))
+	}
+	return span, ot.ContextWithSpan(ctx, span)
+}
+
+// finishRedisSpan marks the span as failed unless err is nil or redis.Nil, then finishes it.
+func finishRedisSpan(span ot.Span, err error) {
+	if span == nil {
+		return
+	}
+	if redisOutcome(err) == RedisError {
+		ext.Error.Set(span, true)
+		span.LogKV(
+			"event", "error",
+			"message", err.Error(),
+		)
+	}
+	span.Finish()
+}
+
+// redisOutcome classifies the error of a command.
+func redisOutcome(err error) string {
+	switch err {
+	case nil:
+		return RedisSuccess
+	case redis.Nil:
+		return RedisNil
+	default:
+		return RedisError
+	}
+}
+
+// redisStatement formats cmds with their arguments, one per line, truncated to
+// redisStatementMaxLen.
+func redisStatement(cmds []redis.Cmder) string {
+	var b strings.Builder
+	for n, cmd := range cmds {
+		if n > 0 {
+			b.WriteByte('\n')
+		}
+		for i, arg := range cmd.Args() {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprint(&b, arg)
+		}
+		if b.Len() > redisStatementMaxLen {
+			break
+		}
+	}
+
+	s := b.String()
+	if len(s) > redisStatementMaxLen {
+		s = s[:redisStatementMaxLen] + "..."
+	}
+	return s
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRedisInstrumentation(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unable to start miniredis: %s", err)
+	}
+	defer mr.Close()
+
+	s, err := helperTestService("redis://"+mr.Addr(), t)
+	if err != nil {
+		t.Fatalf("unable to create service: %s", err)
+	}
+	s.InitPromReg()
+
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("RedisGetItem")
+	ctx := ot.ContextWithSpan(httptest.NewRequest("GET", "/", nil).Context(), parent)
+
+	rc := s.RedisContext(ctx)
+	if err := rc.Set("key", "value", 0).Err(); err != nil {
+		t.Fatalf("SET returned %s", err)
+	}
+	if err := rc.Get("missing").Err(); err != redis.Nil {
+		t.Errorf("GET of missing key returned %v, want redis.Nil", err)
+	}
+	if _, err := rc.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Get("key")
+		pipe.Incr("key")
+		return nil
+	}); err == nil {
+		t.Errorf("expected error incrementing a string")
+	}
+	err = s.RedisWatch(ctx, func(tx *redis.Tx) error {
+		_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Set("other", "value", 0)
+			return nil
+		})
+		return err
+	}, "other")
+	if err != nil {
+		t.Errorf("transaction returned %s", err)
+	}
+	if err := s.Redis.Ping().Err(); err != nil {
+		t.Errorf("PING returned %s", err)
+	}
+	parent.Finish()
+
+	t.Run("Spans", func(t *testing.T) {
+		var tests = []struct {
+			name      string
+			statement string
+			failed    bool
+		}{
+			{"redis.set", "set key value", false},
+			{"redis.get", "get missing", false},
+			{"redis.pipeline", "get key\nincr key", true},
+			{"redis.pipeline", "set other value", false},
+			{"redis.unwatch", "unwatch", false},
+			{"RedisGetItem", "", false},
+		}
+
+		spans := tracer.FinishedSpans()
+		if len(spans) != len(tests) {
+			t.Fatalf("%d spans finished, want %d", len(spans), len(tests))
+		}
+		for n, tt := range tests {
+			span := spans[n]
+			if span.OperationName != tt.name {
+				t.Errorf("span %d is %s, want %s", n, span.OperationName, tt.name)
+				continue
+			}
+			if tt.statement == "" {
+				continue
+			}
+			if span.ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+				t.Errorf("%s isn't a child of the span of the context", span.OperationName)
+			}
+			if got := span.Tag(string(ext.DBStatement)); got != tt.statement {
+				t.Errorf("%s: db.statement is %#v, want %#v", span.OperationName, got, tt.statement)
+			}
+			if got := span.Tag(string(ext.DBType)); got != "redis" {
+				t.Errorf("%s: db.type is %#v, want redis", span.OperationName, got)
+			}
+			if failed, _ := span.Tag(string(ext.Error)).(bool); failed != tt.failed {
+				t.Errorf("%s: error is %v, want %v", span.OperationName, failed, tt.failed)
+			}
+		}
+	})
+
+	t.Run("Statement", func(t *testing.T) {
+		cmd := redis.NewStatusCmd("set", "key", strings.Repeat("a", 2*redisStatementMaxLen))
+		if s := redisStatement([]redis.Cmder{cmd}); len(s) != redisStatementMaxLen+3 || !strings.HasSuffix(s, "...") {
+			t.Errorf("statement of %d bytes wasn't truncated", len(s))
+		}
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		if got := testutil.CollectAndCount(s.redisMetrics.Duration); got != 6 {
+			t.Errorf("redis_command_duration_seconds has %d series, want 6", got)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("/metrics returned %d", w.Code)
+		}
+		for _, want := range []string{
+			`redis_command_duration_seconds_count{command="set",outcome="success",service="test"} 1`,
+			`redis_command_duration_seconds_count{command="get",outcome="nil",service="test"} 1`,
+			`redis_command_duration_seconds_count{command="pipeline",outcome="error",service="test"} 1`,
+			`redis_command_duration_seconds_count{command="pipeline",outcome="success",service="test"} 1`,
+			`redis_command_duration_seconds_count{command="ping",outcome="success",service="test"} 1`,
+			`redis_pool_hits_total{service="test"}`,
+			`redis_pool_misses_total{service="test"}`,
+			`redis_pool_timeouts_total{service="test"} 0`,
+			`redis_pool_idle_connections{service="test"}`,
+			`redis_pool_connections{service="test"}`,
+		} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("metrics are missing %s", want)
+			}
+		}
+	})
+}
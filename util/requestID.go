@@ -5,11 +5,17 @@ import (
 	"net/http"
 
 	"github.com/gofrs/uuid"
+	ot "github.com/opentracing/opentracing-go"
 )
 
 type key int
 
-const requestIDKey key = iota
+const (
+	requestIDKey key = iota
+	routeKey
+	principalKey
+	requestLogKey
+)
 
 // RequestIDFromContext extracts a Request ID from a context.
 func RequestIDFromContext(ctx context.Context) string {
@@ -29,7 +35,6 @@ func AssignRequestID(inner http.Handler, logger *Logger) http.HandlerFunc {
 		ctx := r.Context()
 		if reqID == "" {
 			reqID = uuid.Must(uuid.NewV4()).String()
-			ctx = context.WithValue(ctx, requestIDKey, reqID)
 			logger.Debugw("assigned new requestID",
 				"requestID", reqID,
 			)
@@ -39,6 +44,10 @@ func AssignRequestID(inner http.Handler, logger *Logger) http.HandlerFunc {
 				"requestID", reqID,
 			)
 		}
+		if span := ot.SpanFromContext(ctx); span != nil {
+			span.SetTag("requestID", reqID)
+		}
+		ctx = context.WithValue(ctx, requestIDKey, reqID)
 		w.Header().Set("X-Request-ID", reqID)
 		inner.ServeHTTP(w, r.WithContext(ctx))
 	})
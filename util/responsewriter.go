@@ -0,0 +1,86 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ResponseWriter wraps a http.ResponseWriter and records the status code and size of the response,
+// so metrics, tracing and logging middlewares can observe the outcome of a request.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// WrapResponseWriter returns w as ResponseWriter. If w has already been wrapped by an outer
+// middleware, the existing ResponseWriter is returned so all middlewares share the same view.
+func WrapResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	if rw, ok := w.(*ResponseWriter); ok {
+		return rw
+	}
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+// WriteHeader records and sends the status code. Only the first call has an effect on the recorded
+// status, mirroring net/http.
+func (rw *ResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends b as part of the response body and records its size.
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += n
+	return n, err
+}
+
+// Status returns the status code sent, or 200 if nothing has been written yet, since net/http
+// will send that once the handler returns.
+func (rw *ResponseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Size returns the number of body bytes written.
+func (rw *ResponseWriter) Size() int {
+	return rw.size
+}
+
+// Written returns true if the status code has already been sent.
+func (rw *ResponseWriter) Written() bool {
+	return rw.status != 0
+}
+
+// Flush sends any buffered data to the client, if supported by the wrapped http.ResponseWriter.
+func (rw *ResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, if supported by the wrapped http.ResponseWriter.
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.ResponseWriter doesn't support hijacking")
+	}
+	return h.Hijack()
+}
+
+// StatusClass returns the class of a status code, e.g. 2xx for 201.
+func StatusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
@@ -2,12 +2,34 @@ package util
 
 import "net/http"
 
-//Route defines a specific route
+// Route defines a specific route
 type Route struct {
 	Name        string
 	Method      string
 	Pattern     string
 	HandlerFunc http.HandlerFunc
+
+	// Summary is a short description of the route used in the OpenAPI document.
+	Summary string
+
+	// Request is a value of the type expected as JSON request body, nil if the route takes none.
+	Request interface{}
+
+	// Query lists the optional query parameters of the route for the OpenAPI document.
+	Query []Parameter
+
+	// Responses maps each status code the route may send to a value of the response body type.
+	// A string value describes a plain text body, a Problem an RFC 7807 body and nil an empty body.
+	Responses map[int]interface{}
+
+	// Access declares who may call the route, see AuthMiddleware.
+	Access
+
+	// RateLimit overrides the default rate limit of the service for the route, if enabled.
+	RateLimit RateLimit
+
+	// Unlimited routes, e.g. health checks, are neither rate limited nor shed, see LimitPolicy.
+	Unlimited bool
 }
 
 // Routes defines a slice of all available API Routes
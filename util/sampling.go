@@ -0,0 +1,434 @@
+package util
+
+import (
+	"context"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/samplers/jaegerremote"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sampler types, named like the ones of jaeger-client-go.
+const (
+	// SamplerConst samples all traces if the param is 1 and none if it is 0.
+	SamplerConst = "const"
+	// SamplerProbabilistic samples traces with the probability set by the param, between 0 and 1.
+	SamplerProbabilistic = "probabilistic"
+	// SamplerRateLimiting samples up to param traces per second.
+	SamplerRateLimiting = "ratelimiting"
+	// SamplerRemote fetches the sampling strategy from a Jaeger agent or collector.
+	SamplerRemote = "remote"
+)
+
+// Per-route sampling overrides.
+const (
+	// SampleAlways samples every request of a route.
+	SampleAlways = "always"
+	// SampleNever samples no request of a route, not even failing ones.
+	SampleNever = "never"
+)
+
+// DefaultSamplingOverrides never samples health checks. Metric scrapes aren't traced at all.
+var DefaultSamplingOverrides = map[string]string{
+	"/healthz": SampleNever,
+	"/livez":   SampleNever,
+	"/readyz":  SampleNever,
+}
+
+// SamplerConfig selects how traces started by a service are sampled.
+type SamplerConfig struct {
+	// Type is one of const, probabilistic, ratelimiting or remote. If empty, the sampler is taken
+	// from the JAEGER_SAMPLER_* environment variables, falling back to sampling all traces.
+	Type string
+
+	// Param is passed to the sampler, see the sampler types.
+	Param float64
+
+	// ServerURL is the sampling endpoint queried by the remote sampler, e.g.
+	// http://127.0.0.1:5778/sampling.
+	ServerURL string
+}
+
+// CheckSamplerConfig returns an error if the type or param of cfg are invalid.
+func CheckSamplerConfig(cfg SamplerConfig) error {
+	switch cfg.Type {
+	case "", SamplerRemote:
+		return nil
+	case SamplerConst:
+		if cfg.Param != 0 && cfg.Param != 1 {
+			return errors.Errorf("param of sampler %s must be 0 or 1, got %v", cfg.Type, cfg.Param)
+		}
+	case SamplerProbabilistic:
+		if cfg.Param < 0 || cfg.Param > 1 {
+			return errors.Errorf("param of sampler %s must be between 0 and 1, got %v", cfg.Type, cfg.Param)
+		}
+	case SamplerRateLimiting:
+		if cfg.Param < 0 {
+			return errors.Errorf("param of sampler %s must not be negative, got %v", cfg.Type, cfg.Param)
+		}
+	default:
+		return errors.Errorf("invalid sampler type %#v, must be one of %s, %s, %s or %s", cfg.Type, SamplerConst, SamplerProbabilistic, SamplerRateLimiting, SamplerRemote)
+	}
+	return nil
+}
+
+// resolveSamplerConfig fills an unset sampler from the JAEGER_SAMPLER_* environment variables, or
+// samples all traces if those are unset as well.
+func resolveSamplerConfig(cfg SamplerConfig) (SamplerConfig, error) {
+	if cfg.Type == "" {
+		cfg.Type = strings.ToLower(os.Getenv("JAEGER_SAMPLER_TYPE"))
+		if e := os.Getenv("JAEGER_SAMPLER_PARAM"); e != "" {
+			p, err := strconv.ParseFloat(e, 64)
+			if err != nil {
+				return cfg, errors.Wrapf(err, "cannot parse JAEGER_SAMPLER_PARAM=%s", e)
+			}
+			cfg.Param = p
+		}
+	}
+	if cfg.ServerURL == "" {
+		cfg.ServerURL = os.Getenv("JAEGER_SAMPLER_MANAGER_HOST_PORT")
+	}
+
+	if cfg.Type == "" {
+		cfg.Type = SamplerConst
+		cfg.Param = 1
+	}
+	return cfg, CheckSamplerConfig(cfg)
+}
+
+// newOTelSampler returns the OpenTelemetry equivalent of a jaeger-client-go sampler. Spans with a
+// sampling.priority tag are sampled according to it, like jaeger-client-go does.
+func newOTelSampler(serviceName string, cfg SamplerConfig) (sdktrace.Sampler, error) {
+	var root sdktrace.Sampler
+	switch cfg.Type {
+	case SamplerConst:
+		root = sdktrace.NeverSample()
+		if cfg.Param == 1 {
+			root = sdktrace.AlwaysSample()
+		}
+	case SamplerProbabilistic:
+		root = sdktrace.TraceIDRatioBased(cfg.Param)
+	case SamplerRateLimiting:
+		root = newRateLimitingSampler(cfg.Param)
+	case SamplerRemote:
+		opts := []jaegerremote.Option{jaegerremote.WithInitialSampler(sdktrace.TraceIDRatioBased(0.001))}
+		if cfg.ServerURL != "" {
+			opts = append(opts, jaegerremote.WithSamplingServerURL(cfg.ServerURL))
+		}
+		root = jaegerremote.New(serviceName, opts...)
+	default:
+		return nil, CheckSamplerConfig(cfg)
+	}
+
+	return prioritySampler{sdktrace.ParentBased(root)}, nil
+}
+
+// rateLimitingSampler samples up to a fixed number of traces per second using a token bucket.
+type rateLimitingSampler struct {
+	mu       sync.Mutex
+	rate     float64
+	balance  float64
+	lastTick time.Time
+}
+
+func newRateLimitingSampler(tracesPerSecond float64) *rateLimitingSampler {
+	return &rateLimitingSampler{
+		rate:     tracesPerSecond,
+		balance:  math.Max(tracesPerSecond, 1),
+		lastTick: time.Now(),
+	}
+}
+
+// ShouldSample implements sdktrace.Sampler.
+func (s *rateLimitingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.balance = math.Min(s.balance+now.Sub(s.lastTick).Seconds()*s.rate, math.Max(s.rate, 1))
+	s.lastTick = now
+
+	decision := sdktrace.Drop
+	if s.balance >= 1 {
+		s.balance--
+		decision = sdktrace.RecordAndSample
+	}
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+// Description implements sdktrace.Sampler.
+func (s *rateLimitingSampler) Description() string {
+	return "RateLimitingSampler{" + strconv.FormatFloat(s.rate, 'g', -1, 64) + "}"
+}
+
+// prioritySampler applies the sampling.priority tag set when starting a span and otherwise asks
+// the wrapped sampler. Spans which aren't sampled are still recorded, so a trace can be exported
+// if sampling.priority is raised later, see errorSpanProcessor.
+type prioritySampler struct {
+	sdktrace.Sampler
+}
+
+// ShouldSample implements sdktrace.Sampler.
+func (s prioritySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	tracestate := trace.SpanContextFromContext(p.ParentContext).TraceState()
+	for _, a := range p.Attributes {
+		if string(a.Key) != string(ext.SamplingPriority) {
+			continue
+		}
+		if a.Value.AsInt64() > 0 {
+			return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample, Tracestate: tracestate}
+		}
+		return sdktrace.SamplingResult{Decision: sdktrace.Drop, Tracestate: tracestate}
+	}
+
+	// Children of spans which aren't recorded at all can be dropped right away
+	if parent := trace.SpanFromContext(p.ParentContext); parent.SpanContext().IsValid() && !parent.SpanContext().IsRemote() && !parent.IsRecording() {
+		return sdktrace.SamplingResult{Decision: sdktrace.Drop, Tracestate: tracestate}
+	}
+
+	res := s.Sampler.ShouldSample(p)
+	if res.Decision == sdktrace.Drop {
+		res.Decision = sdktrace.RecordOnly
+	}
+	return res
+}
+
+// Description implements sdktrace.Sampler.
+func (s prioritySampler) Description() string {
+	return "PrioritySampler{" + s.Sampler.Description() + "}"
+}
+
+// errorSpanProcessor passes sampled spans on to the next processor. Spans which are only recorded
+// are held back until the local root span of their trace ends. If that root span has a positive
+// sampling.priority, e.g. because the request failed, all of them are passed on as sampled.
+type errorSpanProcessor struct {
+	next sdktrace.SpanProcessor
+
+	mu      sync.Mutex
+	rootOf  map[trace.SpanID]trace.SpanID
+	pending map[trace.SpanID][]sdktrace.ReadOnlySpan
+}
+
+func newErrorSpanProcessor(next sdktrace.SpanProcessor) *errorSpanProcessor {
+	return &errorSpanProcessor{
+		next:    next,
+		rootOf:  make(map[trace.SpanID]trace.SpanID),
+		pending: make(map[trace.SpanID][]sdktrace.ReadOnlySpan),
+	}
+}
+
+// OnStart implements sdktrace.SpanProcessor.
+func (p *errorSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+	if s.SpanContext().IsSampled() {
+		return
+	}
+
+	id := s.SpanContext().SpanID()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The OpenTracing bridge marks every parent as remote, so a span is only known to be a local
+	// child if its parent has been recorded here
+	if s.Parent().IsValid() {
+		if root, ok := p.rootOf[s.Parent().SpanID()]; ok {
+			p.rootOf[id] = root
+			return
+		}
+	}
+	p.rootOf[id] = id
+	p.pending[id] = nil
+}
+
+// OnEnd implements sdktrace.SpanProcessor.
+func (p *errorSpanProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+
+	id := s.SpanContext().SpanID()
+	p.mu.Lock()
+	root, ok := p.rootOf[id]
+	delete(p.rootOf, id)
+	spans, active := p.pending[root]
+	if !ok || !active {
+		p.mu.Unlock()
+		return
+	}
+	if root != id {
+		p.pending[root] = append(spans, s)
+		p.mu.Unlock()
+		return
+	}
+	delete(p.pending, root)
+	p.mu.Unlock()
+
+	if !raisedPriority(s) {
+		return
+	}
+	for _, span := range append(spans, s) {
+		p.next.OnEnd(sampledSpan{span})
+	}
+}
+
+// Shutdown implements sdktrace.SpanProcessor.
+func (p *errorSpanProcessor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
+
+// ForceFlush implements sdktrace.SpanProcessor.
+func (p *errorSpanProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+// raisedPriority returns true if a span has been tagged with a positive sampling.priority.
+func raisedPriority(s sdktrace.ReadOnlySpan) bool {
+	for _, a := range s.Attributes() {
+		if string(a.Key) == string(ext.SamplingPriority) {
+			return a.Value.AsInt64() > 0
+		}
+	}
+	return false
+}
+
+// sampledSpan marks a recorded span as sampled, so processors and exporters pass it on.
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+// SpanContext returns the span context with the sampled flag set.
+func (s sampledSpan) SpanContext() trace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
+
+// SamplingPolicy adjusts the sampling decision of the root span per route and reports the
+// decisions as trace_sampling_decisions_total.
+type SamplingPolicy struct {
+	// Overrides maps route patterns or names to either always or never.
+	Overrides map[string]string
+
+	// Errors samples requests failing with a server error, even if their trace wasn't sampled.
+	// Only spans of the failing service are exported then: all of them with the otlp backend and
+	// the root span with the jaeger backend.
+	Errors bool
+
+	decisions *prometheus.CounterVec
+}
+
+// NewSamplingPolicy returns a SamplingPolicy using DefaultSamplingOverrides which always samples
+// errors.
+func NewSamplingPolicy(serviceName string) *SamplingPolicy {
+	overrides := make(map[string]string)
+	for k, v := range DefaultSamplingOverrides {
+		overrides[k] = v
+	}
+
+	return &SamplingPolicy{
+		Overrides: overrides,
+		Errors:    true,
+		decisions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "trace_sampling_decisions_total",
+				Help:        "Sampling decisions of request root spans by route.",
+				ConstLabels: prometheus.Labels{"service": serviceName},
+			},
+			[]string{"route", "decision"},
+		),
+	}
+}
+
+// ParseSamplingOverrides parses comma-separated route=always|never pairs, e.g.
+// /healthz=never,/orders/create=always.
+func ParseSamplingOverrides(s string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.Errorf("invalid sampling override %#v, must be route=%s or route=%s", pair, SampleAlways, SampleNever)
+		}
+		route, decision := strings.TrimSpace(kv[0]), strings.ToLower(strings.TrimSpace(kv[1]))
+		if decision != SampleAlways && decision != SampleNever {
+			return nil, errors.Errorf("invalid sampling override %#v, must be route=%s or route=%s", pair, SampleAlways, SampleNever)
+		}
+		overrides[route] = decision
+	}
+	return overrides, nil
+}
+
+// Collectors returns the Prometheus collectors of the policy for registration.
+func (p *SamplingPolicy) Collectors() []prometheus.Collector {
+	if p == nil || p.decisions == nil {
+		return nil
+	}
+	return []prometheus.Collector{p.decisions}
+}
+
+// override returns the override of a route, looked up by pattern first and name second.
+func (p *SamplingPolicy) override(route Route) string {
+	if p == nil {
+		return ""
+	}
+	if o, ok := p.Overrides[route.Pattern]; ok {
+		return o
+	}
+	return p.Overrides[route.Name]
+}
+
+// StartOptions returns the options to start the root span of route with.
+func (p *SamplingPolicy) StartOptions(route Route) []ot.StartSpanOption {
+	switch p.override(route) {
+	case SampleAlways:
+		return []ot.StartSpanOption{ot.Tag{Key: string(ext.SamplingPriority), Value: uint16(1)}}
+	case SampleNever:
+		return []ot.StartSpanOption{ot.Tag{Key: string(ext.SamplingPriority), Value: uint16(0)}}
+	}
+	return nil
+}
+
+// Finish raises the sampling priority of a failed request if errors are sampled and records the
+// final sampling decision of the root span.
+func (p *SamplingPolicy) Finish(span ot.Span, route Route, status int) {
+	if p == nil {
+		return
+	}
+
+	sampled := isSampled(span)
+	if !sampled && p.Errors && status >= 500 && p.override(route) != SampleNever {
+		ext.SamplingPriority.Set(span, 1)
+		sampled = true
+	}
+
+	if p.decisions != nil {
+		decision := "not_sampled"
+		if sampled {
+			decision = "sampled"
+		}
+		p.decisions.With(prometheus.Labels{"route": route.Name, "decision": decision}).Inc()
+	}
+}
+
+// isSampled returns true if the span context of either tracing backend is sampled.
+func isSampled(span ot.Span) bool {
+	sc, ok := span.Context().(interface{ IsSampled() bool })
+	return ok && sc.IsSampled()
+}
@@ -0,0 +1,265 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// helperServeTraced serves a request to route through TracerMiddleware and returns the sampling
+// state of the root span. The handler starts a child span and responds with status.
+func helperServeTraced(tracer ot.Tracer, route Route, policy *SamplingPolicy, status int, t *testing.T) bool {
+	prev := ot.GlobalTracer()
+	ot.SetGlobalTracer(tracer)
+	defer ot.SetGlobalTracer(prev)
+
+	var root ot.Span
+	h := TracerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		root = ot.SpanFromContext(r.Context())
+		span, _ := ot.StartSpanFromContext(r.Context(), "handler")
+		span.Finish()
+		w.WriteHeader(status)
+	}), route, nil, policy)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(route.Method, route.Pattern, nil))
+
+	if root == nil {
+		t.Fatalf("no root span was started for %s", route.Pattern)
+	}
+	return isSampled(root)
+}
+
+func TestSamplerConfig(t *testing.T) {
+	var tests = []struct {
+		cfg   SamplerConfig
+		valid bool
+	}{
+		{SamplerConfig{}, true},
+		{SamplerConfig{Type: SamplerConst, Param: 0}, true},
+		{SamplerConfig{Type: SamplerConst, Param: 0.5}, false},
+		{SamplerConfig{Type: SamplerProbabilistic, Param: 0.1}, true},
+		{SamplerConfig{Type: SamplerProbabilistic, Param: 2}, false},
+		{SamplerConfig{Type: SamplerRateLimiting, Param: 10}, true},
+		{SamplerConfig{Type: SamplerRateLimiting, Param: -1}, false},
+		{SamplerConfig{Type: SamplerRemote, ServerURL: "http://127.0.0.1:5778/sampling"}, true},
+		{SamplerConfig{Type: "adaptive"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := CheckSamplerConfig(tt.cfg); (err == nil) != tt.valid {
+			t.Errorf("CheckSamplerConfig(%+v) returned error %v, want valid: %v", tt.cfg, err, tt.valid)
+		}
+	}
+
+	t.Run("Environment", func(t *testing.T) {
+		defer os.Unsetenv("JAEGER_SAMPLER_TYPE")
+		defer os.Unsetenv("JAEGER_SAMPLER_PARAM")
+
+		cfg, err := resolveSamplerConfig(SamplerConfig{})
+		if err != nil {
+			t.Fatalf("unable to resolve sampler: %s", err)
+		}
+		if want := (SamplerConfig{Type: SamplerConst, Param: 1}); cfg != want {
+			t.Errorf("default sampler is %+v, want %+v", cfg, want)
+		}
+
+		os.Setenv("JAEGER_SAMPLER_TYPE", "Probabilistic")
+		os.Setenv("JAEGER_SAMPLER_PARAM", "0.25")
+		cfg, err = resolveSamplerConfig(SamplerConfig{})
+		if err != nil {
+			t.Fatalf("unable to resolve sampler: %s", err)
+		}
+		if want := (SamplerConfig{Type: SamplerProbabilistic, Param: 0.25}); cfg != want {
+			t.Errorf("sampler from environment is %+v, want %+v", cfg, want)
+		}
+
+		// Explicitly set samplers take precedence
+		cfg, _ = resolveSamplerConfig(SamplerConfig{Type: SamplerRateLimiting, Param: 5})
+		if want := (SamplerConfig{Type: SamplerRateLimiting, Param: 5}); cfg != want {
+			t.Errorf("sampler is %+v, want %+v", cfg, want)
+		}
+
+		os.Setenv("JAEGER_SAMPLER_PARAM", "often")
+		if _, err := resolveSamplerConfig(SamplerConfig{}); err == nil {
+			t.Errorf("expected error for invalid JAEGER_SAMPLER_PARAM")
+		}
+	})
+}
+
+func TestOTelSampler(t *testing.T) {
+	var tests = []struct {
+		cfg  SamplerConfig
+		want []sdktrace.SamplingDecision
+	}{
+		{SamplerConfig{Type: SamplerConst, Param: 1}, []sdktrace.SamplingDecision{sdktrace.RecordAndSample, sdktrace.RecordAndSample}},
+		{SamplerConfig{Type: SamplerConst, Param: 0}, []sdktrace.SamplingDecision{sdktrace.RecordOnly, sdktrace.RecordOnly}},
+		{SamplerConfig{Type: SamplerProbabilistic, Param: 0}, []sdktrace.SamplingDecision{sdktrace.RecordOnly, sdktrace.RecordOnly}},
+		{SamplerConfig{Type: SamplerRateLimiting, Param: 1}, []sdktrace.SamplingDecision{sdktrace.RecordAndSample, sdktrace.RecordOnly}},
+	}
+
+	for _, tt := range tests {
+		sampler, err := newOTelSampler("test", tt.cfg)
+		if err != nil {
+			t.Fatalf("unable to create sampler %+v: %s", tt.cfg, err)
+		}
+
+		for i, want := range tt.want {
+			p := sdktrace.SamplingParameters{
+				ParentContext: t.Context(),
+				TraceID:       trace.TraceID{byte(i + 1)},
+				Name:          "test",
+			}
+			if got := sampler.ShouldSample(p).Decision; got != want {
+				t.Errorf("sampler %+v decided %v for trace %d, want %v", tt.cfg, got, i, want)
+			}
+		}
+	}
+}
+
+func TestSamplingPolicy(t *testing.T) {
+	health := Route{Name: "healthz", Method: "GET", Pattern: "/healthz"}
+	items := Route{Name: "getAllItems", Method: "GET", Pattern: "/items"}
+
+	t.Run("Overrides", func(t *testing.T) {
+		for _, backend := range []string{TracingJaeger, TracingOTLP} {
+			tracer, closer := helperNewTracer(backend, nil, t)
+			defer closer.Close()
+
+			policy := NewSamplingPolicy("test")
+			policy.Overrides[items.Name] = SampleNever
+			if helperServeTraced(tracer, health, policy, http.StatusOK, t) {
+				t.Errorf("%s sampled %s", backend, health.Pattern)
+			}
+			if helperServeTraced(tracer, items, policy, http.StatusInternalServerError, t) {
+				t.Errorf("%s sampled failing %s overridden by name", backend, items.Pattern)
+			}
+
+			policy.Overrides[items.Name] = SampleAlways
+			if !helperServeTraced(tracer, items, policy, http.StatusOK, t) {
+				t.Errorf("%s didn't sample %s", backend, items.Pattern)
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		l, _ := NewLogger("error", "test")
+		exporter := tracetest.NewInMemoryExporter()
+		cfg := TracerConfig{Sampler: SamplerConfig{Type: SamplerConst, Param: 0}}
+		tracer, closer, err := InitOTelTracer("test", cfg, l, sdktrace.NewSimpleSpanProcessor(exporter))
+		if err != nil {
+			t.Fatalf("unable to create tracer: %s", err)
+		}
+		defer closer.Close()
+
+		policy := NewSamplingPolicy("test")
+		if helperServeTraced(tracer, items, policy, http.StatusOK, t) {
+			t.Errorf("successful request was sampled")
+		}
+		if n := len(exporter.GetSpans()); n != 0 {
+			t.Errorf("exported %d spans of a successful request, want 0", n)
+		}
+
+		// The span context of a started span doesn't change, the spans are exported as sampled
+		helperServeTraced(tracer, items, policy, http.StatusInternalServerError, t)
+		spans := exporter.GetSpans()
+		if len(spans) != 2 {
+			t.Fatalf("exported %d spans of a failing request, want 2", len(spans))
+		}
+		for _, s := range spans {
+			if !s.SpanContext.IsSampled() {
+				t.Errorf("span %s was exported without being sampled", s.Name)
+			}
+		}
+		exporter.Reset()
+
+		policy.Errors = false
+		if helperServeTraced(tracer, items, policy, http.StatusInternalServerError, t) {
+			t.Errorf("failing request was sampled with error sampling disabled")
+		}
+		if n := len(exporter.GetSpans()); n != 0 {
+			t.Errorf("exported %d spans with error sampling disabled, want 0", n)
+		}
+
+		// Jaeger only reports the root span of a failing request
+		os.Setenv("JAEGER_SAMPLER_TYPE", SamplerConst)
+		os.Setenv("JAEGER_SAMPLER_PARAM", "0")
+		defer os.Unsetenv("JAEGER_SAMPLER_TYPE")
+		defer os.Unsetenv("JAEGER_SAMPLER_PARAM")
+		jtracer, jcloser := helperNewTracer(TracingJaeger, nil, t)
+		defer jcloser.Close()
+		if helperServeTraced(jtracer, items, NewSamplingPolicy("test"), http.StatusOK, t) {
+			t.Errorf("jaeger sampled a successful request")
+		}
+		if !helperServeTraced(jtracer, items, NewSamplingPolicy("test"), http.StatusInternalServerError, t) {
+			t.Errorf("jaeger didn't sample a failing request")
+		}
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		tracer, closer := helperNewTracer(TracingOTLP, nil, t)
+		defer closer.Close()
+
+		policy := NewSamplingPolicy("test")
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(policy.Collectors()...)
+
+		helperServeTraced(tracer, health, policy, http.StatusOK, t)
+		helperServeTraced(tracer, items, policy, http.StatusOK, t)
+		helperServeTraced(tracer, items, policy, http.StatusOK, t)
+
+		var tests = []struct {
+			route, decision string
+			want            float64
+		}{
+			{health.Name, "not_sampled", 1},
+			{health.Name, "sampled", 0},
+			{items.Name, "sampled", 2},
+		}
+		for _, tt := range tests {
+			c := policy.decisions.With(prometheus.Labels{"route": tt.route, "decision": tt.decision})
+			if got := testutil.ToFloat64(c); got != tt.want {
+				t.Errorf("trace_sampling_decisions_total{route=%s,decision=%s} = %v, want %v", tt.route, tt.decision, got, tt.want)
+			}
+		}
+
+		var nilPolicy *SamplingPolicy
+		if c := nilPolicy.Collectors(); c != nil {
+			t.Errorf("nil policy returned collectors %v", c)
+		}
+		nilPolicy.Finish(ot.NoopTracer{}.StartSpan("test"), items, http.StatusInternalServerError)
+	})
+}
+
+func TestParseSamplingOverrides(t *testing.T) {
+	var tests = []struct {
+		in    string
+		want  map[string]string
+		valid bool
+	}{
+		{"/healthz=never,/livez=never", map[string]string{"/healthz": "never", "/livez": "never"}, true},
+		{" getItem = Always ,", map[string]string{"getItem": "always"}, true},
+		{"", map[string]string{}, true},
+		{"/healthz", nil, false},
+		{"=never", nil, false},
+		{"/healthz=sometimes", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSamplingOverrides(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseSamplingOverrides(%#v) returned error %v, want valid: %v", tt.in, err, tt.valid)
+			continue
+		}
+		if tt.valid && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSamplingOverrides(%#v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
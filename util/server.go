@@ -1,9 +1,693 @@
 package util
 
-import "net/http"
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/obitech/micro-obs/apierr"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
 
 // Server is an interface for types that implement ServeHTTP.
-// For now this is only used for testing but will possibly extended for refactoring at a later point.
 type Server interface {
 	ServeHTTP(w http.ResponseWriter, r *http.Request)
 }
+
+// Service is implemented by the servers of all services, which embed a BaseServer.
+type Service interface {
+	Server
+	Base() *BaseServer
+}
+
+// BaseServer holds what all services share: the HTTP and optional gRPC server, logging, metrics,
+// tracing, health checks and the graceful shutdown. Services embed it and only declare their
+// routes and dependencies:
+//
+//	base, err := util.NewBaseServer("item", "1.0.0", ":8080", "127.0.0.1:8081")
+//	s := &Server{BaseServer: base}
+//	s.UseRedis("redis://127.0.0.1:6379/0")
+//	err = util.Configure(s, options...)
+//	s.HandleRoutes(s.routes(), nil)
+type BaseServer struct {
+	Logger   *Logger
+	Router   *mux.Router
+	PromReg  *prometheus.Registry
+	Metrics  *RequestMetricHistogram
+	Panics   *prometheus.CounterVec
+	Denied   *prometheus.CounterVec
+	OpenAPI  *OpenAPI
+	Redact   *RedactionPolicy
+	Sampling *SamplingPolicy
+	Limits   *LimitPolicy
+	Faults   *FaultInjector
+	Health   *HealthRegistry
+	Auth     *Authenticator
+
+	// Redis is the client of services using Redis, see UseRedis.
+	Redis *redis.Client
+
+	name            string
+	version         string
+	address         string
+	endpoint        string
+	logLevelConfig  string
+	tracing         TracerConfig
+	drainPeriod     time.Duration
+	shutdownTimeout time.Duration
+	server          *http.Server
+	grpcAddress     string
+	grpcServer      *grpc.Server
+	grpcAccess      map[string]Access
+	collectors      []prometheus.Collector
+	closers         []lifecycleCloser
+	redisBase       *redis.Client
+	redisMetrics    *RedisMetrics
+	redisOps        uint64
+}
+
+// NewBaseServer creates the BaseServer of a service listening on address by default. The endpoint
+// is the address other services reach it on.
+func NewBaseServer(name, version, address, endpoint string) (*BaseServer, error) {
+	// Create default logger
+	logger, err := NewLogger(DefaultLogLevel, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create Logger")
+	}
+
+	return &BaseServer{
+		Logger:          logger,
+		Router:          NewRouter(),
+		PromReg:         prometheus.NewRegistry(),
+		Metrics:         NewRequestMetricHistogram(name, DefaultDurationBuckets, DefaultResponseSizeBuckets),
+		Panics:          NewPanicCounter(name),
+		Denied:          NewAuthzDeniedCounter(name),
+		Redact:          DefaultRedactionPolicy(),
+		Sampling:        NewSamplingPolicy(name),
+		Limits:          NewLimitPolicy(name),
+		Faults:          NewFaultInjector(name),
+		Health:          NewHealthRegistry(name),
+		Auth:            NewAuthenticator(),
+		name:            name,
+		version:         version,
+		address:         address,
+		endpoint:        endpoint,
+		tracing:         TracerConfig{Backend: TracingJaeger},
+		drainPeriod:     DefaultDrainPeriod,
+		shutdownTimeout: DefaultShutdownTimeout,
+	}, nil
+}
+
+// Base implements Service.
+func (b *BaseServer) Base() *BaseServer {
+	return b
+}
+
+// UseRedis connects the service to Redis at a default address, which can be changed with
+// SetRedisAddress. Configure instruments the client and checks it for readiness, it's closed on
+// shutdown.
+func (b *BaseServer) UseRedis(address string) error {
+	rc, err := NewRedisClient(address)
+	if err != nil {
+		return err
+	}
+	b.Redis = rc
+	return nil
+}
+
+// UseGRPC serves gRPC on a default address, which can be changed with SetGRPCAddress. The server
+// is created by Configure, see GRPCServer. Access declares who may call methods by their full
+// name, like Route.Access does for HTTP routes.
+func (b *BaseServer) UseGRPC(address string, access map[string]Access) {
+	b.grpcAddress = address
+	b.grpcAccess = make(map[string]Access, len(access))
+	for m, a := range access {
+		b.grpcAccess[m] = a
+	}
+}
+
+// GRPCServer returns the gRPC server created by Configure for services calling UseGRPC, to
+// register their services on.
+func (b *BaseServer) GRPCServer() *grpc.Server {
+	return b.grpcServer
+}
+
+// AddCollectors registers additional Prometheus collectors of a service in InitPromReg.
+func (b *BaseServer) AddCollectors(cs ...prometheus.Collector) {
+	b.collectors = append(b.collectors, cs...)
+}
+
+// AddCloser adds a dependency, e.g. a client of another service, which is closed on shutdown.
+func (b *BaseServer) AddCloser(name string, c io.Closer) {
+	b.closers = append(b.closers, lifecycleCloser{name: name, closer: c})
+}
+
+// Configure applies options to a service, then sets up everything depending on them: Redis is
+// instrumented and the gRPC server is created.
+func Configure(svc Service, options ...ServerOption) error {
+	for _, fn := range options {
+		if err := fn(svc); err != nil {
+			return errors.Wrap(err, "failed to set server options")
+		}
+	}
+
+	b := svc.Base()
+	b.Logger.SetRedactionPolicy(b.Redact)
+
+	if b.Auth.Insecure() && b.Auth.HasCredentials() {
+		return errors.New("authentication can't be disabled while API keys or a JWKS are set")
+	}
+
+	if b.Redis != nil {
+		b.instrumentRedis()
+		b.Health.Register("redis", func(ctx context.Context) error {
+			return b.Redis.Ping().Err()
+		})
+		b.AddCloser("redis", b.Redis)
+	}
+
+	if b.grpcAddress != "" {
+		b.grpcServer = b.newGRPCServer()
+	}
+
+	b.Logger.Debugw("Creating new server",
+		"address", b.address,
+		"grpcAddress", b.grpcAddress,
+		"endpoint", b.endpoint,
+	)
+	return nil
+}
+
+// instrumentRedis traces, monitors and logs all commands sent to Redis and injects the Redis
+// faults of the service into them. The uninstrumented client is kept for RedisContext.
+func (b *BaseServer) instrumentRedis() {
+	b.redisBase = b.Redis
+	b.redisMetrics = NewRedisMetrics(b.name, b.redisBase, DefaultRedisDurationBuckets)
+	b.AddCollectors(b.redisMetrics.Collectors()...)
+
+	b.Redis = b.redisBase.WithContext(context.Background())
+	b.wrapRedis(context.Background(), b.Redis)
+}
+
+// wrapRedis instruments the commands and pipelines of c, sent on behalf of ctx. go-redis doesn't
+// pass the context of a client to its hooks, so each context needs its own hooks.
+func (b *BaseServer) wrapRedis(ctx context.Context, c redisHooks) {
+	c.WrapProcess(func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			return b.processRedis(ctx, strings.ToLower(cmd.Name()), []redis.Cmder{cmd}, func() error { return old(cmd) })
+		}
+	})
+	c.WrapProcessPipeline(func(old func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			return b.processRedis(ctx, RedisPipeline, cmds, func() error { return old(cmds) })
+		}
+	})
+}
+
+// processRedis sends cmds of a command, or pipeline, with send. They are sent in a span if ctx
+// holds one, timed by redis_command_duration_seconds and logged on debug level.
+func (b *BaseServer) processRedis(ctx context.Context, command string, cmds []redis.Cmder, send func() error) error {
+	ops := atomic.AddUint64(&b.redisOps, 1)
+	log := RequestIDLoggerFromContext(ctx, b.Logger)
+	log.Debugw("redis sent",
+		"count", ops,
+		"cmd", cmds,
+	)
+
+	span, ctx := startRedisSpan(ctx, command, cmds, b.redisBase.Options())
+	start := time.Now()
+	err := b.Faults.injectRedis(ctx, cmds, b.Logger)
+	if err == nil {
+		err = send()
+	}
+	b.redisMetrics.Observe(command, err, time.Since(start))
+	finishRedisSpan(span, err)
+
+	log.Debugw("redis received",
+		"count", ops,
+		"cmd", cmds,
+	)
+	return err
+}
+
+// RedisContext returns the Redis client of a service using Redis bound to ctx, so its commands
+// are traced as children of the span of ctx and logged with its request ID.
+func (b *BaseServer) RedisContext(ctx context.Context) *redis.Client {
+	c := b.redisBase.WithContext(ctx)
+	b.wrapRedis(ctx, c)
+	return c
+}
+
+// RedisWatch runs fn in a transaction watching keys like redis.Client.Watch, instrumenting the
+// commands of the transaction like those of RedisContext.
+func (b *BaseServer) RedisWatch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	return b.redisBase.Watch(func(tx *redis.Tx) error {
+		b.wrapRedis(ctx, tx)
+		return fn(tx)
+	}, keys...)
+}
+
+// newGRPCServer creates a gRPC server instrumenting all calls the same way as the HTTP routes.
+// The standard health service is registered as well, reporting NOT_SERVING once the server is
+// shutting down. Like the health check routes, it's public.
+func (b *BaseServer) newGRPCServer() *grpc.Server {
+	b.grpcAccess[healthpb.Health_Check_FullMethodName] = Access{Public: true}
+	b.grpcAccess[healthpb.Health_Watch_FullMethodName] = Access{Public: true}
+	b.grpcAccess[healthpb.Health_List_FullMethodName] = Access{Public: true}
+
+	gs := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			GRPCRecoveryInterceptor(b.Logger, b.Panics),
+			GRPCTracerInterceptor(b.Redact),
+			GRPCPrometheusInterceptor(b.Metrics),
+			GRPCRequestIDInterceptor(b.Logger),
+			GRPCLoggerInterceptor(b.Logger),
+			GRPCClientRateLimitInterceptor(b.Limits, b.Logger),
+			GRPCAuthInterceptor(b.Auth, b.grpcAccess, b.Denied, b.Logger),
+			GRPCLimitInterceptor(b.Limits, b.Logger),
+			GRPCFaultInterceptor(b.Faults, b.Logger),
+			GRPCErrorInterceptor(b.Logger),
+		),
+		grpc.ChainStreamInterceptor(
+			GRPCStreamRecoveryInterceptor(b.Logger, b.Panics),
+			GRPCStreamTracerInterceptor(b.Redact),
+			GRPCStreamPrometheusInterceptor(b.Metrics),
+			GRPCStreamRequestIDInterceptor(b.Logger),
+			GRPCStreamLoggerInterceptor(b.Logger),
+			GRPCStreamClientRateLimitInterceptor(b.Limits, b.Logger),
+			GRPCStreamAuthInterceptor(b.Auth, b.grpcAccess, b.Denied, b.Logger),
+			GRPCStreamLimitInterceptor(b.Limits, b.Logger),
+			GRPCStreamFaultInterceptor(b.Faults, b.Logger),
+			GRPCStreamErrorInterceptor(b.Logger),
+		),
+	)
+
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(gs, hs)
+	b.Health.OnShutdown(hs.Shutdown)
+	return gs
+}
+
+// HandleRoutes registers routes next to the ones all services serve: health checks, log levels,
+// the OpenAPI document generated from all of them and Prometheus metrics. Every route is traced,
+// monitored, logged, assigned a request ID and recovers from panics. Routes that aren't public
+// are authenticated and authorized, routes that aren't unlimited are rate limited, shed under load
+// and hit by injected faults. Unknown paths are answered by notFound, or a problem if it's nil.
+func (b *BaseServer) HandleRoutes(routes Routes, notFound http.HandlerFunc) {
+	routes = append(b.standardRoutes(), routes...)
+	b.OpenAPI = NewOpenAPI(b.name, b.version, routes)
+
+	for _, route := range routes {
+		h := route.HandlerFunc
+
+		// Injecting faults in place of the handler, so they are logged like its responses
+		h = FaultMiddleware(h, route, b.Faults, b.Logger)
+
+		// Rate limiting clients, after authentication so they are identified by their principal
+		h = RateLimitMiddleware(h, route, b.Limits, b.Logger)
+
+		// Authenticating and authorizing requests to private routes, adding the principal to logs
+		h = AuthMiddleware(h, route, b.Auth, b.Denied, b.Logger)
+
+		// Rate limiting IP addresses across routes, before authentication so floods of invalid
+		// credentials don't reach it
+		h = ClientRateLimitMiddleware(h, route, b.Limits, b.Logger)
+
+		// Shedding load before doing any work for requests exceeding the concurrency limit
+		h = LoadShedMiddleware(h, route, b.Limits, b.Logger)
+
+		// Logging each request, outside of the middlewares above so their rejections are logged
+		h = LoggerMiddleware(h, route, b.Logger)
+
+		// Recovering from panics of the handler and all middlewares above, so the 500 is monitored
+		// and traced
+		h = RecoveryMiddleware(h, route, b.Logger, b.Panics)
+
+		// Assign requestID to each request
+		h = AssignRequestID(h, b.Logger)
+
+		// Monitoring each request
+		h = PrometheusMiddleware(h, route, b.Metrics)
+
+		// Tracing each request, outermost so logs and metrics can refer to the span
+		traced := TracerMiddleware(h, route, b.Redact, b.Sampling)
+
+		b.Router.
+			Methods(route.Method).
+			Path(route.Pattern).
+			Name(route.Name).
+			Handler(traced)
+	}
+
+	// Prometheus endpoint
+	promHandler := promhttp.HandlerFor(b.PromReg, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	promHandler = promhttp.InstrumentMetricHandler(b.PromReg, promHandler)
+	b.Router.
+		Methods("GET").
+		Path("/metrics").
+		Name("metrics").
+		Handler(promHandler)
+
+	// 404 handler
+	if notFound == nil {
+		notFound = b.notFound()
+	}
+	b.Router.NotFoundHandler = PrometheusMiddleware(notFound, Route{Name: "notFound"}, b.Metrics)
+}
+
+// standardRoutes are served by all services.
+func (b *BaseServer) standardRoutes() Routes {
+	problem := Problem{}
+	return Routes{
+		Route{
+			Name:        "healthz",
+			Method:      "GET",
+			Pattern:     "/healthz",
+			HandlerFunc: Healthz(),
+			Summary:     "Check the health of the service",
+			Responses:   map[int]interface{}{http.StatusOK: ""},
+			Access:      Access{Public: true},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "livez",
+			Method:      "GET",
+			Pattern:     "/livez",
+			HandlerFunc: b.Health.LivezHandler(),
+			Summary:     "Check if the service is alive",
+			Responses:   map[int]interface{}{http.StatusOK: HealthReport{}},
+			Access:      Access{Public: true},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "readyz",
+			Method:      "GET",
+			Pattern:     "/readyz",
+			HandlerFunc: b.Health.ReadyzHandler(),
+			Summary:     "Check if the service and its dependencies are ready to serve traffic",
+			Responses:   map[int]interface{}{http.StatusOK: HealthReport{}, http.StatusServiceUnavailable: HealthReport{}},
+			Access:      Access{Public: true},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "getLogLevel",
+			Method:      "GET",
+			Pattern:     "/admin/loglevel",
+			HandlerFunc: LogLevelHandler(b.Logger),
+			Summary:     "Retrieve the log level and per-route overrides",
+			Responses:   map[int]interface{}{http.StatusOK: LogLevelConfig{}},
+			Access:      Access{Roles: []string{RoleAdmin}},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "setLogLevel",
+			Method:      "PUT",
+			Pattern:     "/admin/loglevel",
+			HandlerFunc: LogLevelHandler(b.Logger),
+			Summary:     "Change the log level and replace per-route overrides",
+			Request:     LogLevelConfig{},
+			Responses:   map[int]interface{}{http.StatusOK: LogLevelConfig{}, http.StatusBadRequest: problem, http.StatusUnprocessableEntity: problem},
+			Access:      Access{Roles: []string{RoleAdmin}},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "getFaults",
+			Method:      "GET",
+			Pattern:     "/admin/faults",
+			HandlerFunc: FaultHandler(b.Faults, b.Logger),
+			Summary:     "Retrieve the injected faults",
+			Responses:   map[int]interface{}{http.StatusOK: []Fault{}},
+			Access:      Access{Roles: []string{RoleAdmin}},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "setFaults",
+			Method:      "POST",
+			Pattern:     "/admin/faults",
+			HandlerFunc: FaultHandler(b.Faults, b.Logger),
+			Summary:     "Replace the injected faults",
+			Request:     []Fault{},
+			Responses:   map[int]interface{}{http.StatusOK: []Fault{}, http.StatusBadRequest: problem, http.StatusUnprocessableEntity: problem},
+			Access:      Access{Roles: []string{RoleAdmin}},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "clearFaults",
+			Method:      "DELETE",
+			Pattern:     "/admin/faults",
+			HandlerFunc: FaultHandler(b.Faults, b.Logger),
+			Summary:     "Stop injecting faults",
+			Responses:   map[int]interface{}{http.StatusOK: []Fault{}},
+			Access:      Access{Roles: []string{RoleAdmin}},
+			Unlimited:   true,
+		},
+		Route{
+			Name:        "openAPI",
+			Method:      "GET",
+			Pattern:     "/openapi.json",
+			HandlerFunc: OpenAPIHandler(func() *OpenAPI { return b.OpenAPI }),
+			Summary:     "Retrieve the OpenAPI document of the service",
+			Responses:   map[int]interface{}{http.StatusOK: map[string]interface{}{}},
+			Access:      Access{Public: true},
+			Unlimited:   true,
+		},
+	}
+}
+
+// notFound responds with a 404 problem.
+func (b *BaseServer) notFound() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := ot.StartSpanFromContext(r.Context(), "notFound")
+		defer span.Finish()
+		b.RespondError(ctx, apierr.New(apierr.NotFound, "resource not found"), w)
+	}
+}
+
+// InitPromReg initializes a custom Prometheus registry with Collectors.
+func (b *BaseServer) InitPromReg() {
+	b.PromReg.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	b.PromReg.MustRegister(b.Metrics.Collectors()...)
+	b.PromReg.MustRegister(b.Panics, b.Denied)
+	b.PromReg.MustRegister(b.Sampling.Collectors()...)
+	b.PromReg.MustRegister(b.Limits.Collectors()...)
+	b.PromReg.MustRegister(b.Faults.Collectors()...)
+	b.PromReg.MustRegister(b.Health.Collectors()...)
+	b.PromReg.MustRegister(b.collectors...)
+}
+
+// Run starts a Server and shuts it down gracefully on a SIGINT or SIGTERM. Log levels are reloaded
+// on a SIGHUP.
+func (b *BaseServer) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return b.RunContext(ctx)
+}
+
+// RunContext starts a Server and shuts it down gracefully once ctx is cancelled, see Lifecycle.
+func (b *BaseServer) RunContext(ctx context.Context) error {
+	// Create TCP listeners
+	l, err := net.Listen("tcp", b.address)
+	if err != nil {
+		return errors.Wrapf(err, "Failed creating listener on %s", b.address)
+	}
+
+	var gl net.Listener
+	if b.grpcServer != nil {
+		if gl, err = net.Listen("tcp", b.grpcAddress); err != nil {
+			l.Close()
+			return errors.Wrapf(err, "Failed creating listener on %s", b.grpcAddress)
+		}
+	}
+
+	// Create HTTP Server
+	b.server = &http.Server{
+		Handler:        b.Router,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	lc := NewLifecycle(b.Logger, b.Health)
+	lc.DrainPeriod = b.drainPeriod
+	lc.ShutdownTimeout = b.shutdownTimeout
+	for _, c := range b.closers {
+		lc.AddCloser(c.name, c.closer)
+	}
+
+	// Creating tracer
+	tracer, closer, err := NewTracer(b.name, b.tracing, b.Logger)
+	if err != nil {
+		b.Logger.Warnw("unable to initialize tracer",
+			"error", err,
+		)
+	} else {
+		lc.AddCloser("tracer", closer)
+		ot.SetGlobalTracer(tracer)
+	}
+
+	// Exporting metrics
+	metrics, err := NewMetricExporter(b.name, b.tracing, b.PromReg)
+	if err != nil {
+		b.Logger.Warnw("unable to initialize metric exporter",
+			"error", err,
+		)
+	} else {
+		lc.AddCloser("metrics", metrics)
+	}
+
+	lc.AddWorker("loglevel", func(ctx context.Context) error {
+		defer b.Logger.Levels().ReloadOnSignal(b.logLevelConfig, b.Logger, syscall.SIGHUP)()
+		<-ctx.Done()
+		return nil
+	})
+
+	// Listening
+	lc.AddHTTPServer("HTTP", b.server, l)
+	if gl != nil {
+		lc.AddGRPCServer("gRPC", b.grpcServer, gl)
+	}
+	switch {
+	case b.Auth.Insecure():
+		b.Logger.Warnw("Authentication is disabled, private routes and RPCs are served without credentials")
+	case !b.Auth.HasCredentials():
+		b.Logger.Warnw("No API keys or JWKS set, private routes and RPCs reject every request")
+	}
+	b.Logger.Infow("Server listening",
+		"address", b.address,
+		"grpcAddress", b.grpcAddress,
+		"endpoint", b.endpoint,
+	)
+
+	return lc.Run(ctx)
+}
+
+// ServeHTTP dispatches the request to the matching mux handler.
+// This function is mainly intended for testing purposes.
+func (b *BaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.Router.ServeHTTP(w, r)
+}
+
+// ServeGRPC accepts gRPC connections on the passed listener.
+// This function is mainly intended for testing purposes.
+func (b *BaseServer) ServeGRPC(l net.Listener) error {
+	if b.grpcServer == nil {
+		return errors.Errorf("%s doesn't serve gRPC", b.name)
+	}
+	return b.grpcServer.Serve(l)
+}
+
+// InternalError sends a plain 500 response, used if a JSON response can't be sent.
+func (b *BaseServer) InternalError(ctx context.Context, w http.ResponseWriter) {
+	status := http.StatusInternalServerError
+	parent := ot.SpanFromContext(ctx)
+	if parent != nil {
+		parent.SetTag("status", status)
+	}
+
+	w.Header().Del("Content-Type")
+	w.WriteHeader(status)
+	if _, err := io.WriteString(w, "Internal Server Error\n"); err != nil {
+		log := RequestIDLoggerFromContext(ctx, b.Logger)
+		log.Errorw("unable to send response",
+			"error", err,
+		)
+	}
+}
+
+// Respond sends a JSON-encoded Envelope with data, which is usually a slice of the service's
+// resources.
+func (b *BaseServer) Respond(ctx context.Context, status int, m string, c int, data interface{}, w http.ResponseWriter) {
+	span, _ := ot.StartSpanFromContext(ctx, "Respond")
+	defer span.Finish()
+	span.SetTag("status", status)
+
+	// A response that can't be encoded is a bug, RecoveryMiddleware sends a 500 instead
+	res := NewEnvelope(status, m, c, data)
+	if err := res.SendJSON(w); err != nil {
+		panic(errors.Wrap(err, "sending JSON response failed"))
+	}
+
+	// Tracing information
+	b.Redact.TagHeaders(span, w.Header())
+	span.LogKV(
+		"message", m,
+		"count", c,
+		"data", b.Redact.Value(data),
+	)
+}
+
+// RespondError sends err as RFC 7807 problem details, see apierr. Errors not wrapping an
+// apierr.Error are sent as internal errors. Server errors are logged with their cause.
+func (b *BaseServer) RespondError(ctx context.Context, err error, w http.ResponseWriter) {
+	span, ctx := ot.StartSpanFromContext(ctx, "RespondError")
+	defer span.Finish()
+
+	p := NewErrorProblem(err)
+	span.SetTag("status", p.Status)
+	span.SetTag("code", p.Code)
+	if p.Status >= http.StatusInternalServerError {
+		log := RequestIDLoggerFromContext(ctx, b.Logger)
+		log.Errorw("request failed",
+			"code", p.Code,
+			"error", err,
+		)
+	}
+
+	// A problem that can't be encoded is a bug, RecoveryMiddleware sends a 500 instead
+	if err := p.SendJSON(w); err != nil {
+		panic(errors.Wrap(err, "sending problem response failed"))
+	}
+
+	// Tracing information
+	b.Redact.TagHeaders(span, w.Header())
+	span.LogKV(
+		"detail", p.Detail,
+		"errors", b.Redact.Value(p.Errors),
+	)
+}
+
+// ErrorHandlerFunc is an HTTP handler returning an error instead of sending it, see Handle.
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Handle returns an http.HandlerFunc sending the errors returned by h with RespondError.
+func (b *BaseServer) Handle(h ErrorHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			b.RespondError(r.Context(), err, w)
+		}
+	}
+}
+
+// NewRedisClient creates a new go-redis/redis client according to passed options.
+// Address needs to be a valid redis URL, e.g. redis://127.0.0.1:6379/0 or redis://:qwerty@localhost:6379/1
+func NewRedisClient(addr string) (*redis.Client, error) {
+	opt, err := redis.ParseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := redis.NewClient(&redis.Options{
+		Addr:     opt.Addr,
+		Password: opt.Password,
+		DB:       opt.DB,
+	})
+
+	return c, nil
+}
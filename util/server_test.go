@@ -0,0 +1,378 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/obitech/micro-obs/apierr"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testService is a minimal service embedding a BaseServer.
+type testService struct {
+	*BaseServer
+	greeting string
+}
+
+// otherService is a service of another type, to apply mismatching options to.
+type otherService struct {
+	*BaseServer
+}
+
+func setGreeting(g string) ServerOption {
+	return ServiceOption(func(s *testService) error {
+		s.greeting = g
+		return nil
+	})
+}
+
+func helperTestService(redisAddr string, t *testing.T, options ...ServerOption) (*testService, error) {
+	base, err := NewBaseServer("test", "1.0.0", ":0", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create base server: %s", err)
+	}
+	s := &testService{BaseServer: base, greeting: "hello"}
+	if redisAddr != "" {
+		if err := s.UseRedis(redisAddr); err != nil {
+			t.Fatalf("unable to create redis client: %s", err)
+		}
+	}
+	if err := Configure(s, options...); err != nil {
+		return nil, err
+	}
+
+	s.HandleRoutes(Routes{
+		Route{
+			Name:    "greet",
+			Method:  "GET",
+			Pattern: "/greet",
+			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+				s.Respond(r.Context(), http.StatusOK, s.greeting, 0, nil, w)
+			},
+			Summary: "Greet the caller",
+		},
+		Route{
+			Name:    "fail",
+			Method:  "GET",
+			Pattern: "/fail/{code}",
+			HandlerFunc: s.Handle(func(w http.ResponseWriter, r *http.Request) error {
+				code := apierr.Code(mux.Vars(r)["code"])
+				if code == "untyped" {
+					return errors.New("secret cause")
+				}
+				return errors.Wrap(apierr.Wrap(errors.New("secret cause"), code, "failed with %s", code), "wrapped")
+			}),
+			Summary: "Fail with an error",
+		},
+	}, nil)
+	return s, nil
+}
+
+func helperServeEnvelope(s Server, path string, t *testing.T) (int, Envelope[[]string]) {
+	req := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	var env Envelope[[]string]
+	if err := json.NewDecoder(w.Body).Decode(&env); err != nil {
+		t.Fatalf("unable to decode response of %s: %s", path, err)
+	}
+	return w.Code, env
+}
+
+func TestBaseServer(t *testing.T) {
+	t.Run("Service options", func(t *testing.T) {
+		s, err := helperTestService("", t, setGreeting("hi"), SetLogLevel("debug"))
+		if err != nil {
+			t.Fatalf("unable to create service: %s", err)
+		}
+		if s.greeting != "hi" {
+			t.Errorf("greeting is %#v, want hi", s.greeting)
+		}
+
+		base, _ := NewBaseServer("other", "1.0.0", ":0", "")
+		if err := Configure(&otherService{base}, setGreeting("hi")); err == nil {
+			t.Errorf("applying option of another service didn't return error")
+		}
+	})
+
+	t.Run("Optional dependencies", func(t *testing.T) {
+		if _, err := helperTestService("", t, SetRedisAddress("redis://127.0.0.1:6379/0")); err == nil {
+			t.Errorf("setting redis address of service without redis didn't return error")
+		}
+		if _, err := helperTestService("", t, SetGRPCAddress(":9080")); err == nil {
+			t.Errorf("setting gRPC address of service without gRPC didn't return error")
+		}
+	})
+
+	t.Run("Routes", func(t *testing.T) {
+		s, err := helperTestService("", t, SetInsecureNoAuth(true))
+		if err != nil {
+			t.Fatalf("unable to create service: %s", err)
+		}
+
+		code, env := helperServeEnvelope(s, "/greet", t)
+		if code != http.StatusOK || env.Message != "hello" {
+			t.Errorf("/greet returned %d %#v, want 200 hello", code, env.Message)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+		if err := apierr.FromResponse(w.Result()); !apierr.Is(err, apierr.NotFound) {
+			t.Errorf("/missing returned %d with error %v, want %s problem", w.Code, err, apierr.NotFound)
+		}
+
+		for _, path := range []string{"/healthz", "/livez", "/readyz", "/admin/loglevel", "/openapi.json", "/metrics"} {
+			req := httptest.NewRequest("GET", path, nil)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("%s returned %d, want 200", path, w.Code)
+			}
+		}
+
+		if _, ok := s.OpenAPI.Paths["/greet"]; !ok {
+			t.Errorf("OpenAPI document is missing service route /greet")
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("unable to start miniredis: %s", err)
+		}
+		defer mr.Close()
+
+		for _, opt := range []ServerOption{SetRateLimit(-1, 0), SetMaxInFlight(-1), SetRateLimitRedis("mysql://localhost")} {
+			if _, err := helperTestService("", t, opt); err == nil {
+				t.Errorf("expected error for invalid limit option")
+			}
+		}
+
+		s, err := helperTestService("", t, SetRateLimit(0.5, 0), SetMaxInFlight(10), SetRateLimitRedis("redis://"+mr.Addr()), SetInsecureNoAuth(true))
+		if err != nil {
+			t.Fatalf("unable to create service: %s", err)
+		}
+		s.InitPromReg()
+		if s.Limits.RateLimit.Burst != 1 || s.Limits.MaxInFlight != 10 {
+			t.Errorf("limits are %+v, want burst 1 and 10 in-flight requests", s.Limits)
+		}
+
+		for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/greet", nil))
+			if w.Code != want {
+				t.Errorf("/greet returned %d, want %d", w.Code, want)
+			}
+			if want == http.StatusTooManyRequests {
+				if err := s.OpenAPI.ValidateResponse("greet", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+					t.Errorf("/greet drifted from OpenAPI document: %s", err)
+				}
+			}
+		}
+		for n := 0; n < 2; n++ {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("/readyz returned %d, health checks aren't rate limited", w.Code)
+			}
+		}
+
+		if keys := mr.Keys(); len(keys) != 1 || !strings.HasPrefix(keys[0], "ratelimit:test:greet|ip:") {
+			t.Errorf("redis holds keys %v, want bucket of greet", keys)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if want := `requests_rejected_total{reason="rate_limited",route="greet",service="test"} 1`; !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	})
+
+	t.Run("Redis readiness", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("unable to start miniredis: %s", err)
+		}
+		defer mr.Close()
+
+		s, err := helperTestService("redis://"+mr.Addr(), t)
+		if err != nil {
+			t.Fatalf("unable to create service: %s", err)
+		}
+		s.Health.CacheTTL = 0
+
+		helperProbe(s.ServeHTTP, http.StatusOK, t)
+		mr.Close()
+		report := helperProbe(s.ServeHTTP, http.StatusServiceUnavailable, t)
+		if len(report.Checks) != 1 || report.Checks[0].Name != "redis" {
+			t.Errorf("readiness report has checks %v, want redis", report.Checks)
+		}
+	})
+}
+
+func TestRespondError(t *testing.T) {
+	s, err := helperTestService("", t, SetInsecureNoAuth(true))
+	if err != nil {
+		t.Fatalf("unable to create service: %s", err)
+	}
+
+	var tests = []struct {
+		code   string
+		want   apierr.Code
+		status int
+		detail string
+	}{
+		{"not_found", apierr.NotFound, http.StatusNotFound, "failed with not_found"},
+		{"validation_failed", apierr.ValidationFailed, http.StatusUnprocessableEntity, "failed with validation_failed"},
+		{"unavailable", apierr.Unavailable, http.StatusServiceUnavailable, "failed with unavailable"},
+		{"untyped", apierr.Internal, http.StatusInternalServerError, "internal server error"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/fail/"+tt.code, nil))
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status is %d, want %d", tt.code, w.Code, tt.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != ProblemContentType {
+			t.Errorf("%s: Content-Type is %#v, want %#v", tt.code, ct, ProblemContentType)
+		}
+		if strings.Contains(w.Body.String(), "secret cause") {
+			t.Errorf("%s: cause was sent to the client: %s", tt.code, w.Body.String())
+		}
+
+		e := apierr.Decode(w.Code, w.Body.Bytes())
+		if e.Code != tt.want || e.Detail != tt.detail {
+			t.Errorf("%s: decoded %#v, want code %s with detail %#v", tt.code, e, tt.want, tt.detail)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := NewEnvelope(http.StatusCreated, "created", 2, []string{"a", "b"}).SendJSON(w); err != nil {
+		t.Fatalf("unable to send envelope: %s", err)
+	}
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("status is %d, want %d", w.Code, http.StatusCreated)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(strings.ToLower(ct), "application/json") {
+		t.Errorf("Content-Type is %#v, want application/json", ct)
+	}
+	want := `{"status":201,"message":"created","count":2,"data":["a","b"]}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("body is %s, want %s", got, want)
+	}
+}
+
+func TestHandleRoutesMiddlewares(t *testing.T) {
+	helperBaseServer := func(t *testing.T) *BaseServer {
+		base, err := NewBaseServer("test", "1.0.0", ":0", "")
+		if err != nil {
+			t.Fatalf("unable to create base server: %s", err)
+		}
+		return base
+	}
+	routes := Routes{
+		Route{
+			Name:    "private",
+			Method:  "GET",
+			Pattern: "/private",
+			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+		},
+		Route{
+			Name:    "public",
+			Method:  "GET",
+			Pattern: "/public",
+			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			Access: Access{Public: true},
+		},
+	}
+
+	t.Run("Rejections are logged", func(t *testing.T) {
+		base := helperBaseServer(t)
+		l, logs := helperObservedLogger()
+		base.Logger = l
+		base.Limits.RateLimit = RateLimit{Rate: 1, Burst: 1}
+		base.HandleRoutes(routes, nil)
+
+		for _, path := range []string{"/private", "/public", "/public"} {
+			base.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}
+
+		var got []int64
+		for _, e := range logs.FilterMessage("request completed").All() {
+			got = append(got, e.ContextMap()["status"].(int64))
+		}
+		want := []int64{http.StatusUnauthorized, http.StatusOK, http.StatusTooManyRequests}
+		if len(got) != len(want) {
+			t.Fatalf("logged statuses are %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("logged statuses are %v, want %v", got, want)
+				break
+			}
+		}
+	})
+
+	t.Run("Clients are limited before authentication", func(t *testing.T) {
+		base := helperBaseServer(t)
+		base.Limits.ClientRateLimit = RateLimit{Rate: 1, Burst: 2}
+		base.HandleRoutes(routes, nil)
+
+		var tests = []struct {
+			path string
+			key  string
+			want int
+		}{
+			{"/private", "invalid", http.StatusUnauthorized},
+			{"/public", "", http.StatusOK},
+			{"/private", "invalid", http.StatusTooManyRequests},
+			{"/public", "", http.StatusTooManyRequests},
+		}
+
+		for n, tt := range tests {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			base.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("request %d to %s returned %d, want %d", n, tt.path, w.Code, tt.want)
+			}
+		}
+	})
+
+	t.Run("Panicking middleware", func(t *testing.T) {
+		base := helperBaseServer(t)
+		// A nil Authenticator makes AuthMiddleware panic
+		base.Auth = nil
+		base.HandleRoutes(routes, nil)
+
+		w := httptest.NewRecorder()
+		base.ServeHTTP(w, httptest.NewRequest("GET", "/private", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status is %d, want %d", w.Code, http.StatusInternalServerError)
+		}
+		if id := w.Header().Get("X-Request-ID"); id == "" {
+			t.Errorf("500 was sent without X-Request-ID")
+		}
+		if got := testutil.ToFloat64(base.Panics.WithLabelValues("private", "GET")); got != 1 {
+			t.Errorf("panics_total is %v, want 1", got)
+		}
+	})
+}
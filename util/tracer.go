@@ -1,32 +1,54 @@
 package util
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"runtime/debug"
+	"strings"
 
 	ot "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
 	config "github.com/uber/jaeger-client-go/config"
 	"github.com/uber/jaeger-lib/metrics/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// InitTracer returns an instance of Jaeger Tracer that samples 100% of traces and logs all spans to stdout.
-func InitTracer(serviceName string, logger *Logger) (ot.Tracer, io.Closer, error) {
-	cfg, err := config.FromEnv()
+// jaegerMetrics is shared by all Jaeger tracers, since its metrics can only be registered once.
+var jaegerMetrics = prometheus.New()
+
+// InitTracer returns an instance of Jaeger Tracer sampling traces according to cfg.Sampler, which
+// defaults to all traces. Span contexts are injected and extracted in the formats of cfg.Propagation.
+func InitTracer(serviceName string, cfg TracerConfig, logger *Logger) (ot.Tracer, io.Closer, error) {
+	jcfg, err := config.FromEnv()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	cfg.Sampler.Type = "const"
-	cfg.Sampler.Param = 1
-	cfg.Reporter.LogSpans = false
+	sampler, err := resolveSamplerConfig(cfg.Sampler)
+	if err != nil {
+		return nil, nil, err
+	}
+	jcfg.Sampler.Type = sampler.Type
+	jcfg.Sampler.Param = sampler.Param
+	jcfg.Sampler.SamplingServerURL = sampler.ServerURL
+	jcfg.Reporter.LogSpans = false
+
+	propagator, err := NewPropagator(cfg.Propagation...)
+	if err != nil {
+		return nil, nil, err
+	}
+	jp := jaegerPropagator{propagator}
 
-	tracer, closer, err := cfg.New(
+	tracer, closer, err := jcfg.New(
 		serviceName,
 		config.Logger(logger),
-		config.Metrics(prometheus.New()),
+		config.Metrics(jaegerMetrics),
+		config.Injector(ot.HTTPHeaders, jp),
+		config.Extractor(ot.HTTPHeaders, jp),
+		config.Injector(ot.TextMap, jp),
+		config.Extractor(ot.TextMap, jp),
 	)
 	if err != nil {
 		return nil, nil, err
@@ -34,35 +56,100 @@ func InitTracer(serviceName string, logger *Logger) (ot.Tracer, io.Closer, error
 	return tracer, closer, nil
 }
 
-// TracerMiddleware adds a Span to the request Context ready for other handlers to use it.
-func TracerMiddleware(inner http.Handler, route Route) http.HandlerFunc {
+// TracerMiddleware adds a Span to the request Context ready for other handlers to use it. The root
+// span is named after the route, e.g. GET /items/{id}, and records the outcome of the request.
+// Request headers are recorded as tags according to the RedactionPolicy.
+func TracerMiddleware(inner http.Handler, route Route, redact *RedactionPolicy, sampling *SamplingPolicy) http.HandlerFunc {
+	operation := RouteOperationName(route)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var ctx context.Context
 		var span ot.Span
 		tracer := ot.GlobalTracer()
 
 		// If possible, extract span context from headers
-		spanCtx, _ := tracer.Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(r.Header))
-		if spanCtx == nil {
-			span = tracer.StartSpan("request")
-			defer span.Finish()
-			ctx = ot.ContextWithSpan(r.Context(), span)
-		} else {
-			span = tracer.StartSpan("request", ext.RPCServerOption(spanCtx))
-			defer span.Finish()
-			ctx = ot.ContextWithSpan(r.Context(), span)
+		opts := []ot.StartSpanOption{ext.SpanKindRPCServer}
+		if spanCtx, _ := tracer.Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(r.Header)); spanCtx != nil {
+			opts = append(opts, ot.ChildOf(spanCtx))
 		}
+		span = tracer.StartSpan(operation, append(opts, sampling.StartOptions(route)...)...)
+		defer span.Finish()
+		ctx := ot.ContextWithSpan(r.Context(), span)
 
-		for k, v := range r.Header {
-			span.SetTag(fmt.Sprintf("header.%s", k), v)
-		}
+		redact.TagHeaders(span, r.Header)
 
-		// TODO: capture return code as tag in root trace
-		span.SetTag("method", r.Method)
-		span.SetTag("url", r.URL.Path)
+		ext.HTTPMethod.Set(span, r.Method)
+		// Only the path is recorded, query strings may carry credentials or personal data
+		ext.HTTPUrl.Set(span, r.URL.EscapedPath())
 		span.SetTag("handler", route.Name)
 
+		rw := WrapResponseWriter(w)
+		defer func() {
+			if p := recover(); p != nil {
+				tagPanic(span, p)
+				sampling.Finish(span, route, http.StatusInternalServerError)
+				panic(p)
+			}
+		}()
+
 		r = r.WithContext(ctx)
-		inner.ServeHTTP(w, r)
+		inner.ServeHTTP(rw, r)
+
+		SetSpanStatus(span, rw.Status())
+		span.SetTag("http.response_size", rw.Size())
+		sampling.Finish(span, route, rw.Status())
 	})
 }
+
+// tagPanic marks span as failed with a 500 and logs the panic p with its stack as span event.
+func tagPanic(span ot.Span, p interface{}) {
+	ext.Error.Set(span, true)
+	ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
+	span.LogKV(
+		"event", "error",
+		"error.kind", "panic",
+		"message", fmt.Sprint(p),
+		"stack", string(debug.Stack()),
+	)
+}
+
+// RouteOperationName returns the name of the root span of a route, made up of the method and the
+// pattern stripped of regular expressions, e.g. GET /items/{id}.
+func RouteOperationName(route Route) string {
+	if route.Pattern == "" {
+		return "request"
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s", route.Method, pathParam.ReplaceAllString(route.Pattern, "{$1}")))
+}
+
+// SpanIDs returns the hex encoded trace and span ID of a span of either tracing backend. Trace IDs
+// are always 32 characters long, so they can be looked up in Jaeger and OTLP backends alike.
+func SpanIDs(span ot.Span) (traceID, spanID string, ok bool) {
+	if span == nil {
+		return "", "", false
+	}
+
+	switch sc := span.Context().(type) {
+	case jaeger.SpanContext:
+		if !sc.IsValid() {
+			return "", "", false
+		}
+		return fmt.Sprintf("%016x%016x", sc.TraceID().High, sc.TraceID().Low), fmt.Sprintf("%016x", uint64(sc.SpanID())), true
+	case interface {
+		TraceID() trace.TraceID
+		SpanID() trace.SpanID
+	}:
+		if !sc.TraceID().IsValid() {
+			return "", "", false
+		}
+		return sc.TraceID().String(), sc.SpanID().String(), true
+	}
+	return "", "", false
+}
+
+// SetSpanStatus tags a span with the HTTP status code of a response, marking server errors as errors.
+func SetSpanStatus(span ot.Span, status int) {
+	ext.HTTPStatusCode.Set(span, uint16(status))
+	if status >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+}
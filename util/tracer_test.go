@@ -0,0 +1,143 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+)
+
+func TestTracerMiddleware(t *testing.T) {
+	tracer := mocktracer.New()
+	ot.SetGlobalTracer(tracer)
+	defer ot.SetGlobalTracer(ot.NoopTracer{})
+
+	route := Route{
+		Name:    "getItem",
+		Method:  "GET",
+		Pattern: "/items/{id:[a-zA-Z0-9]+}",
+	}
+
+	var tests = []struct {
+		status int
+		body   string
+		panics bool
+		err    bool
+	}{
+		{http.StatusOK, "found", false, false},
+		{http.StatusNotFound, "missing", false, false},
+		{http.StatusServiceUnavailable, "", false, true},
+		{0, "", true, true},
+	}
+
+	for _, tt := range tests {
+		tracer.Reset()
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ot.SpanFromContext(r.Context()) == nil {
+				t.Errorf("no span in request context")
+			}
+			if tt.panics {
+				panic("nasty error")
+			}
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		})
+
+		func() {
+			defer func() {
+				if p := recover(); (p != nil) != tt.panics {
+					t.Errorf("recovered %v, want panic: %v", p, tt.panics)
+				}
+			}()
+			req := httptest.NewRequest("GET", "/items/abc?verbose=1", nil)
+			TracerMiddleware(h, route, nil, nil).ServeHTTP(httptest.NewRecorder(), req)
+		}()
+
+		spans := tracer.FinishedSpans()
+		if len(spans) != 1 {
+			t.Fatalf("recorded %d spans, want 1", len(spans))
+		}
+		span := spans[0]
+
+		if span.OperationName != "GET /items/{id}" {
+			t.Errorf("span is named %s, want %s", span.OperationName, "GET /items/{id}")
+		}
+
+		wantStatus := uint16(tt.status)
+		if tt.panics {
+			wantStatus = http.StatusInternalServerError
+		}
+		if got := span.Tag(string(ext.HTTPStatusCode)); got != wantStatus {
+			t.Errorf("span has status %v, want %v", got, wantStatus)
+		}
+
+		if got := span.Tag(string(ext.HTTPUrl)); got != "/items/abc" {
+			t.Errorf("span has url %v, want %v", got, "/items/abc")
+		}
+
+		if got, _ := span.Tag(string(ext.Error)).(bool); got != tt.err {
+			t.Errorf("span has error %v, want %v", got, tt.err)
+		}
+
+		if !tt.panics && span.Tag("http.response_size") != len(tt.body) {
+			t.Errorf("span has response size %v, want %v", span.Tag("http.response_size"), len(tt.body))
+		}
+
+		if tt.panics && len(span.Logs()) == 0 {
+			t.Errorf("panic not logged on span")
+		}
+	}
+}
+
+func TestRouteOperationName(t *testing.T) {
+	var tests = []struct {
+		route Route
+		want  string
+	}{
+		{Route{Method: "GET", Pattern: "/"}, "GET /"},
+		{Route{Method: "DELETE", Pattern: "/items/{id:[a-zA-Z0-9]+}"}, "DELETE /items/{id}"},
+		{Route{Method: "GET", Pattern: "/orders/{id:-?[0-9]+}/items/{item}"}, "GET /orders/{id}/items/{item}"},
+		{Route{Name: "notFound"}, "request"},
+	}
+
+	for _, tt := range tests {
+		if got := RouteOperationName(tt.route); got != tt.want {
+			t.Errorf("RouteOperationName(%+v) = %s, want %s", tt.route, got, tt.want)
+		}
+	}
+}
+
+func TestGRPCTracerInterceptor(t *testing.T) {
+	tracer := mocktracer.New()
+	ot.SetGlobalTracer(tracer)
+	defer ot.SetGlobalTracer(ot.NoopTracer{})
+
+	interceptor := GRPCTracerInterceptor(nil)
+	methods := []string{"/item.ItemService/Get", "/item.ItemService/Reserve"}
+	for _, method := range methods {
+		interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if ot.SpanFromContext(ctx) == nil {
+				t.Errorf("%s: no span in context", method)
+			}
+			return nil, nil
+		})
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != len(methods) {
+		t.Fatalf("recorded %d spans, want %d", len(spans), len(methods))
+	}
+	for i, span := range spans {
+		if span.OperationName != methods[i] {
+			t.Errorf("span is named %s, want %s", span.OperationName, methods[i])
+		}
+		if got := span.Tag(string(ext.HTTPStatusCode)); got != uint16(http.StatusOK) {
+			t.Errorf("%s: span has status %v, want %d", methods[i], got, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Package utiltest holds test helpers shared by the services.
+package utiltest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/obitech/micro-obs/util"
+)
+
+// CheckContract sends a request with header to h and fails if the response doesn't have status
+// want or isn't described by doc for the route of router it was served by.
+func CheckContract(h http.Handler, router *mux.Router, doc *util.OpenAPI, method, path, body string, header http.Header, want int, t *testing.T) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	var match mux.RouteMatch
+	if !router.Match(req, &match) || match.Route == nil {
+		t.Errorf("no route matching %s %s", method, path)
+		return
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != want {
+		t.Errorf("%s %s returned %d, want %d", method, path, w.Code, want)
+	}
+
+	if err := doc.ValidateResponse(match.Route.GetName(), w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); err != nil {
+		t.Errorf("%s %s drifted from OpenAPI document: %s", method, path, err)
+	}
+}
@@ -0,0 +1,185 @@
+package util
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DecodeJSON parses data into v. Malformed JSON or a payload of the wrong shape is returned as error,
+// while unknown fields and fields of the wrong type are collected as FieldErrors, all of them with
+// paths like items[0].qty.
+func DecodeJSON(data []byte, v interface{}) (FieldErrors, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var typeErrs, unknownErrs FieldErrors
+	checkFields(raw, reflect.TypeOf(v), "", &typeErrs, &unknownErrs)
+
+	// encoding/json only reports the first mistyped field, which checkFields has found already
+	// unless the field decodes itself.
+	if err := json.Unmarshal(data, v); err != nil {
+		te, ok := err.(*json.UnmarshalTypeError)
+		if !ok || te.Field == "" {
+			return nil, err
+		}
+		if len(typeErrs) == 0 {
+			typeErrs.Add(te.Field, "must be of type %s", schemaOf(te.Type, make(map[reflect.Type]bool)).Type)
+		}
+	}
+
+	return append(typeErrs, unknownErrs...), nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// checkFields walks a JSON value decoded with json.Decoder.UseNumber alongside the type it's
+// decoded into. It records every value that doesn't fit its type in typeErrs and every object key
+// that doesn't map to a struct field in unknownErrs.
+func checkFields(raw interface{}, t reflect.Type, path string, typeErrs, unknownErrs *FieldErrors) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || raw == nil {
+		return
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+	if !fitsType(raw, t) {
+		typeErrs.Add(path, "must be of type %s", schemaOf(t, make(map[reflect.Type]bool)).Type)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		a, ok := raw.([]interface{})
+		if !ok {
+			return
+		}
+		for i, e := range a {
+			checkFields(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i), typeErrs, unknownErrs)
+		}
+	case reflect.Map:
+		o := raw.(map[string]interface{})
+		keys := sortedKeys(o)
+		for _, k := range keys {
+			checkFields(o[k], t.Elem(), fieldPath(path, k), typeErrs, unknownErrs)
+		}
+	case reflect.Struct:
+		o := raw.(map[string]interface{})
+
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if name, _ := jsonFieldName(f); name != "-" {
+				fields[name] = f.Type
+			}
+		}
+
+		for _, k := range sortedKeys(o) {
+			ft, ok := lookupField(fields, k)
+			if !ok {
+				unknownErrs.Add(fieldPath(path, k), "unknown field")
+				continue
+			}
+			checkFields(o[k], ft, fieldPath(path, k), typeErrs, unknownErrs)
+		}
+	}
+}
+
+// fitsType reports whether encoding/json is able to decode the JSON value raw into a value of
+// type t, without looking into arrays and objects.
+func fitsType(raw interface{}, t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool:
+		_, ok := raw.(bool)
+		return ok
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseInt(string(n), 10, t.Bits())
+		return err == nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseUint(string(n), 10, t.Bits())
+		return err == nil
+	case reflect.Float32, reflect.Float64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseFloat(string(n), t.Bits())
+		return err == nil
+	case reflect.String:
+		_, ok := raw.(string)
+		return ok
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as base64 string
+			_, ok := raw.(string)
+			return ok
+		}
+		_, ok := raw.([]interface{})
+		return ok
+	case reflect.Array:
+		_, ok := raw.([]interface{})
+		return ok
+	case reflect.Map, reflect.Struct:
+		_, ok := raw.(map[string]interface{})
+		return ok
+	default:
+		return true
+	}
+}
+
+// sortedKeys returns the keys of a JSON object in order, so errors are reported deterministically.
+func sortedKeys(o map[string]interface{}) []string {
+	keys := make([]string, 0, len(o))
+	for k := range o {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lookupField finds a field by its JSON name, preferring an exact match but falling back to the
+// case-insensitive matching encoding/json uses.
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func fieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", path, key)
+}
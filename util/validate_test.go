@@ -0,0 +1,87 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+type validateTestItem struct {
+	ID  string `json:"id"`
+	Qty int    `json:"qty"`
+}
+
+type validateTestOrder struct {
+	ID    int64                        `json:"id"`
+	Items []*validateTestItem          `json:"items"`
+	Meta  map[string]*validateTestItem `json:"meta,omitempty"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var tests = []struct {
+		js     string
+		valid  bool
+		fields []string
+	}{
+		{`{"id": 1, "items": [{"id": "a", "qty": 1}]}`, true, nil},
+		{`{"ID": 1, "Items": [{"Id": "a", "QTY": 1}]}`, true, nil},
+		{`{"id": 1, "meta": {"first": {"id": "a"}}}`, true, nil},
+		{`{`, false, nil},
+		{`[]`, false, nil},
+		{`{"id": 1, "color": "red"}`, true, []string{"color"}},
+		{`{"items": [{"id": "a"}, {"id": "b", "price": 1}]}`, true, []string{"items[1].price"}},
+		{`{"meta": {"first": {"name": "a"}}}`, true, []string{"meta.first.name"}},
+		{`{"id": "1"}`, true, []string{"id"}},
+		{`{"id": "1", "b": 1, "a": 1}`, true, []string{"id", "a", "b"}},
+		{`{"items": [{"id": 1, "qty": 1}, {"id": "b", "qty": "1"}]}`, true, []string{"items[0].id", "items[1].qty"}},
+		{`{"id": 1.5, "items": {"id": "a"}}`, true, []string{"id", "items"}},
+		{`{"items": [{"qty": 1e40}, null, {"qty": true, "price": 1}]}`, true, []string{"items[0].qty", "items[2].qty", "items[2].price"}},
+	}
+
+	for _, tt := range tests {
+		var o validateTestOrder
+		errs, err := DecodeJSON([]byte(tt.js), &o)
+		if (err == nil) != tt.valid {
+			t.Errorf("DecodeJSON(%s) returned error %v, want valid: %v", tt.js, err, tt.valid)
+			continue
+		}
+
+		var fields []string
+		for _, fe := range errs {
+			fields = append(fields, fe.Field)
+		}
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("DecodeJSON(%s) returned errors for %v, want %v", tt.js, fields, tt.fields)
+		}
+	}
+}
+
+func TestDecodeJSONArray(t *testing.T) {
+	var items []*validateTestItem
+	errs, err := DecodeJSON([]byte(`[{"id": "a", "qty": "1"}, {"id": "b", "qty": 1}, {"id": "c", "qty": 1.5, "foo": 1}]`), &items)
+	if err != nil {
+		t.Fatalf("DecodeJSON() returned error %s", err)
+	}
+
+	want := "[0].qty: must be of type integer, [2].qty: must be of type integer, [2].foo: unknown field"
+	if errs.Error() != want {
+		t.Errorf("DecodeJSON() returned %#v, want %#v", errs.Error(), want)
+	}
+	if len(items) != 3 || items[1].ID != "b" || items[1].Qty != 1 {
+		t.Errorf("valid items weren't decoded: %+v", items[1])
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	var errs FieldErrors
+	errs.CheckRequired("name", "")
+	errs.CheckRequired("desc", "set")
+	errs.CheckLength("desc", "😍😍😍", 3)
+	errs.CheckLength("desc", "😍😍😍", 2)
+	errs.CheckRange("qty", 5, 0, 5)
+	errs.CheckRange("qty", -1, 0, 5)
+
+	want := "name: is required, desc: must be at most 2 characters long, got 3, qty: must be between 0 and 5, got -1"
+	if errs.Error() != want {
+		t.Errorf("FieldErrors.Error() = %#v, want %#v", errs.Error(), want)
+	}
+}